@@ -1,26 +1,182 @@
 package main
 
 import (
+	"context"
 	"documentapi/pkg/api"
 	"documentapi/pkg/database"
+	"documentapi/pkg/mail"
+	"documentapi/pkg/publish"
+	"documentapi/pkg/webhook"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
 )
 
 type DocumentCommentService struct {
-	SQL *database.SQLite
-	API *api.API
+	Store database.Store
+	API   *api.API
 }
 
 func main() {
-	sqlService := &database.SQLite{}
-	apiService := &api.API{}
+	backend := flag.String("backend", "sqlite", "storage backend: "+strings.Join(database.Backends, ", "))
+	dbName := flag.String("db", "document-drafts.db", "SQLite database file")
+	dsn := flag.String("dsn", os.Getenv("DATABASE_URL"), "PostgreSQL connection string, defaults to $DATABASE_URL")
+	migrate := flag.String("migrate", "", "run migrations and exit instead of starting the API: up, down or status")
+	steps := flag.Int("steps", 1, "number of migrations to roll back with -migrate=down, 0 for all")
+	dryRun := flag.Bool("dry-run", false, "with -migrate=up or down, show the migrations that would run without running them")
+	tokenSecret := flag.String("token-secret", os.Getenv("DOCUMENTAPI_TOKEN_SECRET"), "HMAC secret for bearer tokens, defaults to $DOCUMENTAPI_TOKEN_SECRET")
+	tokenTTL := flag.Duration("token-ttl", api.DefaultTokenTTL, "lifetime of tokens issued by POST /api/auth/token")
+	webhookWorkers := flag.Int("webhook-workers", webhook.DefaultWorkers, "concurrent webhook deliveries, 0 to leave delivery to another process")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "let webhooks reach loopback, private and link-local addresses")
+	publishInterval := flag.Duration("publish-interval", publish.DefaultInterval, "how often scheduled publications are checked, 0 to leave them to another process")
+	smtpAddr := flag.String("smtp-addr", os.Getenv("DOCUMENTAPI_SMTP_ADDR"), "SMTP server host:port for notification emails, defaults to $DOCUMENTAPI_SMTP_ADDR")
+	smtpUsername := flag.String("smtp-username", os.Getenv("DOCUMENTAPI_SMTP_USERNAME"), "SMTP username, defaults to $DOCUMENTAPI_SMTP_USERNAME")
+	smtpPassword := flag.String("smtp-password", os.Getenv("DOCUMENTAPI_SMTP_PASSWORD"), "SMTP password, defaults to $DOCUMENTAPI_SMTP_PASSWORD")
+	mailDir := flag.String("mail-dir", "", "write notification emails to .eml files in this directory instead of sending them")
+	mailFrom := flag.String("mail-from", "notifications@localhost", "sender address of notification emails")
+	replyAddress := flag.String("reply-address", os.Getenv("DOCUMENTAPI_REPLY_ADDRESS"), "address replies to notification emails go to, with a token added by plus addressing, defaults to $DOCUMENTAPI_REPLY_ADDRESS")
+	inboundSecret := flag.String("inbound-secret", os.Getenv("DOCUMENTAPI_INBOUND_SECRET"), "shared secret the mail gateway sends with POST /api/inbound/email, defaults to $DOCUMENTAPI_INBOUND_SECRET")
+	digestInterval := flag.Duration("digest-interval", mail.DefaultDigestInterval, "how often due notification digests are sent, 0 to leave them to another process")
+	flag.Parse()
 
-	sqlService.Initialize()
-	apiService.Initialize(sqlService)
+	target := *dbName
+	if *backend == "postgres" {
+		target = *dsn
+	}
+
+	if *migrate != "" {
+		migrator, err := openMigrator(*backend, target)
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		if err := runMigrations(migrator, *migrate, *steps, *dryRun); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	store, err := database.OpenStore(*backend, target)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s store: %v", *backend, err)
+	}
+	apiService := &api.API{
+		TokenSecret:          []byte(*tokenSecret),
+		TokenTTL:             *tokenTTL,
+		InboundSecret:        *inboundSecret,
+		AllowPrivateWebhooks: *webhookAllowPrivate,
+	}
+	apiService.Initialize(store)
+
+	if *webhookWorkers > 0 {
+		dispatcher := &webhook.Dispatcher{Store: store, Workers: *webhookWorkers, AllowPrivate: *webhookAllowPrivate}
+		go dispatcher.Run(context.Background())
+	}
+
+	if *publishInterval > 0 {
+		scheduler := &publish.Scheduler{Store: store, Interval: *publishInterval}
+		go scheduler.Run(context.Background())
+	}
+
+	if mailer := newMailer(*smtpAddr, *smtpUsername, *smtpPassword, *mailDir); mailer != nil && *digestInterval > 0 {
+		digester := &mail.Digester{Store: store, Mailer: mailer, From: *mailFrom, Interval: *digestInterval}
+		if *replyAddress != "" {
+			// Reply tokens are checked by the API, so they share its secret.
+			digester.Replies = &mail.Replies{Address: *replyAddress, Secret: apiService.TokenSecret}
+		}
+		go digester.Run(context.Background())
+	}
 
 	d := DocumentCommentService{
-		SQL: sqlService,
-		API: apiService,
+		Store: store,
+		API:   apiService,
 	}
 
 	d.API.StartAPI()
 }
+
+// newMailer - The mailer the mail flags configure: SMTP if a server is given, otherwise files in
+// mailDir. Nil if neither is, which leaves email off.
+func newMailer(smtpAddr, username, password, mailDir string) mail.Mailer {
+	switch {
+	case smtpAddr != "":
+		return &mail.SMTPMailer{Addr: smtpAddr, Username: username, Password: password}
+	case mailDir != "":
+		return &mail.FileMailer{Dir: mailDir}
+	}
+	return nil
+}
+
+// openMigrator - Opens a backend without migrating it, for the -migrate modes.
+func openMigrator(backend, target string) (*database.Migrator, error) {
+	switch backend {
+	case "sqlite":
+		sqlService := &database.SQLite{}
+		if err := sqlService.Open(target); err != nil {
+			return nil, err
+		}
+		return sqlService.Migrator()
+	case "postgres":
+		pgService := &database.Postgres{}
+		if err := pgService.Open(target); err != nil {
+			return nil, err
+		}
+		return pgService.Migrator()
+	default:
+		return nil, fmt.Errorf("the %s backend has no schema to migrate", backend)
+	}
+}
+
+// runMigrations - Handles the -migrate command line modes. With dryRun, up and down only list
+// the migrations they would run.
+func runMigrations(migrator *database.Migrator, mode string, steps int, dryRun bool) error {
+	var migrations []database.Migration
+	var err error
+	switch mode {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+		return nil
+	case "up":
+		migrations, err = migrator.Up(dryRun)
+		if dryRun {
+			printMigrations("Would apply", migrations)
+		} else {
+			printMigrations("Applied", migrations)
+		}
+		return err
+	case "down":
+		migrations, err = migrator.Down(steps, dryRun)
+		if dryRun {
+			printMigrations("Would roll back", migrations)
+		} else {
+			printMigrations("Rolled back", migrations)
+		}
+		return err
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -migrate mode %q\n", mode)
+		flag.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func printMigrations(verb string, migrations []database.Migration) {
+	if len(migrations) == 0 {
+		fmt.Println("No migrations to run")
+		return
+	}
+	for _, migration := range migrations {
+		fmt.Printf("%s %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
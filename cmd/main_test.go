@@ -1,65 +1,57 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"documentapi/pkg/api"
 	"documentapi/pkg/common"
 	"documentapi/pkg/database"
-)
-
-func setup() (*database.SQLite, *api.API, string) {
-	sqlService, dbName := setupTestDB()
-	apiService := &api.API{}
-
-	apiService.Initialize(sqlService)
-
-	return sqlService, apiService, dbName
-}
+	"documentapi/pkg/mail"
+	"documentapi/pkg/publish"
+	"documentapi/pkg/webhook"
 
-func setupTestDB() (*database.SQLite, string) {
-	// Generate a unique filename for the test database
-	dbName := fmt.Sprintf("testdb_%v.db", time.Now().UnixNano())
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
 
+// setup - An API over a fresh SQLite database in a temporary directory, closed and removed
+// when the test ends.
+func setup(t *testing.T) *api.API {
+	t.Helper()
 	sqlService := &database.SQLite{}
-	err := sqlService.Initialize(dbName) // Adjust the Initialize method to accept a db name
-	if err != nil {
-		log.Fatalf("Failed to initialize test database: %v", err)
+	if err := sqlService.Initialize(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
 	}
-
-	return sqlService, dbName
-}
-
-func teardown(sqlService *database.SQLite, dbName string) {
-	// Close the database connection
-	if sqlService.DB != nil {
-		err := sqlService.DB.Close()
-		if err != nil {
-			log.Fatalf("Failed to close database connection: %v", err)
+	t.Cleanup(func() {
+		if err := sqlService.DB.Close(); err != nil {
+			t.Errorf("Failed to close test database: %v", err)
 		}
-	}
+	})
 
-	// Delete the test database file
-	err := os.Remove(dbName)
-	if err != nil {
-		log.Fatalf("Failed to delete test database file: %v", err)
-	}
+	apiService := &api.API{}
+	apiService.Initialize(sqlService)
+	return apiService
 }
 
-func createDraft(serverURL, draftName, draftContent string) ([]byte, error) {
+func createDraft(serverURL, apiKey, draftName, draftContent string) ([]byte, error) {
 	requestBody := strings.NewReader(fmt.Sprintf(`{"name": "%s", "content": "%s"}`, draftName, draftContent))
-	resp, err := http.Post(serverURL+"/api/drafts", "application/json", requestBody)
+	resp, err := doRequest("POST", serverURL+"/api/drafts", apiKey, requestBody)
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +66,15 @@ func createDraft(serverURL, draftName, draftContent string) ([]byte, error) {
 	return body, nil
 }
 
-func getJSON(t *testing.T, url string, target interface{}) {
-	resp, err := http.Get(url)
+func getJSON(t *testing.T, url, apiKey string, target interface{}) {
+	t.Helper()
+	getWorkspaceJSON(t, url, apiKey, 0, target)
+}
+
+// getWorkspaceJSON - Like getJSON, in the given workspace.
+func getWorkspaceJSON(t *testing.T, url, apiKey string, workspaceId int, target interface{}) {
+	t.Helper()
+	resp, err := doWorkspaceRequest("GET", url, apiKey, workspaceId, nil)
 	if err != nil {
 		t.Fatalf("Failed to make GET request: %v", err)
 	}
@@ -90,10 +89,9 @@ func getJSON(t *testing.T, url string, target interface{}) {
 	}
 }
 
-func createComment(serverURL string, draftId, userId int, text string) (string, int, error) {
+func createComment(serverURL string, draftId int, apiKey, text string) (string, int, error) {
 	commentData := database.Comment{
 		DraftId: draftId,
-		UserId:  userId,
 		Text:    text,
 	}
 
@@ -103,7 +101,7 @@ func createComment(serverURL string, draftId, userId int, text string) (string,
 	}
 
 	// Make a POST request to add a comment
-	resp, err := http.Post(serverURL+"/api/comments", "application/json", bytes.NewReader(commentDataBytes))
+	resp, err := doRequest("POST", serverURL+"/api/comments", apiKey, bytes.NewReader(commentDataBytes))
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to make POST request: %v", err)
 	}
@@ -122,14 +120,61 @@ func createComment(serverURL string, draftId, userId int, text string) (string,
 	return result.Message, int(result.Id), nil
 }
 
+// testUser - A registered user, the API key to act as them and their personal workspace.
+type testUser struct {
+	Id          int
+	Key         string
+	WorkspaceId int
+}
+
+func registerUser(t *testing.T, serverURL, username string) testUser {
+	body := strings.NewReader(fmt.Sprintf(`{"username": "%s"}`, username))
+	resp, err := http.Post(serverURL+"/api/users", "application/json", body)
+	if err != nil {
+		t.Fatalf("Failed to register %s: %v", username, err)
+	}
+	defer resp.Body.Close()
+
+	var result api.NewUserResult
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status Created registering %s; got %v", username, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode registration: %v", err)
+	}
+	return testUser{Id: result.User.Id, Key: result.APIKey.Key, WorkspaceId: result.Workspace.Id}
+}
+
+// doRequest - Sends a request with a JSON body as the holder of apiKey, or anonymously if it is empty.
+func doRequest(method, url, apiKey string, body io.Reader) (*http.Response, error) {
+	return doWorkspaceRequest(method, url, apiKey, 0, body)
+}
+
+// doWorkspaceRequest - Like doRequest, in the given workspace, or the caller's first one if it is 0.
+func doWorkspaceRequest(method, url, apiKey string, workspaceId int, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if workspaceId != 0 {
+		req.Header.Set("X-Workspace-Id", strconv.Itoa(workspaceId))
+	}
+	return http.DefaultClient.Do(req)
+}
+
 func TestAddDraft(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
-	body, err := createDraft(server.URL, "Test Draft", "Draft content")
+	owner := registerUser(t, server.URL, "owner")
+
+	body, err := createDraft(server.URL, owner.Key, "Test Draft", "Draft content")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
@@ -142,24 +187,25 @@ func TestAddDraft(t *testing.T) {
 }
 
 func TestGetMostRecentDrafts(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
+	owner := registerUser(t, server.URL, "owner")
+
 	// Create a few drafts for testing
-	_, err := createDraft(server.URL, "Test Draft 1", "Draft content 1")
+	_, err := createDraft(server.URL, owner.Key, "Test Draft 1", "Draft content 1")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
-	_, err = createDraft(server.URL, "Test Draft 2", "Draft content 2")
+	_, err = createDraft(server.URL, owner.Key, "Test Draft 2", "Draft content 2")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
 
 	var drafts []database.Draft
-	getJSON(t, server.URL+"/api/drafts", &drafts)
+	getJSON(t, server.URL+"/api/drafts", owner.Key, &drafts)
 
 	if len(drafts) < 2 {
 		t.Errorf("Expected at least 2 drafts, got %d", len(drafts))
@@ -171,25 +217,26 @@ func TestGetMostRecentDrafts(t *testing.T) {
 }
 
 func TestSearchDrafts(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
+	owner := registerUser(t, server.URL, "owner")
+
 	// Create drafts for testing
-	_, err := createDraft(server.URL, "Custodia rocks", "Content about custodia bank")
+	_, err := createDraft(server.URL, owner.Key, "Custodia rocks", "Content about custodia bank")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
-	_, err = createDraft(server.URL, "Search Test Draft", "Content about API testing")
+	_, err = createDraft(server.URL, owner.Key, "Search Test Draft", "Content about API testing")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
 
 	searchQuery := "custodia bank"
 
-	resp, err := http.Get(server.URL + "/api/drafts/search?text=" + url.QueryEscape(searchQuery))
+	resp, err := doRequest("GET", server.URL+"/api/drafts/search?text="+url.QueryEscape(searchQuery), owner.Key, nil)
 	if err != nil {
 		t.Fatalf("Failed to make GET request: %v", err)
 	}
@@ -214,28 +261,29 @@ func TestSearchDrafts(t *testing.T) {
 }
 
 func TestGetDocumentsLatestVersions(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
+	owner := registerUser(t, server.URL, "owner")
+
 	// Create drafts with diffrent names for testing
-	_, err := createDraft(server.URL, "Custodia rocks", "Content about custodia bank")
+	_, err := createDraft(server.URL, owner.Key, "Custodia rocks", "Content about custodia bank")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
-	_, err = createDraft(server.URL, "Custodia rocks", "Content about how custodia bank is the best bitcoin vault")
+	_, err = createDraft(server.URL, owner.Key, "Custodia rocks", "Content about how custodia bank is the best bitcoin vault")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
-	_, err = createDraft(server.URL, "Rough Draft", "Satoshi's Draft Whitepaper")
+	_, err = createDraft(server.URL, owner.Key, "Rough Draft", "Satoshi's Draft Whitepaper")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
 
-	// Make a GET request to the /api/documents/latest endpoint
-	resp, err := http.Get(server.URL + "/api/documents/latest")
+	// Make a GET request to the /api/documents/latest endpoint, asking for unpublished documents too
+	resp, err := doRequest("GET", server.URL+"/api/documents/latest?draft=latest", owner.Key, nil)
 	if err != nil {
 		t.Fatalf("Failed to make GET request: %v", err)
 	}
@@ -262,22 +310,23 @@ func TestGetDocumentsLatestVersions(t *testing.T) {
 }
 
 func TestAddComment(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
+	user := registerUser(t, server.URL, "commenter")
+
 	// Create a draft for testing
-	_, err := createDraft(server.URL, "Test Draft for Comment", "Draft content for comment")
+	_, err := createDraft(server.URL, user.Key, "Test Draft for Comment", "Draft content for comment")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
 
 	var drafts []database.Draft
-	getJSON(t, server.URL+"/api/drafts", &drafts)
+	getJSON(t, server.URL+"/api/drafts", user.Key, &drafts)
 
-	respMessage, _, err := createComment(server.URL, drafts[0].Id, 1, "This is a test comment")
+	respMessage, _, err := createComment(server.URL, drafts[0].Id, user.Key, "This is a test comment")
 	if err != nil {
 		t.Fatalf("Failed to create comment: %v", err)
 	}
@@ -288,22 +337,23 @@ func TestAddComment(t *testing.T) {
 }
 
 func TestAddReaction(t *testing.T) {
-	sqlService, apiService, dbName := setup()
-	defer teardown(sqlService, dbName)
+	apiService := setup(t)
 
 	server := httptest.NewServer(apiService.Router)
 	defer server.Close()
 
+	user := registerUser(t, server.URL, "reactor")
+
 	// Create a draft and a comment for testing
-	_, err := createDraft(server.URL, "Gensis Block", "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks")
+	_, err := createDraft(server.URL, user.Key, "Gensis Block", "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks")
 	if err != nil {
 		t.Fatalf("Failed to create draft: %v", err)
 	}
 
 	var drafts []database.Draft
-	getJSON(t, server.URL+"/api/drafts", &drafts)
+	getJSON(t, server.URL+"/api/drafts", user.Key, &drafts)
 
-	_, commentId, err := createComment(server.URL, drafts[0].Id, 1, "This is the way")
+	_, commentId, err := createComment(server.URL, drafts[0].Id, user.Key, "This is the way")
 	if err != nil {
 		t.Fatalf("Failed to create comment: %v", err)
 	}
@@ -321,7 +371,7 @@ func TestAddReaction(t *testing.T) {
 	}
 
 	reactionURL := fmt.Sprintf("%s/api/comment/%d/reaction", server.URL, commentId)
-	resp, err := http.Post(reactionURL, "application/json", bytes.NewReader(reactionDataBytes))
+	resp, err := doRequest("POST", reactionURL, user.Key, bytes.NewReader(reactionDataBytes))
 	if err != nil {
 		t.Fatalf("Failed to make POST request: %v", err)
 	}
@@ -340,3 +390,2042 @@ func TestAddReaction(t *testing.T) {
 		t.Errorf("Unexpected response body: %s", respBody)
 	}
 }
+
+func TestReactionToggling(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	workspace := alice.WorkspaceId
+	request := func(method, path, key, body string, status int) []byte {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, workspace, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %v", method, path, status, resp.Status)
+		}
+		result, _ := io.ReadAll(resp.Body)
+		return result
+	}
+	reactions := func(key string) []database.ReactionCount {
+		t.Helper()
+		var page api.CommentPage
+		getWorkspaceJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1", key, workspace, &page)
+		if len(page.Comments) != 1 {
+			t.Fatalf("Expected one comment, got %+v", page)
+		}
+		return page.Comments[0].Reactions
+	}
+
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	if _, _, err := createComment(server.URL, 1, alice.Key, "Ready?"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	request("PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+	request("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "commenter"}`, http.StatusOK)
+
+	request("POST", "/api/comment/1/reaction", alice.Key, `{"emoji": "👍"}`, http.StatusCreated)
+	request("POST", "/api/comment/1/reaction", alice.Key, `{"emoji": "👍"}`, http.StatusOK)
+	request("POST", "/api/comment/1/reaction", alice.Key, `{"emoji": ""}`, http.StatusBadRequest)
+	request("POST", "/api/comment/1/reaction", bob.Key, `{"emoji": "👍"}`, http.StatusCreated)
+	request("POST", "/api/comment/1/reaction", bob.Key, `{"emoji": "🎉"}`, http.StatusCreated)
+
+	expected := []database.ReactionCount{{Emoji: "👍", Count: 2, ReactedByMe: true}, {Emoji: "🎉", Count: 1}}
+	if got := reactions(alice.Key); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected alice to see %+v, got %+v", expected, got)
+	}
+	if got := reactions(bob.Key); len(got) != 2 || !got[0].ReactedByMe || !got[1].ReactedByMe {
+		t.Errorf("Expected bob to see both of their reactions, got %+v", got)
+	}
+
+	var toggled api.ReactionToggleResult
+	json.Unmarshal(request("POST", "/api/comment/1/reaction/toggle", alice.Key, `{"emoji": "👍"}`, http.StatusOK), &toggled)
+	expected = []database.ReactionCount{{Emoji: "👍", Count: 1}, {Emoji: "🎉", Count: 1}}
+	if toggled.Reacted || !reflect.DeepEqual(toggled.Reactions, expected) {
+		t.Errorf("Expected toggling to take alice's 👍 back, got %+v", toggled)
+	}
+	json.Unmarshal(request("POST", "/api/comment/1/reaction/toggle", alice.Key, `{"emoji": "🎉"}`, http.StatusOK), &toggled)
+	if !toggled.Reacted || len(toggled.Reactions) != 2 || toggled.Reactions[1].Count != 2 || !toggled.Reactions[1].ReactedByMe {
+		t.Errorf("Expected toggling to add alice's 🎉, got %+v", toggled)
+	}
+
+	request("DELETE", "/api/comment/1/reaction/"+url.PathEscape("👍"), bob.Key, "", http.StatusNoContent)
+	request("DELETE", "/api/comment/1/reaction/"+url.PathEscape("👍"), bob.Key, "", http.StatusNotFound)
+	request("DELETE", "/api/comment/1/reaction/"+url.PathEscape("not an emoji"), bob.Key, "", http.StatusBadRequest)
+	expected = []database.ReactionCount{{Emoji: "🎉", Count: 2, ReactedByMe: true}}
+	if got := reactions(bob.Key); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected only 🎉 left, got %+v", got)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	store, err := database.OpenStore("memory", "")
+	if err != nil {
+		t.Fatalf("Failed to open memory store: %v", err)
+	}
+	apiService := &api.API{}
+	apiService.Initialize(store)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+
+	if _, err := createDraft(server.URL, owner.Key, "In Memory", "No file on disk"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	var drafts []database.Draft
+	getJSON(t, server.URL+"/api/drafts", owner.Key, &drafts)
+	if len(drafts) != 1 || drafts[0].Content != "No file on disk" {
+		t.Errorf("Unexpected drafts from memory backend: %v", drafts)
+	}
+}
+
+func TestSearchDraftsSnippetsAndErrors(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+
+	_, err := createDraft(server.URL, owner.Key, "Charter", "The first charter draft")
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	_, err = createDraft(server.URL, owner.Key, "Charter", "The final charter draft")
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	var results []database.SearchResult
+	getJSON(t, server.URL+"/api/drafts/search?latest=true&text="+url.QueryEscape("charter"), owner.Key, &results)
+	if len(results) != 1 || results[0].VersionNumber != 2 {
+		t.Fatalf("Expected only the latest version, got %v", results)
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>charter</mark>") {
+		t.Errorf("Expected a highlighted snippet, got %q", results[0].Snippet)
+	}
+
+	resp, err := doRequest("GET", server.URL+"/api/drafts/search?text="+url.QueryEscape(`"unterminated`), owner.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for invalid search; got %v", resp.Status)
+	}
+}
+
+func TestDraftOptimisticConcurrency(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+
+	postDraft := func(body string, ifMatch string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/api/drafts", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+owner.Key)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make POST request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first := postDraft(`{"name": "Spec", "content": "v1", "baseVersion": 0}`, "")
+	if first.StatusCode != http.StatusOK || first.Header.Get("ETag") == "" {
+		t.Fatalf("Expected OK with an ETag; got %v %q", first.Status, first.Header.Get("ETag"))
+	}
+	etagV1 := first.Header.Get("ETag")
+
+	// Two editors start from version 1; the second one must be told about the first.
+	if resp := postDraft(`{"name": "Spec", "content": "editor A"}`, etagV1); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the first edit to succeed; got %v", resp.Status)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/api/drafts", strings.NewReader(`{"name": "Spec", "content": "editor B"}`))
+	req.Header.Set("If-Match", etagV1)
+	req.Header.Set("Authorization", "Bearer "+owner.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make POST request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status Conflict; got %v", resp.Status)
+	}
+	var conflict api.DraftConflictResult
+	if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
+		t.Fatalf("Failed to decode conflict: %v", err)
+	}
+	if conflict.Head == nil || conflict.Head.Content != "editor A" || conflict.Head.VersionNumber != 2 {
+		t.Errorf("Expected the conflict to return the current head, got %+v", conflict)
+	}
+
+	if resp := postDraft(`{"name": "Spec", "content": "stale", "baseVersion": 1}`, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a stale baseVersion to conflict; got %v", resp.Status)
+	}
+	if resp := postDraft(`{"name": "Spec", "content": "x"}`, "not-an-etag"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a malformed If-Match to be rejected; got %v", resp.Status)
+	}
+
+	// Document and draft GETs carry the same tag for the same version.
+	docResp, err := doRequest("GET", server.URL+fmt.Sprintf("/api/documents/%d?draft=latest", conflict.Document.Id), owner.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	docResp.Body.Close()
+	draftResp, err := doRequest("GET", server.URL+fmt.Sprintf("/api/drafts/%d", conflict.Head.Id), owner.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	draftResp.Body.Close()
+	if docResp.Header.Get("ETag") == "" || docResp.Header.Get("ETag") != draftResp.Header.Get("ETag") {
+		t.Errorf("Expected matching ETags, got %q and %q", docResp.Header.Get("ETag"), draftResp.Header.Get("ETag"))
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+fmt.Sprintf("/api/documents/%d?draft=latest", conflict.Document.Id), nil)
+	req.Header.Set("If-None-Match", docResp.Header.Get("ETag"))
+	req.Header.Set("Authorization", "Bearer "+owner.Key)
+	notModified, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make GET request: %v", err)
+	}
+	notModified.Body.Close()
+	if notModified.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status Not Modified; got %v", notModified.Status)
+	}
+}
+
+func TestDocumentDiff(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+
+	for _, content := range []string{`one\ntwo\nthree`, `one\ntwo\nthree`, `one\n2\nthree\nfour`} {
+		if _, err := createDraft(server.URL, owner.Key, "Diff Draft", content); err != nil {
+			t.Fatalf("Failed to create draft: %v", err)
+		}
+	}
+
+	var result api.DiffResult
+	getJSON(t, server.URL+"/api/documents/1/diff?from=1&to=3", owner.Key, &result)
+	if result.From != 1 || result.To != 3 || result.Stats.Insertions != 2 || result.Stats.Deletions != 1 {
+		t.Errorf("Unexpected diff result %+v", result)
+	}
+	if len(result.Hunks) != 1 || len(result.Hunks[0].Lines) != 5 {
+		t.Fatalf("Expected one hunk of five lines, got %+v", result.Hunks)
+	}
+
+	getJSON(t, server.URL+"/api/documents/1/diff?from=1&to=2", owner.Key, &result)
+	if len(result.Hunks) != 0 {
+		t.Errorf("Expected identical versions to have no hunks, got %+v", result.Hunks)
+	}
+
+	// Without to, the diff runs against the latest version.
+	resp, err := doRequest("GET", server.URL+"/api/documents/1/diff?from=1&format=unified", owner.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get unified diff: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want := "--- Diff Draft@v1\n+++ Diff Draft@v3\n@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n"
+	if string(body) != want {
+		t.Errorf("Unexpected unified diff:\n%s\nwant:\n%s", body, want)
+	}
+
+	resp, err = doRequest("GET", server.URL+"/api/documents/1/diff?from=1&to=3&format=html", owner.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get HTML diff: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), "<ins>2</ins>") {
+		t.Errorf("Unexpected HTML diff (%s):\n%s", resp.Header.Get("Content-Type"), body)
+	}
+
+	for query, status := range map[string]int{
+		"from=1&to=9":         http.StatusNotFound,
+		"to=2":                http.StatusBadRequest,
+		"from=1&format=pdf":   http.StatusBadRequest,
+		"from=1&context=wide": http.StatusBadRequest,
+	} {
+		resp, err := doRequest("GET", server.URL+"/api/documents/1/diff?"+query, owner.Key, nil)
+		if err != nil {
+			t.Fatalf("Failed to get diff: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", query, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestDocumentBlame(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+	for _, content := range []string{`one\ntwo\nthree`, `one\nTWO\nthree\nfour`, `zero\none\nTWO\nfour`} {
+		if _, err := createDraft(server.URL, owner.Key, "Notes", content); err != nil {
+			t.Fatalf("Failed to create draft: %v", err)
+		}
+	}
+
+	versionsOf := func(result api.BlameResult) []int {
+		versions := []int{}
+		for _, line := range result.Lines {
+			versions = append(versions, line.Version)
+		}
+		return versions
+	}
+
+	// The latest version is blamed by default, and again from the cache.
+	for i := 0; i < 2; i++ {
+		var latest api.BlameResult
+		getJSON(t, server.URL+"/api/documents/1/blame", owner.Key, &latest)
+		if latest.Version != 3 || fmt.Sprint(versionsOf(latest)) != "[3 1 2 2]" {
+			t.Fatalf("Expected version 3 blamed on [3 1 2 2], got %+v", latest)
+		}
+		if line := latest.Lines[2]; line.Line != 3 || line.Content != "TWO" || line.DraftId != 2 || line.CreatedAt.IsZero() {
+			t.Errorf("Expected line 3 to be TWO from draft 2, got %+v", line)
+		}
+	}
+
+	var earlier api.BlameResult
+	getJSON(t, server.URL+"/api/documents/1/blame?version=2", owner.Key, &earlier)
+	if earlier.Version != 2 || fmt.Sprint(versionsOf(earlier)) != "[1 2 1 2]" {
+		t.Errorf("Expected version 2 blamed on [1 2 1 2], got %+v", earlier)
+	}
+
+	for query, status := range map[string]int{
+		"version=0":   http.StatusNotFound,
+		"version=4":   http.StatusNotFound,
+		"version=two": http.StatusBadRequest,
+	} {
+		resp, err := doRequest("GET", server.URL+"/api/documents/1/blame?"+query, owner.Key, nil)
+		if err != nil {
+			t.Fatalf("Failed to get blame: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", query, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestDraftHistory(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+	editor := registerUser(t, server.URL, "editor")
+	expectStatus := func(method, path, key, body string, status int) {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, owner.WorkspaceId, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %d", method, path, status, resp.StatusCode)
+		}
+	}
+
+	expectStatus("POST", "/api/drafts", owner.Key, `{"name": "Guide", "content": "Intro\nSteps", "summary": "First cut", "metadata": {"ticket": "DOC-1"}}`, http.StatusOK)
+	expectStatus("PUT", fmt.Sprintf("/api/workspaces/%d/members", owner.WorkspaceId), owner.Key, fmt.Sprintf(`{"userId": %d}`, editor.Id), http.StatusOK)
+	expectStatus("PUT", "/api/documents/1/permissions", owner.Key, fmt.Sprintf(`{"userId": %d, "role": "editor"}`, editor.Id), http.StatusOK)
+	expectStatus("POST", "/api/drafts", editor.Key, `{"name": "Guide", "content": "Intro\nMore steps", "summary": "  Expand the steps  "}`, http.StatusOK)
+	expectStatus("POST", "/api/documents/1/restore", owner.Key, `{"version": 1}`, http.StatusCreated)
+	expectStatus("POST", "/api/drafts", owner.Key, `{"name": "Guide", "content": "x", "summary": "`+strings.Repeat("a", 1001)+`"}`, http.StatusBadRequest)
+	expectStatus("POST", "/api/drafts", owner.Key, `{"name": "Guide", "content": "x", "metadata": {"": "empty key"}}`, http.StatusBadRequest)
+
+	var first database.Draft
+	getJSON(t, server.URL+"/api/drafts/1", owner.Key, &first)
+	if first.AuthorId != owner.Id || first.Summary != "First cut" || first.Metadata["ticket"] != "DOC-1" {
+		t.Errorf("Expected the owner's first cut with its ticket, got %+v", first)
+	}
+
+	var history api.DocumentHistory
+	getJSON(t, server.URL+"/api/documents/1/history", owner.Key, &history)
+	summaries := []string{}
+	for _, entry := range history.Versions {
+		summaries = append(summaries, fmt.Sprintf("%d %d %s", entry.VersionNumber, entry.AuthorId, entry.Summary))
+	}
+	want := []string{
+		fmt.Sprintf("3 %d Restored version 1", owner.Id),
+		fmt.Sprintf("2 %d Expand the steps", editor.Id),
+		fmt.Sprintf("1 %d First cut", owner.Id),
+	}
+	if fmt.Sprint(summaries) != fmt.Sprint(want) || history.NextBefore != 0 {
+		t.Errorf("Expected history %v on one page, got %v (next before %d)", want, summaries, history.NextBefore)
+	}
+
+	var page api.DocumentHistory
+	getJSON(t, fmt.Sprintf("%s/api/documents/1/history?authorId=%d&limit=1", server.URL, owner.Id), owner.Key, &page)
+	if len(page.Versions) != 1 || page.Versions[0].VersionNumber != 3 || page.NextBefore != 3 {
+		t.Fatalf("Expected the owner's version 3 and a next page, got %+v", page)
+	}
+	getJSON(t, fmt.Sprintf("%s/api/documents/1/history?authorId=%d&limit=1&before=%d", server.URL, owner.Id, page.NextBefore), owner.Key, &page)
+	if len(page.Versions) != 1 || page.Versions[0].VersionNumber != 1 {
+		t.Errorf("Expected the owner's version 1 next, got %+v", page)
+	}
+
+	var recent []database.Draft
+	getJSON(t, fmt.Sprintf("%s/api/drafts?limit=5&authorId=%d", server.URL, editor.Id), owner.Key, &recent)
+	if len(recent) != 1 || recent[0].VersionNumber != 2 || recent[0].AuthorId != editor.Id {
+		t.Errorf("Expected only the editor's draft, got %+v", recent)
+	}
+	var results []database.SearchResult
+	getJSON(t, fmt.Sprintf("%s/api/drafts/search?text=intro&authorId=%d", server.URL, editor.Id), owner.Key, &results)
+	if len(results) != 1 || results[0].VersionNumber != 2 {
+		t.Errorf("Expected the editor's draft alone to match, got %+v", results)
+	}
+
+	var blame api.BlameResult
+	getJSON(t, server.URL+"/api/documents/1/blame?version=2", owner.Key, &blame)
+	if len(blame.Lines) != 2 || blame.Lines[0].AuthorId != owner.Id || blame.Lines[1].AuthorId != editor.Id {
+		t.Errorf("Expected the lines blamed on the owner then the editor, got %+v", blame.Lines)
+	}
+
+	for _, query := range []string{"authorId=me", "before=0", "limit=101"} {
+		expectStatus("GET", "/api/documents/1/history?"+query, owner.Key, "", http.StatusBadRequest)
+	}
+	expectStatus("GET", "/api/drafts?authorId=-1", owner.Key, "", http.StatusBadRequest)
+}
+
+func TestAnchoredComments(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	user := registerUser(t, server.URL, "anchorer")
+
+	if _, err := createDraft(server.URL, user.Key, "Anchored Draft", `First point.\nSecond point.`); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	post := func(body string) *http.Response {
+		resp, err := doRequest("POST", server.URL+"/api/comments", user.Key, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to post comment: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(`{"draftId": 1, "userId": 1, "text": "Expand this", "anchor": {"quote": "Second point"}}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status Created; got %v", resp.Status)
+	}
+	if resp := post(`{"draftId": 1, "text": "Bad", "anchor": {"startLine": 7}}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for an out of range anchor; got %v", resp.Status)
+	}
+
+	if _, err := createDraft(server.URL, user.Key, "Anchored Draft", `Second point, expanded.`); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	var anchors []database.CommentAnchor
+	getJSON(t, server.URL+"/api/drafts/2/anchors", user.Key, &anchors)
+	if len(anchors) != 1 || anchors[0].Quote != "Second point" || anchors[0].Start != 0 || anchors[0].Orphaned {
+		t.Errorf("Expected the anchor to follow its text into version 2, got %+v", anchors)
+	}
+
+	resp, err := doRequest("GET", server.URL+"/api/drafts/9/anchors", user.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get anchors: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for a missing draft; got %v", resp.Status)
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	user := registerUser(t, server.URL, "threader")
+
+	if _, err := createDraft(server.URL, user.Key, "Threaded Draft", "Content"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	// 1 and 2 are top-level; 3 and 4 reply to 1 and 5 replies to 3.
+	parents := []int{0, 0, 1, 1, 3}
+	for i, parent := range parents {
+		comment := database.Comment{DraftId: 1, Text: fmt.Sprintf("comment %d", i+1)}
+		if parent > 0 {
+			parent := parent
+			comment.ParentCommentId = &parent
+		}
+		body, _ := json.Marshal(comment)
+		resp, err := doRequest("POST", server.URL+"/api/comments", user.Key, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to add comment: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	var page api.CommentPage
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1", user.Key, &page)
+	if page.Total != 2 || len(page.Comments) != 2 || page.HasMore {
+		t.Fatalf("Expected two top-level threads, got %+v", page)
+	}
+	first := page.Comments[0]
+	if first.Id != 1 || first.ReplyCount != 2 || len(first.Replies) != 2 {
+		t.Fatalf("Expected comment 1 with two replies, got %+v", first)
+	}
+	if first.Replies[0].Id != 3 || len(first.Replies[0].Replies) != 1 || first.Replies[0].Replies[0].Id != 5 {
+		t.Errorf("Expected comment 5 nested under 3, got %+v", first.Replies[0])
+	}
+
+	page = api.CommentPage{}
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&depth=1&limit=1", user.Key, &page)
+	if len(page.Comments) != 1 || !page.HasMore || page.Comments[0].ReplyCount != 2 || len(page.Comments[0].Replies) != 0 {
+		t.Errorf("Expected one thread without replies, got %+v", page)
+	}
+
+	page = api.CommentPage{}
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&parentId=1&offset=1", user.Key, &page)
+	if page.Total != 2 || len(page.Comments) != 1 || page.Comments[0].Id != 4 {
+		t.Errorf("Expected the second reply to comment 1, got %+v", page)
+	}
+
+	page = api.CommentPage{}
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&mode=flat", user.Key, &page)
+	var ids []int
+	for _, comment := range page.Comments {
+		ids = append(ids, comment.Id)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" || page.Comments[2].ReplyCount != 1 {
+		t.Errorf("Expected all comments in creation order, got %+v", page.Comments)
+	}
+
+	resp, err := doRequest("GET", server.URL+"/api/drafts/comments-reactions?draftId=1&depth=0", user.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for depth=0; got %v", resp.Status)
+	}
+}
+
+func TestCommentResolution(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	reviewer := registerUser(t, server.URL, "reviewer")
+
+	if _, err := createDraft(server.URL, reviewer.Key, "Reviewed Draft", "Content"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	for _, text := range []string{"Fix the title", "Typo in line 2"} {
+		if _, _, err := createComment(server.URL, 1, reviewer.Key, text); err != nil {
+			t.Fatalf("Failed to create comment: %v", err)
+		}
+	}
+
+	// Resolution is recorded against the caller, not a userId in the body.
+	resp, err := doRequest("POST", server.URL+"/api/comments/1/resolve", reviewer.Key, strings.NewReader(`{"userId": 5}`))
+	if err != nil {
+		t.Fatalf("Failed to resolve thread: %v", err)
+	}
+	var resolved database.Comment
+	json.NewDecoder(resp.Body).Decode(&resolved)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !resolved.Resolved || resolved.ResolvedBy == nil || *resolved.ResolvedBy != reviewer.Id {
+		t.Fatalf("Expected comment 1 resolved by the reviewer, got %v %+v", resp.Status, resolved)
+	}
+
+	var page api.CommentPage
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&status=unresolved", reviewer.Key, &page)
+	if page.Total != 1 || page.Comments[0].Id != 2 {
+		t.Errorf("Expected only the open comment, got %+v", page)
+	}
+
+	// The open comment carries forward to the next version.
+	if _, err := createDraft(server.URL, reviewer.Key, "Reviewed Draft", "Content v2"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	page = api.CommentPage{}
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=2", reviewer.Key, &page)
+	if page.Total != 1 || page.Comments[0].Id != 2 || page.Comments[0].DraftId != 1 {
+		t.Errorf("Expected comment 2 carried onto draft 2, got %+v", page)
+	}
+
+	resp, err = doRequest("POST", server.URL+"/api/comments/1/reopen", reviewer.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen thread: %v", err)
+	}
+	resp.Body.Close()
+	page = api.CommentPage{}
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&status=resolved", reviewer.Key, &page)
+	if resp.StatusCode != http.StatusOK || page.Total != 0 {
+		t.Errorf("Expected no resolved comments after reopening, got %v %+v", resp.Status, page)
+	}
+
+	resp, err = doRequest("POST", server.URL+"/api/comments/99/reopen", reviewer.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen thread: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status Not Found for a missing comment; got %v", resp.Status)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "Alice")
+	bob := registerUser(t, server.URL, "bob")
+
+	if _, err := createDraft(server.URL, alice.Key, "Authored Draft", "Content"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	// Usernames are unique regardless of case.
+	resp, err := http.Post(server.URL+"/api/users", "application/json", strings.NewReader(`{"username": "ALICE"}`))
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status Conflict for a taken username; got %v", resp.Status)
+	}
+
+	// Email addresses must parse, and are stored without a display name.
+	for _, email := range []string{"not an address", `carol@example.com\r\nBcc: eve@example.com`} {
+		resp, err := http.Post(server.URL+"/api/users", "application/json", strings.NewReader(fmt.Sprintf(`{"username": "carol", "email": %q}`, email)))
+		if err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Email %q: expected status Bad Request; got %v", email, resp.Status)
+		}
+	}
+	resp, err = http.Post(server.URL+"/api/users", "application/json", strings.NewReader(`{"username": "carol", "email": "Carol <Carol@Example.com>"}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to register carol: %v %v", resp.Status, err)
+	}
+	var carol api.NewUserResult
+	json.NewDecoder(resp.Body).Decode(&carol)
+	resp.Body.Close()
+	if carol.User.Email != "Carol@Example.com" {
+		t.Errorf("Expected only the address to be stored, got %q", carol.User.Email)
+	}
+
+	// Anonymous and unknown callers cannot comment.
+	for _, key := range []string{"", "dca_unknown", "not.a.token"} {
+		resp, err := doRequest("POST", server.URL+"/api/comments", key, strings.NewReader(`{"draftId": 1, "text": "Hi"}`))
+		if err != nil {
+			t.Fatalf("Failed to post comment: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Key %q: expected status Unauthorized; got %v", key, resp.Status)
+		}
+	}
+
+	// A userId in the body is ignored in favour of the caller.
+	resp, err = doRequest("POST", server.URL+"/api/comments", alice.Key, strings.NewReader(fmt.Sprintf(`{"draftId": 1, "userId": %d, "text": "Hi"}`, bob.Id)))
+	if err != nil {
+		t.Fatalf("Failed to post comment: %v", err)
+	}
+	resp.Body.Close()
+	var page api.CommentPage
+	getJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1", alice.Key, &page)
+	if len(page.Comments) != 1 || page.Comments[0].UserId != alice.Id {
+		t.Fatalf("Expected the comment to be Alice's, got %+v", page.Comments)
+	}
+
+	// API keys can be exchanged for a bearer token, which also authenticates.
+	resp, err = doRequest("POST", server.URL+"/api/auth/token", alice.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get token: %v", err)
+	}
+	var token api.TokenResult
+	json.NewDecoder(resp.Body).Decode(&token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || token.Token == "" {
+		t.Fatalf("Expected a token, got %v %+v", resp.Status, token)
+	}
+
+	resp, err = doRequest("GET", server.URL+"/api/users/me", token.Token, nil)
+	if err != nil {
+		t.Fatalf("Failed to get current user: %v", err)
+	}
+	var me database.User
+	json.NewDecoder(resp.Body).Decode(&me)
+	resp.Body.Close()
+	if me.Id != alice.Id || me.Username != "alice" {
+		t.Errorf("Expected the token to act as alice, got %+v", me)
+	}
+
+	// Tampered tokens are rejected.
+	tampered := token.Token[:len(token.Token)-2] + "xx"
+	resp, err = doRequest("GET", server.URL+"/api/users/me", tampered, nil)
+	if err != nil {
+		t.Fatalf("Failed to get current user: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for a tampered token; got %v", resp.Status)
+	}
+
+	// Revoked keys stop working, and users cannot revoke each other's keys.
+	resp, err = doRequest("POST", server.URL+"/api/users/me/api-keys", alice.Key, strings.NewReader(`{"name": "ci"}`))
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	var key api.NewAPIKeyResult
+	json.NewDecoder(resp.Body).Decode(&key)
+	resp.Body.Close()
+
+	revokeURL := fmt.Sprintf("%s/api/users/me/api-keys/%d", server.URL, key.Id)
+	for user, status := range map[string]int{bob.Key: http.StatusNotFound, alice.Key: http.StatusNoContent} {
+		resp, err := doRequest("DELETE", revokeURL, user, nil)
+		if err != nil {
+			t.Fatalf("Failed to revoke key: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Expected status %d revoking the key; got %v", status, resp.Status)
+		}
+	}
+	resp, err = doRequest("GET", server.URL+"/api/users/me", key.Key, nil)
+	if err != nil {
+		t.Fatalf("Failed to get current user: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be rejected; got %v", resp.Status)
+	}
+}
+
+func TestDocumentPermissions(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	if _, err := createDraft(server.URL, alice.Key, "Private Plan", "Secret content"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	if _, _, err := createComment(server.URL, 1, alice.Key, "Note to self"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	// Both work in alice's workspace.
+	workspace := alice.WorkspaceId
+	expectStatus := func(method, path, key, body string, status int) {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, workspace, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %v", method, path, status, resp.Status)
+		}
+	}
+	expectStatus("PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+
+	// Documents bob holds no role on look like they do not exist.
+	for _, path := range []string{
+		"/api/drafts/1",
+		"/api/drafts/1/anchors",
+		"/api/drafts/comments-reactions?draftId=1",
+		"/api/documents/1",
+		"/api/documents/1/diff?from=1",
+		"/api/documents/1/blame",
+		"/api/documents/1/history",
+		"/api/documents/1/permissions",
+	} {
+		expectStatus("GET", path, bob.Key, "", http.StatusNotFound)
+		expectStatus("GET", path, "", "", http.StatusUnauthorized)
+	}
+	expectStatus("POST", "/api/comments", bob.Key, `{"draftId": 1, "text": "Hi"}`, http.StatusNotFound)
+	expectStatus("POST", "/api/comments/1/resolve", bob.Key, "", http.StatusNotFound)
+	expectStatus("POST", "/api/comment/1/reaction", bob.Key, `{"emoji": "👍"}`, http.StatusNotFound)
+	expectStatus("POST", "/api/drafts", bob.Key, `{"name": "Private Plan", "content": "Mine now"}`, http.StatusForbidden)
+	expectStatus("POST", "/api/drafts", bob.Key, `{"name": "Private Plan", "content": "Mine now", "baseVersion": 0}`, http.StatusForbidden)
+
+	var documents []database.Document
+	getWorkspaceJSON(t, server.URL+"/api/documents/latest", bob.Key, workspace, &documents)
+	var drafts []database.Draft
+	getWorkspaceJSON(t, server.URL+"/api/drafts", bob.Key, workspace, &drafts)
+	var results []database.SearchResult
+	getWorkspaceJSON(t, server.URL+"/api/drafts/search?text=secret", bob.Key, workspace, &results)
+	if len(documents) != 0 || len(drafts) != 0 || len(results) != 0 {
+		t.Errorf("Expected bob to see nothing, got %v, %v and %v", documents, drafts, results)
+	}
+
+	// Viewers can read but not comment or edit, and cannot share.
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "viewer"}`, http.StatusOK)
+	expectStatus("GET", "/api/drafts/1", bob.Key, "", http.StatusOK)
+	expectStatus("POST", "/api/comments", bob.Key, `{"draftId": 1, "text": "Hi"}`, http.StatusForbidden)
+	expectStatus("POST", "/api/drafts", bob.Key, `{"name": "Private Plan", "content": "Mine now"}`, http.StatusForbidden)
+	expectStatus("PUT", "/api/documents/1/permissions", bob.Key, `{"username": "bob", "role": "owner"}`, http.StatusForbidden)
+	getWorkspaceJSON(t, server.URL+"/api/drafts/search?text=secret", bob.Key, workspace, &results)
+	if len(results) != 1 {
+		t.Errorf("Expected bob to find the shared draft, got %v", results)
+	}
+
+	// A group role adds to the direct one.
+	resp, err := doRequest("POST", server.URL+"/api/groups", alice.Key, strings.NewReader(`{"name": "reviewers"}`))
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	var group api.GroupWithMembers
+	json.NewDecoder(resp.Body).Decode(&group)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(group.Members) != 1 {
+		t.Fatalf("Expected the group to be created with alice in it, got %v %+v", resp.Status, group)
+	}
+	groupPath := fmt.Sprintf("/api/groups/%d", group.Id)
+	expectStatus("GET", groupPath, bob.Key, "", http.StatusNotFound)
+	expectStatus("POST", groupPath+"/members", alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+	expectStatus("POST", groupPath+"/members", bob.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusForbidden)
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, fmt.Sprintf(`{"groupId": %d, "role": "commenter"}`, group.Id), http.StatusOK)
+	expectStatus("POST", "/api/comments", bob.Key, `{"draftId": 1, "parentCommentId": 1, "text": "Hi"}`, http.StatusCreated)
+	expectStatus("POST", "/api/comment/1/reaction", bob.Key, `{"emoji": "👍"}`, http.StatusCreated)
+
+	var permissions []database.DocumentPermission
+	getWorkspaceJSON(t, server.URL+"/api/documents/1/permissions", bob.Key, workspace, &permissions)
+	if len(permissions) != 3 {
+		t.Errorf("Expected alice, bob and the group, got %+v", permissions)
+	}
+
+	// The last owner cannot leave; others can give up their own access.
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, fmt.Sprintf(`{"userId": %d, "role": "editor"}`, alice.Id), http.StatusConflict)
+	expectStatus("DELETE", fmt.Sprintf("/api/documents/1/permissions/user/%d", alice.Id), alice.Key, "", http.StatusConflict)
+	expectStatus("DELETE", fmt.Sprintf("/api/documents/1/permissions/user/%d", bob.Id), bob.Key, "", http.StatusNoContent)
+	expectStatus("DELETE", fmt.Sprintf("/api/documents/1/permissions/group/%d", group.Id), bob.Key, "", http.StatusForbidden)
+	expectStatus("DELETE", fmt.Sprintf("/api/documents/1/permissions/group/%d", group.Id), alice.Key, "", http.StatusNoContent)
+	expectStatus("GET", "/api/drafts/1", bob.Key, "", http.StatusNotFound)
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	eve := registerUser(t, server.URL, "eve")
+	if alice.WorkspaceId == eve.WorkspaceId {
+		t.Fatalf("Expected everyone to get their own workspace")
+	}
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "Secret content"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	if _, _, err := createComment(server.URL, 1, alice.Key, "Secret note for @eve"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	resp, err := doRequest("POST", server.URL+"/api/groups", alice.Key, strings.NewReader(`{"name": "reviewers"}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to create group: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	resp, err = doRequest("POST", server.URL+"/api/webhooks", alice.Key, strings.NewReader(`{"url": "http://ci.example/hook"}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to create webhook: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+
+	// noSecrets - Fails if a listing in eve's workspace shows anything of alice's.
+	noSecrets := func(t *testing.T, body []byte) {
+		if strings.Contains(string(body), "Secret") || strings.Contains(string(body), `"alice"`) {
+			t.Errorf("Expected nothing of alice's, got %s", body)
+		}
+	}
+
+	// Every route, called by eve against alice's resources from her own workspace.
+	cases := []struct {
+		method, template, path, body string
+		status                       int
+		check                        func(t *testing.T, body []byte)
+	}{
+		{"GET", "/api/users/me", "/api/users/me", "", http.StatusOK, noSecrets},
+		{"GET", "/api/users/me/api-keys", "/api/users/me/api-keys", "", http.StatusOK, nil},
+		{"POST", "/api/users/me/api-keys", "/api/users/me/api-keys", `{"name": "ci"}`, http.StatusCreated, nil},
+		{"DELETE", "/api/users/me/api-keys/{keyId:[0-9]+}", "/api/users/me/api-keys/1", "", http.StatusNotFound, nil},
+		{"GET", "/api/users/me/notification-settings", "/api/users/me/notification-settings", "", http.StatusOK, nil},
+		{"PUT", "/api/users/me/notification-settings", "/api/users/me/notification-settings", `{"digestFrequency": "hourly"}`, http.StatusOK, nil},
+		{"POST", "/api/auth/token", "/api/auth/token", "", http.StatusOK, nil},
+		{"POST", "/api/workspaces", "/api/workspaces", `{"name": "side project"}`, http.StatusCreated, nil},
+		{"GET", "/api/workspaces", "/api/workspaces", "", http.StatusOK, noSecrets},
+		{"GET", "/api/workspaces/{workspaceId:[0-9]+}/members", fmt.Sprintf("/api/workspaces/%d/members", alice.WorkspaceId), "", http.StatusNotFound, nil},
+		{"PUT", "/api/workspaces/{workspaceId:[0-9]+}/members", fmt.Sprintf("/api/workspaces/%d/members", alice.WorkspaceId), fmt.Sprintf(`{"userId": %d, "role": "admin"}`, eve.Id), http.StatusNotFound, nil},
+		{"DELETE", "/api/workspaces/{workspaceId:[0-9]+}/members/{userId:[0-9]+}", fmt.Sprintf("/api/workspaces/%d/members/%d", alice.WorkspaceId, alice.Id), "", http.StatusNotFound, nil},
+		{"POST", "/api/groups", "/api/groups", `{"name": "reviewers"}`, http.StatusCreated, nil},
+		{"GET", "/api/groups/{groupId:[0-9]+}", "/api/groups/1", "", http.StatusNotFound, nil},
+		{"POST", "/api/groups/{groupId:[0-9]+}/members", "/api/groups/1/members", fmt.Sprintf(`{"userId": %d}`, eve.Id), http.StatusNotFound, nil},
+		{"DELETE", "/api/groups/{groupId:[0-9]+}/members/{userId:[0-9]+}", fmt.Sprintf("/api/groups/1/members/%d", alice.Id), "", http.StatusNotFound, nil},
+		{"POST", "/api/drafts", "/api/drafts", `{"name": "Plan", "content": "Eve's plan", "baseVersion": 0}`, http.StatusOK, nil},
+		{"GET", "/api/drafts", "/api/drafts", "", http.StatusOK, noSecrets},
+		{"GET", "/api/drafts/search", "/api/drafts/search?text=secret", "", http.StatusOK, noSecrets},
+		{"GET", "/api/drafts/comments-reactions", "/api/drafts/comments-reactions?draftId=1", "", http.StatusNotFound, nil},
+		{"GET", "/api/drafts/{draftId:[0-9]+}", "/api/drafts/1", "", http.StatusNotFound, nil},
+		{"GET", "/api/drafts/{draftId:[0-9]+}/anchors", "/api/drafts/1/anchors", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/latest", "/api/documents/latest", "", http.StatusOK, noSecrets},
+		{"GET", "/api/documents/{documentId:[0-9]+}", "/api/documents/1", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/diff", "/api/documents/1/diff?from=1", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/blame", "/api/documents/1/blame", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/history", "/api/documents/1/history", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/permissions", "/api/documents/1/permissions", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/watchers", "/api/documents/1/watchers", "", http.StatusNotFound, nil},
+		{"PUT", "/api/documents/{documentId:[0-9]+}/watch", "/api/documents/1/watch", "", http.StatusNotFound, nil},
+		{"DELETE", "/api/documents/{documentId:[0-9]+}/watch", "/api/documents/1/watch", "", http.StatusNotFound, nil},
+		{"PUT", "/api/documents/{documentId:[0-9]+}/permissions", "/api/documents/1/permissions", fmt.Sprintf(`{"userId": %d, "role": "owner"}`, eve.Id), http.StatusNotFound, nil},
+		{"DELETE", "/api/documents/{documentId:[0-9]+}/permissions/{principalType:user|group}/{principalId:[0-9]+}", fmt.Sprintf("/api/documents/1/permissions/user/%d", alice.Id), "", http.StatusNotFound, nil},
+		{"POST", "/api/comments", "/api/comments", `{"draftId": 1, "text": "Hi"}`, http.StatusNotFound, nil},
+		{"POST", "/api/inbound/email", "/api/inbound/email", "From: eve@example.com\r\n\r\nHi", http.StatusNotFound, nil},
+		{"POST", "/api/comments/{commentId:[0-9]+}/resolve", "/api/comments/1/resolve", "", http.StatusNotFound, nil},
+		{"POST", "/api/comments/{commentId:[0-9]+}/reject", "/api/comments/1/reject", "", http.StatusNotFound, nil},
+		{"POST", "/api/comments/{commentId:[0-9]+}/reopen", "/api/comments/1/reopen", "", http.StatusNotFound, nil},
+		{"POST", "/api/comment/{commentId}/reaction", "/api/comment/1/reaction", `{"emoji": "👍"}`, http.StatusNotFound, nil},
+		{"POST", "/api/comment/{commentId}/reaction/toggle", "/api/comment/1/reaction/toggle", `{"emoji": "👍"}`, http.StatusNotFound, nil},
+		{"DELETE", "/api/comment/{commentId}/reaction/{emoji}", "/api/comment/1/reaction/" + url.PathEscape("👍"), "", http.StatusNotFound, nil},
+		{"POST", "/api/webhooks", "/api/webhooks", `{"url": "http://eve.example/hook", "documentId": 1}`, http.StatusBadRequest, nil},
+		{"GET", "/api/webhooks", "/api/webhooks", "", http.StatusOK, noSecrets},
+		{"DELETE", "/api/webhooks/{webhookId:[0-9]+}", "/api/webhooks/1", "", http.StatusNotFound, nil},
+		{"GET", "/api/webhooks/{webhookId:[0-9]+}/deliveries", "/api/webhooks/1/deliveries", "", http.StatusNotFound, nil},
+		{"POST", "/api/webhooks/{webhookId:[0-9]+}/deliveries/{deliveryId:[0-9]+}/redeliver", "/api/webhooks/1/deliveries/1/redeliver", "", http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/branches", "/api/documents/1/branches", `{"name": "fork"}`, http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/branches", "/api/documents/1/branches", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}", "/api/documents/1/branches/fork", "", http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}/drafts", "/api/documents/1/branches/fork/drafts", `{"content": "x"}`, http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}/merge", "/api/documents/1/branches/fork/merge", "", http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/suggestions/accept", "/api/documents/1/suggestions/accept", `{"commentIds": [1]}`, http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/restore", "/api/documents/1/restore", `{"version": 1}`, http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/publish", "/api/documents/1/publish", `{"version": 1}`, http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/unpublish", "/api/documents/1/unpublish", "", http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/workflow", "/api/documents/1/workflow", "", http.StatusNotFound, nil},
+		{"PUT", "/api/documents/{documentId:[0-9]+}/workflow", "/api/documents/1/workflow", `{"states": ["open"], "initial": "open"}`, http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/approvals", "/api/documents/1/approvals", `{"version": 1}`, http.StatusNotFound, nil},
+		{"DELETE", "/api/documents/{documentId:[0-9]+}/approvals/{version:[0-9]+}", "/api/documents/1/approvals/1", "", http.StatusNotFound, nil},
+		{"POST", "/api/documents/{documentId:[0-9]+}/transitions", "/api/documents/1/transitions", `{"to": "in_review"}`, http.StatusNotFound, nil},
+		{"GET", "/api/documents/{documentId:[0-9]+}/transitions", "/api/documents/1/transitions", "", http.StatusNotFound, nil},
+		{"GET", "/api/notifications", "/api/notifications", "", http.StatusOK, noSecrets},
+		{"GET", "/api/notifications/unread-count", "/api/notifications/unread-count", "", http.StatusOK, nil},
+		{"POST", "/api/notifications/read", "/api/notifications/read", "", http.StatusOK, nil},
+		{"GET", "/api/events", "/api/events?documentId=1", "", http.StatusNotFound, nil},
+		{"GET", "/api/events/ws", "/api/events/ws?documentId=1", "", http.StatusNotFound, nil},
+	}
+
+	covered := map[string]bool{"POST /api/users": true} // Registration is not scoped to anyone
+	for _, tc := range cases {
+		covered[tc.method+" "+tc.template] = true
+		resp, err := doRequest(tc.method, server.URL+tc.path, eve.Key, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", tc.method, tc.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status %d, got %v: %s", tc.method, tc.path, tc.status, resp.Status, body)
+			continue
+		}
+		if tc.check != nil {
+			tc.check(t, body)
+		}
+	}
+
+	// A route added without a cross-tenant case fails here.
+	apiService.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if !covered[method+" "+template] {
+				t.Errorf("No cross-workspace case for %s %s", method, template)
+			}
+		}
+		return nil
+	})
+
+	// Naming another tenant's workspace is the same as naming one that does not exist.
+	for _, workspaceId := range []int{alice.WorkspaceId, 999} {
+		resp, err := doWorkspaceRequest("GET", server.URL+"/api/drafts", eve.Key, workspaceId, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected workspace %d to be not found for eve, got %v", workspaceId, resp.Status)
+		}
+	}
+
+	// Sharing and groups stop at the workspace boundary.
+	resp, err = doRequest("PUT", server.URL+"/api/documents/1/permissions", alice.Key, strings.NewReader(`{"username": "eve", "role": "viewer"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected sharing with an outsider to be refused, got %v", resp.Status)
+	}
+
+	// Members lose access to everything once they leave, whatever roles they were given.
+	membersPath := fmt.Sprintf("%s/api/workspaces/%d/members", server.URL, alice.WorkspaceId)
+	resp, err = doRequest("PUT", membersPath, alice.Key, strings.NewReader(`{"username": "eve"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to add eve to alice's workspace: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	resp, err = doRequest("PUT", server.URL+"/api/documents/1/permissions", alice.Key, strings.NewReader(`{"username": "eve", "role": "editor"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to share with eve: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	var draft database.Draft
+	getWorkspaceJSON(t, server.URL+"/api/drafts/1", eve.Key, alice.WorkspaceId, &draft)
+	if draft.Content != "Secret content" {
+		t.Errorf("Expected eve to read the shared draft, got %+v", draft)
+	}
+
+	resp, err = doRequest("DELETE", fmt.Sprintf("%s/%d", membersPath, alice.Id), alice.Key, nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected the last admin not to be removable, got %v", resp.Status)
+	}
+	resp, err = doRequest("DELETE", fmt.Sprintf("%s/%d", membersPath, eve.Id), alice.Key, nil)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Failed to remove eve: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	for _, workspaceId := range []int{alice.WorkspaceId, 0} {
+		resp, err := doWorkspaceRequest("GET", server.URL+"/api/drafts/1", eve.Key, workspaceId, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected eve to lose access after leaving, got %v", resp.Status)
+		}
+	}
+}
+
+// sseEvent - One frame read from an event stream.
+type sseEvent struct {
+	id, event string
+	data      database.Event
+}
+
+// openEvents - Opens an SSE stream as the holder of apiKey, resuming after lastEventId unless it is empty.
+func openEvents(t *testing.T, url, apiKey string, workspaceId int, lastEventId string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if workspaceId != 0 {
+		req.Header.Set("X-Workspace-Id", strconv.Itoa(workspaceId))
+	}
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("Expected an event stream, got %v %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readEvent - Reads the next event from a stream, skipping keepalive comments.
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var frame sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && frame.id != "":
+			return frame
+		case strings.HasPrefix(line, "id: "):
+			frame.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			frame.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame.data); err != nil {
+				t.Fatalf("Failed to decode event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	resp, err := doRequest("PUT", fmt.Sprintf("%s/api/workspaces/%d/members", server.URL, alice.WorkspaceId), alice.Key, strings.NewReader(`{"username": "bob"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to add bob: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+
+	// Without Last-Event-ID only new events arrive.
+	stream, events := openEvents(t, server.URL+"/api/events?documentId=1", alice.Key, 0, "")
+	// Bob watches the whole workspace but has no role on the document yet.
+	bobStream, bobEvents := openEvents(t, server.URL+"/api/events", bob.Key, alice.WorkspaceId, "")
+	defer bobStream.Body.Close()
+
+	_, commentId, err := createComment(server.URL, 1, alice.Key, "Looks good")
+	if err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	event := readEvent(t, events)
+	if event.event != database.EventCommentAdded || event.data.DraftId != 1 || event.data.DocumentId != 1 {
+		t.Fatalf("Expected the comment to be announced, got %+v", event)
+	}
+	var comment database.Comment
+	if err := json.Unmarshal(event.data.Data, &comment); err != nil || comment.Id != commentId || comment.Text != "Looks good" {
+		t.Errorf("Expected the comment as the event data, got %s", event.data.Data)
+	}
+	commentEventId := event.id
+
+	resp, err = doRequest("POST", fmt.Sprintf("%s/api/comment/%d/reaction", server.URL, commentId), alice.Key, strings.NewReader(`{"emoji": "👍"}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to add reaction: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	if event = readEvent(t, events); event.event != database.EventReactionAdded {
+		t.Fatalf("Expected the reaction to be announced, got %+v", event)
+	}
+	stream.Body.Close()
+
+	// Events on documents bob cannot see are withheld; sharing lets the next one through.
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "Second"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	resp, err = doRequest("PUT", server.URL+"/api/documents/1/permissions", alice.Key, strings.NewReader(`{"username": "bob", "role": "viewer"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to share with bob: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "Third"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	event = readEvent(t, bobEvents)
+	if event.event != database.EventDraftCreated || event.data.DraftId != 3 {
+		t.Errorf("Expected bob's first event to be the third draft, got %+v", event)
+	}
+
+	// Reconnecting with Last-Event-ID replays what was missed from the log.
+	stream, events = openEvents(t, server.URL+"/api/events?documentId=1", alice.Key, 0, commentEventId)
+	defer stream.Body.Close()
+	var replayed []string
+	for i := 0; i < 3; i++ {
+		replayed = append(replayed, readEvent(t, events).event)
+	}
+	expected := []string{database.EventReactionAdded, database.EventDraftCreated, database.EventDraftCreated}
+	if strings.Join(replayed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v to be replayed, got %v", expected, replayed)
+	}
+
+	for _, lastEventId := range []string{"abc", "-1"} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/events", nil)
+		req.Header.Set("Authorization", "Bearer "+alice.Key)
+		req.Header.Set("Last-Event-ID", lastEventId)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected Last-Event-ID %q to be rejected, got %v", lastEventId, resp.Status)
+		}
+	}
+}
+
+func TestEventWebSocket(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	user := registerUser(t, server.URL, "watcher")
+	if _, err := createDraft(server.URL, user.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+user.Key)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events/ws?documentId=1&lastEventId=0"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var event database.Event
+	if err := conn.ReadJSON(&event); err != nil || event.Type != database.EventDraftCreated || event.DraftId != 1 {
+		t.Fatalf("Expected the draft to be replayed, got %+v %v", event, err)
+	}
+
+	if _, _, err := createComment(server.URL, 1, user.Key, "Live"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if err := conn.ReadJSON(&event); err != nil || event.Type != database.EventCommentAdded || event.Id != 2 {
+		t.Errorf("Expected the comment to arrive live, got %+v %v", event, err)
+	}
+
+	anonymous := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(anonymous, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous clients to be refused before the upgrade, got %v", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	// The receiver keeps every delivery it is sent.
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	deliveries := map[string][]received{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries[r.URL.Path] = append(deliveries[r.URL.Path], received{r.Header, body})
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+	waitFor := func(path string, count int) []received {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			got := append([]received{}, deliveries[path]...)
+			mu.Unlock()
+			if len(got) >= count {
+				return got
+			}
+		}
+		t.Fatalf("Timed out waiting for %d deliveries to %s", count, path)
+		return nil
+	}
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	resp, err := doRequest("PUT", fmt.Sprintf("%s/api/workspaces/%d/members", server.URL, alice.WorkspaceId), alice.Key, strings.NewReader(`{"username": "bob"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to add bob: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+
+	createWebhook := func(apiKey, body string, status int) database.Webhook {
+		t.Helper()
+		resp, err := doWorkspaceRequest("POST", server.URL+"/api/webhooks", apiKey, alice.WorkspaceId, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			message, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status %d creating %s, got %v: %s", status, body, resp.Status, message)
+		}
+		var created database.Webhook
+		json.NewDecoder(resp.Body).Decode(&created)
+		return created
+	}
+	createWebhook(bob.Key, `{"url": "http://ci.example/hook"}`, http.StatusForbidden)
+	createWebhook(alice.Key, `{"url": "ftp://ci.example/hook"}`, http.StatusBadRequest)
+	createWebhook(alice.Key, `{"url": "http://ci.example/hook", "eventTypes": ["draft.deleted"]}`, http.StatusBadRequest)
+	createWebhook(alice.Key, `{"url": "http://ci.example/hook", "documentId": 99}`, http.StatusBadRequest)
+
+	// Bob's own document is hidden from alice, so she cannot watch it.
+	resp, err = doWorkspaceRequest("POST", server.URL+"/api/drafts", bob.Key, alice.WorkspaceId, strings.NewReader(`{"name": "Private", "content": "Secret"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create bob's draft: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	createWebhook(alice.Key, `{"url": "http://ci.example/hook", "documentId": 2}`, http.StatusBadRequest)
+
+	// Addresses inside the network are refused unless the server allows them, as for this receiver.
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8080/admin", "http://10.1.2.3/hook", "http://[::1]/hook", receiver.URL} {
+		createWebhook(alice.Key, fmt.Sprintf(`{"url": %q}`, target), http.StatusBadRequest)
+	}
+	apiService.AllowPrivateWebhooks = true
+
+	chat := createWebhook(alice.Key, fmt.Sprintf(`{"url": "%s/chat", "eventTypes": ["comment.added"], "documentId": 1}`, receiver.URL), http.StatusCreated)
+	if !strings.HasPrefix(chat.Secret, webhook.SecretPrefix) {
+		t.Errorf("Expected a generated secret, got %+v", chat)
+	}
+	ci := createWebhook(alice.Key, fmt.Sprintf(`{"url": "%s/ci", "secret": "shh"}`, receiver.URL), http.StatusCreated)
+
+	var webhooks []database.Webhook
+	getJSON(t, server.URL+"/api/webhooks", alice.Key, &webhooks)
+	if len(webhooks) != 2 || webhooks[0].Secret != "" || webhooks[1].Secret != "" {
+		t.Errorf("Expected both webhooks without their secrets, got %+v", webhooks)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcher := &webhook.Dispatcher{Store: apiService.Store, PollInterval: 10 * time.Millisecond, AllowPrivate: true}
+	go dispatcher.Run(ctx)
+
+	resp, err = doWorkspaceRequest("POST", server.URL+"/api/drafts", bob.Key, alice.WorkspaceId, strings.NewReader(`{"name": "Private", "content": "Hidden from the webhooks"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to update bob's draft: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	if _, _, err := createComment(server.URL, 1, alice.Key, "Ship it"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "Second"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	chatDeliveries := waitFor("/chat", 1)
+	if chatDeliveries[0].header.Get(webhook.EventHeader) != database.EventCommentAdded || !webhook.Verify(chat.Secret, chatDeliveries[0].body, chatDeliveries[0].header.Get(webhook.SignatureHeader)) {
+		t.Errorf("Expected a signed comment.added delivery, got %v %s", chatDeliveries[0].header, chatDeliveries[0].body)
+	}
+	ciDeliveries := waitFor("/ci", 2)
+	var events []string
+	for _, delivery := range ciDeliveries {
+		if !webhook.Verify("shh", delivery.body, delivery.header.Get(webhook.SignatureHeader)) {
+			t.Errorf("Expected a valid signature on %s", delivery.body)
+		}
+		var event database.Event
+		json.Unmarshal(delivery.body, &event)
+		events = append(events, event.Type)
+	}
+	sort.Strings(events) // Workers deliver in parallel
+	if strings.Join(events, ",") != database.EventCommentAdded+","+database.EventDraftCreated {
+		t.Errorf("Expected the comment and the draft, got %v", events)
+	}
+
+	// The delivery log shows the outcome and allows sending again.
+	deliveriesPath := fmt.Sprintf("%s/api/webhooks/%d/deliveries", server.URL, chat.Id)
+	var logged []database.WebhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		getJSON(t, deliveriesPath, alice.Key, &logged)
+		if len(logged) == 1 && logged[0].Status == database.DeliveryDelivered {
+			break
+		}
+	}
+	if len(logged) != 1 || logged[0].Status != database.DeliveryDelivered || logged[0].Attempts != 1 || logged[0].ResponseStatus != http.StatusOK {
+		t.Fatalf("Expected one successful delivery, got %+v", logged)
+	}
+
+	resp, err = doRequest("POST", fmt.Sprintf("%s/%d/redeliver", deliveriesPath, logged[0].Id), alice.Key, nil)
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Failed to redeliver: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	chatDeliveries = waitFor("/chat", 2)
+	if string(chatDeliveries[1].body) != string(chatDeliveries[0].body) || chatDeliveries[1].header.Get(webhook.DeliveryHeader) == chatDeliveries[0].header.Get(webhook.DeliveryHeader) {
+		t.Errorf("Expected the same event as a new delivery, got %v", chatDeliveries[1].header)
+	}
+
+	for _, tc := range []struct {
+		method, path, apiKey string
+		status               int
+	}{
+		{"POST", fmt.Sprintf("%s/999/redeliver", deliveriesPath), alice.Key, http.StatusNotFound},
+		{"GET", deliveriesPath, bob.Key, http.StatusForbidden},
+		{"DELETE", fmt.Sprintf("%s/api/webhooks/%d", server.URL, ci.Id), bob.Key, http.StatusForbidden},
+		{"DELETE", fmt.Sprintf("%s/api/webhooks/%d", server.URL, ci.Id), alice.Key, http.StatusNoContent},
+		{"GET", fmt.Sprintf("%s/api/webhooks/%d/deliveries", server.URL, ci.Id), alice.Key, http.StatusNotFound},
+	} {
+		resp, err := doWorkspaceRequest(tc.method, tc.path, tc.apiKey, alice.WorkspaceId, nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status %d, got %v", tc.method, tc.path, tc.status, resp.Status)
+		}
+	}
+}
+
+func TestDocumentWorkflow(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	carol := registerUser(t, server.URL, "carol")
+	if _, err := createDraft(server.URL, alice.Key, "Spec", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	workspace := alice.WorkspaceId
+	expectStatus := func(method, path, key, body string, status int) {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, workspace, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %v", method, path, status, resp.Status)
+		}
+	}
+	expectStatus("PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "commenter"}`, http.StatusOK)
+
+	var status api.WorkflowStatus
+	getWorkspaceJSON(t, server.URL+"/api/documents/1/workflow", bob.Key, workspace, &status)
+	if status.State != database.StateDraft || status.LatestVersion != 1 || len(status.Approvals) != 0 {
+		t.Errorf("Expected the default workflow in draft at version 1, got %+v", status)
+	}
+
+	// Only the owner configures the workflow, and approvers must belong to the workspace.
+	expectStatus("PUT", "/api/documents/1/workflow", bob.Key, `{"states": ["open"], "initial": "open"}`, http.StatusForbidden)
+	expectStatus("PUT", "/api/documents/1/workflow", alice.Key, `{"states": ["open"], "initial": "closed"}`, http.StatusBadRequest)
+	expectStatus("PUT", "/api/documents/1/workflow", alice.Key, fmt.Sprintf(`{"states": ["draft", "in_review", "approved"], "initial": "draft", "requiredApprovers": [%d]}`, carol.Id), http.StatusBadRequest)
+	workflow := fmt.Sprintf(`{
+		"states": ["draft", "in_review", "approved"], "initial": "draft", "returnState": "in_review", "requiredApprovers": [%d],
+		"transitions": [
+			{"from": "draft", "to": "in_review", "role": "commenter"},
+			{"from": "in_review", "to": "approved", "requiresApproval": true}
+		]}`, bob.Id)
+	expectStatus("PUT", "/api/documents/1/workflow", alice.Key, workflow, http.StatusOK)
+
+	expectStatus("POST", "/api/documents/1/transitions", bob.Key, `{"to": "approved"}`, http.StatusConflict)
+	expectStatus("POST", "/api/documents/1/transitions", bob.Key, `{"to": "in_review", "reason": "Please review"}`, http.StatusCreated)
+	expectStatus("POST", "/api/documents/1/transitions", bob.Key, `{"to": "approved"}`, http.StatusForbidden)
+	expectStatus("POST", "/api/documents/1/transitions", alice.Key, `{"to": "approved"}`, http.StatusConflict)
+	expectStatus("POST", "/api/documents/1/approvals", bob.Key, `{"version": 2}`, http.StatusConflict)
+	expectStatus("POST", "/api/documents/1/approvals", alice.Key, `{"version": 1}`, http.StatusForbidden)
+	expectStatus("POST", "/api/documents/1/approvals", bob.Key, `{"version": 1}`, http.StatusCreated)
+	expectStatus("POST", "/api/documents/1/transitions", alice.Key, `{"to": "approved"}`, http.StatusCreated)
+
+	// A new draft sends the document back to review with the approval invalidated.
+	if _, err := createDraft(server.URL, alice.Key, "Spec", "Second"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	getWorkspaceJSON(t, server.URL+"/api/documents/1/workflow", bob.Key, workspace, &status)
+	if status.State != database.StateInReview || status.LatestVersion != 2 || len(status.Approvals) != 0 {
+		t.Errorf("Expected the document back in review with no approvals of version 2, got %+v", status)
+	}
+	expectStatus("DELETE", "/api/documents/1/approvals/2", bob.Key, "", http.StatusNotFound)
+	expectStatus("DELETE", "/api/documents/1/approvals/1", bob.Key, "", http.StatusNoContent)
+
+	var transitions []database.Transition
+	getWorkspaceJSON(t, server.URL+"/api/documents/1/transitions", bob.Key, workspace, &transitions)
+	if len(transitions) != 5 || transitions[0].Reason != "Please review" || transitions[0].UserId != bob.Id ||
+		transitions[1].Reason != database.ReasonApproved || transitions[1].UserId != bob.Id || transitions[2].To != database.StateApproved ||
+		transitions[3].Reason != database.ReasonNewDraft || transitions[4].Reason != database.ReasonApprovalWithdrawn {
+		t.Errorf("Expected the audit trail of the transitions, the approval and its withdrawal, got %+v", transitions)
+	}
+}
+
+func TestPublishing(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	if _, err := createDraft(server.URL, alice.Key, "Handbook", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	workspace := alice.WorkspaceId
+	expectStatus := func(method, path, key, body string, status int) *http.Response {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, workspace, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %v", method, path, status, resp.Status)
+		}
+		return resp
+	}
+	expectStatus("PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "commenter"}`, http.StatusOK)
+
+	// A version nobody approved cannot be published.
+	expectStatus("POST", "/api/documents/1/publish", alice.Key, `{"version": 1}`, http.StatusConflict)
+	expectStatus("POST", "/api/documents/1/approvals", bob.Key, `{"version": 1}`, http.StatusCreated)
+	if _, err := createDraft(server.URL, alice.Key, "Handbook", "Second"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	published := func(key string) (string, []database.Document) {
+		t.Helper()
+		var document api.DocumentWithHead
+		getWorkspaceJSON(t, server.URL+"/api/documents/1", key, workspace, &document)
+		var documents []database.Document
+		getWorkspaceJSON(t, server.URL+"/api/documents/latest", key, workspace, &documents)
+		if document.Head == nil {
+			return "", documents
+		}
+		return document.Head.Content, documents
+	}
+
+	// Nothing is shown by default until it is published, but the latest draft can be asked for.
+	if content, documents := published(bob.Key); content != "" || len(documents) != 0 {
+		t.Errorf("Expected nothing published, got %q and %+v", content, documents)
+	}
+	if resp := expectStatus("GET", "/api/documents/1", bob.Key, "", http.StatusOK); resp.Header.Get("ETag") != "" {
+		t.Errorf("Expected no ETag while unpublished, got %q", resp.Header.Get("ETag"))
+	}
+	var latest api.DocumentWithHead
+	getWorkspaceJSON(t, server.URL+"/api/documents/1?draft=latest", bob.Key, workspace, &latest)
+	if latest.Head == nil || latest.Head.Content != "Second" {
+		t.Errorf("Expected the latest draft, got %+v", latest.Head)
+	}
+	expectStatus("GET", "/api/documents/1?draft=newest", bob.Key, "", http.StatusBadRequest)
+
+	expectStatus("POST", "/api/documents/1/publish", bob.Key, "", http.StatusForbidden)
+	expectStatus("POST", "/api/documents/1/publish", alice.Key, `{"version": 3}`, http.StatusBadRequest)
+	expectStatus("POST", "/api/documents/1/publish", alice.Key, `{"version": 1}`, http.StatusOK)
+	if content, documents := published(bob.Key); content != "First" || len(documents) != 1 || documents[0].PublishedVersion != 1 {
+		t.Errorf("Expected version 1 published, got %q and %+v", content, documents)
+	}
+	if resp := expectStatus("GET", "/api/documents/1", bob.Key, "", http.StatusOK); resp.Header.Get("ETag") != `"1-1"` {
+		t.Errorf("Expected the published version's ETag, got %q", resp.Header.Get("ETag"))
+	}
+
+	// A future publishAt schedules the latest version, once approved, for the scheduler to publish.
+	publishAt := time.Now().Add(time.Hour).UTC()
+	expectStatus("POST", "/api/documents/1/publish", alice.Key, fmt.Sprintf(`{"publishAt": %q}`, publishAt.Format(time.RFC3339)), http.StatusConflict)
+	expectStatus("POST", "/api/documents/1/approvals", bob.Key, `{"version": 2}`, http.StatusCreated)
+	resp, err := doWorkspaceRequest("POST", server.URL+"/api/documents/1/publish", alice.Key, workspace,
+		strings.NewReader(fmt.Sprintf(`{"publishAt": %q}`, publishAt.Format(time.RFC3339))))
+	if err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	var scheduled database.Document
+	json.NewDecoder(resp.Body).Decode(&scheduled)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || scheduled.ScheduledVersion != 2 || scheduled.PublishedVersion != 1 {
+		t.Errorf("Expected version 2 to be scheduled, got %v %+v", resp.Status, scheduled)
+	}
+	scheduler := &publish.Scheduler{Store: apiService.Store, Now: time.Now}
+	if count := scheduler.PublishDue(); count != 0 {
+		t.Errorf("Expected nothing due yet, published %d", count)
+	}
+	scheduler.Now = func() time.Time { return publishAt.Add(time.Minute) }
+	if count := scheduler.PublishDue(); count != 1 {
+		t.Errorf("Expected the scheduled version to be published, published %d", count)
+	}
+	if content, _ := published(bob.Key); content != "Second" {
+		t.Errorf("Expected version 2 published, got %q", content)
+	}
+
+	expectStatus("POST", "/api/documents/1/unpublish", bob.Key, "", http.StatusForbidden)
+	expectStatus("POST", "/api/documents/1/unpublish", alice.Key, "", http.StatusOK)
+	if content, documents := published(bob.Key); content != "" || len(documents) != 0 {
+		t.Errorf("Expected the document to be unpublished, got %q and %+v", content, documents)
+	}
+}
+
+func TestRestoreDocument(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+	for _, content := range []string{"Good version", "Bad version"} {
+		if _, err := createDraft(server.URL, owner.Key, "Policy", content); err != nil {
+			t.Fatalf("Failed to create draft: %v", err)
+		}
+	}
+	if _, _, err := createComment(server.URL, 1, owner.Key, "Looks good"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	restore := func(body string, headers map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+"/api/documents/1/restore", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+owner.Key)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+		return resp
+	}
+
+	resp := restore(`{"version": 1, "carryComments": true}`, nil)
+	var restored database.Draft
+	json.NewDecoder(resp.Body).Decode(&restored)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || restored.VersionNumber != 3 || restored.RestoredFromVersion != 1 || restored.Content != "Good version" {
+		t.Fatalf("Expected version 3 restored from version 1, got %v %+v", resp.Status, restored)
+	}
+	if resp.Header.Get("ETag") != `"1-3"` || resp.Header.Get("Location") != fmt.Sprintf("/api/drafts/%d", restored.Id) {
+		t.Errorf("Expected the new draft's ETag and Location, got %q and %q", resp.Header.Get("ETag"), resp.Header.Get("Location"))
+	}
+
+	var page api.CommentPage
+	getJSON(t, fmt.Sprintf("%s/api/drafts/comments-reactions?draftId=%d", server.URL, restored.Id), owner.Key, &page)
+	if len(page.Comments) != 1 || page.Comments[0].Text != "Looks good" {
+		t.Errorf("Expected the restored version's comment, got %+v", page.Comments)
+	}
+
+	for body, status := range map[string]int{
+		`{"version": 9}`:                    http.StatusBadRequest,
+		`{"version": 1, "baseVersion": 2}`:  http.StatusConflict,
+		`{"version": 1, "baseVersion": -1}`: http.StatusBadRequest,
+	} {
+		if resp := restore(body, nil); resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %v", body, status, resp.Status)
+		}
+	}
+	if resp := restore(`{"version": 2}`, map[string]string{"If-Match": `"1-2"`}); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a stale If-Match to conflict, got %v", resp.Status)
+	}
+	if resp := restore(`{"version": 2}`, map[string]string{"If-Match": `"1-3"`}); resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected a current If-Match to restore, got %v", resp.Status)
+	}
+}
+
+func TestDocumentBranches(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	owner := registerUser(t, server.URL, "owner")
+	if _, err := createDraft(server.URL, owner.Key, "Plan", `title\nintro\nbody\noutro\n`); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := doRequest("POST", server.URL+path, owner.Key, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to POST %s: %v", path, err)
+		}
+		return resp
+	}
+	expectStatus := func(resp *http.Response, status int, what string) {
+		t.Helper()
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %v", what, status, resp.Status)
+		}
+	}
+
+	expectStatus(post("/api/documents/1/branches", `{"name": "rewrite"}`), http.StatusCreated, "create branch")
+	expectStatus(post("/api/documents/1/branches", `{"name": "rewrite"}`), http.StatusConflict, "duplicate branch")
+	expectStatus(post("/api/documents/1/branches", `{"name": "bad name"}`), http.StatusBadRequest, "invalid name")
+	expectStatus(post("/api/documents/1/branches", `{"name": "old", "fromVersion": 5}`), http.StatusBadRequest, "missing version")
+	expectStatus(post("/api/documents/1/branches/rewrite/merge", ""), http.StatusBadRequest, "merge without changes")
+	expectStatus(post("/api/documents/1/branches/missing/drafts", `{"content": "x"}`), http.StatusNotFound, "missing branch")
+
+	// The branch and the document change lines apart from each other, which merges cleanly.
+	expectStatus(post("/api/documents/1/branches/rewrite/drafts", `{"content": "title\nintro\nbetter body\noutro\n"}`), http.StatusCreated, "branch draft")
+	if _, err := createDraft(server.URL, owner.Key, "Plan", `Title\nintro\nbody\noutro\n`); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	var branch api.BranchWithDrafts
+	getJSON(t, server.URL+"/api/documents/1/branches/rewrite", owner.Key, &branch)
+	if branch.BaseVersion != 1 || branch.HeadVersion != 1 || len(branch.Drafts) != 1 {
+		t.Fatalf("Expected the branch forked from version 1 with one draft, got %+v", branch)
+	}
+
+	resp := post("/api/documents/1/branches/rewrite/merge", "")
+	var merged api.MergeBranchResult
+	json.NewDecoder(resp.Body).Decode(&merged)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || merged.Draft == nil || merged.Draft.VersionNumber != 3 || merged.Draft.Content != "Title\nintro\nbetter body\noutro\n" {
+		t.Fatalf("Expected a clean merge into version 3, got %v %+v", resp.Status, merged)
+	}
+	if merged.Branch.MergedVersion != 3 || merged.Branch.MergedHeadVersion != 1 {
+		t.Errorf("Expected the merge to be recorded on the branch, got %+v", merged.Branch)
+	}
+
+	// Both sides rewriting the same line conflicts and leaves the document alone.
+	expectStatus(post("/api/documents/1/branches/rewrite/drafts", `{"content": "title\nintro\nbetter body\nthe end\n"}`), http.StatusCreated, "branch draft")
+	if _, err := createDraft(server.URL, owner.Key, "Plan", `Title\nintro\nbetter body\nfin\n`); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	resp = post("/api/documents/1/branches/rewrite/merge", "")
+	merged = api.MergeBranchResult{}
+	json.NewDecoder(resp.Body).Decode(&merged)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || len(merged.Conflicts) != 1 || merged.Conflicts[0].Ours != "fin\n" || merged.Conflicts[0].Theirs != "the end\n" {
+		t.Fatalf("Expected one conflict on the last line, got %v %+v", resp.Status, merged)
+	}
+	if !strings.Contains(merged.Content, "<<<<<<< version 4\nfin\n=======\nthe end\n>>>>>>> rewrite\n") {
+		t.Errorf("Expected conflict markers in the merged content, got %q", merged.Content)
+	}
+	var document api.DocumentWithHead
+	getJSON(t, server.URL+"/api/documents/1?draft=latest", owner.Key, &document)
+	if document.LatestVersion != 4 {
+		t.Errorf("Expected a conflicted merge not to write, got version %d", document.LatestVersion)
+	}
+
+	// A resolution settles the conflict.
+	expectStatus(post("/api/documents/1/branches/rewrite/merge", `{"baseVersion": 3, "resolution": "x"}`), http.StatusConflict, "stale merge")
+	resp = post("/api/documents/1/branches/rewrite/merge", `{"baseVersion": 4, "resolution": "Title\nintro\nbetter body\nthe end\n"}`)
+	merged = api.MergeBranchResult{}
+	json.NewDecoder(resp.Body).Decode(&merged)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || merged.Draft.VersionNumber != 5 || merged.Branch.MergedHeadVersion != 2 {
+		t.Errorf("Expected the resolution to become version 5, got %v %+v", resp.Status, merged)
+	}
+
+	var branches []database.Branch
+	getJSON(t, server.URL+"/api/documents/1/branches", owner.Key, &branches)
+	if len(branches) != 1 || branches[0].MergedVersion != 5 {
+		t.Errorf("Expected the one merged branch, got %+v", branches)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	if _, err := createDraft(server.URL, alice.Key, "Story", "The quick brown fox jumps over the lazy dog"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	request := func(method, path, apiKey, body string) *http.Response {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, apiKey, alice.WorkspaceId, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to %s %s: %v", method, path, err)
+		}
+		return resp
+	}
+	expectStatus := func(resp *http.Response, status int, what string) {
+		t.Helper()
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %v", what, status, resp.Status)
+		}
+	}
+	expectStatus(request("PUT", fmt.Sprintf("/api/workspaces/%d/members", alice.WorkspaceId), alice.Key, `{"username": "bob"}`), http.StatusOK, "add bob")
+	expectStatus(request("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "commenter"}`), http.StatusOK, "share")
+
+	// Commenters can suggest but only editors decide.
+	for _, suggestion := range []struct{ quote, text string }{{"quick", "slow"}, {"quick brown", "red"}, {"lazy", "sleepy"}, {"fox", "cat"}} {
+		body := fmt.Sprintf(`{"draftId": 1, "text": "Suggestion", "anchor": {"quote": %q}, "suggestion": {"text": %q}}`, suggestion.quote, suggestion.text)
+		expectStatus(request("POST", "/api/comments", bob.Key, body), http.StatusCreated, "suggest "+suggestion.text)
+	}
+	expectStatus(request("POST", "/api/comments", bob.Key, `{"draftId": 1, "text": "x", "suggestion": {"text": "y"}}`), http.StatusBadRequest, "unanchored suggestion")
+	expectStatus(request("POST", "/api/comments", bob.Key, `{"draftId": 1, "text": "x", "parentCommentId": 1, "anchor": {"quote": "dog"}, "suggestion": {"text": "y"}}`), http.StatusBadRequest, "reply suggestion")
+	expectStatus(request("POST", "/api/comments/4/reject", bob.Key, ""), http.StatusForbidden, "commenter rejects")
+	expectStatus(request("POST", "/api/documents/1/suggestions/accept", bob.Key, `{"commentIds": [1]}`), http.StatusForbidden, "commenter accepts")
+
+	resp := request("POST", "/api/comments/4/reject", alice.Key, "")
+	var rejected database.Comment
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || rejected.Suggestion == nil || rejected.Suggestion.Status != database.SuggestionRejected || !rejected.Resolved {
+		t.Fatalf("Expected suggestion 4 to be rejected, got %v %+v", resp.Status, rejected)
+	}
+	expectStatus(request("POST", "/api/comments/4/reject", alice.Key, ""), http.StatusConflict, "reject twice")
+
+	resp = request("POST", "/api/documents/1/suggestions/accept", alice.Key, `{"commentIds": [1, 2, 3, 4], "baseVersion": 1}`)
+	var result api.AcceptSuggestionsResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || result.Draft == nil || result.Draft.Content != "The slow brown fox jumps over the sleepy dog" {
+		t.Fatalf("Expected suggestions 1 and 3 applied in version 2, got %v %+v", resp.Status, result)
+	}
+	if len(result.Accepted) != 2 || result.Accepted[0] != 1 || result.Accepted[1] != 3 {
+		t.Errorf("Expected suggestions 1 and 3 accepted, got %v", result.Accepted)
+	}
+	wantConflicts := []api.SuggestionConflict{{CommentId: 2, Reason: "overlaps suggestion 1"}, {CommentId: 4, Reason: "the suggestion was already rejected"}}
+	if len(result.Conflicts) != 2 || result.Conflicts[0] != wantConflicts[0] || result.Conflicts[1] != wantConflicts[1] {
+		t.Errorf("Expected conflicts %+v, got %+v", wantConflicts, result.Conflicts)
+	}
+
+	var page api.CommentPage
+	getWorkspaceJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1&mode=flat", alice.Key, alice.WorkspaceId, &page)
+	if len(page.Comments) != 4 || page.Comments[0].Suggestion.Status != database.SuggestionAccepted || *page.Comments[0].Suggestion.DraftId != result.Draft.Id {
+		t.Errorf("Expected suggestion 1 accepted by version 2, got %+v", page.Comments)
+	}
+
+	// The text suggestion 2 replaces has since changed, so it can no longer be applied.
+	resp = request("POST", "/api/documents/1/suggestions/accept", alice.Key, `{"commentIds": [2]}`)
+	result = api.AcceptSuggestionsResult{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || len(result.Conflicts) != 1 || result.Conflicts[0].Reason != "the suggested text has changed" {
+		t.Errorf("Expected suggestion 2 to conflict, got %v %+v", resp.Status, result)
+	}
+	expectStatus(request("POST", "/api/documents/1/suggestions/accept", alice.Key, `{"commentIds": [2], "baseVersion": 1}`), http.StatusConflict, "stale base version")
+}
+
+func TestNotifications(t *testing.T) {
+	apiService := setup(t)
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	alice := registerUser(t, server.URL, "alice")
+	bob := registerUser(t, server.URL, "bob")
+	workspace := alice.WorkspaceId
+	expectStatus := func(method, path, key, body string, status int) {
+		t.Helper()
+		resp, err := doWorkspaceRequest(method, server.URL+path, key, workspace, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: expected status %d, got %v", method, path, status, resp.Status)
+		}
+	}
+	inbox := func(key, query string) api.NotificationInbox {
+		t.Helper()
+		var result api.NotificationInbox
+		getWorkspaceJSON(t, server.URL+"/api/notifications"+query, key, workspace, &result)
+		return result
+	}
+
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	expectStatus("PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), alice.Key, fmt.Sprintf(`{"userId": %d}`, bob.Id), http.StatusOK)
+
+	// Mentioning bob before they can see the document notifies nobody.
+	if _, _, err := createComment(server.URL, 1, alice.Key, "@bob too early"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	if got := inbox(bob.Key, ""); len(got.Notifications) != 0 || got.Unread != 0 {
+		t.Errorf("Expected bob's inbox to be empty, got %+v", got)
+	}
+
+	expectStatus("PUT", "/api/documents/1/permissions", alice.Key, `{"username": "bob", "role": "commenter"}`, http.StatusOK)
+	if _, _, err := createComment(server.URL, 1, alice.Key, "What do you think, @Bob?"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	mentioned := inbox(bob.Key, "")
+	if len(mentioned.Notifications) != 1 || mentioned.Unread != 1 || mentioned.Notifications[0].Reason != database.NotifyMention ||
+		mentioned.Notifications[0].CommentId != 2 || mentioned.Notifications[0].Excerpt != "What do you think, @Bob?" {
+		t.Fatalf("Expected bob to be mentioned, got %+v", mentioned)
+	}
+
+	// Bob replies and watches; alice hears of the reply, bob of the next draft.
+	expectStatus("POST", "/api/comments", bob.Key, `{"draftId": 1, "parentCommentId": 2, "text": "Looks good"}`, http.StatusCreated)
+	expectStatus("PUT", "/api/documents/1/watch", bob.Key, "", http.StatusNoContent)
+	expectStatus("PUT", "/api/documents/1/watch", bob.Key, "", http.StatusNoContent)
+	var watchers []database.DocumentWatcher
+	getWorkspaceJSON(t, server.URL+"/api/documents/1/watchers", alice.Key, workspace, &watchers)
+	if len(watchers) != 1 || watchers[0].UserId != bob.Id {
+		t.Errorf("Expected bob to be the only watcher, got %+v", watchers)
+	}
+	expectStatus("POST", "/api/drafts", alice.Key, `{"name": "Plan", "content": "Second", "summary": "Tighten wording"}`, http.StatusOK)
+
+	if replies := inbox(alice.Key, ""); len(replies.Notifications) != 1 || replies.Notifications[0].Reason != database.NotifyReply {
+		t.Errorf("Expected alice to hear of the reply, got %+v", replies)
+	}
+	page := inbox(bob.Key, "?limit=1")
+	if len(page.Notifications) != 1 || page.Notifications[0].Reason != database.NotifyDraft || page.Notifications[0].Excerpt != "Tighten wording" ||
+		page.Unread != 2 || page.NextBefore != page.Notifications[0].Id {
+		t.Fatalf("Expected the new draft first with a next page, got %+v", page)
+	}
+	if next := inbox(bob.Key, fmt.Sprintf("?before=%d", page.NextBefore)); len(next.Notifications) != 1 || next.Notifications[0].Reason != database.NotifyMention {
+		t.Errorf("Expected the mention on the next page, got %+v", next)
+	}
+
+	// Marking read.
+	resp, err := doWorkspaceRequest("POST", server.URL+"/api/notifications/read", bob.Key, workspace,
+		strings.NewReader(fmt.Sprintf(`{"ids": [%d]}`, page.Notifications[0].Id)))
+	if err != nil {
+		t.Fatalf("Failed to mark read: %v", err)
+	}
+	var marked api.MarkReadResult
+	json.NewDecoder(resp.Body).Decode(&marked)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || marked.Marked != 1 || marked.Unread != 1 {
+		t.Errorf("Expected one marked and one left unread, got %v %+v", resp.Status, marked)
+	}
+	if unread := inbox(bob.Key, "?unread=true"); len(unread.Notifications) != 1 || unread.Notifications[0].Reason != database.NotifyMention {
+		t.Errorf("Expected only the mention unread, got %+v", unread)
+	}
+	expectStatus("POST", "/api/notifications/read", bob.Key, "", http.StatusOK)
+	var count api.UnreadCount
+	getWorkspaceJSON(t, server.URL+"/api/notifications/unread-count", bob.Key, workspace, &count)
+	if count.Unread != 0 {
+		t.Errorf("Expected nothing unread, got %d", count.Unread)
+	}
+
+	expectStatus("DELETE", "/api/documents/1/watch", bob.Key, "", http.StatusNoContent)
+	expectStatus("DELETE", "/api/documents/1/watch", bob.Key, "", http.StatusNotFound)
+	expectStatus("GET", "/api/notifications?limit=0", bob.Key, "", http.StatusBadRequest)
+	expectStatus("GET", "/api/notifications?unread=maybe", bob.Key, "", http.StatusBadRequest)
+	expectStatus("POST", "/api/notifications/read", bob.Key, `{"ids": "all"}`, http.StatusBadRequest)
+	expectStatus("GET", "/api/notifications", "", "", http.StatusUnauthorized)
+
+	// Digest settings.
+	var settings database.NotificationSettings
+	getJSON(t, server.URL+"/api/users/me/notification-settings", bob.Key, &settings)
+	if settings.DigestFrequency != database.DefaultDigestFrequency {
+		t.Errorf("Expected the default digest frequency, got %+v", settings)
+	}
+	expectStatus("PUT", "/api/users/me/notification-settings", bob.Key, `{"digestFrequency": "hourly"}`, http.StatusOK)
+	expectStatus("PUT", "/api/users/me/notification-settings", bob.Key, `{"digestFrequency": "weekly"}`, http.StatusBadRequest)
+	getJSON(t, server.URL+"/api/users/me/notification-settings", bob.Key, &settings)
+	if settings.DigestFrequency != database.DigestHourly || settings.UserId != bob.Id {
+		t.Errorf("Expected bob's hourly digests, got %+v", settings)
+	}
+}
+
+func TestInboundEmail(t *testing.T) {
+	apiService := setup(t)
+	apiService.InboundSecret = "gateway-secret"
+
+	server := httptest.NewServer(apiService.Router)
+	defer server.Close()
+
+	register := func(username string) testUser {
+		t.Helper()
+		body := strings.NewReader(fmt.Sprintf(`{"username": "%s", "email": "%s@example.com"}`, username, username))
+		resp, err := http.Post(server.URL+"/api/users", "application/json", body)
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Failed to register %s: %v %v", username, resp.Status, err)
+		}
+		defer resp.Body.Close()
+		var result api.NewUserResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		return testUser{Id: result.User.Id, Key: result.APIKey.Key, WorkspaceId: result.Workspace.Id}
+	}
+	alice := register("alice")
+	bob := register("bob")
+	workspace := alice.WorkspaceId
+
+	if _, err := createDraft(server.URL, alice.Key, "Plan", "First"); err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	if _, _, err := createComment(server.URL, 1, alice.Key, "What do you think, @bob?"); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+	for _, request := range []struct{ method, path, body string }{
+		{"PUT", fmt.Sprintf("/api/workspaces/%d/members", workspace), fmt.Sprintf(`{"userId": %d}`, bob.Id)},
+		{"PUT", "/api/documents/1/permissions", `{"username": "bob", "role": "commenter"}`},
+	} {
+		resp, err := doWorkspaceRequest(request.method, server.URL+request.path, alice.Key, workspace, strings.NewReader(request.body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s failed: %v %v", request.method, request.path, resp.Status, err)
+		}
+		resp.Body.Close()
+	}
+
+	replies := &mail.Replies{Address: "reply@docs.example.com", Secret: apiService.TokenSecret}
+	thread := replies.AddressFor(mail.ReplyToken{WorkspaceId: workspace, UserId: bob.Id, DraftId: 1, CommentId: 1})
+	email := func(from, to, text string) string {
+		return "From: " + from + "\r\nTo: " + to + "\r\nSubject: Re: 1 new notification\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n\r\n" + text
+	}
+	post := func(secret, body string, status int) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+"/api/inbound/email", strings.NewReader(body))
+		req.Header.Set("Content-Type", "message/rfc822")
+		if secret != "" {
+			req.Header.Set("X-Inbound-Secret", secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to post email: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Expected status %d, got %v", status, resp.Status)
+		}
+	}
+
+	reply := "Agreed, ship it.\r\n\r\nOn Mon, 5 Oct 2026 at 09:00, Docs <docs@example.com> wrote:\r\n> What do you think, @bob?\r\n"
+	post("", email("Bob <bob@example.com>", thread, reply), http.StatusUnauthorized)
+	post("wrong", email("Bob <bob@example.com>", thread, reply), http.StatusUnauthorized)
+	post("gateway-secret", email("Alice <alice@example.com>", thread, reply), http.StatusForbidden)
+	post("gateway-secret", email("Bob <bob@example.com>", "reply+1.2.1.1.00000000000000000000@docs.example.com", reply), http.StatusBadRequest)
+	post("gateway-secret", email("Bob <bob@example.com>", thread, "> only quoted"), http.StatusBadRequest)
+	post("gateway-secret", email("Bob <BOB@example.com>", "Docs <"+thread+">", reply), http.StatusCreated)
+
+	var page api.CommentPage
+	getWorkspaceJSON(t, server.URL+"/api/drafts/comments-reactions?draftId=1", alice.Key, workspace, &page)
+	if len(page.Comments) != 1 || len(page.Comments[0].Replies) != 1 {
+		t.Fatalf("Expected bob's reply threaded under the first comment, got %+v", page)
+	}
+	if reply := page.Comments[0].Replies[0]; reply.Text != "Agreed, ship it." || reply.UserId != bob.Id {
+		t.Errorf("Expected bob's reply without the quoted history, got %+v", reply)
+	}
+
+	// Losing access to the document also stops replies by email.
+	resp, err := doWorkspaceRequest("DELETE", fmt.Sprintf("%s/api/documents/1/permissions/user/%d", server.URL, bob.Id), alice.Key, workspace, nil)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Failed to revoke access: %v %v", resp.Status, err)
+	}
+	resp.Body.Close()
+	post("gateway-secret", email("bob@example.com", thread, "One more thing"), http.StatusNotFound)
+}
@@ -5,3 +5,7 @@ go 1.20
 require github.com/mattn/go-sqlite3 v1.14.19
 
 require github.com/gorilla/mux v1.8.1
+
+require github.com/lib/pq v1.10.9
+
+require github.com/gorilla/websocket v1.5.3
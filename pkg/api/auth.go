@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"documentapi/pkg/auth"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTokenTTL - How long tokens from POST /api/auth/token stay valid.
+const DefaultTokenTTL = time.Hour
+
+// tokenIssuer - The iss claim of tokens the API signs.
+const tokenIssuer = "documentapi"
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+type userContextKey struct{}
+
+// authenticate - Middleware that resolves the credential on a request to the acting user.
+// Requests without a credential continue anonymously; a credential that does not check out
+// is rejected with 401 rather than being ignored.
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := requestCredential(r)
+		if credential == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := a.userForCredential(credential)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenExpired) {
+				writeUnauthorized(w, err.Error())
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			writeUnauthorized(w, "Invalid credentials")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// requestCredential - The bearer token or API key sent with a request, if any.
+func requestCredential(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credential, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credential)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// userForCredential - Verifies a JWT locally or looks up an API key. A nil user means the
+// credential is unknown.
+func (a *API) userForCredential(credential string) (*database.User, error) {
+	if !auth.LooksLikeToken(credential) {
+		return a.Store.GetUserByAPIKey(auth.HashAPIKey(credential))
+	}
+
+	claims, err := auth.VerifyToken(a.TokenSecret, credential, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	return a.Store.GetUserById(userId)
+}
+
+// currentUser - The user a request was authenticated as, or nil.
+func currentUser(r *http.Request) *database.User {
+	user, _ := r.Context().Value(userContextKey{}).(*database.User)
+	return user
+}
+
+// requireUser - The acting user, writing a 401 if the request is anonymous.
+func requireUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	user := currentUser(r)
+	if user == nil {
+		writeUnauthorized(w, "Authentication required")
+		return nil, false
+	}
+	return user, true
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="documentapi"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+func (a *API) createUser(w http.ResponseWriter, r *http.Request) {
+	var request CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(request.Username))
+	if !usernamePattern.MatchString(username) {
+		http.Error(w, "username must be 1 to 32 letters, digits, '_', '.' or '-'", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(request.Email)
+	if email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			http.Error(w, "email must be a valid email address", http.StatusBadRequest)
+			return
+		}
+		email = address.Address
+	}
+
+	user, err := a.Store.CreateUser(database.User{
+		Username:    username,
+		DisplayName: strings.TrimSpace(request.DisplayName),
+		Email:       email,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrUsernameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	key, err := a.issueAPIKey(user.Id, "default")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Everyone starts with a workspace of their own to work in.
+	workspace, err := a.Store.CreateWorkspace(database.Workspace{Name: user.Username}, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(NewUserResult{User: *user, APIKey: *key, Workspace: *workspace})
+}
+
+func (a *API) getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+func (a *API) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := a.Store.GetAPIKeysByUserId(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (a *API) addAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := a.issueAPIKey(user.Id, strings.TrimSpace(request.Name))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// issueAPIKey - Generates and stores a key, returning it with the secret part filled in.
+func (a *API) issueAPIKey(userId int, name string) (*NewAPIKeyResult, error) {
+	secret, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := a.Store.CreateAPIKey(database.APIKey{UserId: userId, Name: name, Prefix: prefix}, auth.HashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	return &NewAPIKeyResult{APIKey: *key, Key: secret}, nil
+}
+
+func (a *API) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	keyId, err := strconv.Atoi(mux.Vars(r)["keyId"])
+	if err != nil {
+		http.Error(w, "Invalid keyId", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := a.Store.RevokeAPIKey(user.Id, keyId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) issueToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(a.TokenTTL)
+	token, err := auth.SignToken(a.TokenSecret, auth.Claims{
+		Subject:   strconv.Itoa(user.Id),
+		Issuer:    tokenIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResult{Token: token, TokenType: "Bearer", ExpiresAt: time.Unix(expiresAt.Unix(), 0)})
+}
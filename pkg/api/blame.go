@@ -0,0 +1,160 @@
+package api
+
+import (
+	"container/list"
+	"documentapi/pkg/database"
+	"documentapi/pkg/diff"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxCachedBlames - How many versions' blame the API keeps, dropping the least recently used.
+const maxCachedBlames = 256
+
+// blameCheckpoint - Besides the version asked for, every version numbered a multiple of this
+// is cached while blaming, so going back in history does not start again from version 1.
+const blameCheckpoint = 50
+
+// lineOrigin - The draft that introduced a line.
+type lineOrigin struct {
+	version   int
+	draftId   int
+	createdAt time.Time
+	authorId  int
+}
+
+type blameKey struct {
+	documentId, version int
+}
+
+type blameEntry struct {
+	key     blameKey
+	content string
+	origins []lineOrigin
+}
+
+// blameCache - The line origins of recently blamed versions. Drafts never change once created,
+// so an entry stays valid, and a later version is blamed by diffing on from the nearest one.
+type blameCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Of *blameEntry, most recently used first
+	entries map[blameKey]*list.Element
+}
+
+func newBlameCache(size int) *blameCache {
+	return &blameCache{size: size, order: list.New(), entries: map[blameKey]*list.Element{}}
+}
+
+// nearest - The cached blame of the latest version of a document up to version, or nil.
+func (c *blameCache) nearest(documentId, version int) *blameEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for ; version > 0; version-- {
+		if element, found := c.entries[blameKey{documentId, version}]; found {
+			c.order.MoveToFront(element)
+			return element.Value.(*blameEntry)
+		}
+	}
+	return nil
+}
+
+func (c *blameCache) add(entry *blameEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.entries[entry.key]; found {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*blameEntry).key)
+	}
+}
+
+// blame - Works out which version introduced each line of a version of a document, or nil if
+// the document has no such version.
+func (a *API) blame(document *database.Document, version int) (*blameEntry, error) {
+	if version < 1 || version > document.LatestVersion {
+		return nil, nil
+	}
+
+	entry := a.blames.nearest(document.Id, version)
+	if entry != nil && entry.key.version == version {
+		return entry, nil
+	}
+	from := 1
+	if entry != nil {
+		from = entry.key.version + 1
+	} else {
+		entry = &blameEntry{}
+	}
+
+	drafts, err := a.Store.GetDraftVersions(document.WorkspaceId, document.Id, from, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, draft := range drafts {
+		origin := lineOrigin{version: draft.VersionNumber, draftId: draft.Id, createdAt: draft.CreatedAt, authorId: draft.AuthorId}
+		entry = &blameEntry{
+			key:     blameKey{document.Id, draft.VersionNumber},
+			content: draft.Content,
+			origins: diff.Blame(entry.content, draft.Content, entry.origins, origin),
+		}
+		if draft.VersionNumber == version || draft.VersionNumber%blameCheckpoint == 0 {
+			a.blames.add(entry)
+		}
+	}
+	if entry.key.version != version {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+func (a *API) getBlame(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+	version := document.LatestVersion
+	if param := r.URL.Query().Get("version"); param != "" {
+		var err error
+		if version, err = strconv.Atoi(param); err != nil {
+			http.Error(w, "Invalid version parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entry, err := a.blame(document, version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	result := BlameResult{DocumentId: document.Id, Version: version, Lines: []BlameLine{}}
+	for i, text := range diff.SplitLines(entry.content) {
+		origin := entry.origins[i]
+		result.Lines = append(result.Lines, BlameLine{
+			Line:      i + 1,
+			Content:   text,
+			Version:   origin.version,
+			DraftId:   origin.draftId,
+			CreatedAt: origin.createdAt,
+			AuthorId:  origin.authorId,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"documentapi/pkg/diff"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// branchName - What a branch may be called, matching the {branch} route variable.
+var branchName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+// routeBranch - Loads the document and its branch named by the route variables, writing 404 if
+// the document has no such branch.
+func (a *API) routeBranch(w http.ResponseWriter, r *http.Request, minimum database.Role) (*database.User, *database.Document, *database.Branch, bool) {
+	user, document, ok := a.routeDocument(w, r, minimum)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	branch, err := a.Store.GetBranch(document.Id, mux.Vars(r)["branch"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+	if branch == nil {
+		http.Error(w, "Branch not found", http.StatusNotFound)
+		return nil, nil, nil, false
+	}
+	return user, document, branch, true
+}
+
+func (a *API) createBranch(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request CreateBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !branchName.MatchString(request.Name) {
+		http.Error(w, "name must be 1 to 100 letters, digits, dots, dashes or underscores", http.StatusBadRequest)
+		return
+	}
+	if request.FromVersion == 0 {
+		request.FromVersion = document.LatestVersion
+	}
+
+	created, err := a.Store.CreateBranch(database.Branch{
+		DocumentId:  document.Id,
+		Name:        request.Name,
+		BaseVersion: request.FromVersion,
+		CreatedBy:   user.Id,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			http.Error(w, "Version not found", http.StatusBadRequest)
+		case errors.Is(err, database.ErrBranchExists):
+			http.Error(w, "The document already has a branch with this name", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/documents/%d/branches/%s", document.Id, created.Name))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (a *API) getBranches(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	branches, err := a.Store.GetBranches(document.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(branches)
+}
+
+func (a *API) getBranch(w http.ResponseWriter, r *http.Request) {
+	_, _, branch, ok := a.routeBranch(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	drafts, err := a.Store.GetBranchDrafts(branch.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(BranchWithDrafts{Branch: *branch, Drafts: drafts})
+}
+
+func (a *API) addBranchDraft(w http.ResponseWriter, r *http.Request) {
+	user, _, branch, ok := a.routeBranch(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request BranchDraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.Store.AddBranchDraft(branch.Id, request.Content, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// mergeBase - The text a branch and the document last had in common: the branch version last
+// merged, or the document version it was forked from.
+func (a *API) mergeBase(document *database.Document, branch *database.Branch) (string, error) {
+	if branch.MergedHeadVersion > 0 {
+		merged, err := a.Store.GetBranchDraft(branch.Id, branch.MergedHeadVersion)
+		if err != nil || merged == nil {
+			return "", err
+		}
+		return merged.Content, nil
+	}
+	forked, err := a.Store.GetDraftByVersion(document.WorkspaceId, document.Id, branch.BaseVersion)
+	if err != nil || forked == nil {
+		return "", err
+	}
+	return forked.Content, nil
+}
+
+func (a *API) mergeBranch(w http.ResponseWriter, r *http.Request) {
+	user, document, branch, ok := a.routeBranch(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request MergeBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Summary = strings.TrimSpace(request.Summary); request.Summary == "" {
+		request.Summary = "Merged branch " + branch.Name
+	}
+	if message := checkDraftNotes(request.Summary, nil); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if !a.checkBaseVersion(w, user, document, request.BaseVersion) {
+		return
+	}
+	if branch.HeadVersion == branch.MergedHeadVersion {
+		http.Error(w, "The branch has no changes to merge", http.StatusBadRequest)
+		return
+	}
+
+	base, err := a.mergeBase(document, branch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ours, err := a.Store.GetDraftByVersion(document.WorkspaceId, document.Id, document.LatestVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	theirs, err := a.Store.GetBranchDraft(branch.Id, branch.HeadVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	merged := diff.Merge3(base, ours.Content, theirs.Content, fmt.Sprintf("version %d", ours.VersionNumber), branch.Name)
+	if request.Resolution != nil {
+		merged = diff.MergeResult{Content: *request.Resolution, Conflicts: []diff.Conflict{}}
+	}
+	if len(merged.Conflicts) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(MergeBranchResult{
+			Message:   fmt.Sprintf("%d regions were changed differently on the branch and the document", len(merged.Conflicts)),
+			Branch:    branch,
+			Content:   merged.Content,
+			Conflicts: merged.Conflicts,
+		})
+		return
+	}
+
+	// A branch whose changes the document already has is merged without a new version.
+	var draft *common.Draft
+	if merged.Content != ours.Content {
+		baseVersion := ours.VersionNumber
+		draft = &common.Draft{
+			WorkspaceId: document.WorkspaceId,
+			Name:        document.Name,
+			Content:     merged.Content,
+			BaseVersion: &baseVersion,
+			UserId:      user.Id,
+			Summary:     request.Summary,
+		}
+	}
+	result := MergeBranchResult{Conflicts: merged.Conflicts}
+	result.Draft, result.Branch, err = a.Store.MergeBranch(branch.Id, theirs.VersionNumber, draft, ours.VersionNumber, user.Id)
+	if err != nil {
+		var conflict *database.VersionConflictError
+		if errors.As(err, &conflict) {
+			a.writeVersionConflict(w, user, conflict)
+			return
+		}
+		if errors.Is(err, database.ErrPermissionDenied) {
+			http.Error(w, "This requires the editor role on the document", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if result.Draft != nil {
+		status = http.StatusCreated
+		w.Header().Set("ETag", documentETag(document.Id, result.Draft.VersionNumber))
+		w.Header().Set("Location", fmt.Sprintf("/api/drafts/%d", result.Draft.Id))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// documentETag - The entity tag of a document at a version. A draft shares the tag of
+// the document version it holds, so either can be sent back in If-Match.
+func documentETag(documentId, version int) string {
+	return fmt.Sprintf(`"%d-%d"`, documentId, version)
+}
+
+// parseDocumentETag - Splits a tag from documentETag into its document and version.
+func parseDocumentETag(etag string) (int, int, bool) {
+	etag = strings.TrimSpace(etag)
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, 0, false
+	}
+	documentStr, versionStr, found := strings.Cut(etag[1:len(etag)-1], "-")
+	if !found {
+		return 0, 0, false
+	}
+	documentId, err := strconv.Atoi(documentStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version < 1 {
+		return 0, 0, false
+	}
+	return documentId, version, true
+}
+
+// etagListMatches - Reports whether an If-None-Match style header lists etag, using the
+// weak comparison HTTP specifies for that header.
+func etagListMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventHeartbeat - How often an idle stream is pinged so proxies keep it open.
+	eventHeartbeat = 15 * time.Second
+	// eventWriteTimeout - How long a WebSocket client gets to accept each message.
+	eventWriteTimeout = 10 * time.Second
+)
+
+// The default origin check only accepts same-host WebSocket handshakes.
+var eventUpgrader = websocket.Upgrader{}
+
+// eventStream - What a subscriber asked for and who they are.
+type eventStream struct {
+	user         *database.User
+	filter       database.EventFilter
+	lastEventId  int64 // -1 to skip replay and only send live events
+	subscription *database.Subscription
+}
+
+// openEventStream - Checks the caller may watch the workspace, or the document given by the
+// documentId parameter, and subscribes. Logged events after the Last-Event-ID header, or the
+// lastEventId parameter for clients that cannot set headers, are replayed first; without
+// either only new events are sent.
+func (a *API) openEventStream(w http.ResponseWriter, r *http.Request) (*eventStream, bool) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	stream := &eventStream{user: user, filter: database.EventFilter{WorkspaceId: workspaceId}, lastEventId: -1}
+	if documentParam := r.URL.Query().Get("documentId"); documentParam != "" {
+		documentId, err := strconv.Atoi(documentParam)
+		if err != nil {
+			http.Error(w, "Invalid documentId", http.StatusBadRequest)
+			return nil, false
+		}
+		if _, ok := a.authorizeDocument(w, user, workspaceId, documentId, database.RoleViewer); !ok {
+			return nil, false
+		}
+		stream.filter.DocumentId = documentId
+	}
+
+	lastEventId := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventId != "" {
+		id, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return nil, false
+		}
+		stream.lastEventId = id
+	}
+
+	// Subscribe before replaying so nothing written in between is missed.
+	stream.subscription = a.Store.Subscribe(stream.filter)
+	return stream, true
+}
+
+// visible - Reports whether the subscriber may still see an event. Roles are checked on every
+// event so access taken away mid-stream stops delivery.
+func (a *API) visible(stream *eventStream, event database.Event) (bool, error) {
+	membership, err := a.Store.GetWorkspaceRole(event.WorkspaceId, stream.user.Id)
+	if err != nil || membership == database.WorkspaceRoleNone {
+		return false, err
+	}
+	role, err := a.Store.GetDocumentRole(event.DocumentId, stream.user.Id)
+	return role != database.RoleNone, err
+}
+
+// pumpEvents - Replays logged events after the last one the subscriber saw, then forwards live
+// ones until the context ends, a send fails or the subscriber falls behind. ping is called
+// when the stream has been idle for eventHeartbeat.
+func (a *API) pumpEvents(ctx context.Context, stream *eventStream, send func(database.Event) error, ping func() error) error {
+	defer stream.subscription.Close()
+
+	deliver := func(event database.Event) error {
+		ok, err := a.visible(stream, event)
+		if err != nil || !ok {
+			return err
+		}
+		return send(event)
+	}
+
+	replayed := stream.lastEventId
+	for replayed >= 0 {
+		events, err := a.Store.GetEvents(stream.filter, replayed, database.MaxEventPage)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := deliver(event); err != nil {
+				return err
+			}
+			replayed = event.Id
+		}
+		if len(events) < database.MaxEventPage {
+			break
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case event, open := <-stream.subscription.Events:
+			if !open {
+				return nil // Dropped for falling behind; the client resumes from the log
+			}
+			if event.Id <= replayed {
+				continue // Already sent from the log
+			}
+			if err := deliver(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a *API) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	stream, ok := a.openEventStream(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	a.pumpEvents(r.Context(), stream, func(event database.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+func (a *API) streamEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	stream, ok := a.openEventStream(w, r)
+	if !ok {
+		return
+	}
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		stream.subscription.Close()
+		return // The upgrader has already responded
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading handles them and notices when the client goes away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = a.pumpEvents(ctx, stream, func(event database.Event) error {
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		return conn.WriteJSON(event)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout))
+	})
+	if err == nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(eventWriteTimeout))
+	}
+}
@@ -3,14 +3,24 @@ package api
 import (
 	"documentapi/pkg/common"
 	"documentapi/pkg/database"
+	"documentapi/pkg/diff"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 func (a *API) addDraft(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
 	var draft common.Draft
 	if err := json.NewDecoder(r.Body).Decode(&draft); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -18,19 +28,208 @@ func (a *API) addDraft(w http.ResponseWriter, r *http.Request) {
 	}
 
 	newDraft := common.Draft{
-		Name:    draft.Name,
-		Content: draft.Content,
+		WorkspaceId: workspaceId,
+		Name:        draft.Name,
+		Content:     draft.Content,
+		BaseVersion: draft.BaseVersion,
+		UserId:      user.Id,
+		Summary:     strings.TrimSpace(draft.Summary),
+		Metadata:    draft.Metadata,
+	}
+	if newDraft.BaseVersion != nil && *newDraft.BaseVersion < 0 {
+		http.Error(w, "Invalid baseVersion", http.StatusBadRequest)
+		return
+	}
+	if message := checkDraftNotes(newDraft.Summary, newDraft.Metadata); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
 	}
-	if err := a.SQL.CreateDraft(newDraft); err != nil {
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		baseVersion, conflict, err := a.baseVersionFromIfMatch(workspaceId, newDraft.Name, ifMatch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if conflict != nil {
+			a.writeVersionConflict(w, user, conflict)
+			return
+		}
+		if newDraft.BaseVersion != nil && *newDraft.BaseVersion != baseVersion {
+			http.Error(w, "baseVersion does not agree with If-Match", http.StatusBadRequest)
+			return
+		}
+		newDraft.BaseVersion = &baseVersion
+	}
+
+	created, err := a.Store.CreateDraft(newDraft)
+	if err != nil {
+		var conflict *database.VersionConflictError
+		if errors.As(err, &conflict) {
+			a.writeVersionConflict(w, user, conflict)
+			return
+		}
+		if errors.Is(err, database.ErrPermissionDenied) {
+			http.Error(w, "This requires the editor role on the document", http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", documentETag(created.DocumentId, created.VersionNumber))
+	w.Header().Set("Location", fmt.Sprintf("/api/drafts/%d", created.Id))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Draft added successfully"})
 }
 
+// baseVersionFromIfMatch - Turns an If-Match header for the named document into a base
+// version. A tag for a different document, or * for a missing one, is a conflict.
+func (a *API) baseVersionFromIfMatch(workspaceId int, name, ifMatch string) (int, *database.VersionConflictError, error) {
+	document, err := a.Store.GetDocumentByName(workspaceId, name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if strings.TrimSpace(ifMatch) == "*" {
+		if document == nil {
+			return 0, &database.VersionConflictError{}, nil
+		}
+		return document.LatestVersion, nil, nil
+	}
+
+	documentId, version, ok := parseDocumentETag(ifMatch)
+	if !ok {
+		return 0, nil, errors.New("Invalid If-Match header")
+	}
+	if document == nil || document.Id != documentId {
+		conflict := &database.VersionConflictError{BaseVersion: version, Document: document}
+		if document != nil {
+			if conflict.Head, err = a.Store.GetDraftByVersion(workspaceId, document.Id, document.LatestVersion); err != nil {
+				return 0, nil, err
+			}
+		}
+		return 0, conflict, nil
+	}
+	return version, nil, nil
+}
+
+// writeVersionConflict - Responds 409 with the document's current head, or 403 if the user
+// cannot see the document that holds the name.
+func (a *API) writeVersionConflict(w http.ResponseWriter, user *database.User, conflict *database.VersionConflictError) {
+	if conflict.Document != nil {
+		role, err := a.Store.GetDocumentRole(conflict.Document.Id, user.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if role == database.RoleNone {
+			http.Error(w, "The document name is in use", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("ETag", documentETag(conflict.Document.Id, conflict.Document.LatestVersion))
+	}
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(DraftConflictResult{
+		Message:     conflict.Error(),
+		BaseVersion: conflict.BaseVersion,
+		Document:    conflict.Document,
+		Head:        conflict.Head,
+	})
+}
+
+// checkBaseVersion - Reports whether a request's optional baseVersion is still the document's
+// latest, writing the version conflict if not.
+func (a *API) checkBaseVersion(w http.ResponseWriter, user *database.User, document *database.Document, baseVersion *int) bool {
+	if baseVersion == nil || *baseVersion == document.LatestVersion {
+		return true
+	}
+	head, err := a.Store.GetDraftByVersion(document.WorkspaceId, document.Id, document.LatestVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	a.writeVersionConflict(w, user, &database.VersionConflictError{BaseVersion: *baseVersion, Document: document, Head: head})
+	return false
+}
+
+func (a *API) getDraft(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	draftId, err := strconv.Atoi(mux.Vars(r)["draftId"])
+	if err != nil {
+		http.Error(w, "Invalid draftId", http.StatusBadRequest)
+		return
+	}
+
+	draft, ok := a.authorizeDraft(w, user, workspaceId, draftId, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	etag := documentETag(draft.DocumentId, draft.VersionNumber)
+	w.Header().Set("ETag", etag)
+	if etagListMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(draft)
+}
+
+func (a *API) getDocument(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	documentId, err := strconv.Atoi(mux.Vars(r)["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return
+	}
+	document, ok := a.authorizeDocument(w, user, workspaceId, documentId, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	readDraft, ok := readsLatestDraft(w, r)
+	if !ok {
+		return
+	}
+	version := document.PublishedVersion
+	if readDraft {
+		version = document.LatestVersion
+	}
+
+	// An unpublished document has no content to tag.
+	var head *database.Draft
+	if version > 0 {
+		etag := documentETag(document.Id, version)
+		w.Header().Set("ETag", etag)
+		if etagListMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if head, err = a.Store.GetDraftByVersion(workspaceId, document.Id, version); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DocumentWithHead{Document: *document, Head: head})
+}
+
 func (a *API) getMostRecentDrafts(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
 	limitParam := r.URL.Query().Get("limit")
 	limit := 1 // Default limit
 	if limitParam != "" {
@@ -42,7 +241,12 @@ func (a *API) getMostRecentDrafts(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	recentDrafts, err := a.SQL.GetLatestDrafts(limit)
+	authorId, ok := authorIdParam(w, r)
+	if !ok {
+		return
+	}
+
+	recentDrafts, err := a.Store.GetLatestDrafts(database.Scope{WorkspaceId: workspaceId, UserId: user.Id}, limit, authorId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -53,42 +257,120 @@ func (a *API) getMostRecentDrafts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) searchDrafts(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
 	searchQuery := r.URL.Query().Get("text")
 	if searchQuery == "" {
 		http.Error(w, "text parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	drafts, err := a.SQL.SearchDrafts(searchQuery)
+	options := database.SearchOptions{Scope: database.Scope{WorkspaceId: workspaceId, UserId: user.Id}}
+	if latestParam := r.URL.Query().Get("latest"); latestParam != "" {
+		latest, err := strconv.ParseBool(latestParam)
+		if err != nil {
+			http.Error(w, "Invalid latest parameter", http.StatusBadRequest)
+			return
+		}
+		options.LatestOnly = latest
+	}
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		options.Limit = limit
+	}
+	if options.AuthorId, ok = authorIdParam(w, r); !ok {
+		return
+	}
+
+	results, err := a.Store.SearchDrafts(searchQuery, options)
 	if err != nil {
+		if database.IsSearchSyntaxError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(drafts)
+	json.NewEncoder(w).Encode(results)
 }
 
 func (a *API) getDocumentsLatestVersions(w http.ResponseWriter, r *http.Request) {
-	documents, err := a.SQL.GetAllDocumentsLatestVersions()
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	readDraft, ok := readsLatestDraft(w, r)
+	if !ok {
+		return
+	}
+
+	documents, err := a.Store.GetAllDocumentsLatestVersions(database.Scope{WorkspaceId: workspaceId, UserId: user.Id})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !readDraft {
+		published := []database.Document{}
+		for _, document := range documents {
+			if document.PublishedVersion > 0 {
+				published = append(published, document)
+			}
+		}
+		documents = published
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(documents)
 }
 
 func (a *API) addComment(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
 	var comment database.Comment
 	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	comment.UserId = user.Id // Never trust an identity claimed in the body
+
+	draft, ok := a.authorizeDraft(w, user, workspaceId, comment.DraftId, database.RoleCommenter)
+	if !ok {
+		return
+	}
+	if comment.ParentCommentId != nil {
+		_, parentDraft, ok := a.authorizeComment(w, user, workspaceId, *comment.ParentCommentId, database.RoleCommenter)
+		if !ok {
+			return
+		}
+		if parentDraft.DocumentId != draft.DocumentId {
+			http.Error(w, "parentCommentId belongs to another document", http.StatusBadRequest)
+			return
+		}
+	}
 
-	commentId, err := a.SQL.AddCommentToDraft(comment)
+	commentId, err := a.Store.AddCommentToDraft(workspaceId, comment)
 	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Draft not found", http.StatusNotFound)
+			return
+		}
+		if database.IsAnchorError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
 		return
 	}
@@ -102,6 +384,10 @@ func (a *API) addComment(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) getCommentsAndReactions(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
 	draftIdStr := r.URL.Query().Get("draftId")
 	if draftIdStr == "" {
 		http.Error(w, "draftId query parameter is required", http.StatusBadRequest)
@@ -114,55 +400,241 @@ func (a *API) getCommentsAndReactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comments, err := a.SQL.GetCommentsAndReactionsByDraftId(draftId)
+	options, err := parseThreadOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.authorizeDraft(w, user, workspaceId, draftId, database.RoleViewer); !ok {
+		return
+	}
+
+	comments, err := a.Store.GetCommentsAndReactionsByDraftId(workspaceId, draftId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(commentThreads(comments, options))
+}
+
+func (a *API) resolveThread(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	commentId, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		http.Error(w, "Invalid commentId", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, ok := a.authorizeComment(w, user, workspaceId, commentId, database.RoleCommenter); !ok {
+		return
+	}
+
+	comment, err := a.Store.ResolveThread(workspaceId, commentId, user.Id)
+	a.writeThreadResult(w, comment, err)
+}
+
+func (a *API) reopenThread(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	commentId, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		http.Error(w, "Invalid commentId", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, ok := a.authorizeComment(w, user, workspaceId, commentId, database.RoleCommenter); !ok {
+		return
+	}
+
+	comment, err := a.Store.ReopenThread(workspaceId, commentId)
+	a.writeThreadResult(w, comment, err)
+}
+
+func (a *API) writeThreadResult(w http.ResponseWriter, comment *database.Comment, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(comment)
+}
+
+func (a *API) getDraftAnchors(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	draftId, err := strconv.Atoi(mux.Vars(r)["draftId"])
+	if err != nil {
+		http.Error(w, "Invalid draftId", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.authorizeDraft(w, user, workspaceId, draftId, database.RoleViewer); !ok {
+		return
+	}
+
+	anchors, err := a.Store.GetCommentAnchorsByDraftId(workspaceId, draftId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(anchors)
 }
 
-func (a *API) addReaction(w http.ResponseWriter, r *http.Request) {
+// reactionTarget - The caller, their workspace and the comment a reaction route names, once the
+// caller is known to be allowed to react to it.
+func (a *API) reactionTarget(w http.ResponseWriter, r *http.Request) (*database.User, int, int, bool) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return nil, 0, 0, false
+	}
+
 	vars := mux.Vars(r)
 	commentIdStr, ok := vars["commentId"]
 	if !ok {
 		http.Error(w, "Comment ID is required", http.StatusBadRequest)
-		return
+		return nil, 0, 0, false
 	}
 	commentId, err := strconv.Atoi(commentIdStr)
 	if err != nil {
 		http.Error(w, "Invalid Comment ID", http.StatusBadRequest)
-		return
+		return nil, 0, 0, false
+	}
+
+	if _, _, ok := a.authorizeComment(w, user, workspaceId, commentId, database.RoleCommenter); !ok {
+		return nil, 0, 0, false
 	}
+	return user, workspaceId, commentId, true
+}
 
+// decodeReaction - The reaction in a request body, made as the caller. Reports false after
+// writing the error if the body or its emoji is invalid.
+func decodeReaction(w http.ResponseWriter, r *http.Request, user *database.User, commentId int) (common.Reaction, bool) {
 	newReaction := common.Reaction{}
 	if err := json.NewDecoder(r.Body).Decode(&newReaction); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		return common.Reaction{}, false
 	}
 
-	if !isOnlySupportedEmojis(newReaction.Emoji) {
+	if newReaction.Emoji == "" || !isOnlySupportedEmojis(newReaction.Emoji) {
 		http.Error(w, "Invalid emoji", http.StatusBadRequest)
-		return
+		return common.Reaction{}, false
 	}
 
-	reaction := common.Reaction{
+	return common.Reaction{
 		Id:     commentId,
 		Emoji:  newReaction.Emoji,
-		UserId: newReaction.UserId,
+		UserId: user.Id, // Never trust an identity claimed in the body
+	}, true
+}
+
+// addReaction - Reacts to a comment as the caller. Reacting again with the same emoji changes nothing.
+func (a *API) addReaction(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, commentId, ok := a.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+	reaction, ok := decodeReaction(w, r, user, commentId)
+	if !ok {
+		return
 	}
 
-	if err := a.SQL.AddReactionToComment(reaction); err != nil {
+	added, err := a.Store.AddReactionToComment(workspaceId, reaction)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
 		return
 	}
 
+	if !added {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Reaction already added"})
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Reaction added successfully"})
 }
 
+// removeReaction - Takes back the caller's reaction to a comment with the emoji in the path.
+func (a *API) removeReaction(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, commentId, ok := a.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	emoji := mux.Vars(r)["emoji"]
+	if emoji == "" || !isOnlySupportedEmojis(emoji) {
+		http.Error(w, "Invalid emoji", http.StatusBadRequest)
+		return
+	}
+
+	reaction := common.Reaction{Id: commentId, UserId: user.Id, Emoji: emoji}
+	removed, err := a.Store.RemoveReactionFromComment(workspaceId, reaction)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Reaction not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toggleReaction - Removes the caller's reaction with an emoji if they have one and adds it
+// otherwise, returning the comment's reactions as they now stand.
+func (a *API) toggleReaction(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, commentId, ok := a.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+	reaction, ok := decodeReaction(w, r, user, commentId)
+	if !ok {
+		return
+	}
+
+	reacted, err := a.Store.ToggleReaction(workspaceId, reaction)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to toggle reaction", http.StatusInternalServerError)
+		return
+	}
+	reactions, err := a.Store.GetReactionCounts(workspaceId, commentId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ReactionToggleResult{Reacted: reacted, Reactions: reactions})
+}
+
 func isOnlySupportedEmojis(s string) bool {
 	for _, r := range s {
 		if !isSupportedEmoji(r) {
@@ -182,3 +654,82 @@ func isSupportedEmoji(r rune) bool {
 		(r >= 0x1F900 && r <= 0x1F9FF) || // Supplemental Symbols and Pictographs
 		(r >= 0x1F1E6 && r <= 0x1F1FF) // Regional indicator symbols (for flag emojis)
 }
+
+func (a *API) getDocumentDiff(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	documentId, err := strconv.Atoi(mux.Vars(r)["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return
+	}
+	document, ok := a.authorizeDocument(w, user, workspaceId, documentId, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil {
+		http.Error(w, "from query parameter must be a version number", http.StatusBadRequest)
+		return
+	}
+	to := document.LatestVersion
+	if toParam := query.Get("to"); toParam != "" {
+		if to, err = strconv.Atoi(toParam); err != nil {
+			http.Error(w, "Invalid to parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	context := diff.DefaultContext
+	if contextParam := query.Get("context"); contextParam != "" {
+		if context, err = strconv.Atoi(contextParam); err != nil || context < 0 {
+			http.Error(w, "Invalid context parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	fromDraft, err := a.Store.GetDraftByVersion(workspaceId, documentId, from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	toDraft, err := a.Store.GetDraftByVersion(workspaceId, documentId, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fromDraft == nil || toDraft == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	hunks, stats := diff.Lines(fromDraft.Content, toDraft.Content, context)
+	fromName := fmt.Sprintf("%s@v%d", document.Name, from)
+	toName := fmt.Sprintf("%s@v%d", document.Name, to)
+
+	switch format := query.Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DiffResult{
+			DocumentId: documentId,
+			From:       from,
+			To:         to,
+			Stats:      stats,
+			Hunks:      hunks,
+		})
+	case "unified":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, diff.Unified(hunks, fromName, toName))
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, diff.SideBySideHTML(hunks, fromName, toName))
+	default:
+		http.Error(w, "format must be json, unified or html", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	maxSummaryLength   = 1000 // Characters in a draft's summary
+	maxMetadataEntries = 50
+	maxMetadataKey     = 100
+	maxMetadataValue   = 1000
+)
+
+// checkDraftNotes - Describes what is wrong with a draft's summary or metadata, or returns an
+// empty string if both are acceptable.
+func checkDraftNotes(summary string, metadata map[string]string) string {
+	if utf8.RuneCountInString(summary) > maxSummaryLength {
+		return fmt.Sprintf("summary must be at most %d characters", maxSummaryLength)
+	}
+	if len(metadata) > maxMetadataEntries {
+		return fmt.Sprintf("metadata can have at most %d entries", maxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if key == "" || utf8.RuneCountInString(key) > maxMetadataKey {
+			return fmt.Sprintf("metadata keys must be 1 to %d characters", maxMetadataKey)
+		}
+		if utf8.RuneCountInString(value) > maxMetadataValue {
+			return fmt.Sprintf("metadata values must be at most %d characters", maxMetadataValue)
+		}
+	}
+	return ""
+}
+
+// authorIdParam - The optional authorId query parameter that limits drafts to one author, 0 if
+// it is not given.
+func authorIdParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	param := r.URL.Query().Get("authorId")
+	if param == "" {
+		return 0, true
+	}
+	authorId, err := strconv.Atoi(param)
+	if err != nil || authorId < 1 {
+		http.Error(w, "Invalid authorId parameter", http.StatusBadRequest)
+		return 0, false
+	}
+	return authorId, true
+}
+
+func (a *API) getDocumentHistory(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var options database.HistoryOptions
+	if options.AuthorId, ok = authorIdParam(w, r); !ok {
+		return
+	}
+	query := r.URL.Query()
+	if param := query.Get("before"); param != "" {
+		var err error
+		if options.Before, err = strconv.Atoi(param); err != nil || options.Before < 1 {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	options.Limit = database.MaxHistoryPage
+	if param := query.Get("limit"); param != "" {
+		var err error
+		if options.Limit, err = strconv.Atoi(param); err != nil || options.Limit < 1 || options.Limit > database.MaxHistoryPage {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(database.MaxHistoryPage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	drafts, err := a.Store.GetDraftHistory(document.WorkspaceId, document.Id, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	history := DocumentHistory{DocumentId: document.Id, Versions: []HistoryEntry{}}
+	for _, draft := range drafts {
+		history.Versions = append(history.Versions, HistoryEntry{
+			Id:                  draft.Id,
+			VersionNumber:       draft.VersionNumber,
+			CreatedAt:           draft.CreatedAt,
+			AuthorId:            draft.AuthorId,
+			Summary:             draft.Summary,
+			Metadata:            draft.Metadata,
+			RestoredFromVersion: draft.RestoredFromVersion,
+		})
+	}
+	if len(drafts) == options.Limit {
+		history.NextBefore = drafts[len(drafts)-1].VersionNumber
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/hmac"
+	"documentapi/pkg/database"
+	"documentapi/pkg/mail"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// inboundSecretHeader - Carries the shared secret the mail gateway posting inbound email sends.
+const inboundSecretHeader = "X-Inbound-Secret"
+
+// maxInboundEmail - The largest raw email accepted, attachments included.
+const maxInboundEmail = 10 << 20
+
+// receiveEmail - Takes a raw MIME email replying to a notification and adds it as a comment. The
+// reply address's signed token names the draft and the comment replied to, and the email must
+// come from the address of the user the notification was sent to.
+func (a *API) receiveEmail(w http.ResponseWriter, r *http.Request) {
+	if a.InboundSecret == "" {
+		http.Error(w, "Inbound email is not enabled", http.StatusNotFound)
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get(inboundSecretHeader)), []byte(a.InboundSecret)) {
+		writeUnauthorized(w, "Invalid "+inboundSecretHeader+" header")
+		return
+	}
+
+	email, err := mail.ParseInbound(http.MaxBytesReader(w, r.Body, maxInboundEmail))
+	if err != nil {
+		http.Error(w, "Invalid email: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := mail.TokenFromAddresses(a.TokenSecret, email.Recipients)
+	if err != nil {
+		http.Error(w, "The email was not sent to a valid reply address", http.StatusBadRequest)
+		return
+	}
+	user, err := a.Store.GetUserById(token.UserId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Only the user the notification went to can answer it, from the address it went to.
+	var address string
+	if user != nil {
+		address, _ = mail.NormalizeAddress(user.Email)
+	}
+	if address == "" || address != email.From {
+		http.Error(w, "The sender does not match the reply address", http.StatusForbidden)
+		return
+	}
+	if email.Text == "" {
+		http.Error(w, "The reply has no text", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.checkWorkspaceRole(w, user, token.WorkspaceId, database.WorkspaceRoleMember); !ok {
+		return
+	}
+	draft, ok := a.authorizeDraft(w, user, token.WorkspaceId, token.DraftId, database.RoleCommenter)
+	if !ok {
+		return
+	}
+	comment := database.Comment{DraftId: draft.Id, UserId: user.Id, Text: email.Text}
+	if token.CommentId != 0 {
+		parent, parentDraft, ok := a.authorizeComment(w, user, token.WorkspaceId, token.CommentId, database.RoleCommenter)
+		if !ok {
+			return
+		}
+		if parentDraft.DocumentId != draft.DocumentId {
+			http.Error(w, "The comment replied to belongs to another document", http.StatusBadRequest)
+			return
+		}
+		comment.ParentCommentId = &parent.Id
+	}
+
+	commentId, err := a.Store.AddCommentToDraft(token.WorkspaceId, comment)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Draft not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(NewCommentResult{Id: commentId, Message: "Reply added as a comment"})
+}
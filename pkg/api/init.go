@@ -1,6 +1,7 @@
 package api
 
 import (
+	"documentapi/pkg/auth"
 	"documentapi/pkg/database"
 	"log"
 	"net/http"
@@ -8,17 +9,92 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func (a *API) Initialize(sql *database.SQLite) {
-	a.SQL = sql
+func (a *API) Initialize(store database.Store) {
+	a.Store = store
 	a.Router = mux.NewRouter()
+	a.blames = newBlameCache(maxCachedBlames)
+	a.Router.Use(a.authenticate)
+
+	if len(a.TokenSecret) == 0 {
+		secret, err := auth.NewSecret()
+		if err != nil {
+			log.Fatalf("Failed to generate token secret: %v", err)
+		}
+		log.Printf("No token secret configured, bearer tokens will not survive a restart")
+		a.TokenSecret = secret
+	}
+	if a.TokenTTL <= 0 {
+		a.TokenTTL = DefaultTokenTTL
+	}
+
+	a.Router.HandleFunc("/api/users", a.createUser).Methods("POST")
+	a.Router.HandleFunc("/api/users/me", a.getCurrentUser).Methods("GET")
+	a.Router.HandleFunc("/api/users/me/api-keys", a.getAPIKeys).Methods("GET")
+	a.Router.HandleFunc("/api/users/me/api-keys", a.addAPIKey).Methods("POST")
+	a.Router.HandleFunc("/api/users/me/api-keys/{keyId:[0-9]+}", a.revokeAPIKey).Methods("DELETE")
+	a.Router.HandleFunc("/api/users/me/notification-settings", a.getNotificationSettings).Methods("GET")
+	a.Router.HandleFunc("/api/users/me/notification-settings", a.setNotificationSettings).Methods("PUT")
+	a.Router.HandleFunc("/api/auth/token", a.issueToken).Methods("POST")
+	a.Router.HandleFunc("/api/workspaces", a.createWorkspace).Methods("POST")
+	a.Router.HandleFunc("/api/workspaces", a.getWorkspaces).Methods("GET")
+	a.Router.HandleFunc("/api/workspaces/{workspaceId:[0-9]+}/members", a.getWorkspaceMembers).Methods("GET")
+	a.Router.HandleFunc("/api/workspaces/{workspaceId:[0-9]+}/members", a.setWorkspaceMember).Methods("PUT")
+	a.Router.HandleFunc("/api/workspaces/{workspaceId:[0-9]+}/members/{userId:[0-9]+}", a.removeWorkspaceMember).Methods("DELETE")
+	a.Router.HandleFunc("/api/groups", a.createGroup).Methods("POST")
+	a.Router.HandleFunc("/api/groups/{groupId:[0-9]+}", a.getGroup).Methods("GET")
+	a.Router.HandleFunc("/api/groups/{groupId:[0-9]+}/members", a.addGroupMember).Methods("POST")
+	a.Router.HandleFunc("/api/groups/{groupId:[0-9]+}/members/{userId:[0-9]+}", a.removeGroupMember).Methods("DELETE")
 
 	a.Router.HandleFunc("/api/drafts", a.addDraft).Methods("POST")
 	a.Router.HandleFunc("/api/drafts", a.getMostRecentDrafts).Methods("GET")
 	a.Router.HandleFunc("/api/drafts/search", a.searchDrafts).Methods("GET")
 	a.Router.HandleFunc("/api/drafts/comments-reactions", a.getCommentsAndReactions).Methods("GET")
+	a.Router.HandleFunc("/api/drafts/{draftId:[0-9]+}", a.getDraft).Methods("GET")
+	a.Router.HandleFunc("/api/drafts/{draftId:[0-9]+}/anchors", a.getDraftAnchors).Methods("GET")
 	a.Router.HandleFunc("/api/documents/latest", a.getDocumentsLatestVersions).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}", a.getDocument).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/diff", a.getDocumentDiff).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/blame", a.getBlame).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/history", a.getDocumentHistory).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/watchers", a.getWatchers).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/watch", a.watchDocument).Methods("PUT")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/watch", a.unwatchDocument).Methods("DELETE")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/permissions", a.getDocumentPermissions).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/permissions", a.shareDocument).Methods("PUT")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/permissions/{principalType:user|group}/{principalId:[0-9]+}", a.unshareDocument).Methods("DELETE")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/restore", a.restoreDocument).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/branches", a.createBranch).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/branches", a.getBranches).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}", a.getBranch).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}/drafts", a.addBranchDraft).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/branches/{branch:[A-Za-z0-9._-]+}/merge", a.mergeBranch).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/suggestions/accept", a.acceptSuggestions).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/publish", a.publishDocument).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/unpublish", a.unpublishDocument).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/workflow", a.getWorkflow).Methods("GET")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/workflow", a.setWorkflow).Methods("PUT")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/approvals", a.approveVersion).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/approvals/{version:[0-9]+}", a.withdrawApproval).Methods("DELETE")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/transitions", a.transitionDocument).Methods("POST")
+	a.Router.HandleFunc("/api/documents/{documentId:[0-9]+}/transitions", a.getTransitions).Methods("GET")
+	a.Router.HandleFunc("/api/events", a.streamEvents).Methods("GET")
+	a.Router.HandleFunc("/api/events/ws", a.streamEventsWebSocket).Methods("GET")
+	a.Router.HandleFunc("/api/notifications", a.getNotifications).Methods("GET")
+	a.Router.HandleFunc("/api/notifications/unread-count", a.getUnreadCount).Methods("GET")
+	a.Router.HandleFunc("/api/notifications/read", a.markNotificationsRead).Methods("POST")
+	a.Router.HandleFunc("/api/webhooks", a.createWebhook).Methods("POST")
+	a.Router.HandleFunc("/api/webhooks", a.getWebhooks).Methods("GET")
+	a.Router.HandleFunc("/api/webhooks/{webhookId:[0-9]+}", a.deleteWebhook).Methods("DELETE")
+	a.Router.HandleFunc("/api/webhooks/{webhookId:[0-9]+}/deliveries", a.getWebhookDeliveries).Methods("GET")
+	a.Router.HandleFunc("/api/webhooks/{webhookId:[0-9]+}/deliveries/{deliveryId:[0-9]+}/redeliver", a.redeliverWebhook).Methods("POST")
 	a.Router.HandleFunc("/api/comments", a.addComment).Methods("POST")
+	a.Router.HandleFunc("/api/inbound/email", a.receiveEmail).Methods("POST")
+	a.Router.HandleFunc("/api/comments/{commentId:[0-9]+}/resolve", a.resolveThread).Methods("POST")
+	a.Router.HandleFunc("/api/comments/{commentId:[0-9]+}/reopen", a.reopenThread).Methods("POST")
+	a.Router.HandleFunc("/api/comments/{commentId:[0-9]+}/reject", a.rejectSuggestion).Methods("POST")
 	a.Router.HandleFunc("/api/comment/{commentId}/reaction", a.addReaction).Methods("POST")
+	a.Router.HandleFunc("/api/comment/{commentId}/reaction/toggle", a.toggleReaction).Methods("POST")
+	a.Router.HandleFunc("/api/comment/{commentId}/reaction/{emoji}", a.removeReaction).Methods("DELETE")
 }
 
 func (a *API) StartAPI() {
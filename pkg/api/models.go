@@ -2,16 +2,318 @@ package api
 
 import (
 	"documentapi/pkg/database"
+	"documentapi/pkg/diff"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type API struct {
-	Router *mux.Router
-	SQL    *database.SQLite
+	Router      *mux.Router
+	Store       database.Store
+	TokenSecret []byte        // HMAC key for bearer tokens, random per process if unset
+	TokenTTL    time.Duration // Lifetime of issued tokens, DefaultTokenTTL if unset
+	// InboundSecret - Shared with the mail gateway that posts replies to notification emails.
+	// Inbound email is off while it is empty.
+	InboundSecret string
+	// AllowPrivateWebhooks - Lets webhooks point at loopback and private addresses, for receivers
+	// on the service's own network.
+	AllowPrivateWebhooks bool
+
+	blames *blameCache
 }
 
 type NewCommentResult struct {
 	Id      int64  `json:"id"`
 	Message string `json:"message"`
 }
+
+// DraftConflictResult - Returned with 409 when a draft was based on a stale version.
+type DraftConflictResult struct {
+	Message     string             `json:"message"`
+	BaseVersion int                `json:"baseVersion"`
+	Document    *database.Document `json:"document"`
+	Head        *database.Draft    `json:"head"`
+}
+
+// DocumentWithHead - A document together with its published draft, or its latest with draft=latest.
+type DocumentWithHead struct {
+	database.Document
+	Head *database.Draft `json:"head"`
+}
+
+// DiffResult - The JSON form of a diff between two versions of a document.
+type DiffResult struct {
+	DocumentId int         `json:"documentId"`
+	From       int         `json:"from"`
+	To         int         `json:"to"`
+	Stats      diff.Stats  `json:"stats"`
+	Hunks      []diff.Hunk `json:"hunks"`
+}
+
+// BlameResult - Each line of a version of a document with the version that introduced it.
+type BlameResult struct {
+	DocumentId int         `json:"documentId"`
+	Version    int         `json:"version"`
+	Lines      []BlameLine `json:"lines"`
+}
+
+// BlameLine - A line, numbered from 1, and the draft that introduced it.
+type BlameLine struct {
+	Line      int       `json:"line"`
+	Content   string    `json:"content"`
+	Version   int       `json:"version"`
+	DraftId   int       `json:"draftId"`
+	CreatedAt time.Time `json:"createdAt"`
+	AuthorId  int       `json:"authorId"`
+}
+
+// DocumentHistory - A page of a document's versions, newest first.
+type DocumentHistory struct {
+	DocumentId int            `json:"documentId"`
+	Versions   []HistoryEntry `json:"versions"`
+	NextBefore int            `json:"nextBefore,omitempty"` // Pass as before for the next page, 0 on the last one
+}
+
+// HistoryEntry - A version of a document as the history lists it, without its content.
+type HistoryEntry struct {
+	Id                  int                    `json:"id"`
+	VersionNumber       int                    `json:"versionNumber"`
+	CreatedAt           time.Time              `json:"createdAt"`
+	AuthorId            int                    `json:"authorId"`
+	Summary             string                 `json:"summary"`
+	Metadata            database.DraftMetadata `json:"metadata,omitempty"`
+	RestoredFromVersion int                    `json:"restoredFromVersion,omitempty"`
+}
+
+// CommentPage - One page of comments at one level of a thread, or of the flat list.
+type CommentPage struct {
+	Comments []CommentNode `json:"comments"`
+	Total    int           `json:"total"` // Comments on this level
+	Offset   int           `json:"offset"`
+	Limit    int           `json:"limit"`
+	HasMore  bool          `json:"hasMore"`
+}
+
+// CommentNode - A comment with its direct reply count and, in tree mode, the first replies.
+// A comment with more replies than shown can be paged with parentId.
+type CommentNode struct {
+	database.CommentWithReactions
+	ReplyCount int           `json:"replyCount"`
+	Replies    []CommentNode `json:"replies,omitempty"`
+}
+
+// ReactionToggleResult - Whether the caller has reacted with the emoji after a toggle, and the
+// comment's reactions counted per emoji.
+type ReactionToggleResult struct {
+	Reacted   bool                     `json:"reacted"`
+	Reactions []database.ReactionCount `json:"reactions"`
+}
+
+// CreateUserRequest - The account to register with POST /api/users.
+type CreateUserRequest struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
+}
+
+// NewUserResult - A registered user, their first API key and their personal workspace.
+type NewUserResult struct {
+	User      database.User      `json:"user"`
+	APIKey    NewAPIKeyResult    `json:"apiKey"`
+	Workspace database.Workspace `json:"workspace"`
+}
+
+// CreateAPIKeyRequest - A label for a new API key.
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// NewAPIKeyResult - A new API key. Key is only ever returned here.
+type NewAPIKeyResult struct {
+	database.APIKey
+	Key string `json:"key"`
+}
+
+// TokenResult - A signed bearer token.
+type TokenResult struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ShareRequest - Who to share a document with and in what role. Give one of userId,
+// username or groupId.
+type ShareRequest struct {
+	UserId   int           `json:"userId"`
+	Username string        `json:"username"`
+	GroupId  int           `json:"groupId"`
+	Role     database.Role `json:"role"`
+}
+
+// CreateGroupRequest - The group to create with POST /api/groups.
+type CreateGroupRequest struct {
+	Name string `json:"name"`
+}
+
+// GroupMemberRequest - The user to add to a group, by userId or username.
+type GroupMemberRequest struct {
+	UserId   int    `json:"userId"`
+	Username string `json:"username"`
+}
+
+// GroupWithMembers - A group and the users in it.
+type GroupWithMembers struct {
+	database.Group
+	Members []database.User `json:"members"`
+}
+
+// CreateWorkspaceRequest - The workspace to create with POST /api/workspaces.
+type CreateWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// WorkspaceMemberRequest - The user to add to a workspace, by userId or username, and their
+// role. The role defaults to member.
+type WorkspaceMemberRequest struct {
+	UserId   int                    `json:"userId"`
+	Username string                 `json:"username"`
+	Role     database.WorkspaceRole `json:"role"`
+}
+
+// CreateWebhookRequest - The webhook to add with POST /api/webhooks. Without eventTypes every
+// event is sent, without documentId those of every document, and without a secret one is
+// generated.
+type CreateWebhookRequest struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"eventTypes"`
+	DocumentId int      `json:"documentId"`
+}
+
+// WorkflowStatus - A document's workflow, the state it is in and the approvals of its latest version.
+type WorkflowStatus struct {
+	database.DocumentWorkflow
+	LatestVersion int                 `json:"latestVersion"`
+	Approvals     []database.Approval `json:"approvals"`
+}
+
+// ApproveRequest - The version to approve with POST /api/documents/{id}/approvals, which must be
+// the document's latest.
+type ApproveRequest struct {
+	Version int `json:"version"`
+}
+
+// TransitionRequest - The state to move a document to with POST /api/documents/{id}/transitions,
+// and why.
+type TransitionRequest struct {
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// PublishRequest - What to publish with POST /api/documents/{id}/publish. The version defaults to
+// the latest, and a publishAt in the future schedules the publication instead.
+type PublishRequest struct {
+	Version   int        `json:"version"`
+	PublishAt *time.Time `json:"publishAt"`
+}
+
+// RestoreRequest - The version to copy into a new draft with POST /api/documents/{id}/restore.
+// carryComments brings that version's comments and anchors along, and baseVersion works as it
+// does for POST /api/drafts.
+type RestoreRequest struct {
+	Version       int    `json:"version"`
+	CarryComments bool   `json:"carryComments"`
+	BaseVersion   *int   `json:"baseVersion,omitempty"`
+	Summary       string `json:"summary,omitempty"` // The new draft's summary, "Restored version N" by default
+}
+
+// CreateBranchRequest - The branch to fork with POST /api/documents/{id}/branches, from the
+// document's latest version unless fromVersion is given.
+type CreateBranchRequest struct {
+	Name        string `json:"name"`
+	FromVersion int    `json:"fromVersion"`
+}
+
+// BranchDraftRequest - The content of a new branch version.
+type BranchDraftRequest struct {
+	Content string `json:"content"`
+}
+
+// BranchWithDrafts - A branch together with its versions, oldest first.
+type BranchWithDrafts struct {
+	database.Branch
+	Drafts []database.BranchDraft `json:"drafts"`
+}
+
+// MergeBranchRequest - Options for POST /api/documents/{id}/branches/{name}/merge. baseVersion
+// works as it does for POST /api/drafts; resolution replaces the merged text, for settling the
+// conflicts an earlier attempt reported.
+type MergeBranchRequest struct {
+	BaseVersion *int    `json:"baseVersion,omitempty"`
+	Resolution  *string `json:"resolution,omitempty"`
+	Summary     string  `json:"summary,omitempty"` // The merged draft's summary, "Merged branch NAME" by default
+}
+
+// MergeBranchResult - The outcome of merging a branch. On a conflict, returned with 409, content
+// holds the merged text with conflict markers and nothing is written.
+type MergeBranchResult struct {
+	Message   string           `json:"message,omitempty"`
+	Branch    *database.Branch `json:"branch"`
+	Draft     *database.Draft  `json:"draft,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Conflicts []diff.Conflict  `json:"conflicts"`
+}
+
+// AcceptSuggestionsRequest - The suggestion comments to apply with
+// POST /api/documents/{id}/suggestions/accept. Earlier ones win where they overlap.
+type AcceptSuggestionsRequest struct {
+	CommentIds  []int  `json:"commentIds"`
+	BaseVersion *int   `json:"baseVersion,omitempty"`
+	Summary     string `json:"summary,omitempty"` // The new draft's summary, listing the suggestions by default
+}
+
+// SuggestionConflict - Why a suggestion could not be applied.
+type SuggestionConflict struct {
+	CommentId int    `json:"commentId"`
+	Reason    string `json:"reason"`
+}
+
+// AcceptSuggestionsResult - The draft that applied the accepted suggestions and those that could
+// not be applied. When none could be, it is returned with 409 and no draft.
+type AcceptSuggestionsResult struct {
+	Message   string               `json:"message,omitempty"`
+	Draft     *database.Draft      `json:"draft,omitempty"`
+	Accepted  []int                `json:"accepted"`
+	Conflicts []SuggestionConflict `json:"conflicts"`
+}
+
+// NotificationInbox - A page of the user's notifications, newest first, and how many are unread.
+type NotificationInbox struct {
+	Notifications []database.Notification `json:"notifications"`
+	Unread        int                     `json:"unread"`
+	NextBefore    int64                   `json:"nextBefore,omitempty"` // Pass as before for the next page, 0 on the last one
+}
+
+// UnreadCount - How many of the user's notifications are unread.
+type UnreadCount struct {
+	Unread int `json:"unread"`
+}
+
+// MarkReadRequest - The notifications to mark read with POST /api/notifications/read, or all of
+// them if ids is empty.
+type MarkReadRequest struct {
+	Ids []int64 `json:"ids"`
+}
+
+// MarkReadResult - How many notifications were marked read and how many are still unread.
+type MarkReadResult struct {
+	Marked int `json:"marked"`
+	Unread int `json:"unread"`
+}
+
+// NotificationSettingsRequest - How often to email the user digests of their unread notifications:
+// immediate, hourly, daily or off.
+type NotificationSettingsRequest struct {
+	DigestFrequency database.DigestFrequency `json:"digestFrequency"`
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+func (a *API) watchDocument(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := a.Store.WatchDocument(document.Id, user.Id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) unwatchDocument(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	removed, err := a.Store.UnwatchDocument(document.Id, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "You are not watching this document", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) getWatchers(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	watchers, err := a.Store.GetWatchers(document.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(watchers)
+}
+
+func (a *API) getNotifications(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	options := database.NotificationOptions{Limit: database.MaxNotificationPage}
+	if param := query.Get("unread"); param != "" {
+		var err error
+		if options.UnreadOnly, err = strconv.ParseBool(param); err != nil {
+			http.Error(w, "Invalid unread parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if param := query.Get("before"); param != "" {
+		var err error
+		if options.Before, err = strconv.ParseInt(param, 10, 64); err != nil || options.Before < 1 {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if param := query.Get("limit"); param != "" {
+		var err error
+		if options.Limit, err = strconv.Atoi(param); err != nil || options.Limit < 1 || options.Limit > database.MaxNotificationPage {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(database.MaxNotificationPage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	notifications, err := a.Store.GetNotifications(workspaceId, user.Id, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unread, err := a.Store.CountUnreadNotifications(workspaceId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	inbox := NotificationInbox{Notifications: notifications, Unread: unread}
+	if len(notifications) == options.Limit {
+		inbox.NextBefore = notifications[len(notifications)-1].Id
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(inbox)
+}
+
+func (a *API) getUnreadCount(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	unread, err := a.Store.CountUnreadNotifications(workspaceId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UnreadCount{Unread: unread})
+}
+
+func (a *API) markNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// An empty body marks everything read.
+	var request MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	marked, err := a.Store.MarkNotificationsRead(workspaceId, user.Id, request.Ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unread, err := a.Store.CountUnreadNotifications(workspaceId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MarkReadResult{Marked: marked, Unread: unread})
+}
+
+func (a *API) getNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := a.Store.GetNotificationSettings(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (a *API) setNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request NotificationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !request.DigestFrequency.Valid() {
+		http.Error(w, "digestFrequency must be one of immediate, hourly, daily or off", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := a.Store.SetNotificationSettings(database.NotificationSettings{UserId: user.Id, DigestFrequency: request.DigestFrequency})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,388 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// checkRole - Checks the user holds at least minimum on a document. A document the user
+// cannot see at all is reported with 404 and notFound so its existence does not leak;
+// one they can see but not change gets 403.
+func (a *API) checkRole(w http.ResponseWriter, user *database.User, documentId int, minimum database.Role, notFound string) bool {
+	role, err := a.Store.GetDocumentRole(documentId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if role == database.RoleNone {
+		http.Error(w, notFound, http.StatusNotFound)
+		return false
+	}
+	if !role.AtLeast(minimum) {
+		http.Error(w, "This requires the "+string(minimum)+" role on the document", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeDocument - Loads a document in the workspace the user holds at least minimum on,
+// writing an error if not.
+func (a *API) authorizeDocument(w http.ResponseWriter, user *database.User, workspaceId, documentId int, minimum database.Role) (*database.Document, bool) {
+	document, err := a.Store.GetDocumentById(workspaceId, documentId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if document == nil {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !a.checkRole(w, user, document.Id, minimum, "Document not found") {
+		return nil, false
+	}
+	return document, true
+}
+
+// authorizeDraft - Loads a draft in the workspace the user holds at least minimum on, writing an error if not.
+func (a *API) authorizeDraft(w http.ResponseWriter, user *database.User, workspaceId, draftId int, minimum database.Role) (*database.Draft, bool) {
+	draft, err := a.Store.GetDraftById(workspaceId, draftId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if draft == nil {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !a.checkRole(w, user, draft.DocumentId, minimum, "Draft not found") {
+		return nil, false
+	}
+	return draft, true
+}
+
+// authorizeComment - Loads a comment in the workspace and its draft if the user holds at least
+// minimum on the document, writing an error if not.
+func (a *API) authorizeComment(w http.ResponseWriter, user *database.User, workspaceId, commentId int, minimum database.Role) (*database.Comment, *database.Draft, bool) {
+	comment, err := a.Store.GetCommentById(workspaceId, commentId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	draft, err := a.Store.GetDraftById(workspaceId, comment.DraftId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if draft == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !a.checkRole(w, user, draft.DocumentId, minimum, "Comment not found") {
+		return nil, nil, false
+	}
+	return comment, draft, true
+}
+
+// lookupUser - Finds a user by Id, or by username when no Id is given. Nil if there is no such user.
+func (a *API) lookupUser(userId int, username string) (*database.User, error) {
+	if userId != 0 {
+		return a.Store.GetUserById(userId)
+	}
+	return a.Store.GetUserByUsername(strings.ToLower(strings.TrimSpace(username)))
+}
+
+func (a *API) getDocumentPermissions(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	documentId, err := strconv.Atoi(mux.Vars(r)["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.authorizeDocument(w, user, workspaceId, documentId, database.RoleViewer); !ok {
+		return
+	}
+
+	permissions, err := a.Store.GetDocumentPermissions(documentId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(permissions)
+}
+
+func (a *API) shareDocument(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	documentId, err := strconv.Atoi(mux.Vars(r)["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.authorizeDocument(w, user, workspaceId, documentId, database.RoleOwner); !ok {
+		return
+	}
+
+	var request ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !request.Role.Valid() {
+		http.Error(w, "role must be viewer, commenter, editor or owner", http.StatusBadRequest)
+		return
+	}
+
+	// Documents can only be shared within their workspace.
+	permission := database.DocumentPermission{DocumentId: documentId, Role: request.Role}
+	if request.GroupId != 0 {
+		group, err := a.Store.GetGroupById(request.GroupId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if group == nil || group.WorkspaceId != workspaceId {
+			http.Error(w, "Group not found", http.StatusBadRequest)
+			return
+		}
+		permission.PrincipalType, permission.PrincipalId = database.PrincipalGroup, group.Id
+	} else {
+		grantee, err := a.workspaceMember(workspaceId, request.UserId, request.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if grantee == nil {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+		permission.PrincipalType, permission.PrincipalId = database.PrincipalUser, grantee.Id
+	}
+
+	saved, err := a.Store.SetDocumentPermission(permission)
+	if err != nil {
+		if errors.Is(err, database.ErrLastOwner) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (a *API) unshareDocument(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	documentId, err := strconv.Atoi(vars["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return
+	}
+	principalId, err := strconv.Atoi(vars["principalId"])
+	if err != nil {
+		http.Error(w, "Invalid principalId", http.StatusBadRequest)
+		return
+	}
+	principalType := vars["principalType"]
+
+	// Anyone may give up their own access; taking it from others is for owners.
+	minimum := database.RoleOwner
+	if principalType == database.PrincipalUser && principalId == user.Id {
+		minimum = database.RoleViewer
+	}
+	if _, ok := a.authorizeDocument(w, user, workspaceId, documentId, minimum); !ok {
+		return
+	}
+
+	removed, err := a.Store.RemoveDocumentPermission(documentId, principalType, principalId)
+	if err != nil {
+		if errors.Is(err, database.ErrLastOwner) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) createGroup(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	group, err := a.Store.CreateGroup(database.Group{WorkspaceId: workspaceId, Name: name, OwnerId: user.Id})
+	if err != nil {
+		if errors.Is(err, database.ErrGroupNameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GroupWithMembers{Group: *group, Members: []database.User{*user}})
+}
+
+// memberGroup - Loads a group in the workspace the user belongs to, writing 404 if there is none.
+func (a *API) memberGroup(w http.ResponseWriter, user *database.User, workspaceId int, groupIdParam string) (*database.Group, []database.User, bool) {
+	groupId, err := strconv.Atoi(groupIdParam)
+	if err != nil {
+		http.Error(w, "Invalid groupId", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	group, err := a.Store.GetGroupById(groupId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	var members []database.User
+	if group != nil && group.WorkspaceId == workspaceId {
+		if members, err = a.Store.GetGroupMembers(group.Id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+	}
+	for _, member := range members {
+		if member.Id == user.Id {
+			return group, members, true
+		}
+	}
+	http.Error(w, "Group not found", http.StatusNotFound)
+	return nil, nil, false
+}
+
+func (a *API) getGroup(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	group, members, ok := a.memberGroup(w, user, workspaceId, mux.Vars(r)["groupId"])
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(GroupWithMembers{Group: *group, Members: members})
+}
+
+func (a *API) addGroupMember(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	group, _, ok := a.memberGroup(w, user, workspaceId, mux.Vars(r)["groupId"])
+	if !ok {
+		return
+	}
+	if group.OwnerId != user.Id {
+		http.Error(w, "Only the group owner can add members", http.StatusForbidden)
+		return
+	}
+
+	var request GroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	member, err := a.workspaceMember(workspaceId, request.UserId, request.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if member == nil {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.Store.AddGroupMember(group.Id, member.Id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members, err := a.Store.GetGroupMembers(group.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(GroupWithMembers{Group: *group, Members: members})
+}
+
+func (a *API) removeGroupMember(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	group, _, ok := a.memberGroup(w, user, workspaceId, vars["groupId"])
+	if !ok {
+		return
+	}
+	userId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	// Members may leave; removing anyone else is for the owner, who cannot leave.
+	if group.OwnerId != user.Id && userId != user.Id {
+		http.Error(w, "Only the group owner can remove members", http.StatusForbidden)
+		return
+	}
+	if userId == group.OwnerId {
+		http.Error(w, "The group owner cannot be removed", http.StatusConflict)
+		return
+	}
+
+	removed, err := a.Store.RemoveGroupMember(group.Id, userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
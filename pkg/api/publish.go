@@ -0,0 +1,85 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// readsLatestDraft - Reports whether a read asked for the latest draft with draft=latest rather
+// than the published version, writing 400 for any other value.
+func readsLatestDraft(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch r.URL.Query().Get("draft") {
+	case "":
+		return false, true
+	case "latest":
+		return true, true
+	default:
+		http.Error(w, "draft must be latest", http.StatusBadRequest)
+		return false, false
+	}
+}
+
+func (a *API) publishDocument(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Version == 0 {
+		request.Version = document.LatestVersion
+	}
+	if request.Version < 1 || request.Version > document.LatestVersion {
+		http.Error(w, "Version not found", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	var published *database.Document
+	var err error
+	if request.PublishAt != nil && request.PublishAt.After(time.Now()) {
+		status = http.StatusAccepted
+		published, err = a.Store.SchedulePublication(document.Id, request.Version, *request.PublishAt, user.Id)
+	} else {
+		published, err = a.Store.PublishDocument(document.Id, request.Version, user.Id)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "Version not found", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, database.ErrNotApproved) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(published)
+}
+
+func (a *API) unpublishDocument(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	unpublished, err := a.Store.UnpublishDocument(document.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(unpublished)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func (a *API) restoreDocument(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.BaseVersion != nil && *request.BaseVersion < 0 {
+		http.Error(w, "Invalid baseVersion", http.StatusBadRequest)
+		return
+	}
+	if request.Summary = strings.TrimSpace(request.Summary); request.Summary == "" {
+		request.Summary = fmt.Sprintf("Restored version %d", request.Version)
+	}
+	if message := checkDraftNotes(request.Summary, nil); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	source, err := a.Store.GetDraftByVersion(document.WorkspaceId, document.Id, request.Version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		http.Error(w, "Version not found", http.StatusBadRequest)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		baseVersion, conflict, err := a.baseVersionFromIfMatch(document.WorkspaceId, document.Name, ifMatch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if conflict != nil {
+			a.writeVersionConflict(w, user, conflict)
+			return
+		}
+		if request.BaseVersion != nil && *request.BaseVersion != baseVersion {
+			http.Error(w, "baseVersion does not agree with If-Match", http.StatusBadRequest)
+			return
+		}
+		request.BaseVersion = &baseVersion
+	}
+
+	created, err := a.Store.CreateDraft(common.Draft{
+		WorkspaceId:         document.WorkspaceId,
+		Name:                document.Name,
+		Content:             source.Content,
+		BaseVersion:         request.BaseVersion,
+		UserId:              user.Id,
+		Summary:             request.Summary,
+		RestoredFromVersion: source.VersionNumber,
+		CarryComments:       request.CarryComments,
+	})
+	if err != nil {
+		var conflict *database.VersionConflictError
+		if errors.As(err, &conflict) {
+			a.writeVersionConflict(w, user, conflict)
+			return
+		}
+		if errors.Is(err, database.ErrPermissionDenied) {
+			http.Error(w, "This requires the editor role on the document", http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", documentETag(created.DocumentId, created.VersionNumber))
+	w.Header().Set("Location", fmt.Sprintf("/api/drafts/%d", created.Id))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
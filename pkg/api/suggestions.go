@@ -0,0 +1,220 @@
+package api
+
+import (
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// suggestedEdit - A suggestion placed on the draft it is applied to.
+type suggestedEdit struct {
+	commentId  int
+	start, end int
+	text       string
+}
+
+// planSuggestions - Places each suggestion on the latest draft through its anchor there, in the
+// order given, and reports those that no longer fit or overlap one placed earlier.
+func (a *API) planSuggestions(document *database.Document, head *database.Draft, commentIds []int) ([]suggestedEdit, []SuggestionConflict, error) {
+	anchors, err := a.Store.GetCommentAnchorsByDraftId(document.WorkspaceId, head.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	anchorOf := map[int]database.Anchor{}
+	for _, anchor := range anchors {
+		anchorOf[anchor.CommentId] = anchor.Anchor
+	}
+
+	edits := []suggestedEdit{}
+	conflicts := []SuggestionConflict{}
+	for _, commentId := range commentIds {
+		suggestion, reason, err := a.suggestionConflict(document, commentId, anchorOf, edits)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reason != "" {
+			conflicts = append(conflicts, SuggestionConflict{CommentId: commentId, Reason: reason})
+			continue
+		}
+		anchor := anchorOf[commentId]
+		edits = append(edits, suggestedEdit{commentId: commentId, start: anchor.Start, end: anchor.End, text: suggestion.Text})
+	}
+	return edits, conflicts, nil
+}
+
+// suggestionConflict - Loads a suggestion and reports why it cannot be applied alongside the
+// edits placed so far, or an empty reason if it can.
+func (a *API) suggestionConflict(document *database.Document, commentId int, anchorOf map[int]database.Anchor, placed []suggestedEdit) (*database.Suggestion, string, error) {
+	comment, err := a.Store.GetCommentById(document.WorkspaceId, commentId)
+	if err != nil || comment == nil {
+		return nil, "not a suggestion on this document", err
+	}
+	draft, err := a.Store.GetDraftById(document.WorkspaceId, comment.DraftId)
+	if err != nil {
+		return nil, "", err
+	}
+	suggestion := comment.Suggestion
+	if suggestion == nil || draft == nil || draft.DocumentId != document.Id {
+		return nil, "not a suggestion on this document", nil
+	}
+	if suggestion.Status != database.SuggestionPending {
+		return nil, "the suggestion was already " + string(suggestion.Status), nil
+	}
+
+	anchor, found := anchorOf[commentId]
+	if !found || anchor.Orphaned {
+		return nil, "the suggested text was deleted", nil
+	}
+	if anchor.Quote != suggestion.Quote {
+		return nil, "the suggested text has changed", nil
+	}
+	for _, edit := range placed {
+		if edit.commentId == commentId {
+			return nil, "listed more than once", nil
+		}
+		if anchor.Start < edit.end && edit.start < anchor.End {
+			return nil, fmt.Sprintf("overlaps suggestion %d", edit.commentId), nil
+		}
+	}
+	return suggestion, "", nil
+}
+
+// applySuggestions - The content with each edit's range replaced by its text.
+func applySuggestions(content string, edits []suggestedEdit) string {
+	sorted := append([]suggestedEdit{}, edits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start > sorted[j].start })
+
+	runes := []rune(content)
+	for _, edit := range sorted {
+		runes = append(runes[:edit.start], append([]rune(edit.text), runes[edit.end:]...)...)
+	}
+	return string(runes)
+}
+
+func (a *API) acceptSuggestions(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request AcceptSuggestionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.CommentIds) == 0 {
+		http.Error(w, "commentIds is required", http.StatusBadRequest)
+		return
+	}
+	if message := checkDraftNotes(strings.TrimSpace(request.Summary), nil); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if !a.checkBaseVersion(w, user, document, request.BaseVersion) {
+		return
+	}
+
+	head, err := a.Store.GetDraftByVersion(document.WorkspaceId, document.Id, document.LatestVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	edits, conflicts, err := a.planSuggestions(document, head, request.CommentIds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(edits) == 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(AcceptSuggestionsResult{
+			Message:   "None of the suggestions can be applied to the latest version",
+			Accepted:  []int{},
+			Conflicts: conflicts,
+		})
+		return
+	}
+
+	accepted := make([]int, len(edits))
+	acceptedIds := make([]string, len(edits))
+	for i, edit := range edits {
+		accepted[i] = edit.commentId
+		acceptedIds[i] = strconv.Itoa(edit.commentId)
+	}
+	summary := strings.TrimSpace(request.Summary)
+	if summary == "" {
+		summary = "Accepted suggestions " + strings.Join(acceptedIds, ", ")
+	}
+	baseVersion := head.VersionNumber
+	created, err := a.Store.CreateDraft(common.Draft{
+		WorkspaceId:       document.WorkspaceId,
+		Name:              document.Name,
+		Content:           applySuggestions(head.Content, edits),
+		BaseVersion:       &baseVersion,
+		UserId:            user.Id,
+		Summary:           summary,
+		AcceptSuggestions: accepted,
+	})
+	if err != nil {
+		var conflict *database.VersionConflictError
+		switch {
+		case errors.As(err, &conflict):
+			a.writeVersionConflict(w, user, conflict)
+		case errors.Is(err, database.ErrSuggestionDecided):
+			http.Error(w, "A suggestion was decided while accepting it, try again", http.StatusConflict)
+		case errors.Is(err, database.ErrPermissionDenied):
+			http.Error(w, "This requires the editor role on the document", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", documentETag(created.DocumentId, created.VersionNumber))
+	w.Header().Set("Location", fmt.Sprintf("/api/drafts/%d", created.Id))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AcceptSuggestionsResult{Draft: created, Accepted: accepted, Conflicts: conflicts})
+}
+
+func (a *API) rejectSuggestion(w http.ResponseWriter, r *http.Request) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return
+	}
+	commentId, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		http.Error(w, "Invalid commentId", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, ok := a.authorizeComment(w, user, workspaceId, commentId, database.RoleEditor); !ok {
+		return
+	}
+
+	rejected, err := a.Store.RejectSuggestion(workspaceId, commentId, user.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotSuggestion):
+			http.Error(w, "The comment is not a suggestion", http.StatusBadRequest)
+		case errors.Is(err, database.ErrSuggestionDecided):
+			http.Error(w, "The suggestion was already accepted or rejected", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if rejected == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rejected)
+}
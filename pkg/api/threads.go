@@ -0,0 +1,170 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultThreadDepth      = 3
+	maxThreadDepth          = 10
+	defaultThreadLimit      = 50
+	defaultThreadReplyLimit = 10
+	maxThreadLimit          = 200
+)
+
+// threadOptions - How much of a comment tree to return.
+type threadOptions struct {
+	Flat       bool
+	Resolved   *bool // Only threads in this state, or every thread if nil
+	ParentId   *int  // Page through this comment's replies instead of the top level
+	Depth      int   // Levels of comments returned, the page's own level included
+	Offset     int
+	Limit      int // Comments on the page's own level
+	ReplyLimit int // Replies shown under each comment on deeper levels
+}
+
+// parseThreadOptions - Reads the mode, status, parentId, depth, offset, limit and replyLimit parameters.
+func parseThreadOptions(query url.Values) (threadOptions, error) {
+	options := threadOptions{
+		Depth:      defaultThreadDepth,
+		Limit:      defaultThreadLimit,
+		ReplyLimit: defaultThreadReplyLimit,
+	}
+
+	switch mode := query.Get("mode"); mode {
+	case "", "tree":
+	case "flat":
+		options.Flat = true
+	default:
+		return options, errors.New("mode must be tree or flat")
+	}
+
+	switch status := query.Get("status"); status {
+	case "", "all":
+	case "resolved", "unresolved":
+		resolved := status == "resolved"
+		options.Resolved = &resolved
+	default:
+		return options, errors.New("status must be all, resolved or unresolved")
+	}
+
+	if parentParam := query.Get("parentId"); parentParam != "" {
+		parentId, err := strconv.Atoi(parentParam)
+		if err != nil {
+			return options, errors.New("invalid parentId parameter")
+		}
+		options.ParentId = &parentId
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+		min   int
+		max   int
+	}{
+		{"depth", &options.Depth, 1, maxThreadDepth},
+		{"offset", &options.Offset, 0, -1},
+		{"limit", &options.Limit, 1, maxThreadLimit},
+		{"replyLimit", &options.ReplyLimit, 0, maxThreadLimit},
+	}
+	for _, param := range ints {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < param.min || (param.max >= 0 && value > param.max) {
+			return options, fmt.Errorf("invalid %s parameter", param.name)
+		}
+		*param.value = value
+	}
+	return options, nil
+}
+
+// commentThreads - Builds the requested page of a draft's comments, which arrive oldest first.
+// Replies to comments that are not on the draft are treated as top-level comments.
+func commentThreads(comments []database.CommentWithReactions, options threadOptions) CommentPage {
+	// Resolution applies to whole threads, so filtering by it never splits one.
+	if options.Resolved != nil {
+		var filtered []database.CommentWithReactions
+		for _, comment := range comments {
+			if comment.Resolved == *options.Resolved {
+				filtered = append(filtered, comment)
+			}
+		}
+		comments = filtered
+	}
+
+	byId := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		byId[comment.Id] = true
+	}
+	children := make(map[int][]database.CommentWithReactions)
+	var roots []database.CommentWithReactions
+	for _, comment := range comments {
+		if comment.ParentCommentId != nil && byId[*comment.ParentCommentId] {
+			children[*comment.ParentCommentId] = append(children[*comment.ParentCommentId], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+
+	if options.Flat {
+		start, end := pageBounds(len(comments), options.Offset, options.Limit)
+		page := CommentPage{Comments: []CommentNode{}, Total: len(comments), Offset: options.Offset, Limit: options.Limit, HasMore: end < len(comments)}
+		for _, comment := range comments[start:end] {
+			page.Comments = append(page.Comments, CommentNode{CommentWithReactions: comment, ReplyCount: len(children[comment.Id])})
+		}
+		return page
+	}
+
+	level := roots
+	if options.ParentId != nil {
+		level = children[*options.ParentId]
+	}
+
+	var build func(comments []database.CommentWithReactions, depth int) []CommentNode
+	build = func(comments []database.CommentWithReactions, depth int) []CommentNode {
+		nodes := make([]CommentNode, 0, len(comments))
+		for _, comment := range comments {
+			node := CommentNode{
+				CommentWithReactions: comment,
+				ReplyCount:           len(children[comment.Id]),
+			}
+			if depth < options.Depth {
+				replies := children[comment.Id]
+				if len(replies) > options.ReplyLimit {
+					replies = replies[:options.ReplyLimit]
+				}
+				node.Replies = build(replies, depth+1)
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+
+	start, end := pageBounds(len(level), options.Offset, options.Limit)
+	return CommentPage{
+		Comments: build(level[start:end], 1),
+		Total:    len(level),
+		Offset:   options.Offset,
+		Limit:    options.Limit,
+		HasMore:  end < len(level),
+	}
+}
+
+// pageBounds - The slice bounds of a page, clamped to the number of items.
+func pageBounds(total, offset, limit int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"documentapi/pkg/webhook"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// requireWebhookAdmin - The workspace of a request made by one of its admins, who manage webhooks.
+func (a *API) requireWebhookAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return 0, false
+	}
+	_, ok = a.checkWorkspaceRole(w, user, workspaceId, database.WorkspaceRoleAdmin)
+	return workspaceId, ok
+}
+
+// workspaceWebhook - Loads the webhook named by the webhookId route variable, writing 404 if the
+// workspace has no such webhook.
+func (a *API) workspaceWebhook(w http.ResponseWriter, r *http.Request, workspaceId int) (*database.Webhook, bool) {
+	webhookId, err := strconv.Atoi(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhookId", http.StatusBadRequest)
+		return nil, false
+	}
+	found, err := a.Store.GetWebhookById(workspaceId, webhookId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if found == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return found, true
+}
+
+// validEventTypes - Reports whether every type is one the store publishes.
+func validEventTypes(eventTypes []string) bool {
+	for _, eventType := range eventTypes {
+		known := false
+		for _, published := range database.EventTypes {
+			known = known || eventType == published
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *API) createWebhook(w http.ResponseWriter, r *http.Request) {
+	workspaceId, ok := a.requireWebhookAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(strings.TrimSpace(request.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if !a.AllowPrivateWebhooks {
+		if err := webhook.CheckURL(r.Context(), target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !validEventTypes(request.EventTypes) {
+		http.Error(w, "eventTypes must be among "+strings.Join(database.EventTypes, ", "), http.StatusBadRequest)
+		return
+	}
+	if request.DocumentId != 0 {
+		document, err := a.Store.GetDocumentById(workspaceId, request.DocumentId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		role := database.RoleNone
+		if document != nil {
+			if role, err = a.Store.GetDocumentRole(document.Id, currentUser(r).Id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if role == database.RoleNone { // Indistinguishable from a missing document
+			http.Error(w, "Document not found", http.StatusBadRequest)
+			return
+		}
+	}
+	secret := request.Secret
+	if secret == "" {
+		if secret, err = webhook.NewSecret(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	created, err := a.Store.CreateWebhook(database.Webhook{
+		WorkspaceId: workspaceId,
+		DocumentId:  request.DocumentId,
+		URL:         target.String(),
+		Secret:      secret,
+		EventTypes:  request.EventTypes,
+		CreatedBy:   currentUser(r).Id,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (a *API) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	workspaceId, ok := a.requireWebhookAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	webhooks, err := a.Store.GetWebhooks(workspaceId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = "" // Only shown when created
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (a *API) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	workspaceId, ok := a.requireWebhookAdmin(w, r)
+	if !ok {
+		return
+	}
+	found, ok := a.workspaceWebhook(w, r, workspaceId)
+	if !ok {
+		return
+	}
+
+	if _, err := a.Store.DeleteWebhook(workspaceId, found.Id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	workspaceId, ok := a.requireWebhookAdmin(w, r)
+	if !ok {
+		return
+	}
+	found, ok := a.workspaceWebhook(w, r, workspaceId)
+	if !ok {
+		return
+	}
+	limit := database.MaxDeliveryPage
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 || limit > database.MaxDeliveryPage {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(database.MaxDeliveryPage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := a.Store.GetWebhookDeliveries(workspaceId, found.Id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (a *API) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	workspaceId, ok := a.requireWebhookAdmin(w, r)
+	if !ok {
+		return
+	}
+	found, ok := a.workspaceWebhook(w, r, workspaceId)
+	if !ok {
+		return
+	}
+	deliveryId, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid deliveryId", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := a.Store.RedeliverWebhookDelivery(workspaceId, found.Id, deliveryId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
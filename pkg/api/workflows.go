@@ -0,0 +1,200 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// routeDocument - Loads the document named by the documentId route variable if the user
+// holds at least minimum on it, writing an error if not.
+func (a *API) routeDocument(w http.ResponseWriter, r *http.Request, minimum database.Role) (*database.User, *database.Document, bool) {
+	user, workspaceId, ok := a.requireWorkspace(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	documentId, err := strconv.Atoi(mux.Vars(r)["documentId"])
+	if err != nil {
+		http.Error(w, "Invalid documentId", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	document, ok := a.authorizeDocument(w, user, workspaceId, documentId, minimum)
+	return user, document, ok
+}
+
+// writeWorkflowStatus - Writes a document's workflow with the approvals of its latest version.
+func (a *API) writeWorkflowStatus(w http.ResponseWriter, document *database.Document) {
+	current, err := a.Store.GetWorkflow(document.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	approvals, err := a.Store.GetApprovals(document.Id, document.LatestVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WorkflowStatus{DocumentWorkflow: *current, LatestVersion: document.LatestVersion, Approvals: approvals})
+}
+
+func (a *API) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	a.writeWorkflowStatus(w, document)
+}
+
+func (a *API) setWorkflow(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var workflow database.Workflow
+	if err := json.NewDecoder(r.Body).Decode(&workflow); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Approvers must be able to see the document's workspace.
+	for _, userId := range workflow.RequiredApprovers {
+		approver, err := a.workspaceMember(document.WorkspaceId, userId, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if approver == nil {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := a.Store.SetWorkflow(document.Id, workflow, user.Id); err != nil {
+		if errors.Is(err, database.ErrInvalidWorkflow) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.writeWorkflowStatus(w, document)
+}
+
+func (a *API) approveVersion(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleCommenter)
+	if !ok {
+		return
+	}
+
+	var request ApproveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Version < 1 {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := a.Store.ApproveVersion(document.Id, request.Version, user.Id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotLatestVersion) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, database.ErrSelfApproval) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(approval)
+}
+
+func (a *API) withdrawApproval(w http.ResponseWriter, r *http.Request) {
+	user, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := a.Store.WithdrawApproval(document.Id, version, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) transitionDocument(w http.ResponseWriter, r *http.Request) {
+	// The workflow decides who may make each transition; viewers never can.
+	user, document, ok := a.routeDocument(w, r, database.RoleCommenter)
+	if !ok {
+		return
+	}
+
+	var request TransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	to := strings.TrimSpace(request.To)
+	if to == "" {
+		http.Error(w, "to is required", http.StatusBadRequest)
+		return
+	}
+
+	transition, err := a.Store.TransitionDocument(document.Id, to, user.Id, strings.TrimSpace(request.Reason))
+	if err != nil {
+		var approvalRequired *database.ApprovalRequiredError
+		switch {
+		case errors.Is(err, database.ErrTransitionNotAllowed), errors.As(err, &approvalRequired):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, database.ErrPermissionDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transition)
+}
+
+func (a *API) getTransitions(w http.ResponseWriter, r *http.Request) {
+	_, document, ok := a.routeDocument(w, r, database.RoleViewer)
+	if !ok {
+		return
+	}
+
+	transitions, err := a.Store.GetTransitions(document.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transitions)
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// workspaceHeader - Selects the workspace a request works in.
+const workspaceHeader = "X-Workspace-Id"
+
+// requireWorkspace - The acting user and the workspace the request works in, writing an error
+// if there is none. The workspace comes from the X-Workspace-Id header, or is the first one the
+// user joined. A workspace the user does not belong to is reported as not found.
+func (a *API) requireWorkspace(w http.ResponseWriter, r *http.Request) (*database.User, int, bool) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+
+	header := strings.TrimSpace(r.Header.Get(workspaceHeader))
+	if header == "" {
+		workspaces, err := a.Store.GetWorkspacesByUserId(user.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, 0, false
+		}
+		if len(workspaces) == 0 {
+			http.Error(w, "You do not belong to any workspace", http.StatusBadRequest)
+			return nil, 0, false
+		}
+		return user, workspaces[0].Id, true
+	}
+
+	workspaceId, err := strconv.Atoi(header)
+	if err != nil {
+		http.Error(w, "Invalid "+workspaceHeader+" header", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	if _, ok := a.checkWorkspaceRole(w, user, workspaceId, database.WorkspaceRoleMember); !ok {
+		return nil, 0, false
+	}
+	return user, workspaceId, true
+}
+
+// checkWorkspaceRole - Checks the user belongs to a workspace, and is an admin if minimum is
+// WorkspaceRoleAdmin. Non-members get 404 so other tenants' workspaces do not leak.
+func (a *API) checkWorkspaceRole(w http.ResponseWriter, user *database.User, workspaceId int, minimum database.WorkspaceRole) (database.WorkspaceRole, bool) {
+	role, err := a.Store.GetWorkspaceRole(workspaceId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return role, false
+	}
+	if role == database.WorkspaceRoleNone {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return role, false
+	}
+	if minimum == database.WorkspaceRoleAdmin && role != database.WorkspaceRoleAdmin {
+		http.Error(w, "This requires the admin role in the workspace", http.StatusForbidden)
+		return role, false
+	}
+	return role, true
+}
+
+// workspaceMember - Finds a user by Id or username who belongs to the workspace. Nil if there is
+// no such member, so users of other workspaces look the same as users who do not exist.
+func (a *API) workspaceMember(workspaceId, userId int, username string) (*database.User, error) {
+	user, err := a.lookupUser(userId, username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	role, err := a.Store.GetWorkspaceRole(workspaceId, user.Id)
+	if err != nil || role == database.WorkspaceRoleNone {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (a *API) createWorkspace(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	workspace, err := a.Store.CreateWorkspace(database.Workspace{Name: name}, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (a *API) getWorkspaces(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	workspaces, err := a.Store.GetWorkspacesByUserId(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+func (a *API) getWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, err := strconv.Atoi(mux.Vars(r)["workspaceId"])
+	if err != nil {
+		http.Error(w, "Invalid workspaceId", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.checkWorkspaceRole(w, user, workspaceId, database.WorkspaceRoleMember); !ok {
+		return
+	}
+
+	members, err := a.Store.GetWorkspaceMembers(workspaceId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(members)
+}
+
+func (a *API) setWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, err := strconv.Atoi(mux.Vars(r)["workspaceId"])
+	if err != nil {
+		http.Error(w, "Invalid workspaceId", http.StatusBadRequest)
+		return
+	}
+	if _, ok := a.checkWorkspaceRole(w, user, workspaceId, database.WorkspaceRoleAdmin); !ok {
+		return
+	}
+
+	var request WorkspaceMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Role == database.WorkspaceRoleNone {
+		request.Role = database.WorkspaceRoleMember
+	}
+	if !request.Role.Valid() {
+		http.Error(w, "role must be member or admin", http.StatusBadRequest)
+		return
+	}
+	member, err := a.lookupUser(request.UserId, request.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if member == nil {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := a.Store.SetWorkspaceMember(database.WorkspaceMember{WorkspaceId: workspaceId, UserId: member.Id, Role: request.Role})
+	if err != nil {
+		if errors.Is(err, database.ErrLastAdmin) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (a *API) removeWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	workspaceId, err := strconv.Atoi(vars["workspaceId"])
+	if err != nil {
+		http.Error(w, "Invalid workspaceId", http.StatusBadRequest)
+		return
+	}
+	userId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+
+	// Members may leave; removing anyone else is for admins.
+	minimum := database.WorkspaceRoleAdmin
+	if userId == user.Id {
+		minimum = database.WorkspaceRoleMember
+	}
+	if _, ok := a.checkWorkspaceRole(w, user, workspaceId, minimum); !ok {
+		return
+	}
+
+	removed, err := a.Store.RemoveWorkspaceMember(workspaceId, userId)
+	if err != nil {
+		if errors.Is(err, database.ErrLastAdmin) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
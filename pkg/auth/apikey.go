@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// APIKeyPrefix - Starts every API key so leaked keys are easy to recognise.
+const APIKeyPrefix = "dca_"
+
+// GenerateAPIKey - Creates a random API key. The key is shown to its owner once; only its
+// hash is stored. The returned prefix identifies the key in listings without revealing it.
+func GenerateAPIKey() (key string, prefix string, err error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(random)
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+// HashAPIKey - The value stored for an API key and looked up when it is presented.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSecret - A random secret for signing tokens when none is configured.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	return secret, err
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken - The token is malformed, uses another algorithm or has a bad signature.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired - The token was valid but its exp has passed, or its nbf has not.
+	ErrTokenExpired = errors.New("token expired or not yet valid")
+)
+
+// ClockSkew - How far exp and nbf may be off to allow for clocks that disagree.
+const ClockSkew = 30 * time.Second
+
+// Claims - The JWT claims the API issues and checks. Subject holds the user Id.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// SignToken - Encodes claims as an HS256 signed JWT.
+func SignToken(secret []byte, claims Claims) (string, error) {
+	headerJSON, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(claimsJSON)
+	return signingInput + "." + encoding.EncodeToString(sign(secret, signingInput)), nil
+}
+
+// VerifyToken - Checks an HS256 JWT against the secret and the current time and returns its claims.
+// Tokens without an exp claim are rejected.
+func VerifyToken(secret []byte, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil || h.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(ClockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(ClockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// LooksLikeToken - Reports whether a credential has the three dot-separated parts of a JWT
+// rather than being an API key.
+func LooksLikeToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+func sign(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerifyToken(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Unix(1700000000, 0)
+	token, err := SignToken(secret, Claims{Subject: "42", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	if !LooksLikeToken(token) {
+		t.Errorf("Expected %q to look like a token", token)
+	}
+
+	claims, err := VerifyToken(secret, token, now)
+	if err != nil || claims.Subject != "42" {
+		t.Fatalf("Expected subject 42, got %+v (%v)", claims, err)
+	}
+
+	if _, err := VerifyToken([]byte("other secret"), token, now); err != ErrInvalidToken {
+		t.Errorf("Expected a wrong secret to fail, got %v", err)
+	}
+	if _, err := VerifyToken(secret, token, now.Add(2*time.Hour)); err != ErrTokenExpired {
+		t.Errorf("Expected an expired token to fail, got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	tampered, _ := SignToken(secret, Claims{Subject: "1", ExpiresAt: now.Add(time.Hour).Unix()})
+	if _, err := VerifyToken(secret, parts[0]+"."+strings.Split(tampered, ".")[1]+"."+parts[2], now); err != ErrInvalidToken {
+		t.Errorf("Expected swapped claims to fail, got %v", err)
+	}
+}
+
+func TestVerifyTokenRejectsOtherAlgorithms(t *testing.T) {
+	// {"alg":"none"} with claims {"sub":"1","exp":9999999999} and no signature.
+	token := "eyJhbGciOiJub25lIn0.eyJzdWIiOiIxIiwiZXhwIjo5OTk5OTk5OTk5fQ."
+	if _, err := VerifyToken([]byte("secret"), token, time.Now()); err != ErrInvalidToken {
+		t.Errorf("Expected alg none to be rejected, got %v", err)
+	}
+
+	withoutExpiry, _ := SignToken([]byte("secret"), Claims{Subject: "1"})
+	if _, err := VerifyToken([]byte("secret"), withoutExpiry, time.Now()); err != ErrInvalidToken {
+		t.Errorf("Expected a token without exp to be rejected, got %v", err)
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	other, _, _ := GenerateAPIKey()
+	if key == other || !strings.HasPrefix(key, prefix) || !strings.HasPrefix(key, APIKeyPrefix) || LooksLikeToken(key) {
+		t.Errorf("Unexpected key %q with prefix %q", key, prefix)
+	}
+	if HashAPIKey(key) == HashAPIKey(other) || HashAPIKey(key) != HashAPIKey(key) {
+		t.Errorf("Expected hashes to be stable and distinct")
+	}
+}
@@ -6,6 +6,21 @@ type Draft struct {
 	Name          string `json:"name"`
 	Content       string `json:"content"`
 	VersionNumber int    `json:"versionNumber"`
+	BaseVersion   *int   `json:"baseVersion,omitempty"` // Version the edit started from, 0 for a new document
+	UserId        int    `json:"-"`                     // Who is adding the draft and its author, 0 to skip permission checks
+	WorkspaceId   int    `json:"-"`                     // The workspace the document belongs to
+
+	Summary  string            `json:"summary,omitempty"`  // What changed and why
+	Metadata map[string]string `json:"metadata,omitempty"` // Free-form values kept with the draft
+
+	// Set when restoring: the earlier version Content was copied from, and whether that
+	// version's comments and anchors come along.
+	RestoredFromVersion int  `json:"-"`
+	CarryComments       bool `json:"-"`
+
+	// Set when accepting suggestions: the suggestion comments Content applies, which are marked
+	// accepted by the new draft.
+	AcceptSuggestions []int `json:"-"`
 }
 
 type Reaction struct {
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"documentapi/pkg/diff"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// AnchorError - A comment anchor does not fit the draft it was made on.
+type AnchorError struct {
+	Message string
+}
+
+func (e *AnchorError) Error() string {
+	return "invalid anchor: " + e.Message
+}
+
+// IsAnchorError - Reports whether err is caused by an invalid comment anchor.
+func IsAnchorError(err error) bool {
+	var anchorErr *AnchorError
+	return errors.As(err, &anchorErr)
+}
+
+// resolveAnchor - Fills in an anchor against the content it was made on. A character range
+// is used if End is past Start, otherwise a line range if StartLine is set, otherwise the
+// first occurrence of the quote. A quote given with a range must match the text it covers.
+func resolveAnchor(content string, anchor Anchor) (Anchor, error) {
+	runes := []rune(content)
+	quote := anchor.Quote
+
+	switch {
+	case anchor.End > anchor.Start:
+		if anchor.Start < 0 || anchor.End > len(runes) {
+			return Anchor{}, &AnchorError{Message: "character range is outside the draft"}
+		}
+	case anchor.StartLine > 0:
+		if anchor.EndLine == 0 {
+			anchor.EndLine = anchor.StartLine
+		}
+		lines := strings.SplitAfter(content, "\n")
+		if anchor.EndLine < anchor.StartLine || anchor.EndLine > len(lines) {
+			return Anchor{}, &AnchorError{Message: "line range is outside the draft"}
+		}
+		anchor.Start = 0
+		for _, line := range lines[:anchor.StartLine-1] {
+			anchor.Start += utf8.RuneCountInString(line)
+		}
+		anchor.End = anchor.Start
+		for _, line := range lines[anchor.StartLine-1 : anchor.EndLine] {
+			anchor.End += utf8.RuneCountInString(line)
+		}
+		if anchor.End > anchor.Start && runes[anchor.End-1] == '\n' {
+			anchor.End--
+		}
+		if anchor.End == anchor.Start {
+			return Anchor{}, &AnchorError{Message: "line range is empty"}
+		}
+	case quote != "":
+		index := strings.Index(content, quote)
+		if index < 0 {
+			return Anchor{}, &AnchorError{Message: "quote does not appear in the draft"}
+		}
+		anchor.Start = utf8.RuneCountInString(content[:index])
+		anchor.End = anchor.Start + utf8.RuneCountInString(quote)
+	default:
+		return Anchor{}, &AnchorError{Message: "a character range, line range or quote is required"}
+	}
+
+	anchor.Quote = string(runes[anchor.Start:anchor.End])
+	if quote != "" && quote != anchor.Quote {
+		return Anchor{}, &AnchorError{Message: "quote does not match the anchored text"}
+	}
+	anchor.StartLine, anchor.EndLine = anchorLines(runes, anchor.Start, anchor.End)
+	anchor.Orphaned = false
+	return anchor, nil
+}
+
+// anchorLines - The first and last line touched by the range [start, end).
+func anchorLines(runes []rune, start, end int) (int, int) {
+	startLine := 1
+	for _, r := range runes[:start] {
+		if r == '\n' {
+			startLine++
+		}
+	}
+	endLine := startLine
+	for _, r := range runes[start : end-1] {
+		if r == '\n' {
+			endLine++
+		}
+	}
+	return startLine, endLine
+}
+
+// remapAnchors - Carries the anchors of one draft onto the next version of its document.
+// Anchors whose text was deleted keep their last quote and are flagged as orphaned.
+func remapAnchors(oldContent, newContent string, anchors []CommentAnchor, draftId int) []CommentAnchor {
+	if len(anchors) == 0 {
+		return nil
+	}
+	offsets := diff.NewOffsetMap(oldContent, newContent)
+	runes := []rune(newContent)
+
+	remapped := make([]CommentAnchor, 0, len(anchors))
+	for _, anchor := range anchors {
+		anchor.DraftId = draftId
+		if !anchor.Orphaned {
+			start, end, ok := offsets.MapRange(anchor.Start, anchor.End)
+			if ok {
+				anchor.Start, anchor.End = start, end
+				anchor.Quote = string(runes[start:end])
+				anchor.StartLine, anchor.EndLine = anchorLines(runes, start, end)
+			} else {
+				anchor.Anchor = Anchor{Quote: anchor.Quote, Orphaned: true}
+			}
+		}
+		remapped = append(remapped, anchor)
+	}
+	return remapped
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"documentapi/pkg/common"
+	"time"
+)
+
+// CreateBranch - Forks a branch from branch.BaseVersion of its document. Returns ErrNotFound if
+// the document has no such version and ErrBranchExists if the name is taken.
+func (s *sqlStore) CreateBranch(branch Branch) (*Branch, error) {
+	branch.HeadVersion, branch.CreatedAt = 0, time.Now()
+	query := `
+        INSERT INTO branches (DocumentId, Name, BaseVersion, CreatedBy, CreatedAt)
+        SELECT DocumentId, ?, VersionNumber, ?, ? FROM drafts WHERE DocumentId = ? AND VersionNumber = ?
+        ON CONFLICT (DocumentId, Name) DO NOTHING
+        RETURNING Id`
+	err := s.QueryRow(s.rebind(query), branch.Name, branch.CreatedBy, branch.CreatedAt, branch.DocumentId,
+		branch.BaseVersion).Scan(&branch.Id)
+	if err == sql.ErrNoRows {
+		// Either the version is missing or the name is taken.
+		existing, err := s.GetBranch(branch.DocumentId, branch.Name)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, ErrBranchExists
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+const branchColumns = `
+        Id, DocumentId, Name, BaseVersion, HeadVersion, CreatedBy, CreatedAt,
+        MergedVersion, MergedHeadVersion, MergedBy, MergedAt`
+
+func scanBranch(row interface{ Scan(...interface{}) error }) (*Branch, error) {
+	var branch Branch
+	if err := row.Scan(&branch.Id, &branch.DocumentId, &branch.Name, &branch.BaseVersion, &branch.HeadVersion,
+		&branch.CreatedBy, &branch.CreatedAt, &branch.MergedVersion, &branch.MergedHeadVersion, &branch.MergedBy,
+		&branch.MergedAt); err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+// GetBranch - Retrieves a document's branch by its name.
+func (s *sqlStore) GetBranch(documentId int, name string) (*Branch, error) {
+	query := `SELECT ` + branchColumns + ` FROM branches WHERE DocumentId = ? AND Name = ?`
+	branch, err := scanBranch(s.QueryRow(s.rebind(query), documentId, name))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	return branch, err
+}
+
+// GetBranches - Lists a document's branches, oldest first.
+func (s *sqlStore) GetBranches(documentId int) ([]Branch, error) {
+	query := `SELECT ` + branchColumns + ` FROM branches WHERE DocumentId = ? ORDER BY Id`
+	rows, err := s.Query(s.rebind(query), documentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := []Branch{}
+	for rows.Next() {
+		branch, err := scanBranch(rows)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, *branch)
+	}
+	return branches, rows.Err()
+}
+
+// AddBranchDraft - Adds the next version of a branch. Returns ErrNotFound if there is no such branch.
+func (s *sqlStore) AddBranchDraft(branchId int, content string, userId int) (*BranchDraft, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	created := BranchDraft{BranchId: branchId, Content: content, CreatedBy: userId, CreatedAt: time.Now()}
+	query := `UPDATE branches SET HeadVersion = HeadVersion + 1 WHERE Id = ? RETURNING HeadVersion`
+	if err := tx.QueryRow(s.rebind(query), branchId).Scan(&created.VersionNumber); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	query = `INSERT INTO branch_drafts (BranchId, Content, VersionNumber, CreatedBy, CreatedAt) VALUES (?, ?, ?, ?, ?) RETURNING Id`
+	if err := tx.QueryRow(s.rebind(query), branchId, content, created.VersionNumber, userId, created.CreatedAt).Scan(&created.Id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+const branchDraftColumns = `Id, BranchId, Content, VersionNumber, CreatedBy, CreatedAt`
+
+func branchDraftFields(draft *BranchDraft) []interface{} {
+	return []interface{}{&draft.Id, &draft.BranchId, &draft.Content, &draft.VersionNumber, &draft.CreatedBy, &draft.CreatedAt}
+}
+
+// GetBranchDraft - Retrieves a version of a branch.
+func (s *sqlStore) GetBranchDraft(branchId, version int) (*BranchDraft, error) {
+	query := `SELECT ` + branchDraftColumns + ` FROM branch_drafts WHERE BranchId = ? AND VersionNumber = ?`
+	var draft BranchDraft
+	if err := s.QueryRow(s.rebind(query), branchId, version).Scan(branchDraftFields(&draft)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	return &draft, nil
+}
+
+// GetBranchDrafts - Lists the versions of a branch, oldest first.
+func (s *sqlStore) GetBranchDrafts(branchId int) ([]BranchDraft, error) {
+	query := `SELECT ` + branchDraftColumns + ` FROM branch_drafts WHERE BranchId = ? ORDER BY VersionNumber`
+	rows, err := s.Query(s.rebind(query), branchId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []BranchDraft{}
+	for rows.Next() {
+		var draft BranchDraft
+		if err := rows.Scan(branchDraftFields(&draft)...); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+// MergeBranch - Records that headVersion of a branch was merged into the document by userId, so
+// the next merge starts from there. The merged draft, if any, becomes the document's next version
+// in the same transaction, as CreateDraft would write it; without one the document already has the
+// branch's changes in mergedVersion. An older merge never replaces a newer one. Returns
+// ErrNotFound if there is no such branch, or CreateDraft's errors for the draft.
+func (s *sqlStore) MergeBranch(branchId, headVersion int, merged *common.Draft, mergedVersion, userId int) (*Draft, *Branch, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var created *Draft
+	var event Event
+	if merged != nil {
+		if created, event, err = s.createDraft(tx, *merged); err != nil {
+			tx.Rollback()
+			if err == errVersionMismatch {
+				return nil, nil, s.versionConflict(*merged)
+			}
+			return nil, nil, err
+		}
+		mergedVersion = created.VersionNumber
+	}
+
+	query := `
+        UPDATE branches SET MergedVersion = ?, MergedHeadVersion = ?, MergedBy = ?, MergedAt = ?
+        WHERE Id = ? AND MergedHeadVersion <= ?`
+	if _, err := tx.Exec(s.rebind(query), mergedVersion, headVersion, userId, time.Now(), branchId, headVersion); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	query = `SELECT ` + branchColumns + ` FROM branches WHERE Id = ?`
+	branch, err := scanBranch(tx.QueryRow(s.rebind(query), branchId))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	if created != nil {
+		s.bus.Publish(event)
+	}
+	return created, branch, nil
+}
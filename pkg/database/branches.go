@@ -0,0 +1,35 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// Branch - A named line of drafts forked from one version of a document. Its drafts are numbered
+// from 1 apart from the document's, and merging it back creates a new version of the document.
+type Branch struct {
+	Id                int        `json:"id"`
+	DocumentId        int        `json:"documentId"`
+	Name              string     `json:"name"`
+	BaseVersion       int        `json:"baseVersion"` // The document version it was forked from
+	HeadVersion       int        `json:"headVersion"` // 0 until it has a draft of its own
+	CreatedBy         int        `json:"createdBy"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	MergedVersion     int        `json:"mergedVersion,omitempty"`     // The document version its last merge created
+	MergedHeadVersion int        `json:"mergedHeadVersion,omitempty"` // Its own version that merge took in
+	MergedBy          int        `json:"mergedBy,omitempty"`
+	MergedAt          *time.Time `json:"mergedAt,omitempty"`
+}
+
+// BranchDraft - One version of a branch.
+type BranchDraft struct {
+	Id            int       `json:"id"`
+	BranchId      int       `json:"branchId"`
+	Content       string    `json:"content"`
+	VersionNumber int       `json:"versionNumber"`
+	CreatedBy     int       `json:"createdBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// ErrBranchExists - CreateBranch was given a name the document already has a branch by.
+var ErrBranchExists = errors.New("the document already has a branch with this name")
@@ -3,119 +3,438 @@ package database
 import (
 	"database/sql"
 	"documentapi/pkg/common"
+	"encoding/binary"
+	"fmt"
 	"time"
 )
 
-// createDocument - Creates a new document or increments the version.
-func (s *SQLite) createDocument(name string) (*Document, error) {
-	existingDocument, err := s.GetDocumentByName(name)
+// nextDocumentVersion - Creates the named document in a workspace at version 1, or bumps its
+// latest version, in a single statement so concurrent writers can never share a version number.
+// With a base version the bump only happens if the document is still at that version (0 meaning
+// it must not exist yet); otherwise errVersionMismatch is returned.
+func (s *sqlStore) nextDocumentVersion(tx *sql.Tx, workspaceId int, name string, baseVersion *int) (int, int, error) {
+	var query string
+	var args []interface{}
+	switch {
+	case baseVersion == nil:
+		query = `
+            INSERT INTO documents (WorkspaceId, Name, CreatedAt, LatestVersion) VALUES (?, ?, ?, 1)
+            ON CONFLICT (WorkspaceId, Name) DO UPDATE SET LatestVersion = documents.LatestVersion + 1
+            RETURNING Id, LatestVersion`
+		args = []interface{}{workspaceId, name, time.Now()}
+	case *baseVersion == 0:
+		query = `
+            INSERT INTO documents (WorkspaceId, Name, CreatedAt, LatestVersion) VALUES (?, ?, ?, 1)
+            ON CONFLICT (WorkspaceId, Name) DO NOTHING
+            RETURNING Id, LatestVersion`
+		args = []interface{}{workspaceId, name, time.Now()}
+	default:
+		query = `
+            UPDATE documents SET LatestVersion = LatestVersion + 1
+            WHERE WorkspaceId = ? AND Name = ? AND LatestVersion = ?
+            RETURNING Id, LatestVersion`
+		args = []interface{}{workspaceId, name, *baseVersion}
+	}
+
+	var documentId, version int
+	if err := tx.QueryRow(s.rebind(query), args...).Scan(&documentId, &version); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, errVersionMismatch
+		}
+		return 0, 0, err
+	}
+	return documentId, version, nil
+}
+
+const documentColumns = `
+        Id, WorkspaceId, Name, LatestVersion, CreatedAt,
+        PublishedVersion, PublishedAt, PublishedBy, ScheduledVersion, ScheduledAt, ScheduledBy`
+
+func scanDocument(row interface{ Scan(...interface{}) error }) (*Document, error) {
+	var document Document
+	if err := row.Scan(&document.Id, &document.WorkspaceId, &document.Name, &document.LatestVersion, &document.CreatedAt,
+		&document.PublishedVersion, &document.PublishedAt, &document.PublishedBy, &document.ScheduledVersion,
+		&document.ScheduledAt, &document.ScheduledBy); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// GetDocumentById - Retrieves a document in a workspace by its ID.
+func (s *sqlStore) GetDocumentById(workspaceId, id int) (*Document, error) {
+	query := `SELECT ` + documentColumns + ` FROM documents WHERE Id = ? AND WorkspaceId = ?`
+	document, err := scanDocument(s.QueryRow(s.rebind(query), id, workspaceId))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	return document, err
+}
+
+// GetDocumentByName - Retrieves a document in a workspace by its name.
+func (s *sqlStore) GetDocumentByName(workspaceId int, name string) (*Document, error) {
+	query := `SELECT ` + documentColumns + ` FROM documents WHERE WorkspaceId = ? AND Name = ?`
+	document, err := scanDocument(s.QueryRow(s.rebind(query), workspaceId, name))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	return document, err
+}
+
+// CreateDraft - Creates a new draft for the named document in draft.WorkspaceId. The document
+// row and the draft are written in one transaction. If draft.BaseVersion is set and the document has moved on,
+// a *VersionConflictError describing the current head is returned.
+// With a draft.UserId the user becomes the owner of a new document, and must be at least an
+// editor of an existing one or ErrPermissionDenied is returned. Suggestions in draft.AcceptSuggestions
+// are marked accepted and their threads resolved, or ErrSuggestionDecided returned unless each is
+// still pending on the document. Approvals of earlier versions are invalidated, sending an
+// approved document back for review. A draft.created event is published.
+func (s *sqlStore) CreateDraft(draft common.Draft) (*Draft, error) {
+	tx, err := s.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	if existingDocument != nil {
-		existingDocument.LatestVersion += 1
-		if err := s.incrementDocumentVersion(existingDocument); err != nil {
-			return nil, err
+	created, event, err := s.createDraft(tx, draft)
+	if err != nil {
+		tx.Rollback()
+		if err == errVersionMismatch {
+			return nil, s.versionConflict(draft)
 		}
-		return existingDocument, nil
+		return nil, err
 	}
 
-	query := `INSERT INTO documents (Name, CreatedAt, LatestVersion) VALUES (?, ?, ?)`
-	res, err := s.Exec(query, name, time.Now(), 1)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	s.bus.Publish(event)
+	return created, nil
+}
+
+// createDraft - Writes a draft as CreateDraft does in the caller's transaction, returning the
+// draft.created event to publish once it commits, or errVersionMismatch if the document has
+// moved on from draft.BaseVersion.
+func (s *sqlStore) createDraft(tx *sql.Tx, draft common.Draft) (*Draft, Event, error) {
+	documentId, version, err := s.nextDocumentVersion(tx, draft.WorkspaceId, draft.Name, draft.BaseVersion)
+	if err != nil {
+		return nil, Event{}, err
+	}
+
+	if draft.UserId != 0 {
+		if version == 1 {
+			err = s.grantOwner(tx, documentId, draft.UserId)
+		} else {
+			var role Role
+			if role, err = s.documentRole(tx, documentId, draft.UserId); err == nil && !role.AtLeast(RoleEditor) {
+				err = ErrPermissionDenied
+			}
+		}
+		if err != nil {
+			return nil, Event{}, err
+		}
+	}
+
+	created := Draft{
+		DocumentId:          documentId,
+		Content:             draft.Content,
+		VersionNumber:       version,
+		CreatedAt:           time.Now(),
+		RestoredFromVersion: draft.RestoredFromVersion,
+		AuthorId:            draft.UserId,
+		Summary:             draft.Summary,
+		Metadata:            DraftMetadata(draft.Metadata).copy(),
+	}
+	query := `
+        INSERT INTO drafts (DocumentId, Content, VersionNumber, CreatedAt, RestoredFromVersion, AuthorId, Summary, Metadata)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING Id`
+	if err = tx.QueryRow(s.rebind(query), documentId, draft.Content, version, created.CreatedAt, draft.RestoredFromVersion,
+		created.AuthorId, created.Summary, created.Metadata).Scan(&created.Id); err != nil {
+		return nil, Event{}, err
+	}
 
-	id, err := res.LastInsertId()
+	if err := s.acceptSuggestions(tx, created, draft.AcceptSuggestions, draft.UserId); err != nil {
+		return nil, Event{}, err
+	}
+	if err := s.carryForward(tx, created); err != nil {
+		return nil, Event{}, err
+	}
+	if draft.CarryComments && draft.RestoredFromVersion > 0 {
+		if err := s.carryRestored(tx, created); err != nil {
+			return nil, Event{}, err
+		}
+	}
+	if err := s.invalidateApprovals(tx, created, draft.UserId); err != nil {
+		return nil, Event{}, err
+	}
+
+	event, err := newEvent(EventDraftCreated, draft.WorkspaceId, documentId, created.Id, created)
+	if err == nil {
+		err = s.recordEvent(tx, &event)
+	}
+	if err != nil {
+		return nil, Event{}, err
+	}
+	return &created, event, nil
+}
+
+// carryForward - Carries every unresolved comment on earlier versions over to a new draft as
+// an open item and re-maps the comment anchors of the previous version onto it.
+func (s *sqlStore) carryForward(tx *sql.Tx, draft Draft) error {
+	if draft.VersionNumber < 2 {
+		return nil
+	}
+
+	query := `
+        INSERT INTO comment_carryovers (CommentId, DraftId)
+        SELECT c.Id, ? FROM comments c
+        JOIN drafts d ON d.Id = c.DraftId
+        WHERE d.DocumentId = ? AND d.VersionNumber < ? AND NOT c.Resolved`
+	if _, err := tx.Exec(s.rebind(query), draft.Id, draft.DocumentId, draft.VersionNumber); err != nil {
+		return err
+	}
+
+	var previousId int
+	var previousContent string
+	query = `SELECT Id, Content FROM drafts WHERE DocumentId = ? AND VersionNumber = ?`
+	if err := tx.QueryRow(s.rebind(query), draft.DocumentId, draft.VersionNumber-1).Scan(&previousId, &previousContent); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	anchors, err := s.queryAnchors(tx, previousId)
+	if err != nil {
+		return err
+	}
+	for _, anchor := range remapAnchors(previousContent, draft.Content, anchors, draft.Id) {
+		if err := s.insertAnchor(tx, anchor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carryRestored - Brings the comments of the version a draft was restored from onto it, resolved
+// ones included, with their anchors as they were in that version. Returns ErrNotFound if the
+// document has no such version.
+func (s *sqlStore) carryRestored(tx *sql.Tx, draft Draft) error {
+	var sourceId int
+	query := `SELECT Id FROM drafts WHERE DocumentId = ? AND VersionNumber = ? AND VersionNumber < ?`
+	if err := tx.QueryRow(s.rebind(query), draft.DocumentId, draft.RestoredFromVersion, draft.VersionNumber).Scan(&sourceId); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	query = `
+        INSERT INTO comment_carryovers (CommentId, DraftId)
+        SELECT c.Id, ? FROM comments c
+        WHERE (c.DraftId = ? OR c.Id IN (SELECT CommentId FROM comment_carryovers WHERE DraftId = ?))
+          AND c.Id NOT IN (SELECT CommentId FROM comment_carryovers WHERE DraftId = ?)`
+	if _, err := tx.Exec(s.rebind(query), draft.Id, sourceId, sourceId, draft.Id); err != nil {
+		return err
+	}
+
+	// The content is the same, so the anchors fit as they were rather than as re-mapped.
+	query = `DELETE FROM comment_anchors WHERE DraftId = ? AND CommentId IN (SELECT CommentId FROM comment_anchors WHERE DraftId = ?)`
+	if _, err := tx.Exec(s.rebind(query), draft.Id, sourceId); err != nil {
+		return err
+	}
+	query = `
+        INSERT INTO comment_anchors (CommentId, DraftId, StartOffset, EndOffset, StartLine, EndLine, Quote, Orphaned)
+        SELECT CommentId, ?, StartOffset, EndOffset, StartLine, EndLine, Quote, Orphaned FROM comment_anchors WHERE DraftId = ?`
+	_, err := tx.Exec(s.rebind(query), draft.Id, sourceId)
+	return err
+}
+
+// queryer - The query method shared by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *sqlStore) queryAnchors(q queryer, draftId int) ([]CommentAnchor, error) {
+	query := `
+        SELECT CommentId, DraftId, StartOffset, EndOffset, StartLine, EndLine, Quote, Orphaned
+        FROM comment_anchors
+        WHERE DraftId = ?
+        ORDER BY Orphaned, StartOffset, EndOffset, CommentId`
+
+	rows, err := q.Query(s.rebind(query), draftId)
 	if err != nil {
 		return nil, err
 	}
-	return s.GetDocumentById(int(id))
+	defer rows.Close()
+
+	anchors := []CommentAnchor{}
+	for rows.Next() {
+		var anchor CommentAnchor
+		if err := rows.Scan(&anchor.CommentId, &anchor.DraftId, &anchor.Start, &anchor.End,
+			&anchor.StartLine, &anchor.EndLine, &anchor.Quote, &anchor.Orphaned); err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, rows.Err()
 }
 
-// incrementDocumentVersion - Updates the version of an existing document.
-func (s *SQLite) incrementDocumentVersion(document *Document) error {
-	query := `UPDATE documents SET LatestVersion = ? WHERE Name = ?`
-	_, err := s.Exec(query, document.LatestVersion, document.Name)
+func (s *sqlStore) insertAnchor(tx *sql.Tx, anchor CommentAnchor) error {
+	query := `
+        INSERT INTO comment_anchors (CommentId, DraftId, StartOffset, EndOffset, StartLine, EndLine, Quote, Orphaned)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(s.rebind(query), anchor.CommentId, anchor.DraftId, anchor.Start, anchor.End,
+		anchor.StartLine, anchor.EndLine, anchor.Quote, anchor.Orphaned)
 	return err
 }
 
-// GetDocumentById - Retrieves a document by its ID.
-func (s *SQLite) GetDocumentById(id int) (*Document, error) {
-	query := `SELECT Id, Name, CreatedAt, LatestVersion FROM documents WHERE Id = ?`
-	row := s.QueryRow(query, id)
+// versionConflict - Describes the current head of a document a stale draft was based on.
+func (s *sqlStore) versionConflict(draft common.Draft) error {
+	document, err := s.GetDocumentByName(draft.WorkspaceId, draft.Name)
+	if err != nil {
+		return err
+	}
 
-	var document Document
-	if err := row.Scan(&document.Id, &document.Name, &document.CreatedAt, &document.LatestVersion); err != nil {
+	conflict := &VersionConflictError{BaseVersion: *draft.BaseVersion, Document: document}
+	if document != nil {
+		if conflict.Head, err = s.GetDraftByVersion(draft.WorkspaceId, document.Id, document.LatestVersion); err != nil {
+			return err
+		}
+	}
+	return conflict
+}
+
+// draftColumns - The columns of a draft d, in the order draftFields scans them.
+const draftColumns = `d.Id, d.DocumentId, d.Content, d.VersionNumber, d.CreatedAt, d.RestoredFromVersion, d.AuthorId, d.Summary, d.Metadata`
+
+func draftFields(draft *Draft) []interface{} {
+	return []interface{}{&draft.Id, &draft.DocumentId, &draft.Content, &draft.VersionNumber, &draft.CreatedAt, &draft.RestoredFromVersion,
+		&draft.AuthorId, &draft.Summary, &draft.Metadata}
+}
+
+// draftsInWorkspace - Selects drafts d joined to their document doc, for filtering on doc.WorkspaceId.
+const draftsInWorkspace = `
+        SELECT ` + draftColumns + `
+        FROM drafts d
+        JOIN documents doc ON doc.Id = d.DocumentId`
+
+// GetDraftById - Retrieves a draft in a workspace by its ID.
+func (s *sqlStore) GetDraftById(workspaceId, id int) (*Draft, error) {
+	query := draftsInWorkspace + ` WHERE d.Id = ? AND doc.WorkspaceId = ?`
+	row := s.QueryRow(s.rebind(query), id, workspaceId)
+
+	var draft Draft
+	if err := row.Scan(draftFields(&draft)...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Not found
 		}
 		return nil, err
 	}
-	return &document, nil
+	return &draft, nil
 }
 
-// GetDocumentByName - Retrieves a document by its name.
-func (s *SQLite) GetDocumentByName(name string) (*Document, error) {
-	query := `SELECT Id, Name, CreatedAt, LatestVersion FROM documents WHERE Name = ?`
-	row := s.QueryRow(query, name)
+// GetDraftByVersion - Retrieves a specific version of a document in a workspace.
+func (s *sqlStore) GetDraftByVersion(workspaceId, documentId, version int) (*Draft, error) {
+	query := draftsInWorkspace + ` WHERE d.DocumentId = ? AND d.VersionNumber = ? AND doc.WorkspaceId = ?`
+	row := s.QueryRow(s.rebind(query), documentId, version, workspaceId)
 
-	var document Document
-	if err := row.Scan(&document.Id, &document.Name, &document.CreatedAt, &document.LatestVersion); err != nil {
+	var draft Draft
+	if err := row.Scan(draftFields(&draft)...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Not found
 		}
 		return nil, err
 	}
-	return &document, nil
+	return &draft, nil
 }
 
-// CreateDraft - Creates a new draft for a document.
-func (s *SQLite) CreateDraft(draft common.Draft) error {
-	tx, err := s.Begin()
+// GetDraftVersions - Retrieves versions from through to of a document in a workspace, oldest first.
+func (s *sqlStore) GetDraftVersions(workspaceId, documentId, from, to int) ([]Draft, error) {
+	query := draftsInWorkspace + `
+        WHERE d.DocumentId = ? AND d.VersionNumber BETWEEN ? AND ? AND doc.WorkspaceId = ?
+        ORDER BY d.VersionNumber`
+	rows, err := s.Query(s.rebind(query), documentId, from, to, workspaceId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	doc, err := s.createDocument(draft.Name)
-	if err != nil {
-		tx.Rollback()
-		return err
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		if err := rows.Scan(draftFields(&draft)...); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
 	}
+	return drafts, rows.Err()
+}
 
-	query := `INSERT INTO drafts (DocumentId, Content, VersionNumber, CreatedAt) VALUES (?, ?, ?, ?)`
-	if _, err = tx.Exec(query, doc.Id, draft.Content, doc.LatestVersion, time.Now()); err != nil {
-		tx.Rollback()
-		return err
+// GetDraftHistory - Lists a document's versions in a workspace, newest first, filtered and paged by options.
+func (s *sqlStore) GetDraftHistory(workspaceId, documentId int, options HistoryOptions) ([]Draft, error) {
+	if options.Limit <= 0 || options.Limit > MaxHistoryPage {
+		options.Limit = MaxHistoryPage
 	}
+	query := draftsInWorkspace + ` WHERE d.DocumentId = ? AND doc.WorkspaceId = ?`
+	args := []interface{}{documentId, workspaceId}
+	if options.Before > 0 {
+		query += ` AND d.VersionNumber < ?`
+		args = append(args, options.Before)
+	}
+	if options.AuthorId != 0 {
+		query += ` AND d.AuthorId = ?`
+		args = append(args, options.AuthorId)
+	}
+	query += ` ORDER BY d.VersionNumber DESC LIMIT ?`
 
-	return tx.Commit()
+	rows, err := s.Query(s.rebind(query), append(args, options.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		if err := rows.Scan(draftFields(&draft)...); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
 }
 
-// GetLatestDrafts - Gets the latest drafts, and if limit is 0, it will return all drafts.
-func (s *SQLite) GetLatestDrafts(limit int) ([]Draft, error) {
+// GetLatestDrafts - Gets the latest drafts of the documents in scope, and if limit is 0, it will return all drafts.
+// With an authorId only that user's drafts are counted and returned.
+func (s *sqlStore) GetLatestDrafts(scope Scope, limit, authorId int) ([]Draft, error) {
+	inScope, args := scope.condition("d.DocumentId")
+	byAuthor, newerByAuthor := "", ""
+	if authorId != 0 {
+		byAuthor, newerByAuthor = ` AND d.AuthorId = ?`, ` AND d2.AuthorId = d.AuthorId`
+		args = append(args, authorId)
+	}
 	var query string
 	if limit > 0 {
 		// Query to get the latest 'limit' drafts for each DocumentId
 		query = `
-            SELECT d.Id, d.DocumentId, d.Content, d.VersionNumber, d.CreatedAt
+            SELECT ` + draftColumns + `
             FROM drafts d
             WHERE (
                 SELECT COUNT(*)
                 FROM drafts d2
-                WHERE d2.DocumentId = d.DocumentId AND d2.Id > d.Id
-            ) < ?
-            ORDER BY d.VersionNumber DESC`
+                WHERE d2.DocumentId = d.DocumentId AND d2.Id > d.Id` + newerByAuthor + `
+            ) < ? AND ` + inScope + byAuthor + `
+            ORDER BY d.VersionNumber DESC, d.Id`
+		args = append([]interface{}{limit}, args...)
 	} else {
 		// Query to get all drafts
 		query = `
-            SELECT Id, DocumentId, Content, VersionNumber, CreatedAt
-            FROM drafts
-            ORDER BY VersionNumber DESC`
+            SELECT ` + draftColumns + `
+            FROM drafts d
+            WHERE ` + inScope + byAuthor + `
+            ORDER BY d.VersionNumber DESC, d.Id`
 	}
 
-	rows, err := s.Query(query, limit)
+	rows, err := s.Query(s.rebind(query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +443,7 @@ func (s *SQLite) GetLatestDrafts(limit int) ([]Draft, error) {
 	var drafts []Draft
 	for rows.Next() {
 		var draft Draft
-		if err := rows.Scan(&draft.Id, &draft.DocumentId, &draft.Content, &draft.VersionNumber, &draft.CreatedAt); err != nil {
+		if err := rows.Scan(draftFields(&draft)...); err != nil {
 			return nil, err
 		}
 		drafts = append(drafts, draft)
@@ -137,41 +456,183 @@ func (s *SQLite) GetLatestDrafts(limit int) ([]Draft, error) {
 	return drafts, nil
 }
 
-// SearchDrafts - Searching drafts will search the content of a draft.
-func (s *SQLite) SearchDrafts(query string) ([]Draft, error) {
-	sqlQuery := `
-        SELECT Id, DocumentId, Content, VersionNumber, CreatedAt
-        FROM drafts
-        WHERE Content LIKE ?
-        ORDER BY Id DESC`
+// SearchDrafts - Full-text search over draft content, best match first, with a
+// highlighted snippet for each result.
+func (s *sqlStore) SearchDrafts(text string, options SearchOptions) ([]SearchResult, error) {
+	expr, err := parseSearch(text)
+	if err != nil {
+		return nil, err
+	}
+	if options.Limit <= 0 {
+		options.Limit = DefaultSearchLimit
+	}
+
+	if s.dialect == dialectPostgres {
+		return s.searchDraftsPostgres(expr, options)
+	}
+	if sqliteFTSModule == "fts5" {
+		return s.searchDraftsFTS5(expr, options)
+	}
+	return s.searchDraftsFTS4(expr, options)
+}
+
+// latestVersionFilter - Restricts a search to the newest draft of each document.
+const latestVersionFilter = `
+        AND d.VersionNumber = (SELECT LatestVersion FROM documents WHERE documents.Id = d.DocumentId)`
+
+func (s *sqlStore) searchDraftsFTS5(expr *searchNode, options SearchOptions) ([]SearchResult, error) {
+	query := `
+        SELECT ` + draftColumns + `,
+               -bm25(drafts_fts), snippet(drafts_fts, 0, ?, ?, ?, ?)
+        FROM drafts_fts
+        JOIN drafts d ON d.Id = drafts_fts.rowid
+        WHERE drafts_fts MATCH ?`
+	if options.LatestOnly {
+		query += latestVersionFilter
+	}
+	inScope, scopeArgs := options.condition()
+	query += `
+        AND ` + inScope + `
+        ORDER BY bm25(drafts_fts), d.Id DESC
+        LIMIT ?`
 
-	searchQuery := "%" + query + "%"
-	rows, err := s.Query(sqlQuery, searchQuery)
+	args := []interface{}{snippetStart, snippetEnd, snippetEllipsis, snippetTokens, expr.sqliteMatch("fts5")}
+	args = append(append(args, scopeArgs...), options.Limit)
+	rows, err := s.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var drafts []Draft
+	var results []SearchResult
 	for rows.Next() {
-		var draft Draft
-		if err := rows.Scan(&draft.Id, &draft.DocumentId, &draft.Content, &draft.VersionNumber, &draft.CreatedAt); err != nil {
+		var result SearchResult
+		if err := rows.Scan(append(draftFields(&result.Draft), &result.Score, &result.Snippet)...); err != nil {
 			return nil, err
 		}
-		drafts = append(drafts, draft)
+		results = append(results, result)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return drafts, nil
+	return results, nil
+}
+
+// searchDraftsFTS4 - FTS4 has no bm25(), so every match is scored from its
+// matchinfo('pcnalx') statistics and sorted here.
+func (s *sqlStore) searchDraftsFTS4(expr *searchNode, options SearchOptions) ([]SearchResult, error) {
+	query := `
+        SELECT ` + draftColumns + `,
+               matchinfo(drafts_fts, 'pcnalx'), snippet(drafts_fts, ?, ?, ?, -1, ?)
+        FROM drafts_fts
+        JOIN drafts d ON d.Id = drafts_fts.docid
+        WHERE drafts_fts MATCH ?`
+	if options.LatestOnly {
+		query += latestVersionFilter
+	}
+	inScope, scopeArgs := options.condition()
+	query += `
+        AND ` + inScope
+
+	args := []interface{}{snippetStart, snippetEnd, snippetEllipsis, snippetTokens, expr.sqliteMatch("fts4")}
+	rows, err := s.Query(query, append(args, scopeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		var matchinfo []byte
+		if err := rows.Scan(append(draftFields(&result.Draft), &matchinfo, &result.Snippet)...); err != nil {
+			return nil, err
+		}
+		result.Score = matchinfoBM25(matchinfo)
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortSearchResults(results)
+	if len(results) > options.Limit {
+		results = results[:options.Limit]
+	}
+	return results, nil
+}
+
+// matchinfoBM25 - Scores a row from FTS4 matchinfo('pcnalx') output for a single column table:
+// phrase count, column count, row count, average tokens, row tokens, then per phrase
+// the hits in this row, hits in all rows and rows with a hit.
+func matchinfoBM25(matchinfo []byte) float64 {
+	values := make([]int, len(matchinfo)/4)
+	for i := range values {
+		values[i] = int(binary.LittleEndian.Uint32(matchinfo[i*4:]))
+	}
+	if len(values) < 5 {
+		return 0
+	}
+
+	phrases, totalDocs, avgDocLen, docLen := values[0], values[2], values[3], values[4]
+	hits := make([]int, phrases)
+	docsWithHit := make([]int, phrases)
+	for i := 0; i < phrases && 5+i*3+2 < len(values); i++ {
+		hits[i] = values[5+i*3]
+		docsWithHit[i] = values[5+i*3+2]
+	}
+	return bm25(hits, docsWithHit, totalDocs, float64(docLen), float64(avgDocLen))
 }
 
-// GetAllDocumentsLatestVersions - Retrieves a list of document Id's with the latest draft versions.
-func (s *SQLite) GetAllDocumentsLatestVersions() ([]Document, error) {
-	query := `SELECT Id, Name, LatestVersion, CreatedAt FROM documents ORDER BY Id`
-	rows, err := s.Query(query)
+func (s *sqlStore) searchDraftsPostgres(expr *searchNode, options SearchOptions) ([]SearchResult, error) {
+	query := `
+        SELECT ` + draftColumns + `,
+               ts_rank_cd(d.ContentSearch, q) AS score, ts_headline('simple', coalesce(d.Content, ''), q, ?)
+        FROM drafts d, to_tsquery('simple', ?) q
+        WHERE d.ContentSearch @@ q`
+	if options.LatestOnly {
+		query += latestVersionFilter
+	}
+	inScope, scopeArgs := options.condition()
+	query += `
+        AND ` + inScope + `
+        ORDER BY score DESC, d.Id DESC
+        LIMIT ?`
+
+	headline := fmt.Sprintf(`StartSel=%s, StopSel=%s, FragmentDelimiter="%s", MaxWords=%d, MinWords=%d, MaxFragments=1`,
+		snippetStart, snippetEnd, snippetEllipsis, snippetTokens, snippetTokens/2)
+	args := []interface{}{headline, expr.tsquery()}
+	args = append(append(args, scopeArgs...), options.Limit)
+	rows, err := s.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(append(draftFields(&result.Draft), &result.Score, &result.Snippet)...); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetAllDocumentsLatestVersions - Retrieves a list of the document Id's in scope with the latest draft versions.
+func (s *sqlStore) GetAllDocumentsLatestVersions(scope Scope) ([]Document, error) {
+	inScope, args := scope.condition("Id")
+	query := `SELECT ` + documentColumns + ` FROM documents WHERE ` + inScope + ` ORDER BY Id`
+	rows, err := s.Query(s.rebind(query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -179,11 +640,11 @@ func (s *SQLite) GetAllDocumentsLatestVersions() ([]Document, error) {
 
 	var documents []Document
 	for rows.Next() {
-		var doc Document
-		if err := rows.Scan(&doc.Id, &doc.Name, &doc.LatestVersion, &doc.CreatedAt); err != nil {
+		doc, err := scanDocument(rows)
+		if err != nil {
 			return nil, err
 		}
-		documents = append(documents, doc)
+		documents = append(documents, *doc)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -194,89 +655,223 @@ func (s *SQLite) GetAllDocumentsLatestVersions() ([]Document, error) {
 }
 
 // AddCommentToDraft - Create a comments to drafts. A successful result will return the comment Id.
-func (s *SQLite) AddCommentToDraft(comment Comment) (int64, error) {
-	query := `INSERT INTO comments (DraftId, UserId, Text, ParentCommentId, CreatedAt) VALUES (?, ?, ?, ?, ?)`
-	result, err := s.Exec(query, comment.DraftId, comment.UserId, comment.Text, comment.ParentCommentId, time.Now())
+// A reply to a resolved thread reopens it. An anchored comment is checked against the draft content and an *AnchorError returned if it does not fit.
+// ErrNotFound is returned if the draft or parent comment is not in the workspace. A comment.added event is published.
+func (s *sqlStore) AddCommentToDraft(workspaceId int, comment Comment) (int64, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var content sql.NullString
+	var documentId int
+	query := `SELECT d.Content, d.DocumentId FROM drafts d JOIN documents doc ON doc.Id = d.DocumentId WHERE d.Id = ? AND doc.WorkspaceId = ?`
+	if err := tx.QueryRow(s.rebind(query), comment.DraftId, workspaceId).Scan(&content, &documentId); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	var anchor Anchor
+	if comment.Anchor != nil {
+		if anchor, err = resolveAnchor(content.String, *comment.Anchor); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	suggestion, err := newSuggestion(comment, anchor)
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var suggestionText *string
+	var suggestionQuote string
+	var status SuggestionStatus
+	if suggestion != nil {
+		suggestionText, suggestionQuote, status = &suggestion.Text, suggestion.Quote, suggestion.Status
+	}
+
+	// Replies join their parent's thread, reopening it if it was resolved.
+	var threadId *int
+	if comment.ParentCommentId != nil {
+		var parentThread int
+		var parentResolved bool
+		query := `SELECT c.ThreadId, c.Resolved FROM comments c WHERE c.Id = ? AND ` + commentInWorkspace
+		if err := tx.QueryRow(s.rebind(query), *comment.ParentCommentId, workspaceId).Scan(&parentThread, &parentResolved); err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				return 0, ErrNotFound
+			}
+			return 0, err
+		}
+		threadId = &parentThread
+		if parentResolved {
+			if err := s.setThreadResolved(tx, parentThread, false, nil); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+	}
+
+	comment.CreatedAt = time.Now()
+	query = `
+        INSERT INTO comments (DraftId, UserId, Text, ParentCommentId, ThreadId, CreatedAt, SuggestionText, SuggestionQuote, SuggestionStatus)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING Id`
+	var commentId int64
+	if err := tx.QueryRow(s.rebind(query), comment.DraftId, comment.UserId, comment.Text, comment.ParentCommentId, threadId,
+		comment.CreatedAt, suggestionText, suggestionQuote, status).Scan(&commentId); err != nil {
+		tx.Rollback()
 		return 0, err
 	}
+	if threadId == nil {
+		if _, err := tx.Exec(s.rebind(`UPDATE comments SET ThreadId = Id WHERE Id = ?`), commentId); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if comment.Anchor != nil {
+		if err := s.insertAnchor(tx, CommentAnchor{CommentId: int(commentId), DraftId: comment.DraftId, Anchor: anchor}); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		comment.Anchor = &anchor
+	}
 
-	commentId, err := result.LastInsertId()
+	comment.Id = int(commentId)
+	comment.ThreadId = comment.Id
+	if threadId != nil {
+		comment.ThreadId = *threadId
+	}
+	comment.Resolved, comment.ResolvedBy, comment.ResolvedAt = false, nil, nil
+	comment.Suggestion = suggestion
+	event, err := newEvent(EventCommentAdded, workspaceId, documentId, comment.DraftId, comment)
+	if err == nil {
+		err = s.recordEvent(tx, &event)
+	}
 	if err != nil {
+		tx.Rollback()
 		return 0, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.bus.Publish(event)
 	return commentId, nil
 }
 
-// GetCommentsAndReactionsByDraftId - Retrieves all of a drafts comments, with the the comment reactions.
-func (s *SQLite) GetCommentsAndReactionsByDraftId(draftId int) ([]CommentWithReactions, error) {
+// commentInWorkspace - Matches comments c on drafts of documents in a workspace. Takes the workspace Id.
+const commentInWorkspace = `c.DraftId IN (
+            SELECT d.Id FROM drafts d JOIN documents doc ON doc.Id = d.DocumentId WHERE doc.WorkspaceId = ?)`
+
+// draftInWorkspace - Reports whether a draft belongs to a document in the workspace.
+func (s *sqlStore) draftInWorkspace(workspaceId, draftId int) (bool, error) {
+	query := `SELECT COUNT(*) FROM drafts d JOIN documents doc ON doc.Id = d.DocumentId WHERE d.Id = ? AND doc.WorkspaceId = ?`
+	var count int
+	if err := s.QueryRow(s.rebind(query), draftId, workspaceId).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetCommentAnchorsByDraftId - Retrieves the anchors in a draft, including those carried over
+// from comments on earlier versions. Orphaned anchors come last. A draft outside the workspace has none.
+func (s *sqlStore) GetCommentAnchorsByDraftId(workspaceId, draftId int) ([]CommentAnchor, error) {
+	found, err := s.draftInWorkspace(workspaceId, draftId)
+	if err != nil || !found {
+		return []CommentAnchor{}, err
+	}
+	return s.queryAnchors(s.DB, draftId)
+}
+
+// reactionCountColumns - Columns of reactions grouped by CommentId and Emoji: Mine is 1 if the
+// user given as their one argument is among them, and FirstId orders emojis by first use.
+const reactionCountColumns = `CommentId, Emoji, COUNT(*) AS Total, MAX(CASE WHEN UserId = ? THEN 1 ELSE 0 END) AS Mine, MIN(Id) AS FirstId`
+
+// GetCommentsAndReactionsByDraftId - Retrieves all of a drafts comments, with the the comment reactions
+// counted per emoji as seen by userId (0 for nobody).
+// Unresolved comments carried forward from earlier versions are included. Comments are ordered oldest first, ties broken by Id, and emojis in the order they were first used.
+// A draft outside the workspace has no comments.
+func (s *sqlStore) GetCommentsAndReactionsByDraftId(workspaceId, draftId, userId int) ([]CommentWithReactions, error) {
+	found, err := s.draftInWorkspace(workspaceId, draftId)
+	if err != nil || !found {
+		return []CommentWithReactions{}, err
+	}
+
 	query := `
-        SELECT c.Id, c.UserId, c.Text, c.ParentCommentId, c.CreatedAt, 
-               r.Id, r.UserId, r.Emoji, r.CreatedAt
+        SELECT c.Id, c.DraftId, c.UserId, c.Text, c.ParentCommentId, c.ThreadId,
+               c.Resolved, c.ResolvedBy, c.ResolvedAt, c.CreatedAt, ` + suggestionColumns + `,
+               a.StartOffset, a.EndOffset, a.StartLine, a.EndLine, a.Quote, a.Orphaned,
+               r.Emoji, r.Total, r.Mine
         FROM comments c
-        LEFT JOIN reactions r ON c.Id = r.CommentId
-        WHERE c.DraftId = ?
-        ORDER BY c.CreatedAt DESC`
+        LEFT JOIN comment_anchors a ON a.CommentId = c.Id AND a.DraftId = ?
+        LEFT JOIN (
+            SELECT ` + reactionCountColumns + ` FROM reactions
+            WHERE CommentId IN (SELECT Id FROM comments WHERE DraftId = ?) OR CommentId IN (SELECT CommentId FROM comment_carryovers WHERE DraftId = ?)
+            GROUP BY CommentId, Emoji
+        ) r ON c.Id = r.CommentId
+        WHERE c.DraftId = ? OR c.Id IN (SELECT CommentId FROM comment_carryovers WHERE DraftId = ?)
+        ORDER BY c.CreatedAt, c.Id, r.FirstId`
 
-	rows, err := s.Query(query, draftId)
+	rows, err := s.Query(s.rebind(query), draftId, userId, draftId, draftId, draftId, draftId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	commentsMap := make(map[int]*CommentWithReactions)
+	commentsWithReactions := []CommentWithReactions{}
+	commentIndex := make(map[int]int) // Comment Id to its position in commentsWithReactions
 	for rows.Next() {
 		var commentId int
-		var reactionId sql.NullInt64
-		var reactionUserId sql.NullInt64
 		var reactionEmoji sql.NullString
-		var reactionCreatedAt sql.NullTime
+		var reactionTotal, reactionMine sql.NullInt64
+		var anchorStart, anchorEnd, anchorStartLine, anchorEndLine sql.NullInt64
+		var anchorQuote sql.NullString
+		var anchorOrphaned sql.NullBool
+		var suggestion suggestionRow
 		var comment CommentWithReactions
-		var reaction common.Reaction
 
-		err := rows.Scan(
-			&commentId, &comment.UserId, &comment.Text, &comment.ParentCommentId, &comment.CreatedAt,
-			&reactionId, &reactionUserId, &reactionEmoji, &reactionCreatedAt,
-		)
-		if err != nil {
+		fields := []interface{}{
+			&commentId, &comment.DraftId, &comment.UserId, &comment.Text, &comment.ParentCommentId, &comment.ThreadId,
+			&comment.Resolved, &comment.ResolvedBy, &comment.ResolvedAt, &comment.CreatedAt,
+		}
+		fields = append(fields, suggestion.fields()...)
+		fields = append(fields, &anchorStart, &anchorEnd, &anchorStartLine, &anchorEndLine, &anchorQuote, &anchorOrphaned,
+			&reactionEmoji, &reactionTotal, &reactionMine)
+		if err := rows.Scan(fields...); err != nil {
 			return nil, err
 		}
 
-		// Check if the comment is already in the map
-		if storedComment, found := commentsMap[commentId]; found {
-			if reactionId.Valid { // Check if reactionId is not NULL
-				reaction.Id = int(reactionId.Int64) // Convert to int
-				reaction.UserId = int(reactionUserId.Int64)
-				reaction.Emoji = reactionEmoji.String
-				reaction.CreatedAt = reactionCreatedAt.Time
-				storedComment.Reactions = append(storedComment.Reactions, reaction)
-			}
-		} else {
-			// If not found, create a new comment with the reaction
-			newComment := CommentWithReactions{
-				Id:              commentId,
-				UserId:          comment.UserId,
-				Text:            comment.Text,
-				ParentCommentId: comment.ParentCommentId,
-				CreatedAt:       comment.CreatedAt,
-				Reactions:       []common.Reaction{},
+		index, found := commentIndex[commentId]
+		if !found {
+			comment.Id = commentId
+			comment.Reactions = []ReactionCount{}
+			comment.Suggestion = suggestion.value()
+			if anchorQuote.Valid {
+				comment.Anchor = &Anchor{
+					Start:     int(anchorStart.Int64),
+					End:       int(anchorEnd.Int64),
+					StartLine: int(anchorStartLine.Int64),
+					EndLine:   int(anchorEndLine.Int64),
+					Quote:     anchorQuote.String,
+					Orphaned:  anchorOrphaned.Bool,
+				}
 			}
-			if reactionId.Valid { // Check if reactionId is not NULL
-				reaction.Id = int(reactionId.Int64)
-				reaction.UserId = int(reactionUserId.Int64)
-				reaction.Emoji = reactionEmoji.String
-				reaction.CreatedAt = reactionCreatedAt.Time
-				newComment.Reactions = append(newComment.Reactions, reaction)
-			}
-			commentsMap[commentId] = &newComment
+			index = len(commentsWithReactions)
+			commentIndex[commentId] = index
+			commentsWithReactions = append(commentsWithReactions, comment)
 		}
-	}
 
-	// Convert the map to a slice
-	var commentsWithReactions []CommentWithReactions
-	for _, comment := range commentsMap {
-		commentsWithReactions = append(commentsWithReactions, *comment)
+		if reactionEmoji.Valid { // NULL when the comment has no reactions
+			commentsWithReactions[index].Reactions = append(commentsWithReactions[index].Reactions, ReactionCount{
+				Emoji:       reactionEmoji.String,
+				Count:       int(reactionTotal.Int64),
+				ReactedByMe: userId != 0 && reactionMine.Int64 == 1,
+			})
+		}
 	}
 
 	if err := rows.Err(); err != nil {
@@ -286,9 +881,214 @@ func (s *SQLite) GetCommentsAndReactionsByDraftId(draftId int) ([]CommentWithRea
 	return commentsWithReactions, nil
 }
 
-// AddReactionToComment - Creats a reaction to a comment.
-func (s *SQLite) AddReactionToComment(reaction common.Reaction) error {
-	query := `INSERT INTO reactions (CommentId, UserId, Emoji, CreatedAt) VALUES (?, ?, ?, ?)`
-	_, err := s.Exec(query, reaction.Id, reaction.UserId, reaction.Emoji, time.Now())
+// GetCommentById - Retrieves a comment in a workspace by its ID.
+func (s *sqlStore) GetCommentById(workspaceId, id int) (*Comment, error) {
+	query := `
+        SELECT c.Id, c.DraftId, c.UserId, c.Text, c.ParentCommentId, c.ThreadId, c.Resolved, c.ResolvedBy, c.ResolvedAt, c.CreatedAt,
+               ` + suggestionColumns + `
+        FROM comments c WHERE c.Id = ? AND ` + commentInWorkspace
+	row := s.QueryRow(s.rebind(query), id, workspaceId)
+
+	var comment Comment
+	var suggestion suggestionRow
+	fields := append([]interface{}{&comment.Id, &comment.DraftId, &comment.UserId, &comment.Text, &comment.ParentCommentId,
+		&comment.ThreadId, &comment.Resolved, &comment.ResolvedBy, &comment.ResolvedAt, &comment.CreatedAt}, suggestion.fields()...)
+	if err := row.Scan(fields...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	comment.Suggestion = suggestion.value()
+	return &comment, nil
+}
+
+// ResolveThread - Marks the thread a comment belongs to as resolved by a user. Comments already
+// resolved keep who resolved them and when. Returns the updated comment, or nil if it is not in the workspace.
+func (s *sqlStore) ResolveThread(workspaceId, commentId, userId int) (*Comment, error) {
+	return s.updateThread(workspaceId, commentId, true, &userId)
+}
+
+// ReopenThread - Marks the thread a comment belongs to as unresolved again.
+// Returns the updated comment, or nil if it is not in the workspace.
+func (s *sqlStore) ReopenThread(workspaceId, commentId int) (*Comment, error) {
+	return s.updateThread(workspaceId, commentId, false, nil)
+}
+
+func (s *sqlStore) updateThread(workspaceId, commentId int, resolved bool, userId *int) (*Comment, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var threadId int
+	query := `SELECT c.ThreadId FROM comments c WHERE c.Id = ? AND ` + commentInWorkspace
+	if err := tx.QueryRow(s.rebind(query), commentId, workspaceId).Scan(&threadId); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	if err := s.setThreadResolved(tx, threadId, resolved, userId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetCommentById(workspaceId, commentId)
+}
+
+// setThreadResolved - Resolves or reopens every comment in a thread that is not already in that state.
+func (s *sqlStore) setThreadResolved(tx *sql.Tx, threadId int, resolved bool, userId *int) error {
+	var resolvedAt *time.Time
+	if resolved {
+		now := time.Now()
+		resolvedAt = &now
+	}
+	query := `UPDATE comments SET Resolved = ?, ResolvedBy = ?, ResolvedAt = ? WHERE ThreadId = ? AND Resolved <> ?`
+	_, err := tx.Exec(s.rebind(query), resolved, userId, resolvedAt, threadId, resolved)
 	return err
 }
+
+// reactionChange - What changeReaction does with a user's reaction.
+type reactionChange int
+
+const (
+	reactionAdd reactionChange = iota
+	reactionRemove
+	reactionToggle
+)
+
+// AddReactionToComment - Creats a reaction to a comment. The reaction Id carries the comment Id.
+// Returns false if the user already reacted with the emoji, which is not added twice.
+// ErrNotFound is returned if the comment is not in the workspace. A reaction.added event is published.
+func (s *sqlStore) AddReactionToComment(workspaceId int, reaction common.Reaction) (bool, error) {
+	_, changed, err := s.changeReaction(workspaceId, reaction, reactionAdd)
+	return changed, err
+}
+
+// RemoveReactionFromComment - Removes a user's reaction with an emoji from a comment. The reaction
+// Id carries the comment Id. Returns false if there was none. ErrNotFound is returned if the
+// comment is not in the workspace. A reaction.removed event is published.
+func (s *sqlStore) RemoveReactionFromComment(workspaceId int, reaction common.Reaction) (bool, error) {
+	_, changed, err := s.changeReaction(workspaceId, reaction, reactionRemove)
+	return changed, err
+}
+
+// ToggleReaction - Removes a user's reaction with an emoji from a comment if there is one, and
+// adds it otherwise. Returns whether the user has now reacted with the emoji.
+func (s *sqlStore) ToggleReaction(workspaceId int, reaction common.Reaction) (bool, error) {
+	reacted, _, err := s.changeReaction(workspaceId, reaction, reactionToggle)
+	return reacted, err
+}
+
+// changeReaction - Adds or removes a reaction and records its event in one transaction. Returns
+// whether the user has reacted with the emoji afterwards and whether anything changed.
+func (s *sqlStore) changeReaction(workspaceId int, reaction common.Reaction, change reactionChange) (bool, bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, false, err
+	}
+
+	var draftId, documentId int
+	query := `
+        SELECT d.Id, d.DocumentId FROM comments c
+        JOIN drafts d ON d.Id = c.DraftId
+        JOIN documents doc ON doc.Id = d.DocumentId
+        WHERE c.Id = ? AND doc.WorkspaceId = ?`
+	if err := tx.QueryRow(s.rebind(query), reaction.Id, workspaceId).Scan(&draftId, &documentId); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return false, false, ErrNotFound
+		}
+		return false, false, err
+	}
+
+	changed := common.Reaction{UserId: reaction.UserId, Emoji: reaction.Emoji}
+	var event Event
+	reacted := change != reactionRemove
+	if change != reactionAdd {
+		query = `DELETE FROM reactions WHERE CommentId = ? AND UserId = ? AND Emoji = ? RETURNING Id, CreatedAt`
+		err = tx.QueryRow(s.rebind(query), reaction.Id, reaction.UserId, reaction.Emoji).Scan(&changed.Id, &changed.CreatedAt)
+		switch {
+		case err == nil:
+			reacted = false
+			event, err = newEvent(EventReactionRemoved, workspaceId, documentId, draftId, ReactionRemoved{CommentId: reaction.Id, Reaction: changed})
+		case err == sql.ErrNoRows:
+			err = nil
+		}
+		if err != nil {
+			tx.Rollback()
+			return false, false, err
+		}
+	}
+	if reacted {
+		// A reaction that is already there, perhaps added concurrently, is left as it is.
+		changed.CreatedAt = time.Now()
+		query = `
+            INSERT INTO reactions (CommentId, UserId, Emoji, CreatedAt) VALUES (?, ?, ?, ?)
+            ON CONFLICT (CommentId, UserId, Emoji) DO NOTHING RETURNING Id`
+		err = tx.QueryRow(s.rebind(query), reaction.Id, reaction.UserId, reaction.Emoji, changed.CreatedAt).Scan(&changed.Id)
+		switch {
+		case err == nil:
+			event, err = newEvent(EventReactionAdded, workspaceId, documentId, draftId, ReactionAdded{CommentId: reaction.Id, Reaction: changed})
+		case err == sql.ErrNoRows:
+			err = nil
+		}
+		if err != nil {
+			tx.Rollback()
+			return false, false, err
+		}
+	}
+	if event.Type == "" {
+		return reacted, false, tx.Rollback()
+	}
+
+	if err := s.recordEvent(tx, &event); err != nil {
+		tx.Rollback()
+		return false, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, false, err
+	}
+	s.bus.Publish(event)
+	return reacted, true, nil
+}
+
+// GetReactionCounts - The reactions to a comment counted per emoji as seen by userId, in the order
+// each emoji was first used. ErrNotFound is returned if the comment is not in the workspace.
+func (s *sqlStore) GetReactionCounts(workspaceId, commentId, userId int) ([]ReactionCount, error) {
+	var found int
+	query := `SELECT c.Id FROM comments c WHERE c.Id = ? AND ` + commentInWorkspace
+	if err := s.QueryRow(s.rebind(query), commentId, workspaceId).Scan(&found); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	query = `
+        SELECT r.Emoji, r.Total, r.Mine FROM (
+            SELECT ` + reactionCountColumns + ` FROM reactions WHERE CommentId = ? GROUP BY CommentId, Emoji
+        ) r ORDER BY r.FirstId`
+	rows, err := s.Query(s.rebind(query), userId, commentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ReactionCount{}
+	for rows.Next() {
+		var count ReactionCount
+		var mine int
+		if err := rows.Scan(&count.Emoji, &count.Count, &mine); err != nil {
+			return nil, err
+		}
+		count.ReactedByMe = userId != 0 && mine == 1
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
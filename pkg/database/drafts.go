@@ -0,0 +1,61 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxHistoryPage - The most versions GetDraftHistory returns at once.
+const MaxHistoryPage = 100
+
+// HistoryOptions - Filters and pages the versions GetDraftHistory lists.
+type HistoryOptions struct {
+	AuthorId int // Only versions written by this user, 0 for any author
+	Before   int // Only versions older than this one, 0 to start from the latest
+	Limit    int // At most this many versions, capped at MaxHistoryPage
+}
+
+// DraftMetadata - Free-form string values a client attaches to a draft, stored as a JSON object.
+type DraftMetadata map[string]string
+
+// Value - The Metadata column: the JSON object, or empty if there is no metadata.
+func (m DraftMetadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(map[string]string(m))
+	return string(encoded), err
+}
+
+// Scan - Reads the Metadata column, leaving m nil if it is empty.
+func (m *DraftMetadata) Scan(src interface{}) error {
+	var column []byte
+	switch value := src.(type) {
+	case nil:
+	case string:
+		column = []byte(value)
+	case []byte:
+		column = value
+	default:
+		return fmt.Errorf("cannot scan %T into DraftMetadata", src)
+	}
+
+	*m = nil
+	if len(column) == 0 {
+		return nil
+	}
+	return json.Unmarshal(column, (*map[string]string)(m))
+}
+
+// copy - A copy of the metadata that does not share the map.
+func (m DraftMetadata) copy() DraftMetadata {
+	if m == nil {
+		return nil
+	}
+	copied := DraftMetadata{}
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"documentapi/pkg/common"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event types published by the store.
+const (
+	EventDraftCreated        = "draft.created"
+	EventCommentAdded        = "comment.added"
+	EventReactionAdded       = "reaction.added"
+	EventReactionRemoved     = "reaction.removed"
+	EventDocumentPublished   = "document.published"
+	EventDocumentUnpublished = "document.unpublished"
+)
+
+// MaxEventPage - The most events GetEvents returns at once.
+const MaxEventPage = 500
+
+// eventBuffer - Events a subscriber may fall behind by before it is dropped.
+const eventBuffer = 64
+
+// Event - A change to a document, as published on the bus and kept in the event log.
+// Data holds the created Draft, the added Comment, a ReactionAdded, a ReactionRemoved or the
+// published or unpublished Document.
+type Event struct {
+	Id          int64           `json:"id"`
+	Type        string          `json:"type"`
+	WorkspaceId int             `json:"workspaceId"`
+	DocumentId  int             `json:"documentId"`
+	DraftId     int             `json:"draftId"`
+	Data        json.RawMessage `json:"data"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// ReactionAdded - The data of a reaction.added event.
+type ReactionAdded struct {
+	CommentId int             `json:"commentId"`
+	Reaction  common.Reaction `json:"reaction"`
+}
+
+// ReactionRemoved - The data of a reaction.removed event, with the reaction as it was.
+type ReactionRemoved struct {
+	CommentId int             `json:"commentId"`
+	Reaction  common.Reaction `json:"reaction"`
+}
+
+// EventFilter - The events a subscriber wants: everything in a workspace, or one of its documents.
+type EventFilter struct {
+	WorkspaceId int
+	DocumentId  int // 0 for every document in the workspace
+}
+
+// matches - Reports whether an event passes the filter.
+func (f EventFilter) matches(event Event) bool {
+	return event.WorkspaceId == f.WorkspaceId && (f.DocumentId == 0 || event.DocumentId == f.DocumentId)
+}
+
+// Subscription - Live events matching a filter. Events is closed by Close, or by the bus if the
+// subscriber falls too far behind, after which it should resume from the event log.
+type Subscription struct {
+	Events <-chan Event
+	events chan Event
+	filter EventFilter
+	bus    *EventBus
+}
+
+// Close - Stops delivery and releases the subscription. Safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// EventBus - Fans events out to in-process subscribers. The zero value is ready to use.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscribe - Starts receiving events that match the filter.
+func (b *EventBus) Subscribe(filter EventFilter) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan Event, eventBuffer)
+	subscription := &Subscription{Events: events, events: events, filter: filter, bus: b}
+	if b.subscribers == nil {
+		b.subscribers = make(map[*Subscription]struct{})
+	}
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Publish - Delivers an event to every matching subscriber without blocking. A subscriber whose
+// buffer is full is dropped rather than holding up the writer.
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscribers {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			delete(b.subscribers, subscription)
+			close(subscription.events)
+		}
+	}
+}
+
+func (b *EventBus) unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[subscription]; ok {
+		delete(b.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// newEvent - Builds an event with its data encoded, ready to be recorded.
+func newEvent(eventType string, workspaceId, documentId, draftId int, data interface{}) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Type:        eventType,
+		WorkspaceId: workspaceId,
+		DocumentId:  documentId,
+		DraftId:     draftId,
+		Data:        encoded,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// Subscribe - Starts receiving events from this store that match the filter.
+func (s *sqlStore) Subscribe(filter EventFilter) *Subscription {
+	return s.bus.Subscribe(filter)
+}
+
+// recordEvent - Writes an event to the log in the transaction making the change, filling in its Id,
+// and queues its webhook deliveries and notifications. It is published once the transaction commits.
+func (s *sqlStore) recordEvent(tx *sql.Tx, event *Event) error {
+	query := `INSERT INTO events (WorkspaceId, DocumentId, DraftId, Type, Data, CreatedAt) VALUES (?, ?, ?, ?, ?, ?) RETURNING Id`
+	if err := tx.QueryRow(s.rebind(query), event.WorkspaceId, event.DocumentId, event.DraftId, event.Type, string(event.Data), event.CreatedAt).Scan(&event.Id); err != nil {
+		return err
+	}
+	if err := s.queueDeliveries(tx, *event); err != nil {
+		return err
+	}
+	return s.queueNotifications(tx, *event)
+}
+
+// GetEvents - The logged events matching the filter after the event afterId, oldest first.
+// At most limit events are returned, capped at MaxEventPage.
+func (s *sqlStore) GetEvents(filter EventFilter, afterId int64, limit int) ([]Event, error) {
+	if limit <= 0 || limit > MaxEventPage {
+		limit = MaxEventPage
+	}
+	query := `
+        SELECT Id, Type, WorkspaceId, DocumentId, DraftId, Data, CreatedAt FROM events
+        WHERE WorkspaceId = ? AND (? = 0 OR DocumentId = ?) AND Id > ?
+        ORDER BY Id LIMIT ?`
+	rows, err := s.Query(s.rebind(query), filter.WorkspaceId, filter.DocumentId, filter.DocumentId, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var data string
+		if err := rows.Scan(&event.Id, &event.Type, &event.WorkspaceId, &event.DocumentId, &event.DraftId, &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Data = json.RawMessage(data)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Subscribe - Starts receiving events from this store that match the filter.
+func (m *Memory) Subscribe(filter EventFilter) *Subscription {
+	return m.bus.Subscribe(filter)
+}
+
+// recordEvent - Appends an event to the log, filling in its Id, and queues its webhook
+// deliveries and notifications. Callers hold the write lock.
+func (m *Memory) recordEvent(event *Event) {
+	event.Id = int64(len(m.events) + 1)
+	m.events = append(m.events, *event)
+	m.queueDeliveries(*event)
+	m.queueNotifications(*event)
+}
+
+// GetEvents - The logged events matching the filter after the event afterId, oldest first.
+// At most limit events are returned, capped at MaxEventPage.
+func (m *Memory) GetEvents(filter EventFilter, afterId int64, limit int) ([]Event, error) {
+	if limit <= 0 || limit > MaxEventPage {
+		limit = MaxEventPage
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []Event{}
+	for _, event := range m.events {
+		if event.Id > afterId && filter.matches(event) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+)
+
+// ftsIndexVersion - The migration creating the drafts_fts search index.
+const ftsIndexVersion = 3
+
+// indexedFTSModule - The FTS module drafts_fts was created with, or "" if there is no index.
+func indexedFTSModule(db *sql.DB) (string, error) {
+	var definition string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'drafts_fts'`).Scan(&definition)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	definition = strings.ToLower(definition)
+	for _, module := range []string{"fts5", "fts4"} {
+		if strings.Contains(definition, "using "+module) {
+			return module, nil
+		}
+	}
+	return "other", nil
+}
+
+// errFTS5Index - A build without FTS5 cannot even drop an FTS5 table, so it cannot take over
+// a database indexed by a build with it.
+var errFTS5Index = errors.New("the draft search index uses FTS5, which this binary was built without; build it with -tags sqlite_fts5")
+
+// syncFTSIndex - Rebuilds the search index with module if it was built with another one, so a
+// database migrated by a build without FTS5 moves to FTS5 with a build that has it. Migration 3
+// creates the FTS4 index; the FTS5 one is defined in migrations/sqlite/fts5. Nothing happens
+// while migration 3 is not applied.
+func (m *Migrator) syncFTSIndex(module string) error {
+	current, err := indexedFTSModule(m.DB)
+	if err != nil || current == "" || current == module {
+		return err
+	}
+	if current == "fts5" {
+		return errFTS5Index
+	}
+
+	var index Migration
+	for _, migration := range m.Migrations {
+		if migration.Version == ftsIndexVersion {
+			index = migration
+		}
+	}
+	create := index.Up
+	if module == "fts5" {
+		body, err := embeddedMigrations.ReadFile("migrations/sqlite/fts5/drafts_fts.sql")
+		if err != nil {
+			return err
+		}
+		create = string(body)
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{index.Down, create} {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Rebuilt the draft search index with %s", module)
+	return nil
+}
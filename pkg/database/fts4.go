@@ -0,0 +1,7 @@
+//go:build !(sqlite_fts5 || fts5)
+
+package database
+
+// sqliteFTSModule - Without the sqlite_fts5 build tag go-sqlite3 only ships FTS4,
+// so BM25 is computed from matchinfo() instead of bm25().
+const sqliteFTSModule = "fts4"
@@ -0,0 +1,7 @@
+//go:build sqlite_fts5 || fts5
+
+package database
+
+// sqliteFTSModule - go-sqlite3 includes FTS5 when built with the sqlite_fts5 tag,
+// which gives native bm25() ranking.
+const sqliteFTSModule = "fts5"
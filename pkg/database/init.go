@@ -2,17 +2,49 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Initialize - Opens the database and applies any pending migrations.
 func (s *SQLite) Initialize(dbNames ...string) error {
+	if err := s.Open(dbNames...); err != nil {
+		return err
+	}
+
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(false)
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+	}
+
+	return migrator.syncFTSIndex(sqliteFTSModule)
+}
+
+// Open - Opens the database without touching its schema.
+func (s *SQLite) Open(dbNames ...string) error {
 	dbName := "document-drafts.db" // Default database name
 	if len(dbNames) > 0 {
 		dbName = dbNames[0] // If a name is provided, use it instead
 	}
-	db, err := sql.Open("sqlite3", dbName)
+	// Take the write lock when a transaction begins and wait for other writers
+	// rather than failing with SQLITE_BUSY.
+	separator := "?"
+	if strings.Contains(dbName, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", dbName+separator+"_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		log.Fatal(err)
 		panic("Failed to initialize document service")
@@ -20,53 +52,60 @@ func (s *SQLite) Initialize(dbNames ...string) error {
 		log.Println("Document Service initialized with database:", dbName)
 	}
 
-	setupTables(db)
-
 	s.DB = db
 
 	return nil
 }
 
-func setupTables(db *sql.DB) {
-	createTableStatements := []string{
-		`CREATE TABLE IF NOT EXISTS documents (
-			Id INTEGER PRIMARY KEY AUTOINCREMENT,
-			Name TEXT NOT NULL,
-			LatestVersion INTEGER NOT NULL DEFAULT 1,
-			CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-		`CREATE TABLE IF NOT EXISTS drafts (
-			Id INTEGER PRIMARY KEY AUTOINCREMENT,
-			DocumentId INTEGER NOT NULL,
-			Content TEXT,
-			VersionNumber INTEGER NOT NULL,
-			CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (DocumentId) REFERENCES documents(Id)
-		);`,
-		`CREATE TABLE IF NOT EXISTS comments (
-			Id INTEGER PRIMARY KEY AUTOINCREMENT,
-			DraftId INTEGER NOT NULL,
-			UserId INTEGER NOT NULL,
-			Text TEXT NOT NULL,
-			ParentCommentId INTEGER,
-			CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (DraftId) REFERENCES drafts(Id),
-			FOREIGN KEY (ParentCommentId) REFERENCES comments(Id)
-		);`,
-		`CREATE TABLE IF NOT EXISTS reactions (
-			Id INTEGER PRIMARY KEY AUTOINCREMENT,
-			CommentId INTEGER NOT NULL,
-			UserId INTEGER NOT NULL,
-			Emoji TEXT NOT NULL,
-			CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (CommentId) REFERENCES comments(Id)
-		);`,
+// Migrator - Returns a migrator for this database's schema.
+func (s *SQLite) Migrator() (*Migrator, error) {
+	return NewSQLiteMigrator(s.DB)
+}
+
+// Initialize - Connects to PostgreSQL and applies any pending migrations.
+func (p *Postgres) Initialize(dsn string) error {
+	if err := p.Open(dsn); err != nil {
+		return err
 	}
 
-	for _, stmt := range createTableStatements {
-		_, err := db.Exec(stmt)
-		if err != nil {
-			log.Fatalf("Failed to execute statement: %v, error: %v", stmt, err)
-		}
+	migrator, err := p.Migrator()
+	if err != nil {
+		return err
 	}
+
+	applied, err := migrator.Up(false)
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// Open - Connects to PostgreSQL without touching its schema.
+func (p *Postgres) Open(dsn string) error {
+	if dsn == "" {
+		return errors.New("a PostgreSQL connection string is required")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	log.Println("Document Service initialized with PostgreSQL")
+
+	p.DB = db
+	p.dialect = dialectPostgres
+
+	return nil
+}
+
+// Migrator - Returns a migrator for this database's schema.
+func (p *Postgres) Migrator() (*Migrator, error) {
+	return NewPostgresMigrator(p.DB)
 }
@@ -0,0 +1,2134 @@
+package database
+
+import (
+	"documentapi/pkg/common"
+	"sort"
+	"time"
+)
+
+// NewMemory - Creates an in-memory store holding only the Default workspace.
+func NewMemory() *Memory {
+	return &Memory{workspaces: []Workspace{{Id: DefaultWorkspaceId, Name: "Default", CreatedAt: time.Now()}}}
+}
+
+// Close - Nothing to release for the in-memory store.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// GetDocumentById - Retrieves a document in a workspace by its ID.
+func (m *Memory) GetDocumentById(workspaceId, id int) (*Document, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > len(m.documents) || m.documents[id-1].WorkspaceId != workspaceId {
+		return nil, nil // Not found
+	}
+	document := m.documents[id-1]
+	return &document, nil
+}
+
+// GetDocumentByName - Retrieves a document in a workspace by its name.
+func (m *Memory) GetDocumentByName(workspaceId int, name string) (*Document, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, document := range m.documents {
+		if document.WorkspaceId == workspaceId && document.Name == name {
+			return &document, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+// CreateDraft - Creates a new draft for the named document in draft.WorkspaceId. If draft.BaseVersion is set and the
+// document has moved on, a *VersionConflictError describing the current head is returned.
+// With a draft.UserId the user becomes the owner of a new document, and must be at least an
+// editor of an existing one or ErrPermissionDenied is returned. Suggestions in draft.AcceptSuggestions
+// are marked accepted and their threads resolved, or ErrSuggestionDecided returned unless each is
+// still pending on the document. Approvals of earlier versions are invalidated, sending an
+// approved document back for review. A draft.created event is published.
+func (m *Memory) CreateDraft(draft common.Draft) (*Draft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created, event, err := m.createDraft(draft)
+	if err != nil {
+		return nil, err
+	}
+	m.bus.Publish(event)
+	return created, nil
+}
+
+// createDraft - Writes a draft as CreateDraft does, returning the draft.created event to publish;
+// callers hold the write lock. Nothing is changed when an error is returned.
+func (m *Memory) createDraft(draft common.Draft) (*Draft, Event, error) {
+	now := time.Now()
+	var document *Document
+	for i := range m.documents {
+		if m.documents[i].WorkspaceId == draft.WorkspaceId && m.documents[i].Name == draft.Name {
+			document = &m.documents[i]
+			break
+		}
+	}
+
+	if draft.BaseVersion != nil {
+		current := 0
+		if document != nil {
+			current = document.LatestVersion
+		}
+		if current != *draft.BaseVersion {
+			conflict := &VersionConflictError{BaseVersion: *draft.BaseVersion}
+			if document != nil {
+				documentCopy := *document
+				conflict.Document = &documentCopy
+				conflict.Head = m.draftByVersion(document.Id, document.LatestVersion)
+			}
+			return nil, Event{}, conflict
+		}
+	}
+
+	if document != nil && draft.UserId != 0 && !m.documentRole(document.Id, draft.UserId).AtLeast(RoleEditor) {
+		return nil, Event{}, ErrPermissionDenied
+	}
+	var restored *Draft
+	if draft.CarryComments && draft.RestoredFromVersion > 0 {
+		if document != nil {
+			restored = m.draftByVersion(document.Id, draft.RestoredFromVersion)
+		}
+		if restored == nil {
+			return nil, Event{}, ErrNotFound
+		}
+	}
+	for _, commentId := range draft.AcceptSuggestions {
+		if document == nil || !m.pendingSuggestion(document.Id, commentId) {
+			return nil, Event{}, ErrSuggestionDecided
+		}
+	}
+
+	if document != nil {
+		document.LatestVersion += 1
+	} else {
+		m.documents = append(m.documents, Document{
+			Id:            len(m.documents) + 1,
+			WorkspaceId:   draft.WorkspaceId,
+			Name:          draft.Name,
+			LatestVersion: 1,
+			CreatedAt:     now,
+		})
+		document = &m.documents[len(m.documents)-1]
+		if draft.UserId != 0 {
+			m.permissions = append(m.permissions, DocumentPermission{
+				DocumentId:    document.Id,
+				PrincipalType: PrincipalUser,
+				PrincipalId:   draft.UserId,
+				Role:          RoleOwner,
+				CreatedAt:     now,
+			})
+		}
+	}
+
+	created := Draft{
+		Id:                  len(m.drafts) + 1,
+		DocumentId:          document.Id,
+		Content:             draft.Content,
+		VersionNumber:       document.LatestVersion,
+		CreatedAt:           now,
+		RestoredFromVersion: draft.RestoredFromVersion,
+		AuthorId:            draft.UserId,
+		Summary:             draft.Summary,
+		Metadata:            DraftMetadata(draft.Metadata).copy(),
+	}
+	event, err := newEvent(EventDraftCreated, draft.WorkspaceId, document.Id, created.Id, created)
+	if err != nil {
+		return nil, Event{}, err
+	}
+	m.drafts = append(m.drafts, created)
+
+	if previous := m.draftByVersion(document.Id, created.VersionNumber-1); previous != nil {
+		var anchors []CommentAnchor
+		for _, anchor := range m.anchors {
+			if anchor.DraftId == previous.Id {
+				anchors = append(anchors, anchor)
+			}
+		}
+		m.anchors = append(m.anchors, remapAnchors(previous.Content, created.Content, anchors, created.Id)...)
+	}
+
+	for _, commentId := range draft.AcceptSuggestions {
+		m.decideSuggestion(commentId, SuggestionAccepted, draft.UserId, now, &created.Id)
+	}
+
+	// Unresolved comments on earlier versions carry over as open items.
+	for _, comment := range m.comments {
+		if comment.Resolved || comment.DraftId < 1 || comment.DraftId > len(m.drafts) {
+			continue
+		}
+		if commentDraft := m.drafts[comment.DraftId-1]; commentDraft.DocumentId == created.DocumentId && commentDraft.VersionNumber < created.VersionNumber {
+			m.carryovers = append(m.carryovers, memoryCarryover{CommentId: comment.Id, DraftId: created.Id})
+		}
+	}
+	if restored != nil {
+		m.carryRestored(created, *restored)
+	}
+	m.invalidateApprovals(created, draft.UserId)
+
+	m.recordEvent(&event)
+	return &created, event, nil
+}
+
+// carryRestored - Brings the comments of the version a draft was restored from onto it, resolved
+// ones included, with their anchors as they were in that version. Callers hold the write lock.
+func (m *Memory) carryRestored(draft, restored Draft) {
+	carried := map[int]bool{}
+	for _, carryover := range m.carryovers {
+		if carryover.DraftId == draft.Id {
+			carried[carryover.CommentId] = true
+		}
+	}
+	for _, comment := range m.comments {
+		if !carried[comment.Id] && m.onDraft(comment, restored.Id) {
+			m.carryovers = append(m.carryovers, memoryCarryover{CommentId: comment.Id, DraftId: draft.Id})
+		}
+	}
+
+	// The content is the same, so the anchors fit as they were rather than as re-mapped.
+	anchored := map[int]bool{}
+	for _, anchor := range m.anchors {
+		if anchor.DraftId == restored.Id {
+			anchored[anchor.CommentId] = true
+		}
+	}
+	anchors := m.anchors[:0]
+	for _, anchor := range m.anchors {
+		if !anchored[anchor.CommentId] || anchor.DraftId != draft.Id {
+			anchors = append(anchors, anchor)
+		}
+	}
+	m.anchors = anchors
+	for _, anchor := range m.anchors {
+		if anchor.DraftId == restored.Id {
+			anchor.DraftId = draft.Id
+			m.anchors = append(m.anchors, anchor)
+		}
+	}
+}
+
+// GetDraftById - Retrieves a draft in a workspace by its ID.
+func (m *Memory) GetDraftById(workspaceId, id int) (*Draft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.draftInWorkspace(workspaceId, id) {
+		return nil, nil // Not found
+	}
+	draft := m.drafts[id-1]
+	return &draft, nil
+}
+
+// GetDraftByVersion - Retrieves a specific version of a document in a workspace.
+func (m *Memory) GetDraftByVersion(workspaceId, documentId, version int) (*Draft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if documentId < 1 || documentId > len(m.documents) || m.documents[documentId-1].WorkspaceId != workspaceId {
+		return nil, nil // Not found
+	}
+	return m.draftByVersion(documentId, version), nil
+}
+
+// GetDraftVersions - Retrieves versions from through to of a document in a workspace, oldest first.
+func (m *Memory) GetDraftVersions(workspaceId, documentId, from, to int) ([]Draft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	drafts := []Draft{}
+	if documentId < 1 || documentId > len(m.documents) || m.documents[documentId-1].WorkspaceId != workspaceId {
+		return drafts, nil
+	}
+	for _, draft := range m.drafts {
+		if draft.DocumentId == documentId && draft.VersionNumber >= from && draft.VersionNumber <= to {
+			drafts = append(drafts, draft)
+		}
+	}
+	return drafts, nil
+}
+
+// GetDraftHistory - Lists a document's versions in a workspace, newest first, filtered and paged by options.
+func (m *Memory) GetDraftHistory(workspaceId, documentId int, options HistoryOptions) ([]Draft, error) {
+	if options.Limit <= 0 || options.Limit > MaxHistoryPage {
+		options.Limit = MaxHistoryPage
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	drafts := []Draft{}
+	if documentId < 1 || documentId > len(m.documents) || m.documents[documentId-1].WorkspaceId != workspaceId {
+		return drafts, nil
+	}
+	for i := len(m.drafts) - 1; i >= 0 && len(drafts) < options.Limit; i-- {
+		draft := m.drafts[i]
+		if draft.DocumentId != documentId || (options.Before > 0 && draft.VersionNumber >= options.Before) ||
+			(options.AuthorId != 0 && draft.AuthorId != options.AuthorId) {
+			continue
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, nil
+}
+
+// draftInWorkspace - Reports whether a draft exists in a workspace; callers hold the lock.
+func (m *Memory) draftInWorkspace(workspaceId, draftId int) bool {
+	return draftId >= 1 && draftId <= len(m.drafts) && m.documents[m.drafts[draftId-1].DocumentId-1].WorkspaceId == workspaceId
+}
+
+// commentInWorkspace - Reports whether a comment exists in a workspace; callers hold the lock.
+func (m *Memory) commentInWorkspace(workspaceId, commentId int) bool {
+	return commentId >= 1 && commentId <= len(m.comments) && m.draftInWorkspace(workspaceId, m.comments[commentId-1].DraftId)
+}
+
+// draftByVersion - Looks up a draft copy; callers hold the lock.
+func (m *Memory) draftByVersion(documentId, version int) *Draft {
+	for _, draft := range m.drafts {
+		if draft.DocumentId == documentId && draft.VersionNumber == version {
+			return &draft
+		}
+	}
+	return nil
+}
+
+// GetLatestDrafts - Gets the latest drafts of the documents in scope, and if limit is 0, it will return all drafts.
+func (m *Memory) GetLatestDrafts(scope Scope, limit, authorId int) ([]Draft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var drafts []Draft
+	perDocument := make(map[int]int)
+	// Walk newest first so the per-document limit keeps the most recent drafts.
+	for i := len(m.drafts) - 1; i >= 0; i-- {
+		draft := m.drafts[i]
+		if authorId != 0 && draft.AuthorId != authorId {
+			continue
+		}
+		if limit > 0 && perDocument[draft.DocumentId] >= limit || !m.inScope(scope, draft.DocumentId) {
+			continue
+		}
+		perDocument[draft.DocumentId]++
+		drafts = append(drafts, draft)
+	}
+
+	sort.Slice(drafts, func(i, j int) bool {
+		if drafts[i].VersionNumber != drafts[j].VersionNumber {
+			return drafts[i].VersionNumber > drafts[j].VersionNumber
+		}
+		return drafts[i].Id < drafts[j].Id
+	})
+
+	return drafts, nil
+}
+
+// SearchDrafts - Full-text search over draft content, ranked with BM25 over every draft.
+func (m *Memory) SearchDrafts(text string, options SearchOptions) ([]SearchResult, error) {
+	expr, err := parseSearch(text)
+	if err != nil {
+		return nil, err
+	}
+	if options.Limit <= 0 {
+		options.Limit = DefaultSearchLimit
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	phrases := expr.phrases()
+	tokenized := make([][]searchToken, len(m.drafts))
+	docsWithHit := make([]int, len(phrases))
+	totalTokens := 0
+	for i, draft := range m.drafts {
+		tokenized[i] = searchTokens(draft.Content)
+		totalTokens += len(tokenized[i])
+		for j, phrase := range phrases {
+			if len(phrase.occurrences(tokenized[i])) > 0 {
+				docsWithHit[j]++
+			}
+		}
+	}
+	avgDocLen := 0.0
+	if len(m.drafts) > 0 {
+		avgDocLen = float64(totalTokens) / float64(len(m.drafts))
+	}
+
+	var results []SearchResult
+	for i, draft := range m.drafts {
+		tokens := tokenized[i]
+		if !expr.matches(tokens) {
+			continue
+		}
+		if options.LatestOnly && m.documents[draft.DocumentId-1].LatestVersion != draft.VersionNumber {
+			continue
+		}
+		if options.AuthorId != 0 && draft.AuthorId != options.AuthorId {
+			continue
+		}
+		if !m.inScope(options.Scope, draft.DocumentId) {
+			continue
+		}
+
+		hits := make([]int, len(phrases))
+		for j, phrase := range phrases {
+			hits[j] = len(phrase.occurrences(tokens))
+		}
+		results = append(results, SearchResult{
+			Draft:   draft,
+			Score:   bm25(hits, docsWithHit, len(m.drafts), float64(len(tokens)), avgDocLen),
+			Snippet: highlight(draft.Content, tokens, phrases),
+		})
+	}
+
+	sortSearchResults(results)
+	if len(results) > options.Limit {
+		results = results[:options.Limit]
+	}
+	return results, nil
+}
+
+// GetAllDocumentsLatestVersions - Retrieves a list of the document Id's in scope with the latest draft versions.
+func (m *Memory) GetAllDocumentsLatestVersions(scope Scope) ([]Document, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var documents []Document
+	for _, document := range m.documents {
+		if m.inScope(scope, document.Id) {
+			documents = append(documents, document)
+		}
+	}
+	return documents, nil
+}
+
+// AddCommentToDraft - Create a comments to drafts. A successful result will return the comment Id.
+// A reply to a resolved thread reopens it. An anchored comment is checked against the draft content.
+// ErrNotFound is returned if the draft or parent comment is not in the workspace. A comment.added event is published.
+func (m *Memory) AddCommentToDraft(workspaceId int, comment Comment) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.draftInWorkspace(workspaceId, comment.DraftId) {
+		return 0, ErrNotFound
+	}
+	if parent := comment.ParentCommentId; parent != nil && !m.commentInWorkspace(workspaceId, *parent) {
+		return 0, ErrNotFound
+	}
+
+	var anchor *CommentAnchor
+	if comment.Anchor != nil {
+		resolved, err := resolveAnchor(m.drafts[comment.DraftId-1].Content, *comment.Anchor)
+		if err != nil {
+			return 0, err
+		}
+		anchor = &CommentAnchor{DraftId: comment.DraftId, Anchor: resolved}
+	}
+	var resolvedAnchor Anchor
+	if anchor != nil {
+		resolvedAnchor = anchor.Anchor
+	}
+	suggestion, err := newSuggestion(comment, resolvedAnchor)
+	if err != nil {
+		return 0, err
+	}
+	comment.Suggestion = suggestion
+
+	comment.Id = len(m.comments) + 1
+	comment.Anchor = nil
+	comment.ThreadId = comment.Id
+	comment.Resolved, comment.ResolvedBy, comment.ResolvedAt = false, nil, nil
+	comment.CreatedAt = time.Now()
+	// Replies join their parent's thread, reopening it if it was resolved.
+	if parent := comment.ParentCommentId; parent != nil {
+		comment.ThreadId = m.comments[*parent-1].ThreadId
+		if m.comments[*parent-1].Resolved {
+			m.setThreadResolved(comment.ThreadId, false, nil)
+		}
+	}
+	published := comment
+	if anchor != nil {
+		published.Anchor = &anchor.Anchor
+	}
+	event, err := newEvent(EventCommentAdded, workspaceId, m.drafts[comment.DraftId-1].DocumentId, comment.DraftId, published)
+	if err != nil {
+		return 0, err
+	}
+
+	m.comments = append(m.comments, comment)
+	if anchor != nil {
+		anchor.CommentId = comment.Id
+		m.anchors = append(m.anchors, *anchor)
+	}
+
+	m.recordEvent(&event)
+	m.bus.Publish(event)
+	return int64(comment.Id), nil
+}
+
+// GetCommentAnchorsByDraftId - Retrieves the anchors in a draft, including those carried over
+// from comments on earlier versions. Orphaned anchors come last. A draft outside the workspace has none.
+func (m *Memory) GetCommentAnchorsByDraftId(workspaceId, draftId int) ([]CommentAnchor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.draftInWorkspace(workspaceId, draftId) {
+		return []CommentAnchor{}, nil
+	}
+	return m.draftAnchors(draftId), nil
+}
+
+// draftAnchors - The anchors in a draft in display order; callers hold the lock.
+func (m *Memory) draftAnchors(draftId int) []CommentAnchor {
+	anchors := []CommentAnchor{}
+	for _, anchor := range m.anchors {
+		if anchor.DraftId == draftId {
+			anchors = append(anchors, anchor)
+		}
+	}
+	sort.SliceStable(anchors, func(i, j int) bool {
+		a, b := anchors[i], anchors[j]
+		if a.Orphaned != b.Orphaned {
+			return !a.Orphaned
+		}
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if a.End != b.End {
+			return a.End < b.End
+		}
+		return a.CommentId < b.CommentId
+	})
+	return anchors
+}
+
+// GetCommentsAndReactionsByDraftId - Retrieves all of a drafts comments, with the the comment reactions
+// counted per emoji as seen by userId (0 for nobody).
+// Unresolved comments carried forward from earlier versions are included. Comments are ordered oldest first, ties broken by Id, and emojis in the order they were first used.
+// A draft outside the workspace has no comments.
+func (m *Memory) GetCommentsAndReactionsByDraftId(workspaceId, draftId, userId int) ([]CommentWithReactions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	commentsWithReactions := []CommentWithReactions{}
+	if !m.draftInWorkspace(workspaceId, draftId) {
+		return commentsWithReactions, nil
+	}
+	for _, comment := range m.comments {
+		if !m.onDraft(comment, draftId) {
+			continue
+		}
+
+		withReactions := CommentWithReactions{
+			Id:              comment.Id,
+			DraftId:         comment.DraftId,
+			UserId:          comment.UserId,
+			Text:            comment.Text,
+			ParentCommentId: comment.ParentCommentId,
+			ThreadId:        comment.ThreadId,
+			Resolved:        comment.Resolved,
+			ResolvedBy:      comment.ResolvedBy,
+			ResolvedAt:      comment.ResolvedAt,
+			Suggestion:      comment.Suggestion,
+			CreatedAt:       comment.CreatedAt,
+			Reactions:       m.reactionCounts(comment.Id, userId),
+		}
+		for _, anchor := range m.anchors {
+			if anchor.CommentId == comment.Id && anchor.DraftId == draftId {
+				anchorCopy := anchor.Anchor
+				withReactions.Anchor = &anchorCopy
+			}
+		}
+		commentsWithReactions = append(commentsWithReactions, withReactions)
+	}
+
+	return commentsWithReactions, nil
+}
+
+// onDraft - Reports whether a comment was made on or carried over to a draft; callers hold the lock.
+func (m *Memory) onDraft(comment Comment, draftId int) bool {
+	if comment.DraftId == draftId {
+		return true
+	}
+	for _, carryover := range m.carryovers {
+		if carryover.CommentId == comment.Id && carryover.DraftId == draftId {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCommentById - Retrieves a comment in a workspace by its ID.
+func (m *Memory) GetCommentById(workspaceId, id int) (*Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.commentInWorkspace(workspaceId, id) {
+		return nil, nil // Not found
+	}
+	comment := m.comments[id-1]
+	return &comment, nil
+}
+
+// ResolveThread - Marks the thread a comment belongs to as resolved by a user. Comments already
+// resolved keep who resolved them and when. Returns the updated comment, or nil if it is not in the workspace.
+func (m *Memory) ResolveThread(workspaceId, commentId, userId int) (*Comment, error) {
+	return m.updateThread(workspaceId, commentId, true, &userId)
+}
+
+// ReopenThread - Marks the thread a comment belongs to as unresolved again.
+// Returns the updated comment, or nil if it is not in the workspace.
+func (m *Memory) ReopenThread(workspaceId, commentId int) (*Comment, error) {
+	return m.updateThread(workspaceId, commentId, false, nil)
+}
+
+func (m *Memory) updateThread(workspaceId, commentId int, resolved bool, userId *int) (*Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.commentInWorkspace(workspaceId, commentId) {
+		return nil, nil // Not found
+	}
+	m.setThreadResolved(m.comments[commentId-1].ThreadId, resolved, userId)
+	comment := m.comments[commentId-1]
+	return &comment, nil
+}
+
+// setThreadResolved - Resolves or reopens every comment in a thread that is not already in
+// that state; callers hold the write lock.
+func (m *Memory) setThreadResolved(threadId int, resolved bool, userId *int) {
+	var resolvedAt *time.Time
+	if resolved {
+		now := time.Now()
+		resolvedAt = &now
+	}
+	for i := range m.comments {
+		if m.comments[i].ThreadId == threadId && m.comments[i].Resolved != resolved {
+			m.comments[i].Resolved = resolved
+			m.comments[i].ResolvedBy = userId
+			m.comments[i].ResolvedAt = resolvedAt
+		}
+	}
+}
+
+// AddReactionToComment - Creats a reaction to a comment. The reaction Id carries the comment Id.
+// Returns false if the user already reacted with the emoji, which is not added twice.
+// ErrNotFound is returned if the comment is not in the workspace. A reaction.added event is published.
+func (m *Memory) AddReactionToComment(workspaceId int, reaction common.Reaction) (bool, error) {
+	_, changed, err := m.changeReaction(workspaceId, reaction, reactionAdd)
+	return changed, err
+}
+
+// RemoveReactionFromComment - Removes a user's reaction with an emoji from a comment. The reaction
+// Id carries the comment Id. Returns false if there was none. ErrNotFound is returned if the
+// comment is not in the workspace. A reaction.removed event is published.
+func (m *Memory) RemoveReactionFromComment(workspaceId int, reaction common.Reaction) (bool, error) {
+	_, changed, err := m.changeReaction(workspaceId, reaction, reactionRemove)
+	return changed, err
+}
+
+// ToggleReaction - Removes a user's reaction with an emoji from a comment if there is one, and
+// adds it otherwise. Returns whether the user has now reacted with the emoji.
+func (m *Memory) ToggleReaction(workspaceId int, reaction common.Reaction) (bool, error) {
+	reacted, _, err := m.changeReaction(workspaceId, reaction, reactionToggle)
+	return reacted, err
+}
+
+// changeReaction - Adds or removes a reaction and records its event. Returns whether the user has
+// reacted with the emoji afterwards and whether anything changed.
+func (m *Memory) changeReaction(workspaceId int, reaction common.Reaction, change reactionChange) (bool, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.commentInWorkspace(workspaceId, reaction.Id) {
+		return false, false, ErrNotFound
+	}
+	draft := m.drafts[m.comments[reaction.Id-1].DraftId-1]
+
+	existing := -1
+	for i, candidate := range m.reactions {
+		if !candidate.Removed && candidate.CommentId == reaction.Id && candidate.UserId == reaction.UserId && candidate.Emoji == reaction.Emoji {
+			existing = i
+		}
+	}
+	switch {
+	case existing >= 0 && change == reactionAdd:
+		return true, false, nil
+	case existing < 0 && change == reactionRemove:
+		return false, false, nil
+	case existing >= 0:
+		removed := m.reactions[existing]
+		event, err := newEvent(EventReactionRemoved, workspaceId, draft.DocumentId, draft.Id, ReactionRemoved{CommentId: removed.CommentId, Reaction: removed.Reaction})
+		if err != nil {
+			return false, false, err
+		}
+		m.reactions[existing].Removed = true
+		m.recordEvent(&event)
+		m.bus.Publish(event)
+		return false, true, nil
+	}
+
+	added := memoryReaction{
+		CommentId: reaction.Id,
+		Reaction: common.Reaction{
+			Id:        len(m.reactions) + 1,
+			UserId:    reaction.UserId,
+			Emoji:     reaction.Emoji,
+			CreatedAt: time.Now(),
+		},
+	}
+	event, err := newEvent(EventReactionAdded, workspaceId, draft.DocumentId, draft.Id, ReactionAdded{CommentId: added.CommentId, Reaction: added.Reaction})
+	if err != nil {
+		return false, false, err
+	}
+	m.reactions = append(m.reactions, added)
+
+	m.recordEvent(&event)
+	m.bus.Publish(event)
+	return true, true, nil
+}
+
+// GetReactionCounts - The reactions to a comment counted per emoji as seen by userId, in the order
+// each emoji was first used. ErrNotFound is returned if the comment is not in the workspace.
+func (m *Memory) GetReactionCounts(workspaceId, commentId, userId int) ([]ReactionCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.commentInWorkspace(workspaceId, commentId) {
+		return nil, ErrNotFound
+	}
+	return m.reactionCounts(commentId, userId), nil
+}
+
+// reactionCounts - A comment's reactions counted per emoji as seen by userId; callers hold the lock.
+func (m *Memory) reactionCounts(commentId, userId int) []ReactionCount {
+	counts := []ReactionCount{}
+	index := map[string]int{} // Emoji to its position in counts
+	for _, reaction := range m.reactions {
+		if reaction.Removed || reaction.CommentId != commentId {
+			continue
+		}
+		i, found := index[reaction.Emoji]
+		if !found {
+			i = len(counts)
+			index[reaction.Emoji] = i
+			counts = append(counts, ReactionCount{Emoji: reaction.Emoji})
+		}
+		counts[i].Count++
+		if userId != 0 && reaction.UserId == userId {
+			counts[i].ReactedByMe = true
+		}
+	}
+	return counts
+}
+
+// CreateUser - Creates a user, returning ErrUsernameTaken if the username exists.
+func (m *Memory) CreateUser(user User) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.Username == user.Username {
+			return nil, ErrUsernameTaken
+		}
+	}
+	user.Id = len(m.users) + 1
+	user.CreatedAt = time.Now()
+	m.users = append(m.users, user)
+	return &user, nil
+}
+
+// GetUserById - Retrieves a user by their ID.
+func (m *Memory) GetUserById(id int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > len(m.users) {
+		return nil, nil // Not found
+	}
+	user := m.users[id-1]
+	return &user, nil
+}
+
+// GetUserByUsername - Retrieves a user by their username.
+func (m *Memory) GetUserByUsername(username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+// CreateAPIKey - Stores a new API key for a user under the hash of the key.
+func (m *Memory) CreateAPIKey(key APIKey, keyHash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.Id = len(m.apiKeys) + 1
+	key.CreatedAt = time.Now()
+	key.RevokedAt = nil
+	m.apiKeys = append(m.apiKeys, memoryAPIKey{APIKey: key, KeyHash: keyHash})
+	return &key, nil
+}
+
+// GetUserByAPIKey - Retrieves the owner of an API key that has not been revoked.
+func (m *Memory) GetUserByAPIKey(keyHash string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash && key.RevokedAt == nil && key.UserId >= 1 && key.UserId <= len(m.users) {
+			user := m.users[key.UserId-1]
+			return &user, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+// GetAPIKeysByUserId - Lists a user's API keys, revoked ones included, oldest first.
+func (m *Memory) GetAPIKeysByUserId(userId int) ([]APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, key := range m.apiKeys {
+		if key.UserId == userId {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey - Revokes one of a user's keys. Reports false if the user has no such active key.
+func (m *Memory) RevokeAPIKey(userId, keyId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if keyId < 1 || keyId > len(m.apiKeys) {
+		return false, nil
+	}
+	key := &m.apiKeys[keyId-1]
+	if key.UserId != userId || key.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return true, nil
+}
+
+// inScope - Reports whether a scope can see a document; callers hold the lock.
+func (m *Memory) inScope(scope Scope, documentId int) bool {
+	if m.documents[documentId-1].WorkspaceId != scope.WorkspaceId {
+		return false
+	}
+	return scope.Unrestricted || m.documentRole(documentId, scope.UserId) != RoleNone
+}
+
+// GetDocumentRole - The strongest role a user holds on a document, directly or through a group.
+// RoleNone means the user cannot see the document.
+func (m *Memory) GetDocumentRole(documentId, userId int) (Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.documentRole(documentId, userId), nil
+}
+
+// documentRole - Looks up a user's role; callers hold the lock.
+func (m *Memory) documentRole(documentId, userId int) Role {
+	var roles []Role
+	for _, permission := range m.permissions {
+		if permission.DocumentId != documentId {
+			continue
+		}
+		if permission.PrincipalType == PrincipalUser && permission.PrincipalId == userId ||
+			permission.PrincipalType == PrincipalGroup && m.isMember(permission.PrincipalId, userId) {
+			roles = append(roles, permission.Role)
+		}
+	}
+	return strongestRole(roles)
+}
+
+// isMember - Reports whether a user belongs to a group; callers hold the lock.
+func (m *Memory) isMember(groupId, userId int) bool {
+	for _, member := range m.members {
+		if member.GroupId == groupId && member.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDocumentPermission - Grants a role on a document to a user or group, replacing any role
+// the principal already had. Returns ErrLastOwner if this demotes the only user owner.
+func (m *Memory) SetDocumentPermission(permission DocumentPermission) (*DocumentPermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := m.permissionIndex(permission.DocumentId, permission.PrincipalType, permission.PrincipalId)
+	if index < 0 {
+		permission.CreatedAt = time.Now()
+		m.permissions = append(m.permissions, permission)
+		return &permission, nil
+	}
+
+	previous := m.permissions[index]
+	m.permissions[index].Role = permission.Role
+	if !m.hasOwner(permission.DocumentId) {
+		m.permissions[index] = previous
+		return nil, ErrLastOwner
+	}
+	updated := m.permissions[index]
+	return &updated, nil
+}
+
+// RemoveDocumentPermission - Takes away a principal's role on a document. Reports false if it
+// had none, and returns ErrLastOwner rather than removing the only user owner.
+func (m *Memory) RemoveDocumentPermission(documentId int, principalType string, principalId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := m.permissionIndex(documentId, principalType, principalId)
+	if index < 0 {
+		return false, nil
+	}
+
+	remaining := append(append([]DocumentPermission{}, m.permissions[:index]...), m.permissions[index+1:]...)
+	previous := m.permissions
+	m.permissions = remaining
+	if !m.hasOwner(documentId) {
+		m.permissions = previous
+		return false, ErrLastOwner
+	}
+	return true, nil
+}
+
+// permissionIndex - The position of a principal's permission on a document, or -1; callers hold the lock.
+func (m *Memory) permissionIndex(documentId int, principalType string, principalId int) int {
+	for i, permission := range m.permissions {
+		if permission.DocumentId == documentId && permission.PrincipalType == principalType && permission.PrincipalId == principalId {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasOwner - Reports whether a document has a user owner; callers hold the lock.
+func (m *Memory) hasOwner(documentId int) bool {
+	for _, permission := range m.permissions {
+		if permission.DocumentId == documentId && permission.PrincipalType == PrincipalUser && permission.Role == RoleOwner {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDocumentPermissions - Lists who a document is shared with, groups first.
+func (m *Memory) GetDocumentPermissions(documentId int) ([]DocumentPermission, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	permissions := []DocumentPermission{}
+	for _, permission := range m.permissions {
+		if permission.DocumentId == documentId {
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		if permissions[i].PrincipalType != permissions[j].PrincipalType {
+			return permissions[i].PrincipalType < permissions[j].PrincipalType
+		}
+		return permissions[i].PrincipalId < permissions[j].PrincipalId
+	})
+	return permissions, nil
+}
+
+// CreateGroup - Creates a group in a workspace with its owner as the first member, returning
+// ErrGroupNameTaken if the name exists there.
+func (m *Memory) CreateGroup(group Group) (*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.groups {
+		if existing.WorkspaceId == group.WorkspaceId && existing.Name == group.Name {
+			return nil, ErrGroupNameTaken
+		}
+	}
+	group.Id = len(m.groups) + 1
+	group.CreatedAt = time.Now()
+	m.groups = append(m.groups, group)
+	m.members = append(m.members, memoryGroupMember{GroupId: group.Id, UserId: group.OwnerId})
+	return &group, nil
+}
+
+// GetGroupById - Retrieves a group by its ID.
+func (m *Memory) GetGroupById(id int) (*Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > len(m.groups) {
+		return nil, nil // Not found
+	}
+	group := m.groups[id-1]
+	return &group, nil
+}
+
+// AddGroupMember - Adds a user to a group. Adding an existing member does nothing.
+func (m *Memory) AddGroupMember(groupId, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.isMember(groupId, userId) {
+		m.members = append(m.members, memoryGroupMember{GroupId: groupId, UserId: userId})
+	}
+	return nil
+}
+
+// RemoveGroupMember - Removes a user from a group. Reports false if they were not a member.
+func (m *Memory) RemoveGroupMember(groupId, userId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, member := range m.members {
+		if member.GroupId == groupId && member.UserId == userId {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetGroupMembers - Lists the members of a group by user Id.
+func (m *Memory) GetGroupMembers(groupId int) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := []User{}
+	for _, user := range m.users {
+		if m.isMember(groupId, user.Id) {
+			members = append(members, user)
+		}
+	}
+	return members, nil
+}
+
+// CreateWorkspace - Creates a workspace with adminId as its first admin.
+func (m *Memory) CreateWorkspace(workspace Workspace, adminId int) (*Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workspace.Id = len(m.workspaces) + 1
+	workspace.CreatedAt = time.Now()
+	workspace.Role = WorkspaceRoleNone
+	m.workspaces = append(m.workspaces, workspace)
+	m.memberships = append(m.memberships, WorkspaceMember{
+		WorkspaceId: workspace.Id,
+		UserId:      adminId,
+		Role:        WorkspaceRoleAdmin,
+		CreatedAt:   workspace.CreatedAt,
+	})
+	workspace.Role = WorkspaceRoleAdmin
+	return &workspace, nil
+}
+
+// GetWorkspacesByUserId - The workspaces a user belongs to with their role in each, in the
+// order they joined them.
+func (m *Memory) GetWorkspacesByUserId(userId int) ([]Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	workspaces := []Workspace{}
+	for _, membership := range m.memberships {
+		if membership.UserId == userId {
+			workspace := m.workspaces[membership.WorkspaceId-1]
+			workspace.Role = membership.Role
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	return workspaces, nil
+}
+
+// GetWorkspaceRole - A user's role in a workspace. WorkspaceRoleNone means they are not a member.
+func (m *Memory) GetWorkspaceRole(workspaceId, userId int) (WorkspaceRole, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if index := m.membershipIndex(workspaceId, userId); index >= 0 {
+		return m.memberships[index].Role, nil
+	}
+	return WorkspaceRoleNone, nil
+}
+
+// membershipIndex - The position of a user's membership of a workspace, or -1; callers hold the lock.
+func (m *Memory) membershipIndex(workspaceId, userId int) int {
+	for i, membership := range m.memberships {
+		if membership.WorkspaceId == workspaceId && membership.UserId == userId {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasAdmin - Reports whether a workspace has an admin; callers hold the lock.
+func (m *Memory) hasAdmin(workspaceId int) bool {
+	for _, membership := range m.memberships {
+		if membership.WorkspaceId == workspaceId && membership.Role == WorkspaceRoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// SetWorkspaceMember - Adds a user to a workspace or changes their role. Returns ErrLastAdmin if
+// this demotes the only admin.
+func (m *Memory) SetWorkspaceMember(member WorkspaceMember) (*WorkspaceMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member.Username = ""
+	for _, user := range m.users {
+		if user.Id == member.UserId {
+			member.Username = user.Username
+		}
+	}
+
+	index := m.membershipIndex(member.WorkspaceId, member.UserId)
+	if index < 0 {
+		member.CreatedAt = time.Now()
+		m.memberships = append(m.memberships, member)
+		return &member, nil
+	}
+
+	previous := m.memberships[index]
+	m.memberships[index].Role = member.Role
+	if !m.hasAdmin(member.WorkspaceId) {
+		m.memberships[index] = previous
+		return nil, ErrLastAdmin
+	}
+	member.CreatedAt = previous.CreatedAt
+	return &member, nil
+}
+
+// RemoveWorkspaceMember - Removes a user from a workspace. Reports false if they were not a
+// member and returns ErrLastAdmin if they are its only admin. Permissions they were granted on
+// documents stay, but cannot be used without membership.
+func (m *Memory) RemoveWorkspaceMember(workspaceId, userId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := m.membershipIndex(workspaceId, userId)
+	if index < 0 {
+		return false, nil
+	}
+
+	previous := m.memberships
+	m.memberships = append(append([]WorkspaceMember{}, m.memberships[:index]...), m.memberships[index+1:]...)
+	if !m.hasAdmin(workspaceId) {
+		m.memberships = previous
+		return false, ErrLastAdmin
+	}
+	return true, nil
+}
+
+// GetWorkspaceMembers - Lists the members of a workspace in the order they joined.
+func (m *Memory) GetWorkspaceMembers(workspaceId int) ([]WorkspaceMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := []WorkspaceMember{}
+	for _, membership := range m.memberships {
+		if membership.WorkspaceId == workspaceId {
+			member := membership
+			member.Username = m.users[member.UserId-1].Username
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// CreateWebhook - Adds a webhook to webhook.WorkspaceId.
+func (m *Memory) CreateWebhook(webhook Webhook) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook.Id = len(m.webhooks) + 1
+	webhook.CreatedAt = time.Now()
+	webhook.EventTypes = append([]string{}, webhook.EventTypes...)
+	m.webhooks = append(m.webhooks, memoryWebhook{Webhook: webhook})
+	return &webhook, nil
+}
+
+// webhook - A live webhook in a workspace, or nil; callers hold the lock.
+func (m *Memory) webhook(workspaceId, id int) *memoryWebhook {
+	if id < 1 || id > len(m.webhooks) {
+		return nil
+	}
+	webhook := &m.webhooks[id-1]
+	if webhook.Deleted || webhook.WorkspaceId != workspaceId {
+		return nil
+	}
+	return webhook
+}
+
+// GetWebhookById - Retrieves a webhook in a workspace by its ID.
+func (m *Memory) GetWebhookById(workspaceId, id int) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhook := m.webhook(workspaceId, id)
+	if webhook == nil {
+		return nil, nil // Not found
+	}
+	found := webhook.Webhook
+	return &found, nil
+}
+
+// GetWebhooks - Lists the webhooks of a workspace, oldest first.
+func (m *Memory) GetWebhooks(workspaceId int) ([]Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhooks := []Webhook{}
+	for _, webhook := range m.webhooks {
+		if !webhook.Deleted && webhook.WorkspaceId == workspaceId {
+			webhooks = append(webhooks, webhook.Webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook - Removes a webhook and its deliveries. Reports false if the workspace has no
+// such webhook.
+func (m *Memory) DeleteWebhook(workspaceId, id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook := m.webhook(workspaceId, id)
+	if webhook == nil {
+		return false, nil
+	}
+	webhook.Deleted = true
+	return true, nil
+}
+
+// queueDeliveries - Adds a delivery of the event for every webhook that wants it and whose
+// creator can still see the event's document; callers hold the write lock.
+func (m *Memory) queueDeliveries(event Event) {
+	for _, webhook := range m.webhooks {
+		if webhook.Deleted || !webhook.Wants(event) {
+			continue
+		}
+		if m.membershipIndex(event.WorkspaceId, webhook.CreatedBy) < 0 || m.documentRole(event.DocumentId, webhook.CreatedBy) == RoleNone {
+			continue
+		}
+		m.deliveries = append(m.deliveries, WebhookDelivery{
+			Id:            int64(len(m.deliveries) + 1),
+			WebhookId:     webhook.Id,
+			EventId:       event.Id,
+			EventType:     event.Type,
+			Status:        DeliveryPending,
+			NextAttemptAt: event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		})
+	}
+}
+
+// GetWebhookDeliveries - The most recent deliveries to a webhook in a workspace, newest first.
+// At most limit deliveries are returned, capped at MaxDeliveryPage.
+func (m *Memory) GetWebhookDeliveries(workspaceId, webhookId, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > MaxDeliveryPage {
+		limit = MaxDeliveryPage
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deliveries := []WebhookDelivery{}
+	if m.webhook(workspaceId, webhookId) == nil {
+		return deliveries, nil
+	}
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookId == webhookId {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhookDelivery - Queues the event of an earlier delivery to a webhook in the
+// workspace to be sent again now, as a new delivery. Nil if there is no such delivery.
+func (m *Memory) RedeliverWebhookDelivery(workspaceId, webhookId int, deliveryId int64) (*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webhook(workspaceId, webhookId) == nil || deliveryId < 1 || deliveryId > int64(len(m.deliveries)) {
+		return nil, nil
+	}
+	previous := m.deliveries[deliveryId-1]
+	if previous.WebhookId != webhookId {
+		return nil, nil
+	}
+
+	now := time.Now()
+	delivery := WebhookDelivery{
+		Id:            int64(len(m.deliveries) + 1),
+		WebhookId:     webhookId,
+		EventId:       previous.EventId,
+		EventType:     previous.EventType,
+		Status:        DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	m.deliveries = append(m.deliveries, delivery)
+	return &delivery, nil
+}
+
+// ClaimWebhookDeliveries - Takes up to limit pending deliveries that are due at now, counting
+// an attempt on each and holding them for lease so no other worker picks them up. A claim that
+// is not completed within the lease is retried.
+func (m *Memory) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]PendingDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []*WebhookDelivery{}
+	for i := range m.deliveries {
+		delivery := &m.deliveries[i]
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) && !m.webhooks[delivery.WebhookId-1].Deleted {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := []PendingDelivery{}
+	for _, delivery := range due {
+		delivery.Attempts++
+		delivery.NextAttemptAt = now.Add(lease)
+		webhook := m.webhooks[delivery.WebhookId-1]
+		claimed = append(claimed, PendingDelivery{
+			WebhookDelivery: *delivery,
+			URL:             webhook.URL,
+			Secret:          webhook.Secret,
+			Event:           m.events[delivery.EventId-1],
+		})
+	}
+	return claimed, nil
+}
+
+// CompleteWebhookDelivery - Records the outcome of an attempt on a claimed delivery.
+func (m *Memory) CompleteWebhookDelivery(id int64, result DeliveryResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.deliveries)) {
+		return nil
+	}
+	delivery := &m.deliveries[id-1]
+	attemptedAt := result.AttemptedAt
+	delivery.Status = result.Status
+	delivery.ResponseStatus = result.ResponseStatus
+	delivery.LastError = result.Error
+	delivery.LastAttemptAt = &attemptedAt
+	delivery.NextAttemptAt = attemptedAt
+	delivery.DeliveredAt = nil
+	switch result.Status {
+	case DeliveryPending:
+		delivery.NextAttemptAt = result.NextAttemptAt
+	case DeliveryDelivered:
+		delivery.DeliveredAt = &attemptedAt
+	}
+	return nil
+}
+
+// workflow - A document's workflow and state; callers hold the lock.
+func (m *Memory) workflow(documentId int) DocumentWorkflow {
+	for _, current := range m.workflows {
+		if current.DocumentId == documentId {
+			current.Workflow.Transitions = append([]WorkflowTransition{}, current.Workflow.Transitions...)
+			return current
+		}
+	}
+	workflow := DefaultWorkflow()
+	return DocumentWorkflow{DocumentId: documentId, Workflow: workflow, State: workflow.Initial}
+}
+
+// saveWorkflow - Stores a document's workflow and state; callers hold the write lock.
+func (m *Memory) saveWorkflow(updated DocumentWorkflow) {
+	for i := range m.workflows {
+		if m.workflows[i].DocumentId == updated.DocumentId {
+			m.workflows[i] = updated
+			return
+		}
+	}
+	m.workflows = append(m.workflows, updated)
+}
+
+// moveState - Changes a document's state and adds the change to the audit trail; callers hold
+// the write lock.
+func (m *Memory) moveState(current DocumentWorkflow, transition Transition) Transition {
+	current.State = transition.To
+	current.UpdatedAt = transition.CreatedAt
+	m.saveWorkflow(current)
+	return m.recordTransition(transition)
+}
+
+// recordTransition - Adds an entry to the audit trail, filling in its Id; callers hold the write lock.
+func (m *Memory) recordTransition(transition Transition) Transition {
+	transition.Id = len(m.transitions) + 1
+	m.transitions = append(m.transitions, transition)
+	return transition
+}
+
+// GetWorkflow - A document's workflow and the state it is in.
+func (m *Memory) GetWorkflow(documentId int) (*DocumentWorkflow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	current := m.workflow(documentId)
+	return &current, nil
+}
+
+// SetWorkflow - Replaces a document's workflow after validating it. A document in a state the
+// new workflow does not have moves to its initial state, recorded as made by userId.
+func (m *Memory) SetWorkflow(documentId int, workflow Workflow, userId int) (*DocumentWorkflow, error) {
+	if err := workflow.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.workflow(documentId)
+	updated := DocumentWorkflow{DocumentId: documentId, Workflow: workflow, State: current.State, UpdatedAt: time.Now()}
+	if !workflow.HasState(current.State) {
+		updated.State = workflow.Initial
+		m.transitions = append(m.transitions, Transition{
+			Id: len(m.transitions) + 1, DocumentId: documentId, From: current.State, To: updated.State,
+			VersionNumber: m.latestVersion(documentId), UserId: userId, Reason: ReasonWorkflowChanged, CreatedAt: updated.UpdatedAt,
+		})
+	}
+	m.saveWorkflow(updated)
+	return &updated, nil
+}
+
+// latestVersion - The document's latest version number, 0 if it does not exist; callers hold the lock.
+func (m *Memory) latestVersion(documentId int) int {
+	if documentId < 1 || documentId > len(m.documents) {
+		return 0
+	}
+	return m.documents[documentId-1].LatestVersion
+}
+
+// versionApprovals - The approvals of a version, optionally only those still counting; callers hold the lock.
+func (m *Memory) versionApprovals(documentId, version int, valid bool) []Approval {
+	approvals := []Approval{}
+	for _, approval := range m.approvals {
+		if approval.Withdrawn || approval.DocumentId != documentId || approval.VersionNumber != version {
+			continue
+		}
+		if !valid || approval.InvalidatedAt == nil {
+			approvals = append(approvals, approval.Approval)
+		}
+	}
+	return approvals
+}
+
+// ApproveVersion - Records userId's approval of a document version, which must be its latest or
+// ErrNotLatestVersion is returned. The version's author cannot approve it and gets
+// ErrSelfApproval. Approving twice returns the first approval; the first is added to the audit trail.
+func (m *Memory) ApproveVersion(documentId, version, userId int) (*Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if version < 1 || m.latestVersion(documentId) != version {
+		return nil, ErrNotLatestVersion
+	}
+	draft := m.draftByVersion(documentId, version)
+	if draft.AuthorId != 0 && draft.AuthorId == userId {
+		return nil, ErrSelfApproval
+	}
+	for _, approval := range m.versionApprovals(documentId, version, false) {
+		if approval.UserId == userId {
+			return &approval, nil
+		}
+	}
+	approval := Approval{
+		Id:            len(m.approvals) + 1,
+		DocumentId:    documentId,
+		DraftId:       draft.Id,
+		VersionNumber: version,
+		UserId:        userId,
+		CreatedAt:     time.Now(),
+	}
+	m.approvals = append(m.approvals, memoryApproval{Approval: approval})
+	current := m.workflow(documentId)
+	m.recordTransition(Transition{
+		DocumentId: documentId, From: current.State, To: current.State, VersionNumber: version,
+		UserId: userId, Reason: ReasonApproved, CreatedAt: approval.CreatedAt,
+	})
+	return &approval, nil
+}
+
+// WithdrawApproval - Removes userId's approval of a version and adds the withdrawal to the audit
+// trail. A document in an approved state whose latest version no longer has the approvals it
+// needs returns to its workflow's ReturnState. Reports false if there was no approval.
+func (m *Memory) WithdrawApproval(documentId, version, userId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.approvals {
+		approval := &m.approvals[i]
+		if approval.Withdrawn || approval.DocumentId != documentId || approval.VersionNumber != version || approval.UserId != userId {
+			continue
+		}
+		approval.Withdrawn = true
+
+		current := m.workflow(documentId)
+		transition := Transition{
+			DocumentId: documentId, From: current.State, To: current.State, VersionNumber: version,
+			UserId: userId, Reason: ReasonApprovalWithdrawn, CreatedAt: time.Now(),
+		}
+		if version == m.latestVersion(documentId) && current.Workflow.approved(current.State) && current.State != current.Workflow.ReturnState {
+			if _, ok := current.Workflow.missingApprovals(m.versionApprovals(documentId, version, true)); !ok {
+				transition.To = current.Workflow.ReturnState
+				m.moveState(current, transition)
+				return true, nil
+			}
+		}
+		m.recordTransition(transition)
+		return true, nil
+	}
+	return false, nil
+}
+
+// GetApprovals - The approvals of a document version, oldest first, including invalidated ones.
+func (m *Memory) GetApprovals(documentId, version int) ([]Approval, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.versionApprovals(documentId, version, false), nil
+}
+
+// TransitionDocument - Moves a document to another state of its workflow as userId. Returns
+// ErrTransitionNotAllowed if the workflow has no such transition from the current state,
+// ErrPermissionDenied if the user's role is too weak and an *ApprovalRequiredError if the latest
+// version lacks the approvals the transition needs.
+func (m *Memory) TransitionDocument(documentId int, to string, userId int, reason string) (*Transition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.workflow(documentId)
+	allowed := current.Workflow.Transition(current.State, to)
+	if allowed == nil {
+		return nil, ErrTransitionNotAllowed
+	}
+	if !m.documentRole(documentId, userId).AtLeast(allowed.Role) {
+		return nil, ErrPermissionDenied
+	}
+
+	version := m.latestVersion(documentId)
+	if allowed.RequiresApproval {
+		approvals := m.versionApprovals(documentId, version, true)
+		if missing, ok := current.Workflow.missingApprovals(approvals); !ok {
+			return nil, &ApprovalRequiredError{VersionNumber: version, MinApprovals: current.Workflow.MinApprovals, Approvals: len(approvals), Missing: missing}
+		}
+	}
+
+	transition := m.moveState(current, Transition{
+		DocumentId: documentId, From: current.State, To: to, VersionNumber: version,
+		UserId: userId, Reason: reason, CreatedAt: time.Now(),
+	})
+	return &transition, nil
+}
+
+// GetTransitions - A document's audit trail of state changes, oldest first.
+func (m *Memory) GetTransitions(documentId int) ([]Transition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transitions := []Transition{}
+	for _, transition := range m.transitions {
+		if transition.DocumentId == documentId {
+			transitions = append(transitions, transition)
+		}
+	}
+	return transitions, nil
+}
+
+// invalidateApprovals - Called as a new draft is created: approvals of earlier versions stop
+// counting, and a document in an approved state returns to its workflow's ReturnState. Callers
+// hold the write lock.
+func (m *Memory) invalidateApprovals(draft Draft, userId int) {
+	if draft.VersionNumber < 2 {
+		return
+	}
+	for i := range m.approvals {
+		approval := &m.approvals[i]
+		if approval.DocumentId == draft.DocumentId && approval.VersionNumber < draft.VersionNumber && approval.InvalidatedAt == nil {
+			invalidatedAt := draft.CreatedAt
+			approval.InvalidatedAt = &invalidatedAt
+		}
+	}
+
+	current := m.workflow(draft.DocumentId)
+	if !current.Workflow.approved(current.State) || current.State == current.Workflow.ReturnState {
+		return
+	}
+	m.moveState(current, Transition{
+		DocumentId: draft.DocumentId, From: current.State, To: current.Workflow.ReturnState,
+		VersionNumber: draft.VersionNumber, UserId: userId, Reason: ReasonNewDraft, CreatedAt: draft.CreatedAt,
+	})
+}
+
+// publicationChanged - Records and publishes eventType for a document whose publication changed;
+// callers hold the write lock.
+func (m *Memory) publicationChanged(document *Document, eventType string) {
+	draftId := 0
+	if published := m.draftByVersion(document.Id, document.PublishedVersion); published != nil {
+		draftId = published.Id
+	}
+	event, err := newEvent(eventType, document.WorkspaceId, document.Id, draftId, document)
+	if err != nil {
+		return // A Document always encodes
+	}
+	m.recordEvent(&event)
+	m.bus.Publish(event)
+}
+
+// publishableDocument - The document if version is one of its versions and its workflow approves
+// publishing it. Approvals invalidated by newer drafts still count; callers hold the lock.
+func (m *Memory) publishableDocument(documentId, version int) (*Document, error) {
+	if documentId < 1 || documentId > len(m.documents) {
+		return nil, ErrNotFound
+	}
+	document := &m.documents[documentId-1]
+	if version < 1 || version > document.LatestVersion {
+		return nil, ErrNotFound
+	}
+	current := m.workflow(documentId)
+	if !current.Workflow.approvesPublication(current.State, version == document.LatestVersion, m.versionApprovals(documentId, version, false)) {
+		return nil, ErrNotApproved
+	}
+	return document, nil
+}
+
+// PublishDocument - Makes a version of a document the one readers see, as userId, cancelling any
+// scheduled publication. Returns ErrNotFound if the document has no such version and
+// ErrNotApproved if its workflow has not approved it. A document.published event is published.
+func (m *Memory) PublishDocument(documentId, version, userId int) (*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document, err := m.publishableDocument(documentId, version)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	document.PublishedVersion, document.PublishedAt, document.PublishedBy = version, &now, userId
+	document.ScheduledVersion, document.ScheduledAt, document.ScheduledBy = 0, nil, 0
+	m.publicationChanged(document, EventDocumentPublished)
+	published := *document
+	return &published, nil
+}
+
+// SchedulePublication - Arranges for a version of a document to be published at a later time,
+// replacing any earlier schedule. Returns ErrNotFound if the document has no such version and
+// ErrNotApproved if its workflow has not approved it.
+func (m *Memory) SchedulePublication(documentId, version int, at time.Time, userId int) (*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document, err := m.publishableDocument(documentId, version)
+	if err != nil {
+		return nil, err
+	}
+	document.ScheduledVersion, document.ScheduledAt, document.ScheduledBy = version, &at, userId
+	scheduled := *document
+	return &scheduled, nil
+}
+
+// UnpublishDocument - Withdraws a document from readers and cancels any scheduled publication.
+// Returns ErrNotFound if there is no such document. A document.unpublished event is published
+// if it was published.
+func (m *Memory) UnpublishDocument(documentId int) (*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if documentId < 1 || documentId > len(m.documents) {
+		return nil, ErrNotFound
+	}
+	document := &m.documents[documentId-1]
+	wasPublished := document.PublishedVersion > 0
+	document.PublishedVersion, document.PublishedAt, document.PublishedBy = 0, nil, 0
+	document.ScheduledVersion, document.ScheduledAt, document.ScheduledBy = 0, nil, 0
+	if wasPublished {
+		m.publicationChanged(document, EventDocumentUnpublished)
+	}
+	unpublished := *document
+	return &unpublished, nil
+}
+
+// PublishDueDocuments - Publishes every document whose scheduled publication is due at now, as
+// the user who scheduled it, and returns them.
+func (m *Memory) PublishDueDocuments(now time.Time) ([]Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	published := []Document{}
+	for i := range m.documents {
+		document := &m.documents[i]
+		if document.ScheduledVersion == 0 || document.ScheduledAt.After(now) {
+			continue
+		}
+		publishedAt := now
+		document.PublishedVersion, document.PublishedAt, document.PublishedBy = document.ScheduledVersion, &publishedAt, document.ScheduledBy
+		document.ScheduledVersion, document.ScheduledAt, document.ScheduledBy = 0, nil, 0
+		m.publicationChanged(document, EventDocumentPublished)
+		published = append(published, *document)
+	}
+	return published, nil
+}
+
+// CreateBranch - Forks a branch from branch.BaseVersion of its document. Returns ErrNotFound if
+// the document has no such version and ErrBranchExists if the name is taken.
+func (m *Memory) CreateBranch(branch Branch) (*Branch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.draftByVersion(branch.DocumentId, branch.BaseVersion) == nil {
+		return nil, ErrNotFound
+	}
+	if m.branch(branch.DocumentId, branch.Name) != nil {
+		return nil, ErrBranchExists
+	}
+	branch.Id, branch.HeadVersion, branch.CreatedAt = len(m.branches)+1, 0, time.Now()
+	m.branches = append(m.branches, branch)
+	return &branch, nil
+}
+
+// branch - Looks up a document's branch by name; callers hold the lock.
+func (m *Memory) branch(documentId int, name string) *Branch {
+	for i := range m.branches {
+		if m.branches[i].DocumentId == documentId && m.branches[i].Name == name {
+			return &m.branches[i]
+		}
+	}
+	return nil
+}
+
+// GetBranch - Retrieves a document's branch by its name.
+func (m *Memory) GetBranch(documentId int, name string) (*Branch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := m.branch(documentId, name)
+	if found == nil {
+		return nil, nil // Not found
+	}
+	branch := *found
+	return &branch, nil
+}
+
+// GetBranches - Lists a document's branches, oldest first.
+func (m *Memory) GetBranches(documentId int) ([]Branch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	branches := []Branch{}
+	for _, branch := range m.branches {
+		if branch.DocumentId == documentId {
+			branches = append(branches, branch)
+		}
+	}
+	return branches, nil
+}
+
+// AddBranchDraft - Adds the next version of a branch. Returns ErrNotFound if there is no such branch.
+func (m *Memory) AddBranchDraft(branchId int, content string, userId int) (*BranchDraft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if branchId < 1 || branchId > len(m.branches) {
+		return nil, ErrNotFound
+	}
+	branch := &m.branches[branchId-1]
+	branch.HeadVersion++
+	created := BranchDraft{
+		Id: len(m.branchDrafts) + 1, BranchId: branchId, Content: content, VersionNumber: branch.HeadVersion,
+		CreatedBy: userId, CreatedAt: time.Now(),
+	}
+	m.branchDrafts = append(m.branchDrafts, created)
+	return &created, nil
+}
+
+// GetBranchDraft - Retrieves a version of a branch.
+func (m *Memory) GetBranchDraft(branchId, version int) (*BranchDraft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, draft := range m.branchDrafts {
+		if draft.BranchId == branchId && draft.VersionNumber == version {
+			return &draft, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+// GetBranchDrafts - Lists the versions of a branch, oldest first.
+func (m *Memory) GetBranchDrafts(branchId int) ([]BranchDraft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	drafts := []BranchDraft{}
+	for _, draft := range m.branchDrafts {
+		if draft.BranchId == branchId {
+			drafts = append(drafts, draft)
+		}
+	}
+	return drafts, nil
+}
+
+// MergeBranch - Records that headVersion of a branch was merged into the document by userId, so
+// the next merge starts from there. The merged draft, if any, becomes the document's next version
+// at the same time, as CreateDraft would write it; without one the document already has the
+// branch's changes in mergedVersion. An older merge never replaces a newer one. Returns
+// ErrNotFound if there is no such branch, or CreateDraft's errors for the draft.
+func (m *Memory) MergeBranch(branchId, headVersion int, merged *common.Draft, mergedVersion, userId int) (*Draft, *Branch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if branchId < 1 || branchId > len(m.branches) {
+		return nil, nil, ErrNotFound
+	}
+	var created *Draft
+	var event Event
+	if merged != nil {
+		var err error
+		if created, event, err = m.createDraft(*merged); err != nil {
+			return nil, nil, err
+		}
+		mergedVersion = created.VersionNumber
+	}
+
+	branch := &m.branches[branchId-1]
+	if branch.MergedHeadVersion <= headVersion {
+		now := time.Now()
+		branch.MergedVersion, branch.MergedHeadVersion, branch.MergedBy, branch.MergedAt = mergedVersion, headVersion, userId, &now
+	}
+	if created != nil {
+		m.bus.Publish(event)
+	}
+	result := *branch
+	return created, &result, nil
+}
+
+// pendingSuggestion - Reports whether a comment is a suggestion on the document still waiting
+// for a decision; callers hold the lock.
+func (m *Memory) pendingSuggestion(documentId, commentId int) bool {
+	if commentId < 1 || commentId > len(m.comments) {
+		return false
+	}
+	comment := m.comments[commentId-1]
+	return comment.Suggestion != nil && comment.Suggestion.Status == SuggestionPending &&
+		m.drafts[comment.DraftId-1].DocumentId == documentId
+}
+
+// decideSuggestion - Records the decision on a suggestion and resolves its thread; callers hold
+// the write lock. The suggestion is replaced rather than changed, as copies handed out share it.
+func (m *Memory) decideSuggestion(commentId int, status SuggestionStatus, userId int, at time.Time, draftId *int) {
+	comment := &m.comments[commentId-1]
+	decided := *comment.Suggestion
+	decided.Status, decided.DecidedBy, decided.DecidedAt, decided.DraftId = status, &userId, &at, draftId
+	comment.Suggestion = &decided
+	m.setThreadResolved(comment.ThreadId, true, &userId)
+}
+
+// RejectSuggestion - Marks a suggestion rejected by a user and resolves its thread. Returns nil if
+// the comment is not in the workspace, ErrNotSuggestion if it is not a suggestion and
+// ErrSuggestionDecided if it was already accepted or rejected.
+func (m *Memory) RejectSuggestion(workspaceId, commentId, userId int) (*Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.commentInWorkspace(workspaceId, commentId) {
+		return nil, nil // Not found
+	}
+	if m.comments[commentId-1].Suggestion == nil {
+		return nil, ErrNotSuggestion
+	}
+	if m.comments[commentId-1].Suggestion.Status != SuggestionPending {
+		return nil, ErrSuggestionDecided
+	}
+	m.decideSuggestion(commentId, SuggestionRejected, userId, time.Now(), nil)
+	comment := m.comments[commentId-1]
+	return &comment, nil
+}
+
+// queueNotifications - Stores the notifications an event sends; callers hold the write lock. The
+// event's data was encoded by the store itself, so it always decodes.
+func (m *Memory) queueNotifications(event Event) {
+	n, _ := noticeOf(event)
+	if n == nil {
+		return
+	}
+
+	recipients := map[int]NotificationReason{}
+	for _, username := range n.mentions {
+		for _, user := range m.users {
+			if user.Username == username {
+				addRecipient(recipients, user.Id, NotifyMention)
+			}
+		}
+	}
+	if n.parentId >= 1 && n.parentId <= len(m.comments) {
+		addRecipient(recipients, m.comments[n.parentId-1].UserId, NotifyReply)
+	}
+	if n.watchers {
+		for _, watcher := range m.watchers {
+			if watcher.DocumentId == event.DocumentId {
+				addRecipient(recipients, watcher.UserId, NotifyDraft)
+			}
+		}
+	}
+	delete(recipients, n.actorId)
+
+	userIds := make([]int, 0, len(recipients))
+	for userId := range recipients {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+	for _, userId := range userIds {
+		if m.membershipIndex(event.WorkspaceId, userId) < 0 || m.documentRole(event.DocumentId, userId) == RoleNone {
+			continue
+		}
+		notification := newNotification(event, n, userId, recipients[userId])
+		notification.Id = int64(len(m.notifications) + 1)
+		m.notifications = append(m.notifications, notification)
+	}
+}
+
+// WatchDocument - Starts sending a user a notification for each new draft of a document. Watching
+// a document twice changes nothing.
+func (m *Memory) WatchDocument(documentId, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, watcher := range m.watchers {
+		if watcher.DocumentId == documentId && watcher.UserId == userId {
+			return nil
+		}
+	}
+	m.watchers = append(m.watchers, DocumentWatcher{DocumentId: documentId, UserId: userId, CreatedAt: time.Now()})
+	return nil
+}
+
+// UnwatchDocument - Stops a user watching a document, reporting whether they were.
+func (m *Memory) UnwatchDocument(documentId, userId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, watcher := range m.watchers {
+		if watcher.DocumentId == documentId && watcher.UserId == userId {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetWatchers - The users watching a document, in the order they started.
+func (m *Memory) GetWatchers(documentId int) ([]DocumentWatcher, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	watchers := []DocumentWatcher{}
+	for _, watcher := range m.watchers {
+		if watcher.DocumentId == documentId {
+			watcher.Username = m.users[watcher.UserId-1].Username
+			watchers = append(watchers, watcher)
+		}
+	}
+	return watchers, nil
+}
+
+// inInbox - Reports whether a notification belongs to a user in a workspace and is about a
+// document they can still see; callers hold the lock.
+func (m *Memory) inInbox(notification Notification, workspaceId, userId int) bool {
+	return notification.WorkspaceId == workspaceId && notification.UserId == userId &&
+		m.inScope(Scope{WorkspaceId: workspaceId, UserId: userId}, notification.DocumentId)
+}
+
+// GetNotifications - A user's notifications in a workspace, newest first, filtered and paged by
+// options. Notifications about documents the user can no longer see are left out.
+func (m *Memory) GetNotifications(workspaceId, userId int, options NotificationOptions) ([]Notification, error) {
+	if options.Limit <= 0 || options.Limit > MaxNotificationPage {
+		options.Limit = MaxNotificationPage
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	notifications := []Notification{}
+	for i := len(m.notifications) - 1; i >= 0 && len(notifications) < options.Limit; i-- {
+		notification := m.notifications[i]
+		if !m.inInbox(notification, workspaceId, userId) || (options.UnreadOnly && notification.ReadAt != nil) ||
+			(options.Before > 0 && notification.Id >= options.Before) {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// CountUnreadNotifications - How many of a user's notifications in a workspace are unread.
+func (m *Memory) CountUnreadNotifications(workspaceId, userId int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, notification := range m.notifications {
+		if notification.ReadAt == nil && m.inInbox(notification, workspaceId, userId) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead - Marks a user's unread notifications in a workspace read: those listed
+// in ids, or all of them if ids is empty. Returns how many were marked.
+func (m *Memory) MarkNotificationsRead(workspaceId, userId int, ids []int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	listed := map[int64]bool{}
+	for _, id := range ids {
+		listed[id] = true
+	}
+	now := time.Now()
+	marked := 0
+	for i := range m.notifications {
+		notification := &m.notifications[i]
+		if notification.WorkspaceId != workspaceId || notification.UserId != userId || notification.ReadAt != nil ||
+			(len(ids) > 0 && !listed[notification.Id]) {
+			continue
+		}
+		readAt := now
+		notification.ReadAt = &readAt
+		marked++
+	}
+	return marked, nil
+}
+
+// settingsIndex - The position of a user's notification settings, or -1 if they have none;
+// callers hold the lock.
+func (m *Memory) settingsIndex(userId int) int {
+	for i, settings := range m.settings {
+		if settings.UserId == userId {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetNotificationSettings - A user's notification settings, the defaults if they never chose any.
+func (m *Memory) GetNotificationSettings(userId int) (*NotificationSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	settings := NotificationSettings{UserId: userId, DigestFrequency: DefaultDigestFrequency}
+	if i := m.settingsIndex(userId); i >= 0 {
+		settings = m.settings[i]
+	}
+	return &settings, nil
+}
+
+// SetNotificationSettings - Changes how often a user is sent digests.
+func (m *Memory) SetNotificationSettings(settings NotificationSettings) (*NotificationSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.settingsIndex(settings.UserId)
+	if i < 0 {
+		m.settings = append(m.settings, NotificationSettings{UserId: settings.UserId})
+		i = len(m.settings) - 1
+	}
+	m.settings[i].DigestFrequency = settings.DigestFrequency
+	updated := m.settings[i]
+	return &updated, nil
+}
+
+// ClaimDigests - Claims the digests of up to limit users that are due at now: users with an email
+// address whose frequency has passed since their last digest, and who have unread notifications
+// no digest has included, about documents they can still see. The notifications are marked
+// emailed, so they are never sent twice.
+func (m *Memory) ClaimDigests(now time.Time, limit int) ([]Digest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	claimed := []Digest{}
+	for _, user := range m.users {
+		if len(claimed) == limit {
+			break
+		}
+		settings := NotificationSettings{UserId: user.Id, DigestFrequency: DefaultDigestFrequency}
+		i := m.settingsIndex(user.Id)
+		if i >= 0 {
+			settings = m.settings[i]
+		}
+		if user.Email == "" || !settings.DigestFrequency.due(settings.LastDigestAt, now) {
+			continue
+		}
+
+		digest := Digest{User: user, Frequency: settings.DigestFrequency, Items: []DigestItem{}}
+		for j := range m.notifications {
+			notification := &m.notifications[j]
+			if notification.UserId != user.Id || notification.ReadAt != nil || notification.EmailedAt != nil ||
+				m.membershipIndex(notification.WorkspaceId, user.Id) < 0 || m.documentRole(notification.DocumentId, user.Id) == RoleNone {
+				continue
+			}
+			emailedAt := now
+			notification.EmailedAt = &emailedAt
+			item := DigestItem{Notification: *notification, DocumentName: m.documents[notification.DocumentId-1].Name}
+			if notification.ActorId >= 1 && notification.ActorId <= len(m.users) {
+				item.ActorName = m.users[notification.ActorId-1].Username
+			}
+			digest.Items = append(digest.Items, item)
+		}
+		if len(digest.Items) == 0 {
+			continue
+		}
+
+		if i < 0 {
+			m.settings = append(m.settings, settings)
+			i = len(m.settings) - 1
+		}
+		lastDigestAt := now
+		m.settings[i].LastDigestAt = &lastDigestAt
+		claimed = append(claimed, digest)
+	}
+	return claimed, nil
+}
+
+// ReleaseDigest - Returns the notifications of a digest that could not be sent, so the user's
+// next digest includes them.
+func (m *Memory) ReleaseDigest(digest Digest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range digest.NotificationIds() {
+		if id >= 1 && id <= int64(len(m.notifications)) {
+			m.notifications[id-1].EmailedAt = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/sqlite/fts5/*.sql
+//go:embed migrations/postgres/*.sql
+var embeddedMigrations embed.FS
+
+// Migration - A single versioned schema change loaded from a pair of
+// NNNN_name.up.sql / NNNN_name.down.sql files.
+type Migration struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	Up       string `json:"-"`
+	Down     string `json:"-"`
+	Checksum string `json:"checksum"`
+}
+
+// MigrationStatus - A known migration and whether it has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Migrator - Applies and rolls back migrations, tracking them in schema_migrations.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	dialect    dialect
+}
+
+// LoadMigrations - Reads every *.up.sql and *.down.sql file in the root of fsys,
+// ordered by version. Every version needs an up step; the down step is optional.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.%s.sql", fileName, direction)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", fileName, versionStr)
+		}
+
+		body, err := fs.ReadFile(fsys, fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// NewSQLiteMigrator - Creates a migrator for the embedded SQLite migrations. They are the same
+// in every build; syncFTSIndex moves the search index to the build's FTS module afterwards.
+func NewSQLiteMigrator(db *sql.DB) (*Migrator, error) {
+	return newEmbeddedMigrator(db, dialectSQLite, "sqlite")
+}
+
+// NewPostgresMigrator - Creates a migrator for the embedded PostgreSQL migrations.
+func NewPostgresMigrator(db *sql.DB) (*Migrator, error) {
+	return newEmbeddedMigrator(db, dialectPostgres, "postgres")
+}
+
+// newEmbeddedMigrator - Loads and merges the migrations in each embedded directory.
+func newEmbeddedMigrator(db *sql.DB, d dialect, dirs ...string) (*Migrator, error) {
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, dir := range dirs {
+		sub, err := fs.Sub(embeddedMigrations, path.Join("migrations", dir))
+		if err != nil {
+			return nil, err
+		}
+		loaded, err := LoadMigrations(sub)
+		if err != nil {
+			return nil, err
+		}
+		for _, migration := range loaded {
+			if other, ok := seen[migration.Version]; ok {
+				return nil, fmt.Errorf("migration %d is defined in both %s and %s", migration.Version, other, dir)
+			}
+			seen[migration.Version] = dir
+		}
+		migrations = append(migrations, loaded...)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{DB: db, Migrations: migrations, dialect: d}, nil
+}
+
+// Status - Lists every known migration along with whether it has been applied.
+// It fails if an applied migration was edited or is missing from this binary.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &record.appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending - Returns the migrations that Up would apply, in order.
+func (m *Migrator) Pending() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up - Applies all pending migrations, each in its own transaction.
+// With dryRun set nothing is executed and the pending migrations are returned.
+func (m *Migrator) Up(dryRun bool) ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	var done []Migration
+	for _, migration := range pending {
+		if err := m.apply(migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down - Rolls back the most recently applied migrations, newest first.
+// A steps value of 0 or less rolls back everything.
+func (m *Migrator) Down(steps int, dryRun bool) ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Migration
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		if steps > 0 && len(targets) == steps {
+			break
+		}
+		if statuses[i].Down == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down step", statuses[i].Version, statuses[i].Name)
+		}
+		targets = append(targets, statuses[i].Migration)
+	}
+	if dryRun {
+		return targets, nil
+	}
+
+	var done []Migration
+	for _, migration := range targets {
+		if err := m.revert(migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// applied - Loads schema_migrations and verifies it against the known migrations.
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		Version INTEGER PRIMARY KEY,
+		Name TEXT NOT NULL,
+		Checksum TEXT NOT NULL,
+		AppliedAt TIMESTAMP NOT NULL
+	)`
+	if _, err := m.DB.Exec(query); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.Query(`SELECT Version, Name, Checksum, AppliedAt FROM schema_migrations ORDER BY Version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = record
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]Migration, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		known[migration.Version] = migration
+	}
+	for version, record := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but unknown to this binary", version, record.name)
+		}
+		if migration.Checksum != record.checksum {
+			return nil, fmt.Errorf("migration %d_%s was modified after it was applied (checksum %s, expected %s)",
+				version, migration.Name, migration.Checksum, record.checksum)
+		}
+	}
+
+	return applied, nil
+}
+
+// apply - Runs an up step and records it in the same transaction.
+func (m *Migrator) apply(migration Migration) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(migration.Up); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	query := `INSERT INTO schema_migrations (Version, Name, Checksum, AppliedAt) VALUES (?, ?, ?, ?)`
+	if _, err := tx.Exec(m.dialect.rebind(query), migration.Version, migration.Name, migration.Checksum, time.Now().UTC()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revert - Runs a down step and removes its record in the same transaction.
+func (m *Migrator) revert(migration Migration) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(migration.Down); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.Exec(m.dialect.rebind(`DELETE FROM schema_migrations WHERE Version = ?`), migration.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,418 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_widgets.up.sql":       {Data: []byte(`CREATE TABLE widgets (Id INTEGER PRIMARY KEY);`)},
+		"0001_widgets.down.sql":     {Data: []byte(`DROP TABLE widgets;`)},
+		"0002_widget_name.up.sql":   {Data: []byte(`ALTER TABLE widgets ADD COLUMN Name TEXT;`)},
+		"0002_widget_name.down.sql": {Data: []byte(`ALTER TABLE widgets DROP COLUMN Name;`)},
+		"README.md":                 {Data: []byte(`ignored`)},
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := LoadMigrations(testMigrations())
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "widget_name" {
+		t.Fatalf("Unexpected migrations: %+v", migrations)
+	}
+
+	migrator := &Migrator{DB: db, Migrations: migrations}
+
+	pending, err := migrator.Up(true)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Expected 2 pending migrations in dry-run, got %d (%v)", len(pending), err)
+	}
+	if _, err := db.Exec(`SELECT 1 FROM widgets`); err == nil {
+		t.Fatalf("Dry-run should not create tables")
+	}
+
+	applied, err := migrator.Up(false)
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Expected 2 applied migrations, got %d (%v)", len(applied), err)
+	}
+	if _, err := db.Exec(`INSERT INTO widgets (Name) VALUES ('a')`); err != nil {
+		t.Fatalf("Expected migrated schema: %v", err)
+	}
+
+	applied, err = migrator.Up(false)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("Expected re-running Up to be a no-op, got %d (%v)", len(applied), err)
+	}
+
+	reverted, err := migrator.Down(1, false)
+	if err != nil || len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Fatalf("Expected to roll back version 2, got %+v (%v)", reverted, err)
+	}
+	if _, err := db.Exec(`INSERT INTO widgets (Name) VALUES ('a')`); err == nil {
+		t.Fatalf("Expected Name column to be dropped")
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("Unexpected status after rollback: %+v", statuses)
+	}
+}
+
+func TestMigratorChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	fsys := testMigrations()
+	migrations, _ := LoadMigrations(fsys)
+	if _, err := (&Migrator{DB: db, Migrations: migrations}).Up(false); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	fsys["0001_widgets.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE widgets (Id INTEGER PRIMARY KEY, Extra TEXT);`)}
+	edited, _ := LoadMigrations(fsys)
+	_, err := (&Migrator{DB: db, Migrations: edited}).Up(false)
+	if err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Fatalf("Expected checksum error, got %v", err)
+	}
+
+	_, err = (&Migrator{DB: db, Migrations: edited[:0]}).Up(false)
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("Expected unknown migration error, got %v", err)
+	}
+}
+
+func TestMigratorAdoptsLegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	// The schema setupTables used to create, with existing data.
+	legacy := []string{
+		`CREATE TABLE documents (Id INTEGER PRIMARY KEY AUTOINCREMENT, Name TEXT NOT NULL, LatestVersion INTEGER NOT NULL DEFAULT 1, CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE drafts (Id INTEGER PRIMARY KEY AUTOINCREMENT, DocumentId INTEGER NOT NULL, Content TEXT, VersionNumber INTEGER NOT NULL, CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO documents (Name) VALUES ('legacy')`,
+	}
+	for _, stmt := range legacy {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+
+	var name string
+	if err := db.QueryRow(`SELECT Name FROM documents`).Scan(&name); err != nil || name != "legacy" {
+		t.Errorf("Expected legacy data to survive, got %q (%v)", name, err)
+	}
+}
+
+func TestLoadMigrationsRejectsMissingUp(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{
+		"0001_orphan.down.sql": {Data: []byte(`DROP TABLE orphan;`)},
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for a migration without an up step")
+	}
+}
+
+func TestUniqueVersionsMigrationRepairsDuplicates(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	migrator.Migrations = migrator.Migrations[:1]
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply initial schema: %v", err)
+	}
+
+	// What racing CreateDraft calls used to leave behind.
+	damage := []string{
+		`INSERT INTO documents (Id, Name, LatestVersion) VALUES (1, 'raced', 2), (2, 'raced', 1)`,
+		`INSERT INTO drafts (Id, DocumentId, Content, VersionNumber) VALUES (1, 1, 'a', 1), (2, 2, 'b', 1), (3, 1, 'c', 2)`,
+	}
+	for _, stmt := range damage {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to seed duplicates: %v", err)
+		}
+	}
+
+	migrator, _ = NewSQLiteMigrator(db)
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply unique constraints: %v", err)
+	}
+
+	var documents, latest int
+	db.QueryRow(`SELECT COUNT(*), MAX(LatestVersion) FROM documents`).Scan(&documents, &latest)
+	if documents != 1 || latest != 3 {
+		t.Errorf("Expected one document at version 3, got %d documents at %d", documents, latest)
+	}
+
+	rows, err := db.Query(`SELECT Content, VersionNumber FROM drafts WHERE DocumentId = 1 ORDER BY VersionNumber`)
+	if err != nil {
+		t.Fatalf("Failed to read drafts: %v", err)
+	}
+	defer rows.Close()
+	var order []string
+	for rows.Next() {
+		var content string
+		var version int
+		rows.Scan(&content, &version)
+		order = append(order, fmt.Sprintf("%d:%s", version, content))
+	}
+	if strings.Join(order, ",") != "1:a,2:b,3:c" {
+		t.Errorf("Expected drafts renumbered in creation order, got %v", order)
+	}
+}
+
+func TestUniqueReactionsMigrationDropsRepeats(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	migrator.Migrations = migrator.Migrations[:18]
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply earlier migrations: %v", err)
+	}
+
+	// Reactions could be stacked before they were unique.
+	damage := []string{
+		`INSERT INTO documents (Id, Name, LatestVersion) VALUES (1, 'stacked', 1)`,
+		`INSERT INTO drafts (Id, DocumentId, Content, VersionNumber) VALUES (1, 1, 'a', 1)`,
+		`INSERT INTO comments (Id, DraftId, UserId, Text, ThreadId) VALUES (1, 1, 1, 'nice', 1)`,
+		`INSERT INTO reactions (Id, CommentId, UserId, Emoji) VALUES (1, 1, 1, '👍'), (2, 1, 1, '👍'), (3, 1, 2, '👍'), (4, 1, 1, '🎉'), (5, 1, 1, '👍')`,
+	}
+	for _, stmt := range damage {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to seed repeats: %v", err)
+		}
+	}
+
+	migrator, _ = NewSQLiteMigrator(db)
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply unique reactions: %v", err)
+	}
+	var ids []string
+	rows, err := db.Query(`SELECT Id FROM reactions ORDER BY Id`)
+	if err != nil {
+		t.Fatalf("Failed to read reactions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, fmt.Sprint(id))
+	}
+	if strings.Join(ids, ",") != "1,3,4" {
+		t.Errorf("Expected the first of each repeated reaction to be kept, got %v", ids)
+	}
+	if _, err := db.Exec(`INSERT INTO reactions (CommentId, UserId, Emoji) VALUES (1, 2, '👍')`); err == nil {
+		t.Errorf("Expected a repeated reaction to be rejected")
+	}
+}
+
+func TestFTSIndexIsTheSameInEveryBuild(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if migrator.Migrations[2].Version != 3 || migrator.Migrations[2].Checksum != "49677e058d52c45dd9343658f8771c203ea8cbaa1831db6c311e4512cd51ab01" {
+		t.Errorf("Expected migration 3 to have the same checksum in every build, got %+v", migrator.Migrations[2])
+	}
+
+	// An index built with another module is rebuilt with this build's one, keeping its content.
+	if _, err := db.Exec(`INSERT INTO documents (Id, Name, LatestVersion) VALUES (1, 'plan', 1)`); err != nil {
+		t.Fatalf("Failed to add a document: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO drafts (Id, DocumentId, Content, VersionNumber) VALUES (1, 1, 'quarterly roadmap', 1)`); err != nil {
+		t.Fatalf("Failed to add a draft: %v", err)
+	}
+	if _, err := db.Exec(migrator.Migrations[2].Down + `CREATE VIRTUAL TABLE drafts_fts USING fts3(Content);`); err != nil {
+		t.Fatalf("Failed to replace the index: %v", err)
+	}
+	if err := migrator.syncFTSIndex(sqliteFTSModule); err != nil {
+		t.Fatalf("Failed to sync the index: %v", err)
+	}
+	if module, _ := indexedFTSModule(db); module != sqliteFTSModule {
+		t.Errorf("Expected a %s index, got %q", sqliteFTSModule, module)
+	}
+	var matches int
+	db.QueryRow(`SELECT COUNT(*) FROM drafts_fts WHERE drafts_fts MATCH 'roadmap'`).Scan(&matches)
+	if matches != 1 {
+		t.Errorf("Expected the rebuilt index to find the draft, got %d matches", matches)
+	}
+}
+
+func TestEmbeddedMigrationsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if _, err := migrator.Down(0, false); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to migrate up again: %v", err)
+	}
+}
+
+func TestCommentResolutionMigrationBackfillsThreads(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	all := migrator.Migrations
+	for i, migration := range all {
+		if migration.Name == "comment_resolution" {
+			migrator.Migrations = all[:i]
+		}
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply earlier migrations: %v", err)
+	}
+
+	// 2 and 3 reply into the thread of 1; 4 replies to a comment that no longer exists.
+	seed := `INSERT INTO comments (Id, DraftId, UserId, Text, ParentCommentId) VALUES
+		(1, 1, 1, 'root', NULL), (2, 1, 1, 'reply', 1), (3, 1, 1, 'nested', 2), (4, 1, 1, 'stray', 99)`
+	if _, err := db.Exec(seed); err != nil {
+		t.Fatalf("Failed to seed comments: %v", err)
+	}
+
+	migrator.Migrations = all
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply comment resolution: %v", err)
+	}
+
+	want := map[int]int{1: 1, 2: 1, 3: 1, 4: 4}
+	for id, thread := range want {
+		var got int
+		if err := db.QueryRow(`SELECT ThreadId FROM comments WHERE Id = ?`, id).Scan(&got); err != nil || got != thread {
+			t.Errorf("Comment %d: expected thread %d, got %d (%v)", id, thread, got, err)
+		}
+	}
+}
+
+// migrateBefore - Applies the embedded migrations up to the one with the given name, returning
+// a migrator that applies the rest.
+func migrateBefore(t *testing.T, db *sql.DB, name string) *Migrator {
+	t.Helper()
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+	all := migrator.Migrations
+	for i, migration := range all {
+		if migration.Name == name {
+			migrator.Migrations = all[:i]
+		}
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply the migrations before %s: %v", name, err)
+	}
+	migrator.Migrations = all
+	return migrator
+}
+
+func TestUsersMigrationKeepsLegacyAuthors(t *testing.T) {
+	db := openTestDB(t)
+	migrator := migrateBefore(t, db, "users")
+
+	// Before accounts, comments and reactions carried whatever UserId the client sent.
+	seed := []string{
+		`INSERT INTO comments (Id, DraftId, UserId, Text, ThreadId) VALUES (1, 1, 3, 'first', 1), (2, 1, 7, 'second', 2)`,
+		`INSERT INTO reactions (Id, CommentId, UserId, Emoji) VALUES (1, 1, 7, '👍'), (2, 1, 12, '🎉')`,
+	}
+	for _, stmt := range seed {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply the remaining migrations: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT Id, Username FROM users ORDER BY Id`)
+	if err != nil {
+		t.Fatalf("Failed to read users: %v", err)
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var id int
+		var username string
+		rows.Scan(&id, &username)
+		users = append(users, fmt.Sprintf("%d:%s", id, username))
+	}
+	if strings.Join(users, ",") != "3:legacy-3,7:legacy-7,12:legacy-12" {
+		t.Errorf("Expected a placeholder for each legacy author, got %v", users)
+	}
+
+	var id int
+	if err := db.QueryRow(`INSERT INTO users (Username) VALUES ('alice') RETURNING Id`).Scan(&id); err != nil || id != 13 {
+		t.Errorf("Expected a new account after the legacy ones, got %d (%v)", id, err)
+	}
+}
+
+func TestPermissionsMigrationKeepsDocumentsVisible(t *testing.T) {
+	db := openTestDB(t)
+	migrator := migrateBefore(t, db, "users")
+
+	seed := []string{
+		`INSERT INTO documents (Id, Name, LatestVersion) VALUES (1, 'plan', 1), (2, 'notes', 1)`,
+		`INSERT INTO drafts (Id, DocumentId, Content, VersionNumber) VALUES (1, 1, 'a', 1), (2, 2, 'b', 1)`,
+		`INSERT INTO comments (Id, DraftId, UserId, Text, ThreadId) VALUES (1, 1, 3, 'first', 1), (2, 2, 7, 'second', 2)`,
+	}
+	for _, stmt := range seed {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(false); err != nil {
+		t.Fatalf("Failed to apply the remaining migrations: %v", err)
+	}
+
+	store := &SQLite{sqlStore{DB: db}}
+	for _, documentId := range []int{1, 2} {
+		for userId, role := range map[int]Role{3: RoleOwner, 7: RoleEditor} {
+			if got, err := store.GetDocumentRole(documentId, userId); err != nil || got != role {
+				t.Errorf("Document %d: expected user %d to be %s, got %q (%v)", documentId, userId, role, got, err)
+			}
+		}
+	}
+	scope := Scope{WorkspaceId: DefaultWorkspaceId, UserId: 7}
+	if documents, err := store.GetAllDocumentsLatestVersions(scope); err != nil || len(documents) != 2 {
+		t.Errorf("Expected both existing documents to be listed, got %+v (%v)", documents, err)
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS drafts;
+DROP TABLE IF EXISTS documents;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS documents (
+	Id SERIAL PRIMARY KEY,
+	Name TEXT NOT NULL,
+	LatestVersion INTEGER NOT NULL DEFAULT 1,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS drafts (
+	Id SERIAL PRIMARY KEY,
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	Content TEXT,
+	VersionNumber INTEGER NOT NULL,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	Id SERIAL PRIMARY KEY,
+	DraftId INTEGER NOT NULL REFERENCES drafts(Id),
+	UserId INTEGER NOT NULL,
+	Text TEXT NOT NULL,
+	ParentCommentId INTEGER REFERENCES comments(Id),
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+	Id SERIAL PRIMARY KEY,
+	CommentId INTEGER NOT NULL REFERENCES comments(Id),
+	UserId INTEGER NOT NULL,
+	Emoji TEXT NOT NULL,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS drafts_content_search;
+ALTER TABLE drafts DROP COLUMN IF EXISTS ContentSearch;
@@ -0,0 +1,5 @@
+-- The 'simple' configuration lower-cases without stemming, like SQLite's unicode61.
+ALTER TABLE drafts ADD COLUMN ContentSearch tsvector
+	GENERATED ALWAYS AS (to_tsvector('simple', coalesce(Content, ''))) STORED;
+
+CREATE INDEX drafts_content_search ON drafts USING GIN (ContentSearch);
@@ -0,0 +1,14 @@
+-- The anchor of a comment in each draft, from the one it was made on to the latest.
+CREATE TABLE comment_anchors (
+	CommentId INTEGER NOT NULL REFERENCES comments(Id),
+	DraftId INTEGER NOT NULL REFERENCES drafts(Id),
+	StartOffset INTEGER NOT NULL,
+	EndOffset INTEGER NOT NULL,
+	StartLine INTEGER NOT NULL,
+	EndLine INTEGER NOT NULL,
+	Quote TEXT NOT NULL,
+	Orphaned BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (CommentId, DraftId)
+);
+
+CREATE INDEX comment_anchors_draft ON comment_anchors (DraftId);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS comment_carryovers;
+DROP INDEX IF EXISTS comments_thread;
+ALTER TABLE comments DROP COLUMN IF EXISTS ResolvedAt;
+ALTER TABLE comments DROP COLUMN IF EXISTS ResolvedBy;
+ALTER TABLE comments DROP COLUMN IF EXISTS Resolved;
+ALTER TABLE comments DROP COLUMN IF EXISTS ThreadId;
@@ -0,0 +1,26 @@
+-- Resolution is tracked per thread. ThreadId is the Id of the thread's top-level comment.
+ALTER TABLE comments ADD COLUMN ThreadId INTEGER;
+ALTER TABLE comments ADD COLUMN Resolved BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE comments ADD COLUMN ResolvedBy INTEGER;
+ALTER TABLE comments ADD COLUMN ResolvedAt TIMESTAMPTZ;
+
+WITH RECURSIVE threads (Id, ThreadId) AS (
+	SELECT Id, Id FROM comments WHERE ParentCommentId IS NULL
+	UNION
+	SELECT c.Id, t.ThreadId FROM comments c JOIN threads t ON c.ParentCommentId = t.Id
+)
+UPDATE comments SET ThreadId = threads.ThreadId FROM threads WHERE threads.Id = comments.Id;
+
+-- Replies whose parent no longer exists start their own thread.
+UPDATE comments SET ThreadId = Id WHERE ThreadId IS NULL;
+
+CREATE INDEX comments_thread ON comments (ThreadId);
+
+-- Unresolved comments carried forward onto later drafts of the document as open items.
+CREATE TABLE comment_carryovers (
+	CommentId INTEGER NOT NULL REFERENCES comments(Id),
+	DraftId INTEGER NOT NULL REFERENCES drafts(Id),
+	PRIMARY KEY (CommentId, DraftId)
+);
+
+CREATE INDEX comment_carryovers_draft ON comment_carryovers (DraftId);
@@ -0,0 +1,30 @@
+-- Accounts that comments and reactions are made as. Usernames are stored lowercase.
+CREATE TABLE users (
+	Id SERIAL PRIMARY KEY,
+	Username TEXT NOT NULL UNIQUE,
+	DisplayName TEXT NOT NULL DEFAULT '',
+	Email TEXT NOT NULL DEFAULT '',
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Only a SHA-256 hash of each key is kept; Prefix identifies it in listings.
+CREATE TABLE api_keys (
+	Id SERIAL PRIMARY KEY,
+	UserId INTEGER NOT NULL REFERENCES users(Id),
+	Name TEXT NOT NULL DEFAULT '',
+	Prefix TEXT NOT NULL,
+	KeyHash TEXT NOT NULL UNIQUE,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	RevokedAt TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_user ON api_keys (UserId);
+
+-- Comments and reactions were made as whatever UserId clients sent. Each of those Ids gets a
+-- placeholder account, so accounts created later never take over someone else's comments.
+INSERT INTO users (Id, Username)
+SELECT UserId, 'legacy-' || UserId FROM comments WHERE UserId > 0
+UNION
+SELECT UserId, 'legacy-' || UserId FROM reactions WHERE UserId > 0;
+
+SELECT setval(pg_get_serial_sequence('users', 'id'), (SELECT COALESCE(MAX(Id), 0) + 1 FROM users), false);
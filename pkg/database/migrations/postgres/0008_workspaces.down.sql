@@ -0,0 +1,10 @@
+ALTER TABLE user_groups DROP CONSTRAINT IF EXISTS user_groups_workspace_name_key;
+ALTER TABLE user_groups DROP COLUMN IF EXISTS WorkspaceId;
+ALTER TABLE user_groups ADD CONSTRAINT user_groups_name_key UNIQUE (Name);
+
+DROP INDEX IF EXISTS documents_workspace_name_unique;
+ALTER TABLE documents DROP COLUMN IF EXISTS WorkspaceId;
+CREATE UNIQUE INDEX IF NOT EXISTS documents_name_unique ON documents (Name);
+
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
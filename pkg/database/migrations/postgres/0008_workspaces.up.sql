@@ -0,0 +1,33 @@
+-- Workspaces isolate tenants: documents, groups and the users who can see them all belong
+-- to one. Everything that existed before goes into the Default workspace, and every
+-- existing user joins it, the first one as its admin.
+CREATE TABLE workspaces (
+	Id SERIAL PRIMARY KEY,
+	Name TEXT NOT NULL,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE workspace_members (
+	WorkspaceId INTEGER NOT NULL REFERENCES workspaces(Id),
+	UserId INTEGER NOT NULL REFERENCES users(Id),
+	Role TEXT NOT NULL CHECK (Role IN ('member', 'admin')),
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (WorkspaceId, UserId)
+);
+
+CREATE INDEX workspace_members_user ON workspace_members (UserId);
+
+INSERT INTO workspaces (Id, Name) VALUES (1, 'Default');
+SELECT setval(pg_get_serial_sequence('workspaces', 'id'), 1);
+
+INSERT INTO workspace_members (WorkspaceId, UserId, Role)
+SELECT 1, Id, CASE WHEN Id = (SELECT MIN(Id) FROM users) THEN 'admin' ELSE 'member' END FROM users;
+
+ALTER TABLE documents ADD COLUMN WorkspaceId INTEGER NOT NULL DEFAULT 1 REFERENCES workspaces(Id);
+DROP INDEX documents_name_unique;
+CREATE UNIQUE INDEX documents_workspace_name_unique ON documents (WorkspaceId, Name);
+
+-- Group names become unique per workspace.
+ALTER TABLE user_groups ADD COLUMN WorkspaceId INTEGER NOT NULL DEFAULT 1 REFERENCES workspaces(Id);
+ALTER TABLE user_groups DROP CONSTRAINT user_groups_name_key;
+ALTER TABLE user_groups ADD CONSTRAINT user_groups_workspace_name_key UNIQUE (WorkspaceId, Name);
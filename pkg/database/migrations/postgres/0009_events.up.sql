@@ -0,0 +1,14 @@
+-- Every change published on the event bus, kept so subscribers can resume after the last
+-- event they saw. Data is the event's JSON payload.
+CREATE TABLE events (
+	Id BIGSERIAL PRIMARY KEY,
+	WorkspaceId INTEGER NOT NULL REFERENCES workspaces(Id),
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	DraftId INTEGER NOT NULL,
+	Type TEXT NOT NULL,
+	Data TEXT NOT NULL,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX events_workspace ON events (WorkspaceId, Id);
+CREATE INDEX events_document ON events (DocumentId, Id);
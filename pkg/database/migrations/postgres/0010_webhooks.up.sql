@@ -0,0 +1,34 @@
+-- Webhooks post events to other systems. EventTypes is a comma separated list wrapped in
+-- commas (',draft.created,') so one type can be matched with LIKE, or empty for every type;
+-- DocumentId 0 means every document in the workspace.
+CREATE TABLE webhooks (
+	Id SERIAL PRIMARY KEY,
+	WorkspaceId INTEGER NOT NULL REFERENCES workspaces(Id),
+	DocumentId INTEGER NOT NULL DEFAULT 0,
+	Url TEXT NOT NULL,
+	Secret TEXT NOT NULL,
+	EventTypes TEXT NOT NULL DEFAULT '',
+	CreatedBy INTEGER NOT NULL REFERENCES users(Id),
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhooks_workspace ON webhooks (WorkspaceId);
+
+-- The outbox: one row per event and webhook, written in the transaction that records the
+-- event and worked through by the delivery workers.
+CREATE TABLE webhook_deliveries (
+	Id BIGSERIAL PRIMARY KEY,
+	WebhookId INTEGER NOT NULL REFERENCES webhooks(Id) ON DELETE CASCADE,
+	EventId BIGINT NOT NULL REFERENCES events(Id),
+	Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'failed')),
+	Attempts INTEGER NOT NULL DEFAULT 0,
+	ResponseStatus INTEGER NOT NULL DEFAULT 0,
+	LastError TEXT NOT NULL DEFAULT '',
+	NextAttemptAt TIMESTAMPTZ NOT NULL,
+	LastAttemptAt TIMESTAMPTZ,
+	DeliveredAt TIMESTAMPTZ,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (Status, NextAttemptAt);
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (WebhookId, Id);
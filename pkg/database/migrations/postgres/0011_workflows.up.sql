@@ -0,0 +1,34 @@
+-- Review workflows. Documents without a row use the default workflow in its initial state;
+-- an empty Config is the default workflow, anything else its JSON.
+CREATE TABLE document_workflows (
+	DocumentId INTEGER PRIMARY KEY REFERENCES documents(Id),
+	Config TEXT NOT NULL DEFAULT '',
+	State TEXT NOT NULL,
+	UpdatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Approvals of one version. A newer draft sets InvalidatedAt on the approvals of older ones.
+CREATE TABLE document_approvals (
+	Id SERIAL PRIMARY KEY,
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	DraftId INTEGER NOT NULL REFERENCES drafts(Id),
+	VersionNumber INTEGER NOT NULL,
+	UserId INTEGER NOT NULL REFERENCES users(Id),
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	InvalidatedAt TIMESTAMPTZ,
+	UNIQUE (DocumentId, VersionNumber, UserId)
+);
+
+-- The audit trail of state changes. UserId 0 marks changes the service made itself.
+CREATE TABLE document_transitions (
+	Id SERIAL PRIMARY KEY,
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	FromState TEXT NOT NULL,
+	ToState TEXT NOT NULL,
+	VersionNumber INTEGER NOT NULL,
+	UserId INTEGER NOT NULL DEFAULT 0,
+	Reason TEXT NOT NULL DEFAULT '',
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX document_transitions_document ON document_transitions (DocumentId, Id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS documents_scheduled;
+ALTER TABLE documents DROP COLUMN IF EXISTS ScheduledBy;
+ALTER TABLE documents DROP COLUMN IF EXISTS ScheduledAt;
+ALTER TABLE documents DROP COLUMN IF EXISTS ScheduledVersion;
+ALTER TABLE documents DROP COLUMN IF EXISTS PublishedBy;
+ALTER TABLE documents DROP COLUMN IF EXISTS PublishedAt;
+ALTER TABLE documents DROP COLUMN IF EXISTS PublishedVersion;
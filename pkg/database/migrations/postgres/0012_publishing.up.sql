@@ -0,0 +1,13 @@
+-- Readers see a document's published version rather than its latest draft. A publication can
+-- also be scheduled for a later time.
+ALTER TABLE documents ADD COLUMN PublishedVersion INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN PublishedAt TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN PublishedBy INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN ScheduledVersion INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN ScheduledAt TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN ScheduledBy INTEGER NOT NULL DEFAULT 0;
+
+-- Existing documents keep showing what they showed before.
+UPDATE documents SET PublishedVersion = LatestVersion, PublishedAt = CURRENT_TIMESTAMP;
+
+CREATE INDEX documents_scheduled ON documents (ScheduledAt) WHERE ScheduledVersion > 0;
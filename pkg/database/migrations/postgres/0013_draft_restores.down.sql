@@ -0,0 +1 @@
+ALTER TABLE drafts DROP COLUMN IF EXISTS RestoredFromVersion;
@@ -0,0 +1,26 @@
+-- Named branches of a document, forked from one of its versions. A branch numbers its own
+-- drafts from 1; MergedHeadVersion is the branch version last merged back, 0 if never merged.
+CREATE TABLE branches (
+	Id SERIAL PRIMARY KEY,
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	Name TEXT NOT NULL,
+	BaseVersion INTEGER NOT NULL,
+	HeadVersion INTEGER NOT NULL DEFAULT 0,
+	CreatedBy INTEGER NOT NULL DEFAULT 0,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	MergedVersion INTEGER NOT NULL DEFAULT 0,
+	MergedHeadVersion INTEGER NOT NULL DEFAULT 0,
+	MergedBy INTEGER NOT NULL DEFAULT 0,
+	MergedAt TIMESTAMPTZ,
+	UNIQUE (DocumentId, Name)
+);
+
+CREATE TABLE branch_drafts (
+	Id SERIAL PRIMARY KEY,
+	BranchId INTEGER NOT NULL REFERENCES branches(Id),
+	Content TEXT NOT NULL,
+	VersionNumber INTEGER NOT NULL,
+	CreatedBy INTEGER NOT NULL DEFAULT 0,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (BranchId, VersionNumber)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionDraftId;
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionDecidedAt;
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionDecidedBy;
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionStatus;
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionQuote;
+ALTER TABLE comments DROP COLUMN IF EXISTS SuggestionText;
@@ -0,0 +1,8 @@
+-- Suggestion comments propose replacing their anchored text. SuggestionText is NULL on ordinary
+-- comments; SuggestionDraftId is the draft that applied an accepted suggestion.
+ALTER TABLE comments ADD COLUMN SuggestionText TEXT;
+ALTER TABLE comments ADD COLUMN SuggestionQuote TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN SuggestionStatus TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN SuggestionDecidedBy INTEGER;
+ALTER TABLE comments ADD COLUMN SuggestionDecidedAt TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN SuggestionDraftId INTEGER;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS drafts_author;
+ALTER TABLE drafts DROP COLUMN IF EXISTS Metadata;
+ALTER TABLE drafts DROP COLUMN IF EXISTS Summary;
+ALTER TABLE drafts DROP COLUMN IF EXISTS AuthorId;
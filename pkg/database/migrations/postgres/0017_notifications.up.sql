@@ -0,0 +1,27 @@
+-- Users watching a document hear of its new drafts.
+CREATE TABLE document_watchers (
+	DocumentId INTEGER NOT NULL REFERENCES documents(Id),
+	UserId INTEGER NOT NULL REFERENCES users(Id),
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (DocumentId, UserId)
+);
+
+-- Each user's inbox: at most one notification per event, for the strongest reason it concerns
+-- them. CommentId is 0 for a new draft; ReadAt is NULL until it is read.
+CREATE TABLE notifications (
+	Id BIGSERIAL PRIMARY KEY,
+	UserId INTEGER NOT NULL REFERENCES users(Id),
+	WorkspaceId INTEGER NOT NULL,
+	EventId BIGINT NOT NULL REFERENCES events(Id),
+	Reason TEXT NOT NULL,
+	DocumentId INTEGER NOT NULL,
+	DraftId INTEGER NOT NULL,
+	CommentId INTEGER NOT NULL DEFAULT 0,
+	ActorId INTEGER NOT NULL DEFAULT 0,
+	Excerpt TEXT NOT NULL DEFAULT '',
+	ReadAt TIMESTAMPTZ,
+	CreatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (UserId, EventId)
+);
+
+CREATE INDEX notifications_inbox ON notifications (UserId, WorkspaceId, Id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS notification_settings;
+ALTER TABLE notifications DROP COLUMN IF EXISTS EmailedAt;
@@ -0,0 +1,9 @@
+-- Notifications are emailed in digests. EmailedAt is NULL until a digest includes the
+-- notification; users without settings get daily digests.
+ALTER TABLE notifications ADD COLUMN EmailedAt TIMESTAMPTZ;
+
+CREATE TABLE notification_settings (
+	UserId INTEGER PRIMARY KEY REFERENCES users(Id),
+	DigestFrequency TEXT NOT NULL DEFAULT 'daily',
+	LastDigestAt TIMESTAMPTZ
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS reactions_comment_user_emoji_unique;
@@ -0,0 +1,37 @@
+-- Tables created by the original setupTables. IF NOT EXISTS lets databases
+-- created before migrations existed adopt this version without changes.
+CREATE TABLE IF NOT EXISTS documents (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	Name TEXT NOT NULL,
+	LatestVersion INTEGER NOT NULL DEFAULT 1,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS drafts (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	DocumentId INTEGER NOT NULL,
+	Content TEXT,
+	VersionNumber INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	DraftId INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	Text TEXT NOT NULL,
+	ParentCommentId INTEGER,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (DraftId) REFERENCES drafts(Id),
+	FOREIGN KEY (ParentCommentId) REFERENCES comments(Id)
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	CommentId INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	Emoji TEXT NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (CommentId) REFERENCES comments(Id)
+);
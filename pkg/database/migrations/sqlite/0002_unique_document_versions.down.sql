@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS drafts_document_version_unique;
+DROP INDEX IF EXISTS documents_name_unique;
@@ -0,0 +1,24 @@
+-- Databases written before CreateDraft was transactional can hold duplicate
+-- documents for one name and repeated version numbers. Fold duplicates into
+-- the oldest document and renumber each document's drafts in creation order
+-- before adding the constraints.
+UPDATE drafts SET DocumentId = (
+	SELECT MIN(d2.Id) FROM documents d2
+	WHERE d2.Name = (SELECT d1.Name FROM documents d1 WHERE d1.Id = drafts.DocumentId)
+)
+WHERE DocumentId IN (SELECT Id FROM documents);
+
+DELETE FROM documents WHERE Id NOT IN (SELECT MIN(Id) FROM documents GROUP BY Name);
+
+UPDATE drafts SET VersionNumber = (
+	SELECT COUNT(*) FROM drafts d2
+	WHERE d2.DocumentId = drafts.DocumentId AND d2.Id <= drafts.Id
+);
+
+UPDATE documents SET LatestVersion = (
+	SELECT MAX(VersionNumber) FROM drafts WHERE drafts.DocumentId = documents.Id
+)
+WHERE EXISTS (SELECT 1 FROM drafts WHERE drafts.DocumentId = documents.Id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS documents_name_unique ON documents (Name);
+CREATE UNIQUE INDEX IF NOT EXISTS drafts_document_version_unique ON drafts (DocumentId, VersionNumber);
@@ -0,0 +1,7 @@
+-- Drops the index whichever module it was built with.
+DROP TRIGGER IF EXISTS drafts_fts_update;
+DROP TRIGGER IF EXISTS drafts_fts_update_after;
+DROP TRIGGER IF EXISTS drafts_fts_update_before;
+DROP TRIGGER IF EXISTS drafts_fts_delete;
+DROP TRIGGER IF EXISTS drafts_fts_insert;
+DROP TABLE IF EXISTS drafts_fts;
@@ -0,0 +1,24 @@
+-- External content index over drafts.Content, kept in sync by triggers.
+CREATE VIRTUAL TABLE drafts_fts USING fts4(
+	content="drafts",
+	Content,
+	tokenize=unicode61
+);
+
+CREATE TRIGGER drafts_fts_insert AFTER INSERT ON drafts BEGIN
+	INSERT INTO drafts_fts (docid, Content) VALUES (new.Id, new.Content);
+END;
+
+CREATE TRIGGER drafts_fts_delete BEFORE DELETE ON drafts BEGIN
+	DELETE FROM drafts_fts WHERE docid = old.Id;
+END;
+
+CREATE TRIGGER drafts_fts_update_before BEFORE UPDATE OF Content ON drafts BEGIN
+	DELETE FROM drafts_fts WHERE docid = old.Id;
+END;
+
+CREATE TRIGGER drafts_fts_update_after AFTER UPDATE OF Content ON drafts BEGIN
+	INSERT INTO drafts_fts (docid, Content) VALUES (new.Id, new.Content);
+END;
+
+INSERT INTO drafts_fts (drafts_fts) VALUES ('rebuild');
@@ -0,0 +1,16 @@
+-- The anchor of a comment in each draft, from the one it was made on to the latest.
+CREATE TABLE comment_anchors (
+	CommentId INTEGER NOT NULL,
+	DraftId INTEGER NOT NULL,
+	StartOffset INTEGER NOT NULL,
+	EndOffset INTEGER NOT NULL,
+	StartLine INTEGER NOT NULL,
+	EndLine INTEGER NOT NULL,
+	Quote TEXT NOT NULL,
+	Orphaned BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (CommentId, DraftId),
+	FOREIGN KEY (CommentId) REFERENCES comments(Id),
+	FOREIGN KEY (DraftId) REFERENCES drafts(Id)
+);
+
+CREATE INDEX comment_anchors_draft ON comment_anchors (DraftId);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS comment_carryovers;
+DROP INDEX IF EXISTS comments_thread;
+ALTER TABLE comments DROP COLUMN ResolvedAt;
+ALTER TABLE comments DROP COLUMN ResolvedBy;
+ALTER TABLE comments DROP COLUMN Resolved;
+ALTER TABLE comments DROP COLUMN ThreadId;
@@ -0,0 +1,29 @@
+-- Accounts that comments and reactions are made as. Usernames are stored lowercase.
+CREATE TABLE users (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	Username TEXT NOT NULL UNIQUE,
+	DisplayName TEXT NOT NULL DEFAULT '',
+	Email TEXT NOT NULL DEFAULT '',
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Only a SHA-256 hash of each key is kept; Prefix identifies it in listings.
+CREATE TABLE api_keys (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	UserId INTEGER NOT NULL,
+	Name TEXT NOT NULL DEFAULT '',
+	Prefix TEXT NOT NULL,
+	KeyHash TEXT NOT NULL UNIQUE,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	RevokedAt DATETIME,
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
+
+CREATE INDEX api_keys_user ON api_keys (UserId);
+
+-- Comments and reactions were made as whatever UserId clients sent. Each of those Ids gets a
+-- placeholder account, so accounts created later never take over someone else's comments.
+INSERT INTO users (Id, Username)
+SELECT UserId, 'legacy-' || UserId FROM comments WHERE UserId > 0
+UNION
+SELECT UserId, 'legacy-' || UserId FROM reactions WHERE UserId > 0;
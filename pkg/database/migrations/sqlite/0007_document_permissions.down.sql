@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS document_permissions;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS user_groups;
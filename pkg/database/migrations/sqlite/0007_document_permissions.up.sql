@@ -0,0 +1,37 @@
+-- Groups of users that documents can be shared with.
+CREATE TABLE user_groups (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	Name TEXT NOT NULL UNIQUE,
+	OwnerId INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (OwnerId) REFERENCES users(Id)
+);
+
+CREATE TABLE group_members (
+	GroupId INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	PRIMARY KEY (GroupId, UserId),
+	FOREIGN KEY (GroupId) REFERENCES user_groups(Id),
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
+
+CREATE INDEX group_members_user ON group_members (UserId);
+
+-- Roles on documents held by users or groups.
+CREATE TABLE document_permissions (
+	DocumentId INTEGER NOT NULL,
+	PrincipalType TEXT NOT NULL CHECK (PrincipalType IN ('user', 'group')),
+	PrincipalId INTEGER NOT NULL,
+	Role TEXT NOT NULL CHECK (Role IN ('viewer', 'commenter', 'editor', 'owner')),
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (DocumentId, PrincipalType, PrincipalId),
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+CREATE INDEX document_permissions_principal ON document_permissions (PrincipalType, PrincipalId);
+
+-- Everyone could edit every document before, so existing documents keep that for existing
+-- users, with the first one as the owner each document needs.
+INSERT INTO document_permissions (DocumentId, PrincipalType, PrincipalId, Role)
+SELECT d.Id, 'user', u.Id, CASE WHEN u.Id = (SELECT MIN(Id) FROM users) THEN 'owner' ELSE 'editor' END
+FROM documents d CROSS JOIN users u;
@@ -0,0 +1,18 @@
+CREATE TABLE user_groups_old (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	Name TEXT NOT NULL UNIQUE,
+	OwnerId INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (OwnerId) REFERENCES users(Id)
+);
+INSERT INTO user_groups_old (Id, Name, OwnerId, CreatedAt)
+SELECT Id, Name, OwnerId, CreatedAt FROM user_groups;
+DROP TABLE user_groups;
+ALTER TABLE user_groups_old RENAME TO user_groups;
+
+DROP INDEX IF EXISTS documents_workspace_name_unique;
+ALTER TABLE documents DROP COLUMN WorkspaceId;
+CREATE UNIQUE INDEX IF NOT EXISTS documents_name_unique ON documents (Name);
+
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
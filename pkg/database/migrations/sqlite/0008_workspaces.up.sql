@@ -0,0 +1,46 @@
+-- Workspaces isolate tenants: documents, groups and the users who can see them all belong
+-- to one. Everything that existed before goes into the Default workspace, and every
+-- existing user joins it, the first one as its admin.
+CREATE TABLE workspaces (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	Name TEXT NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE workspace_members (
+	WorkspaceId INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	Role TEXT NOT NULL CHECK (Role IN ('member', 'admin')),
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (WorkspaceId, UserId),
+	FOREIGN KEY (WorkspaceId) REFERENCES workspaces(Id),
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
+
+CREATE INDEX workspace_members_user ON workspace_members (UserId);
+
+INSERT INTO workspaces (Id, Name) VALUES (1, 'Default');
+
+INSERT INTO workspace_members (WorkspaceId, UserId, Role)
+SELECT 1, Id, CASE WHEN Id = (SELECT MIN(Id) FROM users) THEN 'admin' ELSE 'member' END FROM users;
+
+-- SQLite cannot add a column with both a REFERENCES clause and a non-null default.
+ALTER TABLE documents ADD COLUMN WorkspaceId INTEGER NOT NULL DEFAULT 1;
+DROP INDEX documents_name_unique;
+CREATE UNIQUE INDEX documents_workspace_name_unique ON documents (WorkspaceId, Name);
+
+-- Group names become unique per workspace, which needs the table rebuilt.
+CREATE TABLE user_groups_new (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorkspaceId INTEGER NOT NULL,
+	Name TEXT NOT NULL,
+	OwnerId INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (WorkspaceId, Name),
+	FOREIGN KEY (WorkspaceId) REFERENCES workspaces(Id),
+	FOREIGN KEY (OwnerId) REFERENCES users(Id)
+);
+INSERT INTO user_groups_new (Id, WorkspaceId, Name, OwnerId, CreatedAt)
+SELECT Id, 1, Name, OwnerId, CreatedAt FROM user_groups;
+DROP TABLE user_groups;
+ALTER TABLE user_groups_new RENAME TO user_groups;
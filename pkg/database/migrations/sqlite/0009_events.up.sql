@@ -0,0 +1,16 @@
+-- Every change published on the event bus, kept so subscribers can resume after the last
+-- event they saw. Data is the event's JSON payload.
+CREATE TABLE events (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorkspaceId INTEGER NOT NULL,
+	DocumentId INTEGER NOT NULL,
+	DraftId INTEGER NOT NULL,
+	Type TEXT NOT NULL,
+	Data TEXT NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (WorkspaceId) REFERENCES workspaces(Id),
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+CREATE INDEX events_workspace ON events (WorkspaceId, Id);
+CREATE INDEX events_document ON events (DocumentId, Id);
@@ -0,0 +1,38 @@
+-- Webhooks post events to other systems. EventTypes is a comma separated list wrapped in
+-- commas (',draft.created,') so one type can be matched with LIKE, or empty for every type;
+-- DocumentId 0 means every document in the workspace.
+CREATE TABLE webhooks (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorkspaceId INTEGER NOT NULL,
+	DocumentId INTEGER NOT NULL DEFAULT 0,
+	Url TEXT NOT NULL,
+	Secret TEXT NOT NULL,
+	EventTypes TEXT NOT NULL DEFAULT '',
+	CreatedBy INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (WorkspaceId) REFERENCES workspaces(Id),
+	FOREIGN KEY (CreatedBy) REFERENCES users(Id)
+);
+
+CREATE INDEX webhooks_workspace ON webhooks (WorkspaceId);
+
+-- The outbox: one row per event and webhook, written in the transaction that records the
+-- event and worked through by the delivery workers.
+CREATE TABLE webhook_deliveries (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	WebhookId INTEGER NOT NULL,
+	EventId INTEGER NOT NULL,
+	Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'failed')),
+	Attempts INTEGER NOT NULL DEFAULT 0,
+	ResponseStatus INTEGER NOT NULL DEFAULT 0,
+	LastError TEXT NOT NULL DEFAULT '',
+	NextAttemptAt DATETIME NOT NULL,
+	LastAttemptAt DATETIME,
+	DeliveredAt DATETIME,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (WebhookId) REFERENCES webhooks(Id) ON DELETE CASCADE,
+	FOREIGN KEY (EventId) REFERENCES events(Id)
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (Status, NextAttemptAt);
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (WebhookId, Id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS document_transitions;
+DROP TABLE IF EXISTS document_approvals;
+DROP TABLE IF EXISTS document_workflows;
@@ -0,0 +1,39 @@
+-- Review workflows. Documents without a row use the default workflow in its initial state;
+-- an empty Config is the default workflow, anything else its JSON.
+CREATE TABLE document_workflows (
+	DocumentId INTEGER PRIMARY KEY,
+	Config TEXT NOT NULL DEFAULT '',
+	State TEXT NOT NULL,
+	UpdatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+-- Approvals of one version. A newer draft sets InvalidatedAt on the approvals of older ones.
+CREATE TABLE document_approvals (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	DocumentId INTEGER NOT NULL,
+	DraftId INTEGER NOT NULL,
+	VersionNumber INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	InvalidatedAt DATETIME,
+	UNIQUE (DocumentId, VersionNumber, UserId),
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id),
+	FOREIGN KEY (DraftId) REFERENCES drafts(Id),
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
+
+-- The audit trail of state changes. UserId 0 marks changes the service made itself.
+CREATE TABLE document_transitions (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	DocumentId INTEGER NOT NULL,
+	FromState TEXT NOT NULL,
+	ToState TEXT NOT NULL,
+	VersionNumber INTEGER NOT NULL,
+	UserId INTEGER NOT NULL DEFAULT 0,
+	Reason TEXT NOT NULL DEFAULT '',
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+CREATE INDEX document_transitions_document ON document_transitions (DocumentId, Id);
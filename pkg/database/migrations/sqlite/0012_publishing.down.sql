@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS documents_scheduled;
+ALTER TABLE documents DROP COLUMN ScheduledBy;
+ALTER TABLE documents DROP COLUMN ScheduledAt;
+ALTER TABLE documents DROP COLUMN ScheduledVersion;
+ALTER TABLE documents DROP COLUMN PublishedBy;
+ALTER TABLE documents DROP COLUMN PublishedAt;
+ALTER TABLE documents DROP COLUMN PublishedVersion;
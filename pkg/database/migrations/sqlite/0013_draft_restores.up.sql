@@ -0,0 +1,2 @@
+-- A draft restored from an earlier version records which one it copies.
+ALTER TABLE drafts ADD COLUMN RestoredFromVersion INTEGER NOT NULL DEFAULT 0;
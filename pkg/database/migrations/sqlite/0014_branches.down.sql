@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS branch_drafts;
+DROP TABLE IF EXISTS branches;
@@ -0,0 +1,28 @@
+-- Named branches of a document, forked from one of its versions. A branch numbers its own
+-- drafts from 1; MergedHeadVersion is the branch version last merged back, 0 if never merged.
+CREATE TABLE branches (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	DocumentId INTEGER NOT NULL,
+	Name TEXT NOT NULL,
+	BaseVersion INTEGER NOT NULL,
+	HeadVersion INTEGER NOT NULL DEFAULT 0,
+	CreatedBy INTEGER NOT NULL DEFAULT 0,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	MergedVersion INTEGER NOT NULL DEFAULT 0,
+	MergedHeadVersion INTEGER NOT NULL DEFAULT 0,
+	MergedBy INTEGER NOT NULL DEFAULT 0,
+	MergedAt DATETIME,
+	UNIQUE (DocumentId, Name),
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id)
+);
+
+CREATE TABLE branch_drafts (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	BranchId INTEGER NOT NULL,
+	Content TEXT NOT NULL,
+	VersionNumber INTEGER NOT NULL,
+	CreatedBy INTEGER NOT NULL DEFAULT 0,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (BranchId, VersionNumber),
+	FOREIGN KEY (BranchId) REFERENCES branches(Id)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE comments DROP COLUMN SuggestionDraftId;
+ALTER TABLE comments DROP COLUMN SuggestionDecidedAt;
+ALTER TABLE comments DROP COLUMN SuggestionDecidedBy;
+ALTER TABLE comments DROP COLUMN SuggestionStatus;
+ALTER TABLE comments DROP COLUMN SuggestionQuote;
+ALTER TABLE comments DROP COLUMN SuggestionText;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS drafts_author;
+ALTER TABLE drafts DROP COLUMN Metadata;
+ALTER TABLE drafts DROP COLUMN Summary;
+ALTER TABLE drafts DROP COLUMN AuthorId;
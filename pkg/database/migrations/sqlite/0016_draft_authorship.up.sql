@@ -0,0 +1,7 @@
+-- Who wrote each draft and why. AuthorId is 0 for drafts written before authors were recorded;
+-- Metadata is a JSON object of strings, or empty.
+ALTER TABLE drafts ADD COLUMN AuthorId INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE drafts ADD COLUMN Summary TEXT NOT NULL DEFAULT '';
+ALTER TABLE drafts ADD COLUMN Metadata TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX drafts_author ON drafts (DocumentId, AuthorId);
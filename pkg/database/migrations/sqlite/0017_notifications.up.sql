@@ -0,0 +1,31 @@
+-- Users watching a document hear of its new drafts.
+CREATE TABLE document_watchers (
+	DocumentId INTEGER NOT NULL,
+	UserId INTEGER NOT NULL,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (DocumentId, UserId),
+	FOREIGN KEY (DocumentId) REFERENCES documents(Id),
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
+
+-- Each user's inbox: at most one notification per event, for the strongest reason it concerns
+-- them. CommentId is 0 for a new draft; ReadAt is NULL until it is read.
+CREATE TABLE notifications (
+	Id INTEGER PRIMARY KEY AUTOINCREMENT,
+	UserId INTEGER NOT NULL,
+	WorkspaceId INTEGER NOT NULL,
+	EventId INTEGER NOT NULL,
+	Reason TEXT NOT NULL,
+	DocumentId INTEGER NOT NULL,
+	DraftId INTEGER NOT NULL,
+	CommentId INTEGER NOT NULL DEFAULT 0,
+	ActorId INTEGER NOT NULL DEFAULT 0,
+	Excerpt TEXT NOT NULL DEFAULT '',
+	ReadAt DATETIME,
+	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (UserId, EventId),
+	FOREIGN KEY (UserId) REFERENCES users(Id),
+	FOREIGN KEY (EventId) REFERENCES events(Id)
+);
+
+CREATE INDEX notifications_inbox ON notifications (UserId, WorkspaceId, Id);
@@ -0,0 +1,10 @@
+-- Notifications are emailed in digests. EmailedAt is NULL until a digest includes the
+-- notification; users without settings get daily digests.
+ALTER TABLE notifications ADD COLUMN EmailedAt DATETIME;
+
+CREATE TABLE notification_settings (
+	UserId INTEGER PRIMARY KEY,
+	DigestFrequency TEXT NOT NULL DEFAULT 'daily',
+	LastDigestAt DATETIME,
+	FOREIGN KEY (UserId) REFERENCES users(Id)
+);
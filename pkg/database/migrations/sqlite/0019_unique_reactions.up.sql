@@ -0,0 +1,5 @@
+-- A user reacts to a comment with each emoji at most once. Keep the first of
+-- any reactions repeated before this was enforced.
+DELETE FROM reactions WHERE Id NOT IN (SELECT MIN(Id) FROM reactions GROUP BY CommentId, UserId, Emoji);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_comment_user_emoji_unique ON reactions (CommentId, UserId, Emoji);
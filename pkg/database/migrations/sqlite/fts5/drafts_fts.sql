@@ -0,0 +1,23 @@
+-- The FTS5 version of migration 3's index, which syncFTSIndex swaps in for builds
+-- with FTS5. External content index over drafts.Content, kept in sync by triggers.
+CREATE VIRTUAL TABLE drafts_fts USING fts5(
+	Content,
+	content = 'drafts',
+	content_rowid = 'Id',
+	tokenize = 'unicode61'
+);
+
+CREATE TRIGGER drafts_fts_insert AFTER INSERT ON drafts BEGIN
+	INSERT INTO drafts_fts (rowid, Content) VALUES (new.Id, new.Content);
+END;
+
+CREATE TRIGGER drafts_fts_delete AFTER DELETE ON drafts BEGIN
+	INSERT INTO drafts_fts (drafts_fts, rowid, Content) VALUES ('delete', old.Id, old.Content);
+END;
+
+CREATE TRIGGER drafts_fts_update AFTER UPDATE OF Content ON drafts BEGIN
+	INSERT INTO drafts_fts (drafts_fts, rowid, Content) VALUES ('delete', old.Id, old.Content);
+	INSERT INTO drafts_fts (rowid, Content) VALUES (new.Id, new.Content);
+END;
+
+INSERT INTO drafts_fts (drafts_fts) VALUES ('rebuild');
@@ -3,42 +3,191 @@ package database
 import (
 	"database/sql"
 	"documentapi/pkg/common"
+	"sync"
 	"time"
 )
 
-type SQLite struct {
+// sqlStore - The database/sql implementation of Store shared by the SQLite and Postgres backends.
+type sqlStore struct {
 	*sql.DB
+	dialect dialect
+	bus     EventBus
+}
+
+type SQLite struct {
+	sqlStore
+}
+
+type Postgres struct {
+	sqlStore
+}
+
+// Memory - A Store kept entirely in process memory, used for tests and throwaway instances.
+type Memory struct {
+	mu            sync.RWMutex
+	documents     []Document
+	drafts        []Draft
+	comments      []Comment
+	anchors       []CommentAnchor
+	carryovers    []memoryCarryover
+	reactions     []memoryReaction
+	users         []User
+	apiKeys       []memoryAPIKey
+	permissions   []DocumentPermission
+	groups        []Group
+	members       []memoryGroupMember
+	workspaces    []Workspace
+	memberships   []WorkspaceMember
+	events        []Event
+	bus           EventBus
+	webhooks      []memoryWebhook
+	deliveries    []WebhookDelivery
+	workflows     []DocumentWorkflow
+	approvals     []memoryApproval
+	transitions   []Transition
+	branches      []Branch
+	branchDrafts  []BranchDraft
+	watchers      []DocumentWatcher
+	notifications []Notification
+	settings      []NotificationSettings
+}
+
+// memoryApproval - An approval, kept in place when withdrawn so Ids stay positions.
+type memoryApproval struct {
+	Approval
+	Withdrawn bool
+}
+
+// memoryWebhook - A webhook, kept in place when deleted so Ids stay positions.
+type memoryWebhook struct {
+	Webhook
+	Deleted bool
+}
+
+type memoryGroupMember struct {
+	GroupId int
+	UserId  int
+}
+
+type memoryAPIKey struct {
+	APIKey
+	KeyHash string
+}
+
+// memoryCarryover - An unresolved comment carried onto a later draft.
+type memoryCarryover struct {
+	CommentId int
+	DraftId   int
+}
+
+type memoryReaction struct {
+	CommentId int
+	common.Reaction
+	Removed bool
 }
 
 type Document struct {
-	Id            int       `json:"id"`
-	Name          string    `json:"name"`
-	LatestVersion int       `json:"latestVersion"`
-	CreatedAt     time.Time `json:"createdAt"`
+	Id               int        `json:"id"`
+	WorkspaceId      int        `json:"workspaceId"`
+	Name             string     `json:"name"`
+	LatestVersion    int        `json:"latestVersion"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	PublishedVersion int        `json:"publishedVersion"` // 0 while unpublished
+	PublishedAt      *time.Time `json:"publishedAt,omitempty"`
+	PublishedBy      int        `json:"publishedBy,omitempty"`
+	ScheduledVersion int        `json:"scheduledVersion,omitempty"` // Published at ScheduledAt, 0 if nothing is scheduled
+	ScheduledAt      *time.Time `json:"scheduledAt,omitempty"`
+	ScheduledBy      int        `json:"scheduledBy,omitempty"`
 }
 
 type Draft struct {
-	Id            int       `json:"id"`
-	DocumentId    int       `json:"documentId"`
-	Content       string    `json:"content"`
-	VersionNumber int       `json:"versionNumber"`
-	CreatedAt     time.Time `json:"createdAt"`
+	Id                  int           `json:"id"`
+	DocumentId          int           `json:"documentId"`
+	Content             string        `json:"content"`
+	VersionNumber       int           `json:"versionNumber"`
+	CreatedAt           time.Time     `json:"createdAt"`
+	RestoredFromVersion int           `json:"restoredFromVersion,omitempty"` // The earlier version this draft copies
+	AuthorId            int           `json:"authorId"`                      // 0 if it was written before authors were recorded
+	Summary             string        `json:"summary"`                       // What changed and why
+	Metadata            DraftMetadata `json:"metadata,omitempty"`
 }
 
 type Comment struct {
-	Id              int       `json:"id"`
-	DraftId         int       `json:"draftId"`
-	UserId          int       `json:"userId"`
-	Text            string    `json:"text"`
-	ParentCommentId *int      `json:"parentCommentId"`
-	CreatedAt       time.Time `json:"createdAt"`
+	Id              int         `json:"id"`
+	DraftId         int         `json:"draftId"`
+	UserId          int         `json:"userId"`
+	Text            string      `json:"text"`
+	ParentCommentId *int        `json:"parentCommentId"`
+	Anchor          *Anchor     `json:"anchor,omitempty"`
+	ThreadId        int         `json:"threadId"` // Id of the thread's top-level comment
+	Resolved        bool        `json:"resolved"`
+	ResolvedBy      *int        `json:"resolvedBy,omitempty"`
+	ResolvedAt      *time.Time  `json:"resolvedAt,omitempty"`
+	Suggestion      *Suggestion `json:"suggestion,omitempty"` // Set on suggestions, which need an anchor
+	CreatedAt       time.Time   `json:"createdAt"`
 }
 
+// CommentWithReactions - A comment as listed for a draft. DraftId differs from the listed draft
+// when an unresolved comment was carried forward from an earlier version.
 type CommentWithReactions struct {
-	Id              int               `json:"id"`
-	UserId          int               `json:"userId"`
-	Text            string            `json:"text"`
-	ParentCommentId *int              `json:"parentCommentId,omitempty"`
-	CreatedAt       time.Time         `json:"createdAt"`
-	Reactions       []common.Reaction `json:"reactions"`
+	Id              int             `json:"id"`
+	DraftId         int             `json:"draftId"`
+	UserId          int             `json:"userId"`
+	Text            string          `json:"text"`
+	ParentCommentId *int            `json:"parentCommentId,omitempty"`
+	Anchor          *Anchor         `json:"anchor,omitempty"`
+	ThreadId        int             `json:"threadId"`
+	Resolved        bool            `json:"resolved"`
+	ResolvedBy      *int            `json:"resolvedBy,omitempty"`
+	ResolvedAt      *time.Time      `json:"resolvedAt,omitempty"`
+	Suggestion      *Suggestion     `json:"suggestion,omitempty"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	Reactions       []ReactionCount `json:"reactions"` // One per emoji, in the order each was first used
+}
+
+// ReactionCount - How many users reacted to a comment with an emoji, and whether the user
+// listing the comment is one of them.
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
+}
+
+// Anchor - The text a comment points at. Offsets count characters from the start of the
+// draft content with End exclusive; lines are 1-based and inclusive. When creating a comment
+// give a character range, a line range or just the quote to anchor on its first occurrence.
+type Anchor struct {
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Quote     string `json:"quote"`
+	Orphaned  bool   `json:"orphaned"` // The anchored text was deleted in this or an earlier version
+}
+
+// CommentAnchor - Where a comment's anchor sits in one draft. Anchors are carried onto
+// every later version of the document.
+type CommentAnchor struct {
+	CommentId int `json:"commentId"`
+	DraftId   int `json:"draftId"`
+	Anchor
+}
+
+// User - An account comments and reactions are made as.
+type User struct {
+	Id          int       `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Email       string    `json:"email,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// APIKey - A long-lived credential for a user. The key itself is only known when created.
+type APIKey struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"userId"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
 }
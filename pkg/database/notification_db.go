@@ -0,0 +1,358 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+// queueNotifications - Stores the notifications an event sends, in the transaction recording it:
+// mentions and replies for a comment, and a new draft for the document's watchers. Recipients
+// must belong to the workspace and be able to see the document, and whoever caused the event
+// is never notified of it.
+func (s *sqlStore) queueNotifications(tx *sql.Tx, event Event) error {
+	n, err := noticeOf(event)
+	if err != nil || n == nil {
+		return err
+	}
+
+	recipients := map[int]NotificationReason{}
+	add := func(reason NotificationReason, query string, args ...interface{}) error {
+		rows, err := tx.Query(s.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userId int
+			if err := rows.Scan(&userId); err != nil {
+				return err
+			}
+			addRecipient(recipients, userId, reason)
+		}
+		return rows.Err()
+	}
+	if len(n.mentions) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(n.mentions)), ", ")
+		args := make([]interface{}, len(n.mentions))
+		for i, username := range n.mentions {
+			args[i] = username
+		}
+		if err := add(NotifyMention, `SELECT Id FROM users WHERE Username IN (`+placeholders+`)`, args...); err != nil {
+			return err
+		}
+	}
+	if n.parentId != 0 {
+		if err := add(NotifyReply, `SELECT UserId FROM comments WHERE Id = ?`, n.parentId); err != nil {
+			return err
+		}
+	}
+	if n.watchers {
+		if err := add(NotifyDraft, `SELECT UserId FROM document_watchers WHERE DocumentId = ?`, event.DocumentId); err != nil {
+			return err
+		}
+	}
+	delete(recipients, n.actorId)
+
+	userIds := make([]int, 0, len(recipients))
+	for userId := range recipients {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+	for _, userId := range userIds {
+		canSee, err := s.canSeeDocument(tx, event.WorkspaceId, event.DocumentId, userId)
+		if err != nil {
+			return err
+		}
+		if !canSee {
+			continue
+		}
+
+		notification := newNotification(event, n, userId, recipients[userId])
+		query := `
+            INSERT INTO notifications (UserId, WorkspaceId, EventId, Reason, DocumentId, DraftId, CommentId, ActorId, Excerpt, CreatedAt)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		if _, err := tx.Exec(s.rebind(query), notification.UserId, notification.WorkspaceId, notification.EventId, notification.Reason,
+			notification.DocumentId, notification.DraftId, notification.CommentId, notification.ActorId, notification.Excerpt,
+			notification.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canSeeDocument - Reports whether a user belongs to a workspace and holds a role on one of its documents.
+func (s *sqlStore) canSeeDocument(q queryer, workspaceId, documentId, userId int) (bool, error) {
+	if role, err := s.workspaceRole(q, workspaceId, userId); err != nil || role == WorkspaceRoleNone {
+		return false, err
+	}
+	role, err := s.documentRole(q, documentId, userId)
+	return role != RoleNone, err
+}
+
+// WatchDocument - Starts sending a user a notification for each new draft of a document. Watching
+// a document twice changes nothing.
+func (s *sqlStore) WatchDocument(documentId, userId int) error {
+	query := `INSERT INTO document_watchers (DocumentId, UserId, CreatedAt) VALUES (?, ?, ?) ON CONFLICT (DocumentId, UserId) DO NOTHING`
+	_, err := s.Exec(s.rebind(query), documentId, userId, time.Now())
+	return err
+}
+
+// UnwatchDocument - Stops a user watching a document, reporting whether they were.
+func (s *sqlStore) UnwatchDocument(documentId, userId int) (bool, error) {
+	result, err := s.Exec(s.rebind(`DELETE FROM document_watchers WHERE DocumentId = ? AND UserId = ?`), documentId, userId)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// GetWatchers - The users watching a document, in the order they started.
+func (s *sqlStore) GetWatchers(documentId int) ([]DocumentWatcher, error) {
+	query := `
+        SELECT w.DocumentId, w.UserId, u.Username, w.CreatedAt
+        FROM document_watchers w
+        JOIN users u ON u.Id = w.UserId
+        WHERE w.DocumentId = ?
+        ORDER BY w.CreatedAt, w.UserId`
+	rows, err := s.Query(s.rebind(query), documentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watchers := []DocumentWatcher{}
+	for rows.Next() {
+		var watcher DocumentWatcher
+		if err := rows.Scan(&watcher.DocumentId, &watcher.UserId, &watcher.Username, &watcher.CreatedAt); err != nil {
+			return nil, err
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers, rows.Err()
+}
+
+const notificationColumns = `
+        n.Id, n.UserId, n.WorkspaceId, n.EventId, n.Reason, n.DocumentId, n.DraftId, n.CommentId,
+        n.ActorId, n.Excerpt, n.ReadAt, n.EmailedAt, n.CreatedAt`
+
+func notificationFields(notification *Notification) []interface{} {
+	return []interface{}{
+		&notification.Id, &notification.UserId, &notification.WorkspaceId, &notification.EventId, &notification.Reason,
+		&notification.DocumentId, &notification.DraftId, &notification.CommentId, &notification.ActorId,
+		&notification.Excerpt, &notification.ReadAt, &notification.EmailedAt, &notification.CreatedAt,
+	}
+}
+
+// inbox - Matches notifications n of a user in a workspace, about documents they can still see.
+// Takes the workspace and user Ids, then the scope's arguments.
+func inbox(workspaceId, userId int) (string, []interface{}) {
+	inScope, args := Scope{WorkspaceId: workspaceId, UserId: userId}.condition("n.DocumentId")
+	return `n.WorkspaceId = ? AND n.UserId = ? AND ` + inScope, append([]interface{}{workspaceId, userId}, args...)
+}
+
+// GetNotifications - A user's notifications in a workspace, newest first, filtered and paged by
+// options. Notifications about documents the user can no longer see are left out.
+func (s *sqlStore) GetNotifications(workspaceId, userId int, options NotificationOptions) ([]Notification, error) {
+	if options.Limit <= 0 || options.Limit > MaxNotificationPage {
+		options.Limit = MaxNotificationPage
+	}
+	condition, args := inbox(workspaceId, userId)
+	query := `SELECT ` + notificationColumns + ` FROM notifications n WHERE ` + condition
+	if options.UnreadOnly {
+		query += ` AND n.ReadAt IS NULL`
+	}
+	if options.Before > 0 {
+		query += ` AND n.Id < ?`
+		args = append(args, options.Before)
+	}
+	query += ` ORDER BY n.Id DESC LIMIT ?`
+
+	rows, err := s.Query(s.rebind(query), append(args, options.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(notificationFields(&notification)...); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// CountUnreadNotifications - How many of a user's notifications in a workspace are unread.
+func (s *sqlStore) CountUnreadNotifications(workspaceId, userId int) (int, error) {
+	condition, args := inbox(workspaceId, userId)
+	var count int
+	err := s.QueryRow(s.rebind(`SELECT COUNT(*) FROM notifications n WHERE `+condition+` AND n.ReadAt IS NULL`), args...).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationsRead - Marks a user's unread notifications in a workspace read: those listed
+// in ids, or all of them if ids is empty. Returns how many were marked.
+func (s *sqlStore) MarkNotificationsRead(workspaceId, userId int, ids []int64) (int, error) {
+	query := `UPDATE notifications SET ReadAt = ? WHERE WorkspaceId = ? AND UserId = ? AND ReadAt IS NULL`
+	args := []interface{}{time.Now(), workspaceId, userId}
+	if len(ids) > 0 {
+		query += ` AND Id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	result, err := s.Exec(s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	marked, err := result.RowsAffected()
+	return int(marked), err
+}
+
+// GetNotificationSettings - A user's notification settings, the defaults if they never chose any.
+func (s *sqlStore) GetNotificationSettings(userId int) (*NotificationSettings, error) {
+	settings := NotificationSettings{UserId: userId, DigestFrequency: DefaultDigestFrequency}
+	query := `SELECT DigestFrequency, LastDigestAt FROM notification_settings WHERE UserId = ?`
+	err := s.QueryRow(s.rebind(query), userId).Scan(&settings.DigestFrequency, &settings.LastDigestAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetNotificationSettings - Changes how often a user is sent digests.
+func (s *sqlStore) SetNotificationSettings(settings NotificationSettings) (*NotificationSettings, error) {
+	query := `
+        INSERT INTO notification_settings (UserId, DigestFrequency) VALUES (?, ?)
+        ON CONFLICT (UserId) DO UPDATE SET DigestFrequency = excluded.DigestFrequency`
+	if _, err := s.Exec(s.rebind(query), settings.UserId, settings.DigestFrequency); err != nil {
+		return nil, err
+	}
+	return s.GetNotificationSettings(settings.UserId)
+}
+
+// ClaimDigests - Claims the digests of up to limit users that are due at now: users with an email
+// address whose frequency has passed since their last digest, and who have unread notifications
+// no digest has included, about documents they can still see. The notifications are marked
+// emailed, so several schedulers never send the same one twice.
+func (s *sqlStore) ClaimDigests(now time.Time, limit int) ([]Digest, error) {
+	query := `
+        SELECT u.Id, u.Username, u.DisplayName, u.Email, u.CreatedAt, COALESCE(s.DigestFrequency, ?), s.LastDigestAt
+        FROM users u
+        LEFT JOIN notification_settings s ON s.UserId = u.Id
+        WHERE u.Email <> '' AND COALESCE(s.DigestFrequency, ?) <> ?
+          AND EXISTS (SELECT 1 FROM notifications n WHERE n.UserId = u.Id AND n.ReadAt IS NULL AND n.EmailedAt IS NULL)
+        ORDER BY u.Id`
+	rows, err := s.Query(s.rebind(query), DefaultDigestFrequency, DefaultDigestFrequency, DigestOff)
+	if err != nil {
+		return nil, err
+	}
+	due := []Digest{}
+	for rows.Next() {
+		var digest Digest
+		var lastDigestAt *time.Time
+		if err := rows.Scan(&digest.User.Id, &digest.User.Username, &digest.User.DisplayName, &digest.User.Email,
+			&digest.User.CreatedAt, &digest.Frequency, &lastDigestAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if digest.Frequency.due(lastDigestAt, now) {
+			due = append(due, digest)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := []Digest{}
+	for _, digest := range due {
+		if len(claimed) == limit {
+			break
+		}
+		if digest.Items, err = s.claimDigestItems(digest.User.Id, now); err != nil {
+			return nil, err
+		}
+		if len(digest.Items) == 0 {
+			continue
+		}
+		query := `
+            INSERT INTO notification_settings (UserId, DigestFrequency, LastDigestAt) VALUES (?, ?, ?)
+            ON CONFLICT (UserId) DO UPDATE SET LastDigestAt = excluded.LastDigestAt`
+		if _, err := s.Exec(s.rebind(query), digest.User.Id, digest.Frequency, now.UTC()); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, digest)
+	}
+	return claimed, nil
+}
+
+// claimDigestItems - Marks a user's unread, unemailed notifications about documents they can
+// see, in workspaces they still belong to, emailed at now, returning those this call won,
+// oldest first.
+func (s *sqlStore) claimDigestItems(userId int, now time.Time) ([]DigestItem, error) {
+	query := `
+        SELECT ` + notificationColumns + `, doc.Name, COALESCE(a.Username, '')
+        FROM notifications n
+        JOIN documents doc ON doc.Id = n.DocumentId
+        LEFT JOIN users a ON a.Id = n.ActorId
+        WHERE n.UserId = ? AND n.ReadAt IS NULL AND n.EmailedAt IS NULL
+          AND n.WorkspaceId IN (SELECT m.WorkspaceId FROM workspace_members m WHERE m.UserId = ?)
+          AND n.DocumentId IN (SELECT p.DocumentId FROM document_permissions p WHERE ` + userPrincipals + `)
+        ORDER BY n.Id`
+	rows, err := s.Query(s.rebind(query), userId, userId, userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	pending := []DigestItem{}
+	for rows.Next() {
+		var item DigestItem
+		if err := rows.Scan(append(notificationFields(&item.Notification), &item.DocumentName, &item.ActorName)...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := []DigestItem{}
+	for _, item := range pending {
+		result, err := s.Exec(s.rebind(`UPDATE notifications SET EmailedAt = ? WHERE Id = ? AND EmailedAt IS NULL`), now.UTC(), item.Id)
+		if err != nil {
+			return nil, err
+		}
+		if won, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if won == 0 {
+			continue
+		}
+		emailedAt := now
+		item.EmailedAt = &emailedAt
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ReleaseDigest - Returns the notifications of a digest that could not be sent, so the user's
+// next digest includes them.
+func (s *sqlStore) ReleaseDigest(digest Digest) error {
+	ids := digest.NotificationIds()
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `UPDATE notifications SET EmailedAt = NULL WHERE Id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + `)`
+	_, err := s.Exec(s.rebind(query), args...)
+	return err
+}
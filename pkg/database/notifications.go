@@ -0,0 +1,230 @@
+package database
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxNotificationPage - The most notifications GetNotifications returns at once.
+const MaxNotificationPage = 100
+
+// excerptLength - How many characters of a comment a notification keeps.
+const excerptLength = 200
+
+// NotificationReason - Why a user was notified, strongest first.
+type NotificationReason string
+
+const (
+	NotifyMention NotificationReason = "mention" // Mentioned by @username in a comment
+	NotifyReply   NotificationReason = "reply"   // Someone replied to their comment
+	NotifyDraft   NotificationReason = "draft"   // A new draft of a document they watch
+)
+
+// strength - Orders reasons so a user concerned for several is notified for the strongest.
+func (r NotificationReason) strength() int {
+	switch r {
+	case NotifyMention:
+		return 3
+	case NotifyReply:
+		return 2
+	case NotifyDraft:
+		return 1
+	}
+	return 0
+}
+
+// Notification - An event that concerns a user, waiting in their inbox.
+type Notification struct {
+	Id          int64              `json:"id"`
+	UserId      int                `json:"userId"`
+	WorkspaceId int                `json:"workspaceId"`
+	EventId     int64              `json:"eventId"`
+	Reason      NotificationReason `json:"reason"`
+	DocumentId  int                `json:"documentId"`
+	DraftId     int                `json:"draftId"`
+	CommentId   int                `json:"commentId,omitempty"` // 0 for a new draft
+	ActorId     int                `json:"actorId"`             // Who wrote the comment or draft
+	Excerpt     string             `json:"excerpt"`             // The start of the comment, or the draft's summary
+	ReadAt      *time.Time         `json:"readAt,omitempty"`
+	EmailedAt   *time.Time         `json:"emailedAt,omitempty"` // When a digest included it
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+// NotificationOptions - Filters and pages the notifications GetNotifications lists.
+type NotificationOptions struct {
+	UnreadOnly bool
+	Before     int64 // Only notifications older than this one, 0 to start from the newest
+	Limit      int   // At most this many, capped at MaxNotificationPage
+}
+
+// DigestFrequency - How often a user is emailed the notifications they have not read.
+type DigestFrequency string
+
+const (
+	DigestImmediate DigestFrequency = "immediate" // The next time digests are sent
+	DigestHourly    DigestFrequency = "hourly"
+	DigestDaily     DigestFrequency = "daily"
+	DigestOff       DigestFrequency = "off"
+)
+
+// DigestFrequencies - Every frequency a user can choose.
+var DigestFrequencies = []DigestFrequency{DigestImmediate, DigestHourly, DigestDaily, DigestOff}
+
+// DefaultDigestFrequency - The frequency of users who have not chosen one.
+const DefaultDigestFrequency = DigestDaily
+
+// Valid - Reports whether f is one of DigestFrequencies.
+func (f DigestFrequency) Valid() bool {
+	for _, frequency := range DigestFrequencies {
+		if f == frequency {
+			return true
+		}
+	}
+	return false
+}
+
+// Period - The least time between two of a user's digests.
+func (f DigestFrequency) Period() time.Duration {
+	switch f {
+	case DigestHourly:
+		return time.Hour
+	case DigestDaily:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// due - Reports whether a user whose last digest went at lastDigestAt is due another at now.
+func (f DigestFrequency) due(lastDigestAt *time.Time, now time.Time) bool {
+	if f == DigestOff {
+		return false
+	}
+	return lastDigestAt == nil || !now.Before(lastDigestAt.Add(f.Period()))
+}
+
+// NotificationSettings - How a user wants to hear of their notifications outside the API.
+type NotificationSettings struct {
+	UserId          int             `json:"userId"`
+	DigestFrequency DigestFrequency `json:"digestFrequency"`
+	LastDigestAt    *time.Time      `json:"lastDigestAt,omitempty"`
+}
+
+// Digest - A user's unread notifications that no email has included yet, claimed for one email.
+type Digest struct {
+	User      User
+	Frequency DigestFrequency
+	Items     []DigestItem // Oldest first
+}
+
+// DigestItem - A notification with the names a digest shows for it.
+type DigestItem struct {
+	Notification
+	DocumentName string
+	ActorName    string // The actor's username, empty if the notification has no actor
+}
+
+// NotificationIds - The Ids of the notifications in a digest.
+func (d Digest) NotificationIds() []int64 {
+	ids := make([]int64, len(d.Items))
+	for i, item := range d.Items {
+		ids[i] = item.Id
+	}
+	return ids
+}
+
+// DocumentWatcher - A user who hears of every new draft of a document.
+type DocumentWatcher struct {
+	DocumentId int       `json:"documentId"`
+	UserId     int       `json:"userId"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// mentionPattern - @username, not preceded by anything that would make it part of a word or
+// an email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@-])@([A-Za-z0-9][A-Za-z0-9_.-]{0,31})`)
+
+// ParseMentions - The usernames mentioned in a comment, lowercased, each once, in the order
+// they first appear. A trailing dot ends the sentence rather than the username.
+func ParseMentions(text string) []string {
+	usernames := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], "."))
+		if username != "" && !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames
+}
+
+// notice - Who an event may notify, before checking they can see the document.
+type notice struct {
+	actorId   int
+	commentId int
+	excerpt   string
+	mentions  []string // Usernames mentioned in a comment
+	parentId  int      // The comment replied to, 0 if none
+	watchers  bool     // Whether the document's watchers hear of it
+}
+
+// noticeOf - What an event may notify people of, or nil if it notifies nobody.
+func noticeOf(event Event) (*notice, error) {
+	switch event.Type {
+	case EventCommentAdded:
+		var comment Comment
+		if err := json.Unmarshal(event.Data, &comment); err != nil {
+			return nil, err
+		}
+		n := &notice{actorId: comment.UserId, commentId: comment.Id, excerpt: excerpt(comment.Text), mentions: ParseMentions(comment.Text)}
+		if comment.ParentCommentId != nil {
+			n.parentId = *comment.ParentCommentId
+		}
+		return n, nil
+	case EventDraftCreated:
+		var draft struct {
+			AuthorId int    `json:"authorId"`
+			Summary  string `json:"summary"`
+		}
+		if err := json.Unmarshal(event.Data, &draft); err != nil {
+			return nil, err
+		}
+		return &notice{actorId: draft.AuthorId, excerpt: excerpt(draft.Summary), watchers: true}, nil
+	}
+	return nil, nil
+}
+
+// excerpt - The start of a text, cut at excerptLength characters.
+func excerpt(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= excerptLength {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:excerptLength])) + "…"
+}
+
+// addRecipient - Records that an event concerns a user, keeping the strongest reason.
+func addRecipient(recipients map[int]NotificationReason, userId int, reason NotificationReason) {
+	if current, found := recipients[userId]; !found || reason.strength() > current.strength() {
+		recipients[userId] = reason
+	}
+}
+
+// newNotification - The notification an event sends a recipient.
+func newNotification(event Event, n *notice, userId int, reason NotificationReason) Notification {
+	return Notification{
+		UserId:      userId,
+		WorkspaceId: event.WorkspaceId,
+		EventId:     event.Id,
+		Reason:      reason,
+		DocumentId:  event.DocumentId,
+		DraftId:     event.DraftId,
+		CommentId:   n.commentId,
+		ActorId:     n.actorId,
+		Excerpt:     n.excerpt,
+		CreatedAt:   event.CreatedAt,
+	}
+}
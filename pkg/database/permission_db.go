@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// userPrincipals - Matches permission rows p held by a user directly or through one of their
+// groups. Takes the user Id twice.
+var userPrincipals = principalsOf("?")
+
+// principalsOf - Matches permission rows p held by the user in userColumn directly or through
+// one of their groups.
+func principalsOf(userColumn string) string {
+	return `((p.PrincipalType = 'user' AND p.PrincipalId = ` + userColumn + `)
+            OR (p.PrincipalType = 'group' AND p.PrincipalId IN (SELECT GroupId FROM group_members WHERE UserId = ` + userColumn + `)))`
+}
+
+// condition - A WHERE condition limiting documentColumn to the documents the scope can see.
+func (scope Scope) condition(documentColumn string) (string, []interface{}) {
+	condition := documentColumn + ` IN (SELECT doc.Id FROM documents doc WHERE doc.WorkspaceId = ?)`
+	if scope.Unrestricted {
+		return condition, []interface{}{scope.WorkspaceId}
+	}
+	return condition + ` AND ` + documentColumn + ` IN (SELECT p.DocumentId FROM document_permissions p WHERE ` + userPrincipals + `)`,
+		[]interface{}{scope.WorkspaceId, scope.UserId, scope.UserId}
+}
+
+// GetDocumentRole - The strongest role a user holds on a document, directly or through a group.
+// RoleNone means the user cannot see the document.
+func (s *sqlStore) GetDocumentRole(documentId, userId int) (Role, error) {
+	return s.documentRole(s.DB, documentId, userId)
+}
+
+func (s *sqlStore) documentRole(q queryer, documentId, userId int) (Role, error) {
+	query := `SELECT p.Role FROM document_permissions p WHERE p.DocumentId = ? AND ` + userPrincipals
+	rows, err := q.Query(s.rebind(query), documentId, userId, userId)
+	if err != nil {
+		return RoleNone, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role); err != nil {
+			return RoleNone, err
+		}
+		roles = append(roles, role)
+	}
+	return strongestRole(roles), rows.Err()
+}
+
+// grantOwner - Makes the user who created a document its owner.
+func (s *sqlStore) grantOwner(tx *sql.Tx, documentId, userId int) error {
+	query := `INSERT INTO document_permissions (DocumentId, PrincipalType, PrincipalId, Role, CreatedAt) VALUES (?, ?, ?, ?, ?)`
+	_, err := tx.Exec(s.rebind(query), documentId, PrincipalUser, userId, RoleOwner, time.Now())
+	return err
+}
+
+// SetDocumentPermission - Grants a role on a document to a user or group, replacing any role
+// the principal already had. Returns ErrLastOwner if this demotes the only user owner.
+func (s *sqlStore) SetDocumentPermission(permission DocumentPermission) (*DocumentPermission, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	permission.CreatedAt = time.Now()
+	query := `
+        INSERT INTO document_permissions (DocumentId, PrincipalType, PrincipalId, Role, CreatedAt) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (DocumentId, PrincipalType, PrincipalId) DO UPDATE SET Role = excluded.Role
+        RETURNING CreatedAt`
+	if err := tx.QueryRow(s.rebind(query), permission.DocumentId, permission.PrincipalType, permission.PrincipalId,
+		permission.Role, permission.CreatedAt).Scan(&permission.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := s.checkOwnerRemains(tx, permission.DocumentId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// RemoveDocumentPermission - Takes away a principal's role on a document. Reports false if it
+// had none, and returns ErrLastOwner rather than removing the only user owner.
+func (s *sqlStore) RemoveDocumentPermission(documentId int, principalType string, principalId int) (bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	query := `DELETE FROM document_permissions WHERE DocumentId = ? AND PrincipalType = ? AND PrincipalId = ?`
+	result, err := tx.Exec(s.rebind(query), documentId, principalType, principalId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	if err := s.checkOwnerRemains(tx, documentId); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// checkOwnerRemains - Returns ErrLastOwner if a document has no user owner left.
+func (s *sqlStore) checkOwnerRemains(tx *sql.Tx, documentId int) error {
+	var owners int
+	query := `SELECT COUNT(*) FROM document_permissions WHERE DocumentId = ? AND PrincipalType = ? AND Role = ?`
+	if err := tx.QueryRow(s.rebind(query), documentId, PrincipalUser, RoleOwner).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// GetDocumentPermissions - Lists who a document is shared with, groups first.
+func (s *sqlStore) GetDocumentPermissions(documentId int) ([]DocumentPermission, error) {
+	query := `
+        SELECT DocumentId, PrincipalType, PrincipalId, Role, CreatedAt
+        FROM document_permissions
+        WHERE DocumentId = ?
+        ORDER BY PrincipalType, PrincipalId`
+	rows, err := s.Query(s.rebind(query), documentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []DocumentPermission{}
+	for rows.Next() {
+		var permission DocumentPermission
+		if err := rows.Scan(&permission.DocumentId, &permission.PrincipalType, &permission.PrincipalId,
+			&permission.Role, &permission.CreatedAt); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// CreateGroup - Creates a group in a workspace with its owner as the first member, returning
+// ErrGroupNameTaken if the name exists there.
+func (s *sqlStore) CreateGroup(group Group) (*Group, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	group.CreatedAt = time.Now()
+	query := `
+        INSERT INTO user_groups (WorkspaceId, Name, OwnerId, CreatedAt) VALUES (?, ?, ?, ?)
+        ON CONFLICT (WorkspaceId, Name) DO NOTHING
+        RETURNING Id`
+	if err := tx.QueryRow(s.rebind(query), group.WorkspaceId, group.Name, group.OwnerId, group.CreatedAt).Scan(&group.Id); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrGroupNameTaken
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec(s.rebind(`INSERT INTO group_members (GroupId, UserId) VALUES (?, ?)`), group.Id, group.OwnerId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GetGroupById - Retrieves a group by its ID.
+func (s *sqlStore) GetGroupById(id int) (*Group, error) {
+	query := `SELECT Id, WorkspaceId, Name, OwnerId, CreatedAt FROM user_groups WHERE Id = ?`
+	var group Group
+	if err := s.QueryRow(s.rebind(query), id).Scan(&group.Id, &group.WorkspaceId, &group.Name, &group.OwnerId, &group.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// AddGroupMember - Adds a user to a group. Adding an existing member does nothing.
+func (s *sqlStore) AddGroupMember(groupId, userId int) error {
+	query := `INSERT INTO group_members (GroupId, UserId) VALUES (?, ?) ON CONFLICT (GroupId, UserId) DO NOTHING`
+	_, err := s.Exec(s.rebind(query), groupId, userId)
+	return err
+}
+
+// RemoveGroupMember - Removes a user from a group. Reports false if they were not a member.
+func (s *sqlStore) RemoveGroupMember(groupId, userId int) (bool, error) {
+	result, err := s.Exec(s.rebind(`DELETE FROM group_members WHERE GroupId = ? AND UserId = ?`), groupId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetGroupMembers - Lists the members of a group by user Id.
+func (s *sqlStore) GetGroupMembers(groupId int) ([]User, error) {
+	query := `
+        SELECT u.Id, u.Username, u.DisplayName, u.Email, u.CreatedAt
+        FROM group_members m
+        JOIN users u ON u.Id = m.UserId
+        WHERE m.GroupId = ?
+        ORDER BY u.Id`
+	rows, err := s.Query(s.rebind(query), groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.Email, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, user)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// Role - What a user may do with a document. Each role can do everything the roles below it can.
+type Role string
+
+const (
+	RoleNone      Role = ""
+	RoleViewer    Role = "viewer"    // Read drafts and comments
+	RoleCommenter Role = "commenter" // Comment, react and resolve threads
+	RoleEditor    Role = "editor"    // Add drafts
+	RoleOwner     Role = "owner"     // Share and unshare
+)
+
+// Roles - Every assignable role, weakest first.
+var Roles = []Role{RoleViewer, RoleCommenter, RoleEditor, RoleOwner}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// AtLeast - Reports whether r grants everything minimum does.
+func (r Role) AtLeast(minimum Role) bool {
+	return r.rank() >= minimum.rank()
+}
+
+// Valid - Reports whether r is one of Roles.
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// strongestRole - The highest of the roles a user holds directly and through groups.
+func strongestRole(roles []Role) Role {
+	strongest := RoleNone
+	for _, role := range roles {
+		if role.rank() > strongest.rank() {
+			strongest = role
+		}
+	}
+	return strongest
+}
+
+// Principal types a document can be shared with.
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+)
+
+// DocumentPermission - A role on a document granted to a user or a group.
+type DocumentPermission struct {
+	DocumentId    int       `json:"documentId"`
+	PrincipalType string    `json:"principalType"`
+	PrincipalId   int       `json:"principalId"`
+	Role          Role      `json:"role"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Group - A set of users in a workspace documents can be shared with at once. Only the owner manages members.
+type Group struct {
+	Id          int       `json:"id"`
+	WorkspaceId int       `json:"workspaceId"`
+	Name        string    `json:"name"`
+	OwnerId     int       `json:"ownerId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Scope - Limits list and search results to the documents in a workspace a user holds any
+// role on. The zero Scope matches nothing; Unrestricted skips the role check for callers
+// acting on behalf of the service, but never crosses workspaces.
+type Scope struct {
+	WorkspaceId  int
+	UserId       int
+	Unrestricted bool
+}
+
+var (
+	// ErrPermissionDenied - The acting user's role on a document does not allow the change.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrLastOwner - Removing the permission would leave the document without a user owner.
+	ErrLastOwner = errors.New("a document must keep at least one owner")
+	// ErrGroupNameTaken - CreateGroup was given a name that already exists in the workspace.
+	ErrGroupNameTaken = errors.New("group name is already taken")
+)
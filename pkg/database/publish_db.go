@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// changePublication - Runs an update of a document's publication columns and, if it matched,
+// records eventType for the document as it now is. Returns ErrNotFound if nothing matched and
+// ErrNotApproved if approved is a version the workflow has not approved for publication.
+func (s *sqlStore) changePublication(documentId, approved int, eventType string, update string, args ...interface{}) (*Document, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(s.rebind(update), args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	if approved > 0 {
+		if err := s.checkApproved(tx, documentId, approved); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	document, err := scanDocument(tx.QueryRow(s.rebind(`SELECT `+documentColumns+` FROM documents WHERE Id = ?`), documentId))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var event Event
+	if eventType != "" {
+		draftId := 0
+		if document.PublishedVersion > 0 {
+			query := `SELECT Id FROM drafts WHERE DocumentId = ? AND VersionNumber = ?`
+			err = tx.QueryRow(s.rebind(query), documentId, document.PublishedVersion).Scan(&draftId)
+		}
+		if err == nil {
+			event, err = newEvent(eventType, document.WorkspaceId, documentId, draftId, document)
+		}
+		if err == nil {
+			err = s.recordEvent(tx, &event)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if eventType != "" {
+		s.bus.Publish(event)
+	}
+	return document, nil
+}
+
+// checkApproved - Returns ErrNotApproved unless the workflow lets version be published. Approvals
+// invalidated by newer drafts still count, so an approved version stays publishable.
+func (s *sqlStore) checkApproved(q queryer, documentId, version int) error {
+	current, _, err := s.loadWorkflow(q, documentId)
+	if err != nil {
+		return err
+	}
+	latest, err := s.latestVersion(q, documentId)
+	if err != nil {
+		return err
+	}
+	approvals, err := s.queryApprovals(q, `SELECT `+approvalColumns+` FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ?`,
+		documentId, version)
+	if err != nil {
+		return err
+	}
+	if !current.Workflow.approvesPublication(current.State, version == latest, approvals) {
+		return ErrNotApproved
+	}
+	return nil
+}
+
+// PublishDocument - Makes a version of a document the one readers see, as userId, cancelling any
+// scheduled publication. Returns ErrNotFound if the document has no such version and
+// ErrNotApproved if its workflow has not approved it. A document.published event is published.
+func (s *sqlStore) PublishDocument(documentId, version, userId int) (*Document, error) {
+	query := `
+        UPDATE documents
+        SET PublishedVersion = ?, PublishedAt = ?, PublishedBy = ?, ScheduledVersion = 0, ScheduledAt = NULL, ScheduledBy = 0
+        WHERE Id = ? AND ? BETWEEN 1 AND LatestVersion`
+	return s.changePublication(documentId, version, EventDocumentPublished, query, version, time.Now(), userId, documentId, version)
+}
+
+// SchedulePublication - Arranges for a version of a document to be published at a later time,
+// replacing any earlier schedule. Returns ErrNotFound if the document has no such version and
+// ErrNotApproved if its workflow has not approved it.
+func (s *sqlStore) SchedulePublication(documentId, version int, at time.Time, userId int) (*Document, error) {
+	query := `
+        UPDATE documents SET ScheduledVersion = ?, ScheduledAt = ?, ScheduledBy = ?
+        WHERE Id = ? AND ? BETWEEN 1 AND LatestVersion`
+	return s.changePublication(documentId, version, "", query, version, at.UTC(), userId, documentId, version)
+}
+
+// UnpublishDocument - Withdraws a document from readers and cancels any scheduled publication.
+// Returns ErrNotFound if there is no such document. A document.unpublished event is published
+// if it was published.
+func (s *sqlStore) UnpublishDocument(documentId int) (*Document, error) {
+	var published int
+	err := s.QueryRow(s.rebind(`SELECT PublishedVersion FROM documents WHERE Id = ?`), documentId).Scan(&published)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	eventType := ""
+	if published > 0 {
+		eventType = EventDocumentUnpublished
+	}
+	query := `
+        UPDATE documents
+        SET PublishedVersion = 0, PublishedAt = NULL, PublishedBy = 0, ScheduledVersion = 0, ScheduledAt = NULL, ScheduledBy = 0
+        WHERE Id = ? AND PublishedVersion = ?`
+	document, err := s.changePublication(documentId, 0, eventType, query, documentId, published)
+	if err == ErrNotFound {
+		return s.UnpublishDocument(documentId) // Published again meanwhile
+	}
+	return document, err
+}
+
+// PublishDueDocuments - Publishes every document whose scheduled publication is due at now, as
+// the user who scheduled it, and returns them. Safe to run from several processes at once.
+func (s *sqlStore) PublishDueDocuments(now time.Time) ([]Document, error) {
+	query := `SELECT Id, ScheduledVersion FROM documents WHERE ScheduledVersion > 0 AND ScheduledAt <= ? ORDER BY ScheduledAt, Id`
+	rows, err := s.Query(s.rebind(query), now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	type due struct{ documentId, version int }
+	var pending []due
+	for rows.Next() {
+		var next due
+		if err := rows.Scan(&next.documentId, &next.version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, next)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only the process whose update still finds the schedule in place publishes it.
+	published := []Document{}
+	query = `
+        UPDATE documents
+        SET PublishedVersion = ScheduledVersion, PublishedAt = ?, PublishedBy = ScheduledBy,
+            ScheduledVersion = 0, ScheduledAt = NULL, ScheduledBy = 0
+        WHERE Id = ? AND ScheduledVersion = ? AND ScheduledAt <= ?`
+	for _, next := range pending {
+		document, err := s.changePublication(next.documentId, 0, EventDocumentPublished, query, now, next.documentId, next.version, now.UTC())
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		published = append(published, *document)
+	}
+	return published, nil
+}
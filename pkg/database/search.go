@@ -0,0 +1,456 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchOptions - Controls how SearchDrafts matches and limits results.
+type SearchOptions struct {
+	LatestOnly bool // Only search the latest version of each document
+	AuthorId   int  // Only search drafts written by this user, 0 for any author
+	Limit      int  // Maximum number of results, 0 for the default
+	Scope      Scope
+}
+
+// condition - The SQL condition on drafts d for the options' scope and author, with its arguments.
+func (options SearchOptions) condition() (string, []interface{}) {
+	inScope, args := options.Scope.condition("d.DocumentId")
+	if options.AuthorId != 0 {
+		inScope += ` AND d.AuthorId = ?`
+		args = append(args, options.AuthorId)
+	}
+	return inScope, args
+}
+
+// DefaultSearchLimit - Used when SearchOptions.Limit is not set.
+const DefaultSearchLimit = 50
+
+// SearchResult - A draft matching a search, best match first.
+type SearchResult struct {
+	Draft
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchSyntaxError - The search text could not be parsed.
+type SearchSyntaxError struct {
+	Message string
+}
+
+func (e *SearchSyntaxError) Error() string {
+	return "invalid search: " + e.Message
+}
+
+// IsSearchSyntaxError - Reports whether err is caused by invalid search text.
+func IsSearchSyntaxError(err error) bool {
+	var syntaxErr *SearchSyntaxError
+	return errors.As(err, &syntaxErr)
+}
+
+const (
+	snippetStart    = "<mark>"
+	snippetEnd      = "</mark>"
+	snippetEllipsis = "…"
+	snippetTokens   = 16
+)
+
+// searchNode - A parsed search expression. Leaves are phrases of one or more
+// tokens; a prefix phrase matches any token starting with its last token.
+type searchNode struct {
+	op          string // "" for a phrase, otherwise AND, OR or NOT
+	left, right *searchNode
+	tokens      []string
+	prefix      bool
+}
+
+// parseSearch - Parses the search syntax shared by every backend:
+//
+//	word            matches the word, case-insensitively
+//	word*           matches words starting with word
+//	"some phrase"   matches the words next to each other
+//	a AND b, a b    both must match
+//	a OR b          either must match
+//	a NOT b         a must match and b must not
+//	( ... )         grouping
+//
+// NOT binds tighter than AND, which binds tighter than OR.
+func parseSearch(text string) (*searchNode, error) {
+	lexemes, err := lexSearch(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchParser{lexemes: lexemes}
+	if len(lexemes) == 0 {
+		return nil, &SearchSyntaxError{Message: "no search terms"}
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lexemes) {
+		return nil, &SearchSyntaxError{Message: "unexpected " + p.lexemes[p.pos].describe()}
+	}
+	return node, nil
+}
+
+type searchLexeme struct {
+	kind   string // "(", ")", "AND", "OR", "NOT" or "phrase"
+	tokens []string
+	prefix bool
+}
+
+func (l searchLexeme) describe() string {
+	if l.kind == "phrase" {
+		return `"` + strings.Join(l.tokens, " ") + `"`
+	}
+	return l.kind
+}
+
+func lexSearch(text string) ([]searchLexeme, error) {
+	var lexemes []searchLexeme
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			lexemes = append(lexemes, searchLexeme{kind: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &SearchSyntaxError{Message: "unterminated quote"}
+			}
+			lexeme := searchLexeme{kind: "phrase", tokens: searchTokenStrings(string(runes[i+1 : end]))}
+			i = end + 1
+			if i < len(runes) && runes[i] == '*' {
+				lexeme.prefix = true
+				i++
+			}
+			if len(lexeme.tokens) > 0 {
+				lexemes = append(lexemes, lexeme)
+			}
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '(' && runes[end] != ')' && runes[end] != '"' {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+			if word == "AND" || word == "OR" || word == "NOT" {
+				lexemes = append(lexemes, searchLexeme{kind: word})
+				continue
+			}
+			lexeme := searchLexeme{kind: "phrase", prefix: strings.HasSuffix(word, "*")}
+			lexeme.tokens = searchTokenStrings(word)
+			if len(lexeme.tokens) > 0 {
+				lexemes = append(lexemes, lexeme)
+			}
+		}
+	}
+	return lexemes, nil
+}
+
+type searchParser struct {
+	lexemes []searchLexeme
+	pos     int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos < len(p.lexemes) {
+		return p.lexemes[p.pos].kind
+	}
+	return ""
+}
+
+func (p *searchParser) parseOr() (*searchNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &searchNode{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parseAnd() (*searchNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "AND":
+			p.pos++
+		case "phrase", "(":
+			// Adjacent terms are an implicit AND.
+		default:
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &searchNode{op: "AND", left: left, right: right}
+	}
+}
+
+func (p *searchParser) parseNot() (*searchNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "NOT" {
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &searchNode{op: "NOT", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parsePrimary() (*searchNode, error) {
+	if p.pos >= len(p.lexemes) {
+		return nil, &SearchSyntaxError{Message: "expected a search term at the end"}
+	}
+	lexeme := p.lexemes[p.pos]
+	switch lexeme.kind {
+	case "phrase":
+		p.pos++
+		return &searchNode{tokens: lexeme.tokens, prefix: lexeme.prefix}, nil
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, &SearchSyntaxError{Message: "missing closing parenthesis"}
+		}
+		p.pos++
+		return node, nil
+	default:
+		return nil, &SearchSyntaxError{Message: "expected a search term before " + lexeme.describe()}
+	}
+}
+
+// phrases - The leaf phrases of the expression, in order.
+func (n *searchNode) phrases() []*searchNode {
+	if n.op == "" {
+		return []*searchNode{n}
+	}
+	return append(n.left.phrases(), n.right.phrases()...)
+}
+
+// sqliteMatch - Renders the expression as an FTS4 or FTS5 MATCH argument.
+func (n *searchNode) sqliteMatch(module string) string {
+	if n.op != "" {
+		return "(" + n.left.sqliteMatch(module) + " " + n.op + " " + n.right.sqliteMatch(module) + ")"
+	}
+	phrase := strings.Join(n.tokens, " ")
+	switch {
+	case !n.prefix:
+		return `"` + phrase + `"`
+	case module == "fts5":
+		return `"` + phrase + `"*`
+	default:
+		return `"` + phrase + `*"`
+	}
+}
+
+// tsquery - Renders the expression for PostgreSQL's to_tsquery.
+func (n *searchNode) tsquery() string {
+	switch n.op {
+	case "AND":
+		return "(" + n.left.tsquery() + " & " + n.right.tsquery() + ")"
+	case "OR":
+		return "(" + n.left.tsquery() + " | " + n.right.tsquery() + ")"
+	case "NOT":
+		return "(" + n.left.tsquery() + " & !" + n.right.tsquery() + ")"
+	}
+	parts := make([]string, len(n.tokens))
+	for i, token := range n.tokens {
+		parts[i] = "'" + token + "'"
+	}
+	if n.prefix {
+		parts[len(parts)-1] += ":*"
+	}
+	return "(" + strings.Join(parts, " <-> ") + ")"
+}
+
+// searchToken - A lower-cased run of letters and digits and where it sits in the text.
+type searchToken struct {
+	text       string
+	start, end int // Byte offsets into the original text
+}
+
+// searchTokens - Splits text the way the unicode61 tokenizer does.
+func searchTokens(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, searchToken{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+func searchTokenStrings(text string) []string {
+	tokens := searchTokens(text)
+	strs := make([]string, len(tokens))
+	for i, token := range tokens {
+		strs[i] = token.text
+	}
+	return strs
+}
+
+// occurrences - Token offsets at which the phrase starts in tokens.
+func (n *searchNode) occurrences(tokens []searchToken) []int {
+	var starts []int
+	for i := 0; i+len(n.tokens) <= len(tokens); i++ {
+		matched := true
+		for j, want := range n.tokens {
+			got := tokens[i+j].text
+			if n.prefix && j == len(n.tokens)-1 {
+				matched = strings.HasPrefix(got, want)
+			} else {
+				matched = got == want
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// matches - Evaluates the expression against a tokenized document.
+func (n *searchNode) matches(tokens []searchToken) bool {
+	switch n.op {
+	case "AND":
+		return n.left.matches(tokens) && n.right.matches(tokens)
+	case "OR":
+		return n.left.matches(tokens) || n.right.matches(tokens)
+	case "NOT":
+		return n.left.matches(tokens) && !n.right.matches(tokens)
+	}
+	return len(n.occurrences(tokens)) > 0
+}
+
+// bm25 - Okapi BM25 with the same constants and idf floor as SQLite's FTS5.
+// hits and docsWithHit hold one entry per query phrase.
+func bm25(hits, docsWithHit []int, totalDocs int, docLen, avgDocLen float64) float64 {
+	const k1 = 1.2
+	const b = 0.75
+
+	if avgDocLen <= 0 {
+		avgDocLen = 1
+	}
+	score := 0.0
+	for i, f := range hits {
+		if f == 0 {
+			continue
+		}
+		n := float64(docsWithHit[i])
+		idf := math.Log((float64(totalDocs) - n + 0.5) / (n + 0.5))
+		if idf <= 0 {
+			idf = 1e-6
+		}
+		tf := float64(f)
+		score += idf * (tf * (k1 + 1)) / (tf + k1*(1-b+b*docLen/avgDocLen))
+	}
+	return score
+}
+
+// highlight - Builds a snippet of roughly snippetTokens tokens around the first match,
+// marking every matched token.
+func highlight(text string, tokens []searchToken, phrases []*searchNode) string {
+	marked := make([]bool, len(tokens))
+	first := -1
+	for _, phrase := range phrases {
+		for _, start := range phrase.occurrences(tokens) {
+			for i := start; i < start+len(phrase.tokens); i++ {
+				marked[i] = true
+			}
+			if first < 0 || start < first {
+				first = start
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	from := first - snippetTokens/4
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetTokens
+	if to > len(tokens) {
+		to = len(tokens)
+		if from = to - snippetTokens; from < 0 {
+			from = 0
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString(snippetEllipsis)
+	}
+	pos := tokens[from].start
+	for i := from; i < to; i++ {
+		b.WriteString(text[pos:tokens[i].start])
+		if marked[i] {
+			b.WriteString(snippetStart + text[tokens[i].start:tokens[i].end] + snippetEnd)
+		} else {
+			b.WriteString(text[tokens[i].start:tokens[i].end])
+		}
+		pos = tokens[i].end
+	}
+	if to < len(tokens) {
+		b.WriteString(snippetEllipsis)
+	} else {
+		b.WriteString(text[pos:])
+	}
+	return b.String()
+}
+
+// sortSearchResults - Best score first, newest draft first among ties.
+func sortSearchResults(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id > results[j].Id
+	})
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"documentapi/pkg/common"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store - The persistence operations used by the API.
+type Store interface {
+	CreateDraft(draft common.Draft) (*Draft, error)
+	GetDocumentById(workspaceId, id int) (*Document, error)
+	GetDocumentByName(workspaceId int, name string) (*Document, error)
+	GetDraftById(workspaceId, id int) (*Draft, error)
+	GetDraftByVersion(workspaceId, documentId, version int) (*Draft, error)
+	GetDraftVersions(workspaceId, documentId, from, to int) ([]Draft, error)
+	GetDraftHistory(workspaceId, documentId int, options HistoryOptions) ([]Draft, error)
+	GetLatestDrafts(scope Scope, limit, authorId int) ([]Draft, error)
+	SearchDrafts(text string, options SearchOptions) ([]SearchResult, error)
+	GetAllDocumentsLatestVersions(scope Scope) ([]Document, error)
+	AddCommentToDraft(workspaceId int, comment Comment) (int64, error)
+	GetCommentById(workspaceId, id int) (*Comment, error)
+	ResolveThread(workspaceId, commentId, userId int) (*Comment, error)
+	ReopenThread(workspaceId, commentId int) (*Comment, error)
+	GetCommentsAndReactionsByDraftId(workspaceId, draftId, userId int) ([]CommentWithReactions, error)
+	GetCommentAnchorsByDraftId(workspaceId, draftId int) ([]CommentAnchor, error)
+	RejectSuggestion(workspaceId, commentId, userId int) (*Comment, error)
+	AddReactionToComment(workspaceId int, reaction common.Reaction) (bool, error)
+	RemoveReactionFromComment(workspaceId int, reaction common.Reaction) (bool, error)
+	ToggleReaction(workspaceId int, reaction common.Reaction) (bool, error)
+	GetReactionCounts(workspaceId, commentId, userId int) ([]ReactionCount, error)
+	CreateUser(user User) (*User, error)
+	GetUserById(id int) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	CreateAPIKey(key APIKey, keyHash string) (*APIKey, error)
+	GetUserByAPIKey(keyHash string) (*User, error)
+	GetAPIKeysByUserId(userId int) ([]APIKey, error)
+	RevokeAPIKey(userId, keyId int) (bool, error)
+	CreateWorkspace(workspace Workspace, adminId int) (*Workspace, error)
+	GetWorkspacesByUserId(userId int) ([]Workspace, error)
+	GetWorkspaceRole(workspaceId, userId int) (WorkspaceRole, error)
+	SetWorkspaceMember(member WorkspaceMember) (*WorkspaceMember, error)
+	RemoveWorkspaceMember(workspaceId, userId int) (bool, error)
+	GetWorkspaceMembers(workspaceId int) ([]WorkspaceMember, error)
+	GetDocumentRole(documentId, userId int) (Role, error)
+	SetDocumentPermission(permission DocumentPermission) (*DocumentPermission, error)
+	RemoveDocumentPermission(documentId int, principalType string, principalId int) (bool, error)
+	GetDocumentPermissions(documentId int) ([]DocumentPermission, error)
+	CreateGroup(group Group) (*Group, error)
+	GetGroupById(id int) (*Group, error)
+	AddGroupMember(groupId, userId int) error
+	RemoveGroupMember(groupId, userId int) (bool, error)
+	GetGroupMembers(groupId int) ([]User, error)
+	Subscribe(filter EventFilter) *Subscription
+	GetEvents(filter EventFilter, afterId int64, limit int) ([]Event, error)
+	CreateWebhook(webhook Webhook) (*Webhook, error)
+	GetWebhookById(workspaceId, id int) (*Webhook, error)
+	GetWebhooks(workspaceId int) ([]Webhook, error)
+	DeleteWebhook(workspaceId, id int) (bool, error)
+	GetWebhookDeliveries(workspaceId, webhookId, limit int) ([]WebhookDelivery, error)
+	RedeliverWebhookDelivery(workspaceId, webhookId int, deliveryId int64) (*WebhookDelivery, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]PendingDelivery, error)
+	CompleteWebhookDelivery(id int64, result DeliveryResult) error
+	GetWorkflow(documentId int) (*DocumentWorkflow, error)
+	SetWorkflow(documentId int, workflow Workflow, userId int) (*DocumentWorkflow, error)
+	ApproveVersion(documentId, version, userId int) (*Approval, error)
+	WithdrawApproval(documentId, version, userId int) (bool, error)
+	GetApprovals(documentId, version int) ([]Approval, error)
+	TransitionDocument(documentId int, to string, userId int, reason string) (*Transition, error)
+	GetTransitions(documentId int) ([]Transition, error)
+	PublishDocument(documentId, version, userId int) (*Document, error)
+	SchedulePublication(documentId, version int, at time.Time, userId int) (*Document, error)
+	UnpublishDocument(documentId int) (*Document, error)
+	PublishDueDocuments(now time.Time) ([]Document, error)
+	CreateBranch(branch Branch) (*Branch, error)
+	GetBranch(documentId int, name string) (*Branch, error)
+	GetBranches(documentId int) ([]Branch, error)
+	AddBranchDraft(branchId int, content string, userId int) (*BranchDraft, error)
+	GetBranchDraft(branchId, version int) (*BranchDraft, error)
+	GetBranchDrafts(branchId int) ([]BranchDraft, error)
+	MergeBranch(branchId, headVersion int, merged *common.Draft, mergedVersion, userId int) (*Draft, *Branch, error)
+	WatchDocument(documentId, userId int) error
+	UnwatchDocument(documentId, userId int) (bool, error)
+	GetWatchers(documentId int) ([]DocumentWatcher, error)
+	GetNotifications(workspaceId, userId int, options NotificationOptions) ([]Notification, error)
+	CountUnreadNotifications(workspaceId, userId int) (int, error)
+	MarkNotificationsRead(workspaceId, userId int, ids []int64) (int, error)
+	GetNotificationSettings(userId int) (*NotificationSettings, error)
+	SetNotificationSettings(settings NotificationSettings) (*NotificationSettings, error)
+	ClaimDigests(now time.Time, limit int) ([]Digest, error)
+	ReleaseDigest(digest Digest) error
+	Close() error
+}
+
+var (
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Postgres)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// Backends - The names accepted by OpenStore.
+var Backends = []string{"sqlite", "postgres", "memory"}
+
+// OpenStore - Opens and migrates the named backend. The dsn is the database file
+// for sqlite, a connection string for postgres and is ignored for memory.
+func OpenStore(backend, dsn string) (Store, error) {
+	switch backend {
+	case "sqlite":
+		s := &SQLite{}
+		if dsn == "" {
+			return s, s.Initialize()
+		}
+		return s, s.Initialize(dsn)
+	case "postgres":
+		p := &Postgres{}
+		return p, p.Initialize(dsn)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, expected one of %s", backend, strings.Join(Backends, ", "))
+	}
+}
+
+// ErrUsernameTaken - CreateUser was given a username that already exists.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// VersionConflictError - A draft was based on a version that is no longer the document's latest.
+type VersionConflictError struct {
+	BaseVersion int
+	Document    *Document // Nil if the document does not exist
+	Head        *Draft    // The document's latest draft, if any
+}
+
+func (e *VersionConflictError) Error() string {
+	current := 0
+	if e.Document != nil {
+		current = e.Document.LatestVersion
+	}
+	return fmt.Sprintf("draft is based on version %d but the document is at version %d", e.BaseVersion, current)
+}
+
+// errVersionMismatch - Internal signal that a conditional version bump matched no document.
+var errVersionMismatch = errors.New("document version mismatch")
+
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// rebind - Rewrites ? placeholders into the form the dialect expects.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rebind - Rewrites ? placeholders for this store's dialect.
+func (s *sqlStore) rebind(query string) string {
+	return s.dialect.rebind(query)
+}
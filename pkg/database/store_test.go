@@ -0,0 +1,1973 @@
+package database
+
+import (
+	"documentapi/pkg/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeFactories - Every backend the conformance suite runs against. PostgreSQL
+// runs only when DOCUMENTAPI_POSTGRES_DSN points at a disposable database.
+func storeFactories() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemory()
+		},
+		"sqlite": func(t *testing.T) Store {
+			s := &SQLite{}
+			if err := s.Initialize(filepath.Join(t.TempDir(), "conformance.db")); err != nil {
+				t.Fatalf("Failed to initialize SQLite: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"postgres": func(t *testing.T) Store {
+			dsn := os.Getenv("DOCUMENTAPI_POSTGRES_DSN")
+			if dsn == "" {
+				t.Skip("DOCUMENTAPI_POSTGRES_DSN is not set")
+			}
+			p := &Postgres{}
+			if err := p.Open(dsn); err != nil {
+				t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+			}
+			t.Cleanup(func() { p.Close() })
+			migrator, err := p.Migrator()
+			if err != nil {
+				t.Fatalf("Failed to load migrations: %v", err)
+			}
+			// Start every test from an empty schema.
+			if _, err := migrator.Down(0, false); err != nil {
+				t.Fatalf("Failed to reset schema: %v", err)
+			}
+			if _, err := migrator.Up(false); err != nil {
+				t.Fatalf("Failed to migrate: %v", err)
+			}
+			return p
+		},
+	}
+}
+
+// everything - A scope that sees every document, for tests that are not about permissions.
+var everything = Scope{WorkspaceId: DefaultWorkspaceId, Unrestricted: true}
+
+// conformanceTests - Behaviour every Store implementation must share.
+var conformanceTests = []struct {
+	name string
+	run  func(t *testing.T, store Store)
+}{
+	{"CreateDraftVersions", testCreateDraftVersions},
+	{"GetLatestDrafts", testGetLatestDrafts},
+	{"SearchDrafts", testSearchDrafts},
+	{"SearchLatestOnly", testSearchLatestOnly},
+	{"CommentsAndReactions", testCommentsAndReactions},
+	{"ReactionToggling", testReactionToggling},
+	{"CommentAnchors", testCommentAnchors},
+	{"CommentResolution", testCommentResolution},
+	{"UsersAndAPIKeys", testUsersAndAPIKeys},
+	{"DocumentPermissions", testDocumentPermissions},
+	{"Workspaces", testWorkspaces},
+	{"Events", testEvents},
+	{"Webhooks", testWebhooks},
+	{"Workflows", testWorkflows},
+	{"Publishing", testPublishing},
+	{"RestoreDraft", testRestoreDraft},
+	{"Branches", testBranches},
+	{"Suggestions", testSuggestions},
+	{"DraftAuthorship", testDraftAuthorship},
+	{"Notifications", testNotifications},
+	{"Digests", testDigests},
+	{"ConcurrentCreateDraft", testConcurrentCreateDraft},
+	{"BaseVersionConflicts", testBaseVersionConflicts},
+	{"ConcurrentBaseVersion", testConcurrentBaseVersion},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for backend, newStore := range storeFactories() {
+		newStore := newStore
+		t.Run(backend, func(t *testing.T) {
+			for _, tc := range conformanceTests {
+				tc := tc
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, newStore(t))
+				})
+			}
+		})
+	}
+}
+
+func mustCreateDraft(t *testing.T, store Store, name, content string) *Draft {
+	t.Helper()
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: name, Content: content})
+	if err != nil {
+		t.Fatalf("Failed to create draft %q: %v", name, err)
+	}
+	return draft
+}
+
+func testCreateDraftVersions(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "alpha", "one")
+	mustCreateDraft(t, store, "alpha", "two")
+	mustCreateDraft(t, store, "beta", "only")
+
+	alpha, err := store.GetDocumentByName(DefaultWorkspaceId, "alpha")
+	if err != nil || alpha == nil {
+		t.Fatalf("Expected document alpha, got %v (%v)", alpha, err)
+	}
+	if alpha.LatestVersion != 2 {
+		t.Errorf("Expected alpha at version 2, got %d", alpha.LatestVersion)
+	}
+
+	byId, err := store.GetDocumentById(DefaultWorkspaceId, alpha.Id)
+	if err != nil || byId == nil || byId.Name != "alpha" {
+		t.Errorf("Expected GetDocumentById to find alpha, got %v (%v)", byId, err)
+	}
+
+	versions, err := store.GetDraftVersions(DefaultWorkspaceId, alpha.Id, 1, 5)
+	if err != nil || len(versions) != 2 || versions[0].Content != "one" || versions[1].Content != "two" {
+		t.Errorf("Expected alpha's versions [one two], got %+v (%v)", versions, err)
+	}
+	if versions, err := store.GetDraftVersions(DefaultWorkspaceId, alpha.Id, 2, 2); err != nil || len(versions) != 1 || versions[0].VersionNumber != 2 {
+		t.Errorf("Expected only version 2, got %+v (%v)", versions, err)
+	}
+	if versions, err := store.GetDraftVersions(DefaultWorkspaceId+1, alpha.Id, 1, 5); err != nil || len(versions) != 0 {
+		t.Errorf("Expected no versions from another workspace, got %+v (%v)", versions, err)
+	}
+
+	missing, err := store.GetDocumentByName(DefaultWorkspaceId, "gamma")
+	if err != nil || missing != nil {
+		t.Errorf("Expected no document for an unknown name, got %v (%v)", missing, err)
+	}
+
+	documents, err := store.GetAllDocumentsLatestVersions(everything)
+	if err != nil {
+		t.Fatalf("Failed to list documents: %v", err)
+	}
+	if len(documents) != 2 || documents[0].Name != "alpha" || documents[1].Name != "beta" {
+		t.Errorf("Expected documents [alpha beta] in creation order, got %+v", documents)
+	}
+}
+
+func testGetLatestDrafts(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "alpha", "a1")
+	mustCreateDraft(t, store, "alpha", "a2")
+	mustCreateDraft(t, store, "alpha", "a3")
+	mustCreateDraft(t, store, "beta", "b1")
+
+	latest, err := store.GetLatestDrafts(everything, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to get latest drafts: %v", err)
+	}
+	if got := draftContents(latest); !equalStrings(got, []string{"a3", "b1"}) {
+		t.Errorf("Expected latest drafts [a3 b1], got %v", got)
+	}
+
+	two, err := store.GetLatestDrafts(everything, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to get latest drafts: %v", err)
+	}
+	if got := draftContents(two); !equalStrings(got, []string{"a3", "a2", "b1"}) {
+		t.Errorf("Expected latest two drafts [a3 a2 b1], got %v", got)
+	}
+
+	all, err := store.GetLatestDrafts(everything, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to get all drafts: %v", err)
+	}
+	if len(all) != 4 || all[0].VersionNumber != 3 {
+		t.Errorf("Expected all 4 drafts, newest version first, got %+v", all)
+	}
+}
+
+func testSearchDrafts(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "alpha", "Bitcoin vault design for the custodia bank")
+	mustCreateDraft(t, store, "beta", "Quarterly report on the bank vault budget")
+	mustCreateDraft(t, store, "gamma", "Vault vault vault: a short note about vaults")
+	mustCreateDraft(t, store, "delta", "Discount of 100% on item_code")
+
+	cases := []struct {
+		query string
+		want  []string // Document names of the expected matches
+	}{
+		{"bitcoin", []string{"alpha"}},
+		{"BANK vault", []string{"alpha", "beta"}},
+		{`"bank vault"`, []string{"beta"}},
+		{"quarter*", []string{"beta"}},
+		{"bitcoin OR quarterly", []string{"alpha", "beta"}},
+		{"vault NOT bank", []string{"gamma"}},
+		{"(bitcoin OR budget) AND custodia", []string{"alpha"}},
+		{"100%", []string{"delta"}},
+		{"%", nil},
+		{"item_code", []string{"delta"}},
+		{"nothing here", nil},
+	}
+	for _, tc := range cases {
+		results, err := store.SearchDrafts(tc.query, SearchOptions{Scope: everything})
+		if tc.want == nil && err != nil && IsSearchSyntaxError(err) {
+			continue
+		}
+		if err != nil {
+			t.Errorf("Search %q failed: %v", tc.query, err)
+			continue
+		}
+		if got := resultDocuments(t, store, results); !equalStrings(got, tc.want) {
+			t.Errorf("Search %q: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	// gamma mentions vault most often relative to its length.
+	ranked, err := store.SearchDrafts("vault", SearchOptions{Scope: everything})
+	if err != nil || len(ranked) != 3 {
+		t.Fatalf("Expected 3 ranked results, got %v (%v)", ranked, err)
+	}
+	if got := resultDocuments(t, store, ranked[:1]); got[0] != "gamma" {
+		t.Errorf("Expected gamma to rank first, got %v", resultDocuments(t, store, ranked))
+	}
+	for i := 1; i < len(ranked); i++ {
+		if ranked[i].Score > ranked[i-1].Score {
+			t.Errorf("Results are not ordered by score: %v", ranked)
+		}
+	}
+	if !strings.Contains(ranked[0].Snippet, "<mark>") {
+		t.Errorf("Expected a highlighted snippet, got %q", ranked[0].Snippet)
+	}
+
+	limited, err := store.SearchDrafts("vault", SearchOptions{Scope: everything, Limit: 1})
+	if err != nil || len(limited) != 1 {
+		t.Errorf("Expected limit to cap results at 1, got %d (%v)", len(limited), err)
+	}
+
+	for _, bad := range []string{`"unterminated`, "vault AND", "(vault", "OR vault", "   "} {
+		if _, err := store.SearchDrafts(bad, SearchOptions{Scope: everything}); !IsSearchSyntaxError(err) {
+			t.Errorf("Expected a syntax error for %q, got %v", bad, err)
+		}
+	}
+}
+
+func testSearchLatestOnly(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "alpha", "first wording of the charter")
+	mustCreateDraft(t, store, "alpha", "second wording of the charter")
+
+	all, err := store.SearchDrafts("charter", SearchOptions{Scope: everything})
+	if err != nil || len(all) != 2 {
+		t.Fatalf("Expected both versions to match, got %v (%v)", all, err)
+	}
+
+	latest, err := store.SearchDrafts("charter", SearchOptions{Scope: everything, LatestOnly: true})
+	if err != nil || len(latest) != 1 || latest[0].VersionNumber != 2 {
+		t.Errorf("Expected only version 2, got %v (%v)", latest, err)
+	}
+
+	old, err := store.SearchDrafts("first", SearchOptions{Scope: everything, LatestOnly: true})
+	if err != nil || len(old) != 0 {
+		t.Errorf("Expected no match in superseded versions, got %v (%v)", old, err)
+	}
+}
+
+// resultDocuments - Names of the documents the results belong to.
+func resultDocuments(t *testing.T, store Store, results []SearchResult) []string {
+	t.Helper()
+	names := make([]string, 0, len(results))
+	for _, result := range results {
+		document, err := store.GetDocumentById(DefaultWorkspaceId, result.DocumentId)
+		if err != nil || document == nil {
+			t.Fatalf("Failed to load document %d: %v", result.DocumentId, err)
+		}
+		names = append(names, document.Name)
+	}
+	return names
+}
+
+func testCommentsAndReactions(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "alpha", "content")
+	drafts, err := store.GetLatestDrafts(everything, 1, 0)
+	if err != nil || len(drafts) != 1 {
+		t.Fatalf("Expected one draft, got %v (%v)", drafts, err)
+	}
+	draftId := drafts[0].Id
+
+	rootId, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: draftId, UserId: 1, Text: "root"})
+	if err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	parent := int(rootId)
+	replyId, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: draftId, UserId: 2, Text: "reply", ParentCommentId: &parent})
+	if err != nil {
+		t.Fatalf("Failed to add reply: %v", err)
+	}
+	if replyId == rootId {
+		t.Fatalf("Expected distinct comment ids, got %d twice", rootId)
+	}
+
+	for _, emoji := range []string{"👍", "🎉"} {
+		if _, err := store.AddReactionToComment(DefaultWorkspaceId, common.Reaction{Id: int(rootId), UserId: 2, Emoji: emoji}); err != nil {
+			t.Fatalf("Failed to add reaction: %v", err)
+		}
+	}
+
+	comments, err := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, draftId, 0)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("Expected 2 comments, got %+v", comments)
+	}
+	if comments[0].Id != int(rootId) || comments[1].Id != int(replyId) {
+		t.Errorf("Expected comments oldest first, got %+v", comments)
+	}
+	if comments[0].Reactions[0].Emoji != "👍" {
+		t.Errorf("Expected reactions in the order they were added, got %+v", comments[0].Reactions)
+	}
+	for _, comment := range comments {
+		switch comment.Text {
+		case "root":
+			if len(comment.Reactions) != 2 || comment.ParentCommentId != nil {
+				t.Errorf("Expected root with 2 reactions and no parent, got %+v", comment)
+			}
+		case "reply":
+			if len(comment.Reactions) != 0 || comment.ParentCommentId == nil || *comment.ParentCommentId != parent {
+				t.Errorf("Expected reply to root without reactions, got %+v", comment)
+			}
+		default:
+			t.Errorf("Unexpected comment %+v", comment)
+		}
+	}
+
+	other, err := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, draftId+1, 0)
+	if err != nil || len(other) != 0 {
+		t.Errorf("Expected no comments on another draft, got %v (%v)", other, err)
+	}
+}
+
+func testReactionToggling(t *testing.T, store Store) {
+	draft := mustCreateDraft(t, store, "reactions", "content")
+	commentId, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: draft.Id, UserId: 1, Text: "ship it"})
+	if err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	events := store.Subscribe(EventFilter{WorkspaceId: DefaultWorkspaceId})
+	defer events.Close()
+	reaction := func(userId int, emoji string) common.Reaction {
+		return common.Reaction{Id: int(commentId), UserId: userId, Emoji: emoji}
+	}
+
+	for i, expected := range []bool{true, false} {
+		if added, err := store.AddReactionToComment(DefaultWorkspaceId, reaction(2, "👍")); err != nil || added != expected {
+			t.Errorf("Adding 👍 time %d: expected %v, got %v (%v)", i+1, expected, added, err)
+		}
+	}
+	store.AddReactionToComment(DefaultWorkspaceId, reaction(3, "🎉"))
+	store.AddReactionToComment(DefaultWorkspaceId, reaction(3, "👍"))
+
+	counts, err := store.GetReactionCounts(DefaultWorkspaceId, int(commentId), 2)
+	expected := []ReactionCount{{Emoji: "👍", Count: 2, ReactedByMe: true}, {Emoji: "🎉", Count: 1}}
+	if err != nil || !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected %+v, got %+v (%v)", expected, counts, err)
+	}
+	comments, _ := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, draft.Id, 3)
+	expected = []ReactionCount{{Emoji: "👍", Count: 2, ReactedByMe: true}, {Emoji: "🎉", Count: 1, ReactedByMe: true}}
+	if len(comments) != 1 || !reflect.DeepEqual(comments[0].Reactions, expected) {
+		t.Errorf("Expected the listing to count reactions as user 3 sees them, got %+v", comments)
+	}
+	if comments, _ = store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, draft.Id, 0); comments[0].Reactions[0].ReactedByMe {
+		t.Errorf("Expected nobody's reactions to be marked without a user, got %+v", comments[0].Reactions)
+	}
+
+	if reacted, err := store.ToggleReaction(DefaultWorkspaceId, reaction(2, "👍")); err != nil || reacted {
+		t.Errorf("Expected toggling 👍 to remove it, got %v (%v)", reacted, err)
+	}
+	if reacted, err := store.ToggleReaction(DefaultWorkspaceId, reaction(2, "🎉")); err != nil || !reacted {
+		t.Errorf("Expected toggling 🎉 to add it, got %v (%v)", reacted, err)
+	}
+	if removed, err := store.RemoveReactionFromComment(DefaultWorkspaceId, reaction(3, "👍")); err != nil || !removed {
+		t.Errorf("Expected user 3's 👍 to be removed, got %v (%v)", removed, err)
+	}
+	if removed, err := store.RemoveReactionFromComment(DefaultWorkspaceId, reaction(3, "👍")); err != nil || removed {
+		t.Errorf("Expected nothing left to remove, got %v (%v)", removed, err)
+	}
+	counts, _ = store.GetReactionCounts(DefaultWorkspaceId, int(commentId), 2)
+	if expected = []ReactionCount{{Emoji: "🎉", Count: 2, ReactedByMe: true}}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, counts)
+	}
+	if added, err := store.AddReactionToComment(DefaultWorkspaceId, reaction(2, "👍")); err != nil || !added {
+		t.Errorf("Expected a removed reaction to be added again, got %v (%v)", added, err)
+	}
+
+	// Only changes are published: three adds, a removal, an add, a removal and the re-add.
+	var types []string
+	for i := 0; i < 7; i++ {
+		select {
+		case event := <-events.Events:
+			types = append(types, event.Type)
+			if event.Type == EventReactionRemoved && len(types) == 4 {
+				var removed ReactionRemoved
+				if err := json.Unmarshal(event.Data, &removed); err != nil || removed.CommentId != int(commentId) || removed.Reaction.UserId != 2 || removed.Reaction.Emoji != "👍" || removed.Reaction.Id == 0 {
+					t.Errorf("Expected the removed reaction in the event, got %+v (%v)", removed, err)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for events, got %v", types)
+		}
+	}
+	expectedTypes := []string{EventReactionAdded, EventReactionAdded, EventReactionAdded, EventReactionRemoved, EventReactionAdded, EventReactionRemoved, EventReactionAdded}
+	if !equalStrings(types, expectedTypes) {
+		t.Errorf("Expected %v, got %v", expectedTypes, types)
+	}
+
+	if _, err := store.ToggleReaction(DefaultWorkspaceId, reaction(2, "👍")); err != nil {
+		t.Fatalf("Failed to toggle: %v", err)
+	}
+	other, _ := store.CreateWorkspace(Workspace{Name: "other"}, 1)
+	for name, change := range map[string]func(int, common.Reaction) (bool, error){
+		"add":    store.AddReactionToComment,
+		"remove": store.RemoveReactionFromComment,
+		"toggle": store.ToggleReaction,
+	} {
+		if _, err := change(other.Id, reaction(2, "👍")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %s in another workspace to be not found, got %v", name, err)
+		}
+	}
+	if _, err := store.GetReactionCounts(other.Id, int(commentId), 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected counts in another workspace to be not found, got %v", err)
+	}
+}
+
+func testConcurrentCreateDraft(t *testing.T, store Store) {
+	const writers = 8
+	const draftsPerWriter = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*draftsPerWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < draftsPerWriter; i++ {
+				content := fmt.Sprintf("writer %d draft %d", w, i)
+				if _, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "contended", Content: content}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent CreateDraft failed: %v", err)
+	}
+
+	documents, err := store.GetAllDocumentsLatestVersions(everything)
+	if err != nil {
+		t.Fatalf("Failed to list documents: %v", err)
+	}
+	if len(documents) != 1 {
+		t.Fatalf("Expected a single document for one name, got %+v", documents)
+	}
+	if documents[0].LatestVersion != writers*draftsPerWriter {
+		t.Errorf("Expected latest version %d, got %d", writers*draftsPerWriter, documents[0].LatestVersion)
+	}
+
+	drafts, err := store.GetLatestDrafts(everything, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to get drafts: %v", err)
+	}
+	if len(drafts) != writers*draftsPerWriter {
+		t.Fatalf("Expected %d drafts, got %d", writers*draftsPerWriter, len(drafts))
+	}
+	seen := make(map[int]bool)
+	for _, draft := range drafts {
+		if draft.DocumentId != documents[0].Id {
+			t.Errorf("Draft %d belongs to unexpected document %d", draft.Id, draft.DocumentId)
+		}
+		if seen[draft.VersionNumber] {
+			t.Errorf("Version %d was assigned more than once", draft.VersionNumber)
+		}
+		seen[draft.VersionNumber] = true
+	}
+	for version := 1; version <= writers*draftsPerWriter; version++ {
+		if !seen[version] {
+			t.Errorf("Version %d is missing", version)
+		}
+	}
+}
+
+func testBaseVersionConflicts(t *testing.T, store Store) {
+	zero, one, three := 0, 1, 3
+
+	created, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "alpha", Content: "v1", BaseVersion: &zero})
+	if err != nil {
+		t.Fatalf("Expected base version 0 to create a new document: %v", err)
+	}
+	if created.VersionNumber != 1 || created.Id == 0 || created.Content != "v1" {
+		t.Errorf("Unexpected created draft %+v", created)
+	}
+
+	fetched, err := store.GetDraftById(DefaultWorkspaceId, created.Id)
+	if err != nil || fetched == nil || fetched.Content != "v1" {
+		t.Errorf("Expected GetDraftById to return the new draft, got %v (%v)", fetched, err)
+	}
+
+	if _, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "alpha", Content: "again", BaseVersion: &zero}); err == nil {
+		t.Errorf("Expected base version 0 to conflict with an existing document")
+	}
+
+	second, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "alpha", Content: "v2", BaseVersion: &one})
+	if err != nil || second.VersionNumber != 2 {
+		t.Fatalf("Expected base version 1 to create version 2, got %v (%v)", second, err)
+	}
+
+	_, err = store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "alpha", Content: "stale", BaseVersion: &one})
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a version conflict, got %v", err)
+	}
+	if conflict.BaseVersion != 1 || conflict.Document == nil || conflict.Document.LatestVersion != 2 {
+		t.Errorf("Unexpected conflict details %+v", conflict)
+	}
+	if conflict.Head == nil || conflict.Head.Content != "v2" {
+		t.Errorf("Expected the conflict to carry the current head, got %+v", conflict.Head)
+	}
+
+	_, err = store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "missing", Content: "x", BaseVersion: &three})
+	if !errors.As(err, &conflict) || conflict.Document != nil {
+		t.Errorf("Expected a conflict without a document, got %v", err)
+	}
+
+	head, err := store.GetDraftByVersion(DefaultWorkspaceId, created.DocumentId, 2)
+	if err != nil || head == nil || head.Content != "v2" {
+		t.Errorf("Expected version 2 to be unchanged by rejected drafts, got %v (%v)", head, err)
+	}
+	if missing, err := store.GetDraftByVersion(DefaultWorkspaceId, created.DocumentId, 3); err != nil || missing != nil {
+		t.Errorf("Expected no version 3, got %v (%v)", missing, err)
+	}
+}
+
+func testConcurrentBaseVersion(t *testing.T, store Store) {
+	mustCreateDraft(t, store, "contended", "v1")
+
+	const writers = 8
+	one := 1
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, conflicted := 0, 0
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			_, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "contended", Content: fmt.Sprint("edit ", w), BaseVersion: &one})
+			var conflict *VersionConflictError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &conflict):
+				conflicted++
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if succeeded != 1 || conflicted != writers-1 {
+		t.Errorf("Expected exactly one writer to win, got %d successes and %d conflicts", succeeded, conflicted)
+	}
+}
+
+func draftContents(drafts []Draft) []string {
+	contents := make([]string, 0, len(drafts))
+	for _, draft := range drafts {
+		contents = append(contents, draft.Content)
+	}
+	return contents
+}
+
+// equalStrings - Compares two string slices ignoring order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPostgresRebind(t *testing.T) {
+	got := dialectPostgres.rebind(`SELECT Id FROM documents WHERE Name = ? AND Note = '?' AND Id > ?`)
+	want := `SELECT Id FROM documents WHERE Name = $1 AND Note = '?' AND Id > $2`
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if query := `SELECT ?`; dialectSQLite.rebind(query) != query {
+		t.Errorf("Expected SQLite queries to be left unchanged")
+	}
+}
+
+func testCommentAnchors(t *testing.T, store Store) {
+	v1 := mustCreateDraft(t, store, "anchored", "Intro line\nThe quick brown fox.\nClosing line")
+
+	anchored := map[string]*Anchor{
+		"range": {Start: 15, End: 26},  // "quick brown"
+		"lines": {StartLine: 3},        // "Closing line"
+		"quote": {Quote: "Intro line"}, // Deleted in v2
+	}
+	ids := make(map[int]string)
+	for text, anchor := range anchored {
+		id, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: v1.Id, UserId: 1, Text: text, Anchor: anchor})
+		if err != nil {
+			t.Fatalf("Failed to add %s comment: %v", text, err)
+		}
+		ids[int(id)] = text
+	}
+
+	comments, err := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, v1.Id, 0)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	for _, comment := range comments {
+		if comment.Anchor == nil {
+			t.Fatalf("Expected %q to carry its anchor", comment.Text)
+		}
+		want := map[string]Anchor{
+			"range": {Start: 15, End: 26, StartLine: 2, EndLine: 2, Quote: "quick brown"},
+			"lines": {Start: 32, End: 44, StartLine: 3, EndLine: 3, Quote: "Closing line"},
+			"quote": {Start: 0, End: 10, StartLine: 1, EndLine: 1, Quote: "Intro line"},
+		}[comment.Text]
+		if *comment.Anchor != want {
+			t.Errorf("%s: expected anchor %+v, got %+v", comment.Text, want, *comment.Anchor)
+		}
+	}
+
+	for _, bad := range []Anchor{{Start: 5, End: 500}, {StartLine: 9}, {Quote: "missing"}, {Start: 0, End: 5, Quote: "Other"}, {}} {
+		bad := bad
+		if _, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: v1.Id, UserId: 1, Text: "bad", Anchor: &bad}); !IsAnchorError(err) {
+			t.Errorf("Expected an anchor error for %+v, got %v", bad, err)
+		}
+	}
+
+	v2 := mustCreateDraft(t, store, "anchored", "The slow brown fox.\nClosing line")
+	anchors, err := store.GetCommentAnchorsByDraftId(DefaultWorkspaceId, v2.Id)
+	if err != nil {
+		t.Fatalf("Failed to get anchors: %v", err)
+	}
+	if len(anchors) != 3 {
+		t.Fatalf("Expected all 3 anchors on v2, got %+v", anchors)
+	}
+	want := []struct {
+		text     string
+		quote    string
+		orphaned bool
+	}{
+		{"range", "brown", false},
+		{"lines", "Closing line", false},
+		{"quote", "Intro line", true},
+	}
+	for i, w := range want {
+		anchor := anchors[i]
+		if ids[anchor.CommentId] != w.text || anchor.DraftId != v2.Id || anchor.Quote != w.quote || anchor.Orphaned != w.orphaned {
+			t.Errorf("Anchor %d: expected %s quoting %q (orphaned %v), got %+v", i, w.text, w.quote, w.orphaned, anchor)
+		}
+	}
+	if anchors[1].StartLine != 2 || anchors[1].Start != 20 {
+		t.Errorf("Expected the closing line anchor to move up a line, got %+v", anchors[1])
+	}
+
+	// Orphaned anchors stay orphaned on later versions.
+	v3 := mustCreateDraft(t, store, "anchored", "Intro line\nThe slow brown fox.\nClosing line")
+	anchors, err = store.GetCommentAnchorsByDraftId(DefaultWorkspaceId, v3.Id)
+	if err != nil || len(anchors) != 3 || !anchors[2].Orphaned {
+		t.Errorf("Expected the orphaned anchor to carry forward, got %+v (%v)", anchors, err)
+	}
+}
+
+func testCommentResolution(t *testing.T, store Store) {
+	v1 := mustCreateDraft(t, store, "reviewed", "content")
+
+	add := func(draftId int, text string, parent *int) int {
+		id, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: draftId, UserId: 1, Text: text, ParentCommentId: parent})
+		if err != nil {
+			t.Fatalf("Failed to add comment: %v", err)
+		}
+		return int(id)
+	}
+	root := add(v1.Id, "root", nil)
+	reply := add(v1.Id, "reply", &root)
+	other := add(v1.Id, "other", nil)
+
+	comment, err := store.ResolveThread(DefaultWorkspaceId, reply, 7)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if comment == nil || !comment.Resolved || comment.ThreadId != root || comment.ResolvedBy == nil || *comment.ResolvedBy != 7 || comment.ResolvedAt == nil {
+		t.Fatalf("Expected the reply to be resolved by 7 in thread %d, got %+v", root, comment)
+	}
+	if comment, _ := store.ResolveThread(DefaultWorkspaceId, root, 8); *comment.ResolvedBy != 7 {
+		t.Errorf("Expected resolving twice to keep the first resolver, got %+v", comment)
+	}
+	if comment, _ := store.GetCommentById(DefaultWorkspaceId, other); comment.Resolved {
+		t.Errorf("Expected other threads to stay open, got %+v", comment)
+	}
+	if comment, err := store.ResolveThread(DefaultWorkspaceId, 999, 7); comment != nil || err != nil {
+		t.Errorf("Expected nil for a missing comment, got %+v (%v)", comment, err)
+	}
+
+	// Only the open thread carries forward.
+	v2 := mustCreateDraft(t, store, "reviewed", "content v2")
+	comments, err := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, v2.Id, 0)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	if len(comments) != 1 || comments[0].Id != other || comments[0].DraftId != v1.Id {
+		t.Fatalf("Expected only the open comment carried onto v2, got %+v", comments)
+	}
+
+	// Replying to a resolved thread reopens it, and it carries forward from then on.
+	add(v1.Id, "late reply", &root)
+	if comment, _ := store.GetCommentById(DefaultWorkspaceId, root); comment.Resolved || comment.ResolvedBy != nil {
+		t.Errorf("Expected the reply to reopen the thread, got %+v", comment)
+	}
+	v3 := mustCreateDraft(t, store, "reviewed", "content v3")
+	if comments, _ = store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, v3.Id, 0); len(comments) != 4 {
+		t.Errorf("Expected all 4 open comments on v3, got %+v", comments)
+	}
+
+	if comment, _ := store.ResolveThread(DefaultWorkspaceId, root, 7); !comment.Resolved {
+		t.Fatalf("Expected the thread to resolve again")
+	}
+	if comment, _ := store.ReopenThread(DefaultWorkspaceId, reply); comment.Resolved || comment.ResolvedAt != nil {
+		t.Errorf("Expected the thread to reopen, got %+v", comment)
+	}
+}
+
+func testUsersAndAPIKeys(t *testing.T, store Store) {
+	alice, err := store.CreateUser(User{Username: "alice", DisplayName: "Alice"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := store.CreateUser(User{Username: "alice"}); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken, got %v", err)
+	}
+	if user, err := store.GetUserByUsername("alice"); err != nil || user == nil || user.Id != alice.Id {
+		t.Errorf("Expected to find alice by name, got %+v (%v)", user, err)
+	}
+	if user, err := store.GetUserById(999); user != nil || err != nil {
+		t.Errorf("Expected nil for a missing user, got %+v (%v)", user, err)
+	}
+
+	key, err := store.CreateAPIKey(APIKey{UserId: alice.Id, Name: "laptop", Prefix: "dca_1234"}, "hash-1")
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if user, err := store.GetUserByAPIKey("hash-1"); err != nil || user == nil || user.Id != alice.Id {
+		t.Errorf("Expected the key to belong to alice, got %+v (%v)", user, err)
+	}
+
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	if revoked, _ := store.RevokeAPIKey(bob.Id, key.Id); revoked {
+		t.Errorf("Expected bob not to be able to revoke alice's key")
+	}
+	if revoked, err := store.RevokeAPIKey(alice.Id, key.Id); !revoked || err != nil {
+		t.Errorf("Expected alice to revoke her key, got %v (%v)", revoked, err)
+	}
+	if user, _ := store.GetUserByAPIKey("hash-1"); user != nil {
+		t.Errorf("Expected a revoked key not to authenticate, got %+v", user)
+	}
+
+	keys, err := store.GetAPIKeysByUserId(alice.Id)
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt == nil || keys[0].Name != "laptop" {
+		t.Errorf("Expected one revoked key listed, got %+v (%v)", keys, err)
+	}
+}
+
+func testDocumentPermissions(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	carol, _ := store.CreateUser(User{Username: "carol"})
+
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "plan", Content: "secret plan", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "other", Content: "secret other", UserId: carol.Id})
+
+	if role, err := store.GetDocumentRole(draft.DocumentId, alice.Id); role != RoleOwner || err != nil {
+		t.Errorf("Expected the creator to own the document, got %q (%v)", role, err)
+	}
+	if role, _ := store.GetDocumentRole(draft.DocumentId, bob.Id); role != RoleNone {
+		t.Errorf("Expected bob to have no role, got %q", role)
+	}
+	if _, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "plan", Content: "bob's plan", UserId: bob.Id}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied for bob, got %v", err)
+	}
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, draft.DocumentId); document.LatestVersion != 1 {
+		t.Errorf("Expected a denied draft not to bump the version, got %d", document.LatestVersion)
+	}
+
+	visible := func(userId int) []string {
+		documents, err := store.GetAllDocumentsLatestVersions(Scope{WorkspaceId: DefaultWorkspaceId, UserId: userId})
+		if err != nil {
+			t.Fatalf("Failed to list documents: %v", err)
+		}
+		var names []string
+		for _, document := range documents {
+			names = append(names, document.Name)
+		}
+		return names
+	}
+	if got := visible(bob.Id); len(got) != 0 {
+		t.Errorf("Expected bob to see nothing, got %v", got)
+	}
+	if got := visible(alice.Id); !equalStrings(got, []string{"plan"}) {
+		t.Errorf("Expected alice to see only plan, got %v", got)
+	}
+
+	// Sharing through a group.
+	group, err := store.CreateGroup(Group{WorkspaceId: DefaultWorkspaceId, Name: "reviewers", OwnerId: carol.Id})
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if _, err := store.CreateGroup(Group{WorkspaceId: DefaultWorkspaceId, Name: "reviewers", OwnerId: bob.Id}); !errors.Is(err, ErrGroupNameTaken) {
+		t.Errorf("Expected ErrGroupNameTaken, got %v", err)
+	}
+	if err := store.AddGroupMember(group.Id, bob.Id); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	store.AddGroupMember(group.Id, bob.Id)
+	if members, err := store.GetGroupMembers(group.Id); err != nil || len(members) != 2 {
+		t.Errorf("Expected carol and bob in the group, got %+v (%v)", members, err)
+	}
+	if _, err := store.SetDocumentPermission(DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: PrincipalGroup, PrincipalId: group.Id, Role: RoleCommenter}); err != nil {
+		t.Fatalf("Failed to share with group: %v", err)
+	}
+	if _, err := store.SetDocumentPermission(DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: PrincipalUser, PrincipalId: bob.Id, Role: RoleViewer}); err != nil {
+		t.Fatalf("Failed to share with bob: %v", err)
+	}
+	if role, _ := store.GetDocumentRole(draft.DocumentId, bob.Id); role != RoleCommenter {
+		t.Errorf("Expected bob's strongest role to be commenter, got %q", role)
+	}
+	if got := visible(bob.Id); !equalStrings(got, []string{"plan"}) {
+		t.Errorf("Expected bob to see plan, got %v", got)
+	}
+	if drafts, _ := store.GetLatestDrafts(Scope{WorkspaceId: DefaultWorkspaceId, UserId: bob.Id}, 0, 0); !equalStrings(draftContents(drafts), []string{"secret plan"}) {
+		t.Errorf("Expected bob's drafts to be scoped, got %v", draftContents(drafts))
+	}
+	results, err := store.SearchDrafts("secret", SearchOptions{Scope: Scope{WorkspaceId: DefaultWorkspaceId, UserId: bob.Id}})
+	if err != nil || len(results) != 1 || results[0].DocumentId != draft.DocumentId {
+		t.Errorf("Expected bob's search to be scoped, got %+v (%v)", results, err)
+	}
+	if results, _ := store.SearchDrafts("secret", SearchOptions{}); len(results) != 0 {
+		t.Errorf("Expected the zero scope to match nothing, got %+v", results)
+	}
+
+	permissions, err := store.GetDocumentPermissions(draft.DocumentId)
+	if err != nil || len(permissions) != 3 || permissions[0].PrincipalType != PrincipalGroup {
+		t.Errorf("Expected the group then two users, got %+v (%v)", permissions, err)
+	}
+
+	// The last user owner cannot be demoted or removed.
+	if _, err := store.SetDocumentPermission(DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: PrincipalUser, PrincipalId: alice.Id, Role: RoleEditor}); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner when demoting, got %v", err)
+	}
+	if _, err := store.RemoveDocumentPermission(draft.DocumentId, PrincipalUser, alice.Id); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner when removing, got %v", err)
+	}
+	if role, _ := store.GetDocumentRole(draft.DocumentId, alice.Id); role != RoleOwner {
+		t.Errorf("Expected alice to still own the document, got %q", role)
+	}
+	store.SetDocumentPermission(DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: PrincipalUser, PrincipalId: bob.Id, Role: RoleOwner})
+	if removed, err := store.RemoveDocumentPermission(draft.DocumentId, PrincipalUser, alice.Id); !removed || err != nil {
+		t.Errorf("Expected alice's role to be removed once bob owns it, got %v (%v)", removed, err)
+	}
+	if removed, _ := store.RemoveDocumentPermission(draft.DocumentId, PrincipalUser, alice.Id); removed {
+		t.Errorf("Expected removing a missing permission to report false")
+	}
+
+	// Leaving the group drops the roles it granted.
+	if removed, err := store.RemoveGroupMember(group.Id, carol.Id); !removed || err != nil {
+		t.Errorf("Expected carol to leave the group, got %v (%v)", removed, err)
+	}
+	if role, _ := store.GetDocumentRole(draft.DocumentId, carol.Id); role != RoleNone {
+		t.Errorf("Expected carol to lose the group's role, got %q", role)
+	}
+}
+
+func testWorkspaces(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+
+	acme, err := store.CreateWorkspace(Workspace{Name: "acme"}, alice.Id)
+	if err != nil || acme.Role != WorkspaceRoleAdmin {
+		t.Fatalf("Failed to create workspace: %+v (%v)", acme, err)
+	}
+	globex, _ := store.CreateWorkspace(Workspace{Name: "globex"}, bob.Id)
+
+	// The same name is a different document in each workspace.
+	ours, err := store.CreateDraft(common.Draft{WorkspaceId: acme.Id, Name: "plan", Content: "acme plan", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	theirs, err := store.CreateDraft(common.Draft{WorkspaceId: globex.Id, Name: "plan", Content: "globex plan", UserId: bob.Id})
+	if err != nil {
+		t.Fatalf("Expected the name to be free in another workspace, got %v", err)
+	}
+	if ours.DocumentId == theirs.DocumentId || theirs.VersionNumber != 1 {
+		t.Errorf("Expected separate documents at version 1, got %+v and %+v", ours, theirs)
+	}
+	if document, _ := store.GetDocumentByName(acme.Id, "plan"); document == nil || document.Id != ours.DocumentId || document.WorkspaceId != acme.Id {
+		t.Errorf("Expected acme's plan by name, got %+v", document)
+	}
+
+	commentId, err := store.AddCommentToDraft(acme.Id, Comment{DraftId: ours.Id, UserId: alice.Id, Text: "ours"})
+	if err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	parent := int(commentId)
+
+	// Nothing in acme can be read or written through globex.
+	if document, err := store.GetDocumentById(globex.Id, ours.DocumentId); document != nil || err != nil {
+		t.Errorf("Expected no document across workspaces, got %+v (%v)", document, err)
+	}
+	if draft, err := store.GetDraftById(globex.Id, ours.Id); draft != nil || err != nil {
+		t.Errorf("Expected no draft across workspaces, got %+v (%v)", draft, err)
+	}
+	if draft, _ := store.GetDraftByVersion(globex.Id, ours.DocumentId, 1); draft != nil {
+		t.Errorf("Expected no draft version across workspaces, got %+v", draft)
+	}
+	if comment, _ := store.GetCommentById(globex.Id, parent); comment != nil {
+		t.Errorf("Expected no comment across workspaces, got %+v", comment)
+	}
+	if comments, err := store.GetCommentsAndReactionsByDraftId(globex.Id, ours.Id, 0); len(comments) != 0 || err != nil {
+		t.Errorf("Expected no comments across workspaces, got %+v (%v)", comments, err)
+	}
+	if anchors, err := store.GetCommentAnchorsByDraftId(globex.Id, ours.Id); len(anchors) != 0 || err != nil {
+		t.Errorf("Expected no anchors across workspaces, got %+v (%v)", anchors, err)
+	}
+	if _, err := store.AddCommentToDraft(globex.Id, Comment{DraftId: ours.Id, UserId: bob.Id, Text: "leak"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound commenting across workspaces, got %v", err)
+	}
+	if _, err := store.AddCommentToDraft(globex.Id, Comment{DraftId: theirs.Id, UserId: bob.Id, Text: "leak", ParentCommentId: &parent}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound replying across workspaces, got %v", err)
+	}
+	if _, err := store.AddReactionToComment(globex.Id, common.Reaction{Id: parent, UserId: bob.Id, Emoji: "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound reacting across workspaces, got %v", err)
+	}
+	if comment, _ := store.ResolveThread(globex.Id, parent, bob.Id); comment != nil {
+		t.Errorf("Expected no thread to resolve across workspaces, got %+v", comment)
+	}
+	if comment, _ := store.GetCommentById(acme.Id, parent); comment.Resolved {
+		t.Errorf("Expected the thread to stay open")
+	}
+
+	// Even an unrestricted scope stays inside its workspace.
+	unrestricted := Scope{WorkspaceId: globex.Id, Unrestricted: true}
+	if documents, _ := store.GetAllDocumentsLatestVersions(unrestricted); len(documents) != 1 || documents[0].Id != theirs.DocumentId {
+		t.Errorf("Expected only globex's document, got %+v", documents)
+	}
+	if drafts, _ := store.GetLatestDrafts(unrestricted, 0, 0); !equalStrings(draftContents(drafts), []string{"globex plan"}) {
+		t.Errorf("Expected only globex's drafts, got %v", draftContents(drafts))
+	}
+	if results, _ := store.SearchDrafts("plan", SearchOptions{Scope: unrestricted}); len(results) != 1 || results[0].Id != theirs.Id {
+		t.Errorf("Expected only globex's drafts to match, got %+v", results)
+	}
+
+	// Group names are unique per workspace.
+	if _, err := store.CreateGroup(Group{WorkspaceId: acme.Id, Name: "reviewers", OwnerId: alice.Id}); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	group, err := store.CreateGroup(Group{WorkspaceId: globex.Id, Name: "reviewers", OwnerId: bob.Id})
+	if err != nil {
+		t.Fatalf("Expected the group name to be free in another workspace, got %v", err)
+	}
+	if fetched, _ := store.GetGroupById(group.Id); fetched.WorkspaceId != globex.Id {
+		t.Errorf("Expected the group to belong to globex, got %+v", fetched)
+	}
+
+	// Membership.
+	if role, err := store.GetWorkspaceRole(acme.Id, bob.Id); role != WorkspaceRoleNone || err != nil {
+		t.Errorf("Expected bob not to be in acme, got %q (%v)", role, err)
+	}
+	member, err := store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: acme.Id, UserId: bob.Id, Role: WorkspaceRoleMember})
+	if err != nil || member.Username != "bob" || member.CreatedAt.IsZero() {
+		t.Fatalf("Failed to add bob to acme: %+v (%v)", member, err)
+	}
+	workspaces, err := store.GetWorkspacesByUserId(bob.Id)
+	if err != nil || len(workspaces) != 2 || workspaces[0].Id != globex.Id || workspaces[1].Role != WorkspaceRoleMember {
+		t.Errorf("Expected globex then acme for bob, got %+v (%v)", workspaces, err)
+	}
+	if members, err := store.GetWorkspaceMembers(acme.Id); err != nil || len(members) != 2 || members[0].Username != "alice" {
+		t.Errorf("Expected alice then bob in acme, got %+v (%v)", members, err)
+	}
+
+	// The last admin cannot be demoted or removed.
+	if _, err := store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: acme.Id, UserId: alice.Id, Role: WorkspaceRoleMember}); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin when demoting, got %v", err)
+	}
+	if _, err := store.RemoveWorkspaceMember(acme.Id, alice.Id); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin when removing, got %v", err)
+	}
+	if role, _ := store.GetWorkspaceRole(acme.Id, alice.Id); role != WorkspaceRoleAdmin {
+		t.Errorf("Expected alice to still be an admin, got %q", role)
+	}
+	if removed, err := store.RemoveWorkspaceMember(acme.Id, bob.Id); !removed || err != nil {
+		t.Errorf("Expected bob to be removed, got %v (%v)", removed, err)
+	}
+	if removed, _ := store.RemoveWorkspaceMember(acme.Id, bob.Id); removed {
+		t.Errorf("Expected removing a missing member to report false")
+	}
+}
+
+func testEvents(t *testing.T, store Store) {
+	admin, _ := store.CreateUser(User{Username: "admin"})
+	other, _ := store.CreateWorkspace(Workspace{Name: "other"}, admin.Id)
+	everywhere := store.Subscribe(EventFilter{WorkspaceId: DefaultWorkspaceId})
+	defer everywhere.Close()
+
+	first := mustCreateDraft(t, store, "first", "one")
+	second := mustCreateDraft(t, store, "second", "two")
+	onFirst := store.Subscribe(EventFilter{WorkspaceId: DefaultWorkspaceId, DocumentId: first.DocumentId})
+	defer onFirst.Close()
+
+	store.CreateDraft(common.Draft{WorkspaceId: other.Id, Name: "elsewhere", Content: "x"})
+	commentId, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: first.Id, UserId: 1, Text: "hello"})
+	if err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	if _, err := store.AddReactionToComment(DefaultWorkspaceId, common.Reaction{Id: int(commentId), UserId: 2, Emoji: "👍"}); err != nil {
+		t.Fatalf("Failed to add reaction: %v", err)
+	}
+	mustCreateDraft(t, store, "second", "two again")
+
+	receive := func(subscription *Subscription) Event {
+		t.Helper()
+		select {
+		case event := <-subscription.Events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for an event")
+			return Event{}
+		}
+	}
+	var types []string
+	for i := 0; i < 5; i++ {
+		types = append(types, receive(everywhere).Type)
+	}
+	if !equalStrings(types, []string{EventDraftCreated, EventDraftCreated, EventCommentAdded, EventReactionAdded, EventDraftCreated}) {
+		t.Errorf("Expected the workspace's events in order, got %v", types)
+	}
+
+	comment := receive(onFirst)
+	var added Comment
+	if err := json.Unmarshal(comment.Data, &added); err != nil || comment.Type != EventCommentAdded || added.Id != int(commentId) || added.Text != "hello" || added.ThreadId != added.Id {
+		t.Errorf("Expected the comment.added event with the comment, got %+v %+v (%v)", comment, added, err)
+	}
+	reaction := receive(onFirst)
+	var reacted ReactionAdded
+	if err := json.Unmarshal(reaction.Data, &reacted); err != nil || reacted.CommentId != int(commentId) || reacted.Reaction.Emoji != "👍" || reacted.Reaction.Id == 0 {
+		t.Errorf("Expected the reaction.added event with the reaction, got %+v (%v)", reacted, err)
+	}
+	if reaction.DocumentId != first.DocumentId || reaction.DraftId != first.Id {
+		t.Errorf("Expected the reaction to be on the first document, got %+v", reaction)
+	}
+	select {
+	case event := <-onFirst.Events:
+		t.Errorf("Expected no events from other documents, got %+v", event)
+	default:
+	}
+
+	// The log replays what a subscriber missed.
+	logged, err := store.GetEvents(EventFilter{WorkspaceId: DefaultWorkspaceId}, 0, 0)
+	if err != nil || len(logged) != 5 {
+		t.Fatalf("Expected 5 logged events, got %+v (%v)", logged, err)
+	}
+	if logged[1].DocumentId != second.DocumentId || logged[1].Id <= logged[0].Id {
+		t.Errorf("Expected events oldest first, got %+v", logged)
+	}
+	after, err := store.GetEvents(EventFilter{WorkspaceId: DefaultWorkspaceId, DocumentId: first.DocumentId}, logged[0].Id, 1)
+	if err != nil || len(after) != 1 || after[0].Type != EventCommentAdded || string(after[0].Data) != string(comment.Data) {
+		t.Errorf("Expected the comment after the first event, got %+v (%v)", after, err)
+	}
+	if elsewhere, _ := store.GetEvents(EventFilter{WorkspaceId: other.Id}, 0, 0); len(elsewhere) != 1 {
+		t.Errorf("Expected the other workspace's own event, got %+v", elsewhere)
+	}
+
+	onFirst.Close()
+	onFirst.Close()
+	if _, open := <-onFirst.Events; open {
+		t.Errorf("Expected a closed subscription's channel to be closed")
+	}
+}
+
+func testWebhooks(t *testing.T, store Store) {
+	admin, _ := store.CreateUser(User{Username: "admin"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	other, _ := store.CreateWorkspace(Workspace{Name: "other"}, admin.Id)
+	store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: DefaultWorkspaceId, UserId: admin.Id, Role: WorkspaceRoleAdmin})
+	store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: DefaultWorkspaceId, UserId: bob.Id, Role: WorkspaceRoleMember})
+	createDraft := func(workspaceId, userId int, name, content string) *Draft {
+		t.Helper()
+		draft, err := store.CreateDraft(common.Draft{WorkspaceId: workspaceId, UserId: userId, Name: name, Content: content})
+		if err != nil {
+			t.Fatalf("Failed to create draft %q: %v", name, err)
+		}
+		return draft
+	}
+	first := createDraft(DefaultWorkspaceId, admin.Id, "first", "one") // Before any webhook, so never delivered
+
+	create := func(webhook Webhook) *Webhook {
+		t.Helper()
+		webhook.CreatedBy = admin.Id
+		created, err := store.CreateWebhook(webhook)
+		if err != nil || created.Id == 0 {
+			t.Fatalf("Failed to create webhook: %+v (%v)", created, err)
+		}
+		return created
+	}
+	everything := create(Webhook{WorkspaceId: DefaultWorkspaceId, URL: "http://ci.example/hook", Secret: "s1"})
+	comments := create(Webhook{WorkspaceId: DefaultWorkspaceId, URL: "http://chat.example/hook", Secret: "s2", EventTypes: []string{EventCommentAdded}})
+	onFirst := create(Webhook{WorkspaceId: DefaultWorkspaceId, DocumentId: first.DocumentId, URL: "http://first.example/hook", Secret: "s3"})
+	elsewhere := create(Webhook{WorkspaceId: other.Id, URL: "http://other.example/hook", Secret: "s4"})
+
+	createDraft(DefaultWorkspaceId, admin.Id, "first", "two")
+	if _, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: first.Id, UserId: admin.Id, Text: "hello"}); err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	createDraft(DefaultWorkspaceId, admin.Id, "second", "one")
+	createDraft(DefaultWorkspaceId, bob.Id, "private", "one") // The admin cannot see it, so never delivered
+	createDraft(other.Id, admin.Id, "elsewhere", "x")
+
+	deliveryTypes := func(webhookId int) []string {
+		t.Helper()
+		deliveries, err := store.GetWebhookDeliveries(DefaultWorkspaceId, webhookId, 0)
+		if err != nil {
+			t.Fatalf("Failed to list deliveries: %v", err)
+		}
+		types := []string{}
+		for _, delivery := range deliveries {
+			types = append(types, delivery.EventType)
+		}
+		return types
+	}
+	if types := deliveryTypes(everything.Id); !equalStrings(types, []string{EventDraftCreated, EventCommentAdded, EventDraftCreated}) {
+		t.Errorf("Expected every event newest first, got %v", types)
+	}
+	if types := deliveryTypes(comments.Id); !equalStrings(types, []string{EventCommentAdded}) {
+		t.Errorf("Expected only the comment, got %v", types)
+	}
+	if types := deliveryTypes(onFirst.Id); !equalStrings(types, []string{EventCommentAdded, EventDraftCreated}) {
+		t.Errorf("Expected only the first document's events, got %v", types)
+	}
+	if types := deliveryTypes(elsewhere.Id); len(types) != 0 {
+		t.Errorf("Expected another workspace's deliveries to be hidden, got %v", types)
+	}
+	if limited, _ := store.GetWebhookDeliveries(DefaultWorkspaceId, everything.Id, 1); len(limited) != 1 || limited[0].Status != DeliveryPending {
+		t.Errorf("Expected one pending delivery, got %+v", limited)
+	}
+
+	// Claims hold deliveries for the lease and count an attempt.
+	now := time.Now()
+	claimed, err := store.ClaimWebhookDeliveries(now, time.Minute, 10)
+	if err != nil || len(claimed) != 7 {
+		t.Fatalf("Expected all 7 deliveries to be claimed, got %d (%v)", len(claimed), err)
+	}
+	for _, pending := range claimed {
+		if pending.Attempts != 1 || pending.URL == "" || pending.Secret == "" || pending.Event.Id != pending.EventId || pending.Event.Type != pending.EventType || len(pending.Event.Data) == 0 {
+			t.Errorf("Expected a claimed delivery with its webhook and event, got %+v", pending)
+		}
+	}
+	if again, _ := store.ClaimWebhookDeliveries(now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("Expected leased deliveries not to be claimed twice, got %d", len(again))
+	}
+	if limited, _ := store.ClaimWebhookDeliveries(now.Add(2*time.Minute), time.Minute, 2); len(limited) != 2 || limited[0].Attempts != 2 {
+		t.Errorf("Expected two expired leases to be claimed again, got %+v", limited)
+	}
+
+	delivered, failed, retried := claimed[0], claimed[1], claimed[2]
+	store.CompleteWebhookDelivery(delivered.Id, DeliveryResult{Status: DeliveryDelivered, ResponseStatus: 204, AttemptedAt: now})
+	store.CompleteWebhookDelivery(failed.Id, DeliveryResult{Status: DeliveryFailed, ResponseStatus: 500, Error: "boom", AttemptedAt: now})
+	store.CompleteWebhookDelivery(retried.Id, DeliveryResult{Status: DeliveryPending, Error: "timeout", AttemptedAt: now, NextAttemptAt: now.Add(time.Hour)})
+	later, err := store.ClaimWebhookDeliveries(now.Add(10*time.Minute), time.Minute, 10)
+	if err != nil || len(later) != 4 {
+		t.Errorf("Expected the 4 unfinished deliveries to be retried, got %d (%v)", len(later), err)
+	}
+	if retries, _ := store.ClaimWebhookDeliveries(now.Add(2*time.Hour), time.Minute, 10); len(retries) != 5 {
+		t.Errorf("Expected the backed off delivery to be due again, got %d", len(retries))
+	}
+
+	deliveries, _ := store.GetWebhookDeliveries(DefaultWorkspaceId, delivered.WebhookId, 0)
+	var recorded *WebhookDelivery
+	for i := range deliveries {
+		if deliveries[i].Id == delivered.Id {
+			recorded = &deliveries[i]
+		}
+	}
+	if recorded == nil || recorded.Status != DeliveryDelivered || recorded.ResponseStatus != 204 || recorded.DeliveredAt == nil || recorded.LastAttemptAt == nil {
+		t.Errorf("Expected the delivery to be recorded as delivered, got %+v", recorded)
+	}
+
+	// Redelivery queues a fresh copy of the same event.
+	redelivery, err := store.RedeliverWebhookDelivery(DefaultWorkspaceId, failed.WebhookId, failed.Id)
+	if err != nil || redelivery == nil || redelivery.Id == failed.Id || redelivery.EventId != failed.EventId || redelivery.Status != DeliveryPending || redelivery.Attempts != 0 {
+		t.Errorf("Expected a new pending delivery of the same event, got %+v (%v)", redelivery, err)
+	}
+	if missing, _ := store.RedeliverWebhookDelivery(other.Id, failed.WebhookId, failed.Id); missing != nil {
+		t.Errorf("Expected no redelivery from another workspace, got %+v", missing)
+	}
+	if missing, _ := store.RedeliverWebhookDelivery(DefaultWorkspaceId, elsewhere.Id, failed.Id); missing != nil {
+		t.Errorf("Expected no redelivery through another webhook, got %+v", missing)
+	}
+
+	if removed, err := store.DeleteWebhook(DefaultWorkspaceId, comments.Id); err != nil || !removed {
+		t.Errorf("Expected the webhook to be deleted, got %v (%v)", removed, err)
+	}
+	if removed, _ := store.DeleteWebhook(DefaultWorkspaceId, elsewhere.Id); removed {
+		t.Errorf("Expected another workspace's webhook to be left alone")
+	}
+	if found, _ := store.GetWebhookById(DefaultWorkspaceId, comments.Id); found != nil {
+		t.Errorf("Expected a deleted webhook to be gone, got %+v", found)
+	}
+	webhooks, err := store.GetWebhooks(DefaultWorkspaceId)
+	if err != nil || len(webhooks) != 2 || webhooks[0].Id != everything.Id || len(webhooks[0].EventTypes) != 0 || webhooks[1].DocumentId != first.DocumentId {
+		t.Errorf("Expected the two remaining webhooks, got %+v (%v)", webhooks, err)
+	}
+	if found, _ := store.GetWebhookById(other.Id, elsewhere.Id); found == nil || found.Secret != "s4" || found.URL != "http://other.example/hook" {
+		t.Errorf("Expected the other workspace's webhook, got %+v", found)
+	}
+}
+
+func testWorkflows(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	carol, _ := store.CreateUser(User{Username: "carol"})
+	first, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "spec", Content: "one", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	documentId := first.DocumentId
+	store.SetDocumentPermission(DocumentPermission{DocumentId: documentId, PrincipalType: PrincipalUser, PrincipalId: bob.Id, Role: RoleCommenter})
+
+	current, err := store.GetWorkflow(documentId)
+	if err != nil || current.State != StateDraft || len(current.Workflow.Transitions) != 4 {
+		t.Fatalf("Expected the default workflow in draft, got %+v (%v)", current, err)
+	}
+
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); !errors.Is(err, ErrTransitionNotAllowed) {
+		t.Errorf("Expected draft to approved to be refused, got %v", err)
+	}
+	if _, err := store.TransitionDocument(documentId, StateInReview, bob.Id, ""); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected a commenter to be refused, got %v", err)
+	}
+	if transition, err := store.TransitionDocument(documentId, StateInReview, alice.Id, "ready"); err != nil || transition.From != StateDraft || transition.VersionNumber != 1 {
+		t.Fatalf("Expected the document to go to review, got %+v (%v)", transition, err)
+	}
+
+	var approvalRequired *ApprovalRequiredError
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); !errors.As(err, &approvalRequired) || approvalRequired.Approvals != 0 {
+		t.Errorf("Expected an approval to be required, got %v", err)
+	}
+	if _, err := store.ApproveVersion(documentId, 1, alice.Id); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("Expected the author's own approval to be refused, got %v", err)
+	}
+	if _, err := store.ApproveVersion(documentId, 2, bob.Id); !errors.Is(err, ErrNotLatestVersion) {
+		t.Errorf("Expected a missing version to be refused, got %v", err)
+	}
+	approval, err := store.ApproveVersion(documentId, 1, bob.Id)
+	if err != nil || approval.DraftId != first.Id || approval.UserId != bob.Id {
+		t.Fatalf("Expected bob's approval of version 1, got %+v (%v)", approval, err)
+	}
+	if again, err := store.ApproveVersion(documentId, 1, bob.Id); err != nil || again.Id != approval.Id {
+		t.Errorf("Expected approving twice to return the first approval, got %+v (%v)", again, err)
+	}
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); err != nil {
+		t.Fatalf("Expected the approved version to be approved, got %v", err)
+	}
+
+	// A new draft invalidates the approval and sends the document back to review.
+	second, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "spec", Content: "two", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	if current, _ := store.GetWorkflow(documentId); current.State != StateInReview {
+		t.Errorf("Expected a new draft to send the document back to review, got %q", current.State)
+	}
+	approvals, err := store.GetApprovals(documentId, 1)
+	if err != nil || len(approvals) != 1 || approvals[0].InvalidatedAt == nil {
+		t.Errorf("Expected the old approval to be invalidated, got %+v (%v)", approvals, err)
+	}
+	if _, err := store.ApproveVersion(documentId, 1, carol.Id); !errors.Is(err, ErrNotLatestVersion) {
+		t.Errorf("Expected an old version to be refused, got %v", err)
+	}
+
+	// A required approver must sign off on the latest version.
+	workflow := DefaultWorkflow()
+	workflow.RequiredApprovers = []int{carol.Id}
+	if _, err := store.SetWorkflow(documentId, workflow, alice.Id); err != nil {
+		t.Fatalf("Failed to set workflow: %v", err)
+	}
+	store.ApproveVersion(documentId, second.VersionNumber, bob.Id)
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); !errors.As(err, &approvalRequired) || len(approvalRequired.Missing) != 1 || approvalRequired.Missing[0] != carol.Id {
+		t.Errorf("Expected carol's approval to be missing, got %v", err)
+	}
+	store.ApproveVersion(documentId, second.VersionNumber, carol.Id)
+	if removed, err := store.WithdrawApproval(documentId, second.VersionNumber, carol.Id); err != nil || !removed {
+		t.Errorf("Expected carol's approval to be withdrawn, got %v (%v)", removed, err)
+	}
+	if removed, _ := store.WithdrawApproval(documentId, second.VersionNumber, carol.Id); removed {
+		t.Errorf("Expected nothing left to withdraw")
+	}
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); !errors.As(err, &approvalRequired) {
+		t.Errorf("Expected the withdrawn approval not to count, got %v", err)
+	}
+	store.ApproveVersion(documentId, second.VersionNumber, carol.Id)
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); err != nil {
+		t.Errorf("Expected both approvals to be enough, got %v", err)
+	}
+
+	// Withdrawing an approval the approved state needs sends the document back to review.
+	store.WithdrawApproval(documentId, second.VersionNumber, carol.Id)
+	if current, _ := store.GetWorkflow(documentId); current.State != StateInReview {
+		t.Errorf("Expected the withdrawal to send the document back to review, got %q", current.State)
+	}
+	store.ApproveVersion(documentId, second.VersionNumber, carol.Id)
+	if _, err := store.TransitionDocument(documentId, StateApproved, alice.Id, ""); err != nil {
+		t.Errorf("Expected the renewed approvals to be enough, got %v", err)
+	}
+
+	// Invalid workflows are refused; one without the current state resets the document.
+	if _, err := store.SetWorkflow(documentId, Workflow{States: []string{"open"}, Initial: "closed"}, alice.Id); !errors.Is(err, ErrInvalidWorkflow) {
+		t.Errorf("Expected an invalid workflow to be refused, got %v", err)
+	}
+	simple := Workflow{States: []string{"open", "closed"}, Initial: "open", Transitions: []WorkflowTransition{{From: "open", To: "closed", Role: RoleOwner}}}
+	updated, err := store.SetWorkflow(documentId, simple, alice.Id)
+	if err != nil || updated.State != "open" || updated.Workflow.MinApprovals != 1 || updated.Workflow.ReturnState != "open" {
+		t.Errorf("Expected the document reset to open with defaults filled in, got %+v (%v)", updated, err)
+	}
+	if current, _ := store.GetWorkflow(documentId); current.State != "open" || current.Workflow.Transitions[0].Role != RoleOwner {
+		t.Errorf("Expected the stored workflow, got %+v", current)
+	}
+
+	transitions, err := store.GetTransitions(documentId)
+	if err != nil {
+		t.Fatalf("Failed to list transitions: %v", err)
+	}
+	var trail []string
+	var moves []Transition
+	approved, withdrawn := 0, 0
+	for _, transition := range transitions {
+		switch {
+		case transition.From != transition.To:
+			trail = append(trail, transition.From+">"+transition.To)
+			moves = append(moves, transition)
+		case transition.Reason == ReasonApproved:
+			approved++
+		case transition.Reason == ReasonApprovalWithdrawn:
+			withdrawn++
+		}
+	}
+	want := []string{"draft>in_review", "in_review>approved", "approved>in_review", "in_review>approved", "approved>in_review", "in_review>approved", "approved>open"}
+	if !equalStrings(trail, want) {
+		t.Errorf("Expected the audit trail %v, got %v", want, trail)
+	}
+	if len(moves) == len(want) && (moves[0].Reason != "ready" || moves[2].Reason != ReasonNewDraft || moves[2].VersionNumber != 2 ||
+		moves[4].Reason != ReasonApprovalWithdrawn || moves[4].UserId != carol.Id || moves[6].Reason != ReasonWorkflowChanged || moves[6].UserId != alice.Id) {
+		t.Errorf("Expected reasons and versions on the audit trail, got %+v", moves)
+	}
+	if approved != 5 || withdrawn != 1 {
+		t.Errorf("Expected 5 approvals and 1 withdrawal on the audit trail, got %d and %d", approved, withdrawn)
+	}
+	if other, _ := store.GetTransitions(documentId + 1); len(other) != 0 {
+		t.Errorf("Expected no transitions for another document, got %+v", other)
+	}
+}
+
+func testPublishing(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	first := mustCreateDraft(t, store, "guide", "one")
+	documentId := first.DocumentId
+
+	// Only approved versions go out; an approval still counts once a newer draft exists.
+	if _, err := store.PublishDocument(documentId, 1, alice.Id); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected an unapproved version not to be published, got %v", err)
+	}
+	if _, err := store.SchedulePublication(documentId, 1, time.Now().Add(time.Hour), alice.Id); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected an unapproved version not to be scheduled, got %v", err)
+	}
+	store.ApproveVersion(documentId, 1, bob.Id)
+	mustCreateDraft(t, store, "guide", "two")
+	if _, err := store.PublishDocument(documentId, 2, alice.Id); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected the unapproved new version not to be published, got %v", err)
+	}
+	store.ApproveVersion(documentId, 2, bob.Id)
+	subscription := store.Subscribe(EventFilter{WorkspaceId: DefaultWorkspaceId, DocumentId: documentId})
+	defer subscription.Close()
+
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, documentId); document.PublishedVersion != 0 || document.PublishedAt != nil {
+		t.Errorf("Expected a new document to be unpublished, got %+v", document)
+	}
+	if _, err := store.PublishDocument(documentId, 3, alice.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing version to be refused, got %v", err)
+	}
+	published, err := store.PublishDocument(documentId, 1, alice.Id)
+	if err != nil || published.PublishedVersion != 1 || published.PublishedBy != alice.Id || published.PublishedAt == nil || published.LatestVersion != 2 {
+		t.Fatalf("Expected version 1 to be published, got %+v (%v)", published, err)
+	}
+	select {
+	case event := <-subscription.Events:
+		if event.Type != EventDocumentPublished || event.DraftId != first.Id {
+			t.Errorf("Expected a document.published event for the first draft, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected a document.published event")
+	}
+
+	// Scheduled publications wait for their time and go out as the user who scheduled them.
+	now := time.Now()
+	if _, err := store.SchedulePublication(documentId, 2, now.Add(time.Hour), bob.Id); err != nil {
+		t.Fatalf("Failed to schedule publication: %v", err)
+	}
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, documentId); document.ScheduledVersion != 2 || document.ScheduledAt == nil || !document.ScheduledAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected version 2 to be scheduled, got %+v", document)
+	}
+	if due, err := store.PublishDueDocuments(now.Add(time.Minute)); err != nil || len(due) != 0 {
+		t.Errorf("Expected nothing due yet, got %+v (%v)", due, err)
+	}
+	due, err := store.PublishDueDocuments(now.Add(2 * time.Hour))
+	if err != nil || len(due) != 1 || due[0].PublishedVersion != 2 || due[0].PublishedBy != bob.Id || due[0].ScheduledVersion != 0 || due[0].ScheduledAt != nil {
+		t.Fatalf("Expected the scheduled version to be published, got %+v (%v)", due, err)
+	}
+	if again, _ := store.PublishDueDocuments(now.Add(2 * time.Hour)); len(again) != 0 {
+		t.Errorf("Expected a schedule to run once, got %+v", again)
+	}
+
+	// Publishing now or unpublishing cancels a schedule.
+	store.SchedulePublication(documentId, 1, now.Add(time.Hour), bob.Id)
+	if document, _ := store.PublishDocument(documentId, 2, alice.Id); document.ScheduledVersion != 0 {
+		t.Errorf("Expected publishing to cancel the schedule, got %+v", document)
+	}
+	store.SchedulePublication(documentId, 1, now.Add(time.Hour), bob.Id)
+	unpublished, err := store.UnpublishDocument(documentId)
+	if err != nil || unpublished.PublishedVersion != 0 || unpublished.PublishedAt != nil || unpublished.ScheduledVersion != 0 {
+		t.Errorf("Expected the document to be unpublished, got %+v (%v)", unpublished, err)
+	}
+	if due, _ := store.PublishDueDocuments(now.Add(2 * time.Hour)); len(due) != 0 {
+		t.Errorf("Expected the cancelled schedule not to run, got %+v", due)
+	}
+	if _, err := store.UnpublishDocument(documentId + 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing document to be refused, got %v", err)
+	}
+
+	var types []string
+	events, _ := store.GetEvents(EventFilter{WorkspaceId: DefaultWorkspaceId, DocumentId: documentId}, 0, 0)
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	want := []string{EventDraftCreated, EventDraftCreated, EventDocumentPublished, EventDocumentPublished, EventDocumentPublished, EventDocumentUnpublished}
+	if !equalStrings(types, want) {
+		t.Errorf("Expected events %v, got %v", want, types)
+	}
+}
+
+func testRestoreDraft(t *testing.T, store Store) {
+	v1 := mustCreateDraft(t, store, "notes", "Intro\nThe plan\nOutro")
+	anchoredId, _ := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: v1.Id, UserId: 1, Text: "anchored", Anchor: &Anchor{Quote: "The plan"}})
+	resolvedId, _ := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: v1.Id, UserId: 1, Text: "resolved"})
+	store.ResolveThread(DefaultWorkspaceId, int(resolvedId), 1)
+	mustCreateDraft(t, store, "notes", "Something else entirely")
+
+	restore := func(version int, carryComments bool) (*Draft, error) {
+		return store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "notes", Content: v1.Content,
+			RestoredFromVersion: version, CarryComments: carryComments})
+	}
+	commentTexts := func(draftId int) []string {
+		t.Helper()
+		comments, err := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, draftId, 0)
+		if err != nil {
+			t.Fatalf("Failed to get comments: %v", err)
+		}
+		texts := []string{}
+		for _, comment := range comments {
+			texts = append(texts, comment.Text)
+		}
+		return texts
+	}
+
+	// Without its comments a restore is an ordinary draft: open comments carry forward and
+	// anchors are re-mapped from the version before.
+	plain, err := restore(1, false)
+	if err != nil || plain.VersionNumber != 3 || plain.RestoredFromVersion != 1 {
+		t.Fatalf("Expected version 3 restored from 1, got %+v (%v)", plain, err)
+	}
+	if found, _ := store.GetDraftById(DefaultWorkspaceId, plain.Id); found.RestoredFromVersion != 1 || found.Content != v1.Content {
+		t.Errorf("Expected the restore to be recorded, got %+v", found)
+	}
+	if texts := commentTexts(plain.Id); !equalStrings(texts, []string{"anchored"}) {
+		t.Errorf("Expected only the open comment, got %v", texts)
+	}
+	if anchors, _ := store.GetCommentAnchorsByDraftId(DefaultWorkspaceId, plain.Id); len(anchors) != 1 || !anchors[0].Orphaned {
+		t.Errorf("Expected the re-mapped anchor to stay orphaned, got %+v", anchors)
+	}
+
+	// Carrying comments brings every comment of the version with its anchor as it was.
+	carried, err := restore(1, true)
+	if err != nil || carried.VersionNumber != 4 {
+		t.Fatalf("Expected version 4, got %+v (%v)", carried, err)
+	}
+	if texts := commentTexts(carried.Id); !equalStrings(texts, []string{"anchored", "resolved"}) {
+		t.Errorf("Expected both comments of version 1, got %v", texts)
+	}
+	anchors, err := store.GetCommentAnchorsByDraftId(DefaultWorkspaceId, carried.Id)
+	if err != nil || len(anchors) != 1 || anchors[0].CommentId != int(anchoredId) || anchors[0].Orphaned || anchors[0].Quote != "The plan" || anchors[0].StartLine != 2 {
+		t.Errorf("Expected the anchor as it was in version 1, got %+v (%v)", anchors, err)
+	}
+
+	if _, err := restore(7, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing version to be refused, got %v", err)
+	}
+	if document, _ := store.GetDocumentByName(DefaultWorkspaceId, "notes"); document.LatestVersion != 4 {
+		t.Errorf("Expected a refused restore not to add a version, got %d", document.LatestVersion)
+	}
+}
+
+func testBranches(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	first := mustCreateDraft(t, store, "guide", "one")
+	documentId := first.DocumentId
+
+	if _, err := store.CreateBranch(Branch{DocumentId: documentId, Name: "rewrite", BaseVersion: 2}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing base version to be refused, got %v", err)
+	}
+	branch, err := store.CreateBranch(Branch{DocumentId: documentId, Name: "rewrite", BaseVersion: 1, CreatedBy: alice.Id})
+	if err != nil || branch.Id == 0 || branch.HeadVersion != 0 || branch.CreatedBy != alice.Id {
+		t.Fatalf("Expected a new branch, got %+v (%v)", branch, err)
+	}
+	if _, err := store.CreateBranch(Branch{DocumentId: documentId, Name: "rewrite", BaseVersion: 1}); !errors.Is(err, ErrBranchExists) {
+		t.Errorf("Expected a taken name to be refused, got %v", err)
+	}
+	if _, err := store.AddBranchDraft(branch.Id+100, "x", alice.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing branch to be refused, got %v", err)
+	}
+
+	// Branch drafts are numbered on their own and leave the document's versions alone.
+	for i, content := range []string{"uno", "une"} {
+		draft, err := store.AddBranchDraft(branch.Id, content, alice.Id)
+		if err != nil || draft.VersionNumber != i+1 || draft.Content != content || draft.CreatedBy != alice.Id {
+			t.Fatalf("Expected branch version %d, got %+v (%v)", i+1, draft, err)
+		}
+	}
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, documentId); document.LatestVersion != 1 {
+		t.Errorf("Expected the document to stay at version 1, got %d", document.LatestVersion)
+	}
+	if found, _ := store.GetBranch(documentId, "rewrite"); found == nil || found.HeadVersion != 2 {
+		t.Errorf("Expected the branch head at version 2, got %+v", found)
+	}
+	if drafts, _ := store.GetBranchDrafts(branch.Id); len(drafts) != 2 || drafts[0].Content != "uno" {
+		t.Errorf("Expected both branch drafts oldest first, got %+v", drafts)
+	}
+	if draft, _ := store.GetBranchDraft(branch.Id, 2); draft == nil || draft.Content != "une" {
+		t.Errorf("Expected branch version 2, got %+v", draft)
+	}
+	if draft, _ := store.GetBranchDraft(branch.Id, 3); draft != nil {
+		t.Errorf("Expected no branch version 3, got %+v", draft)
+	}
+	if found, _ := store.GetBranch(documentId, "other"); found != nil {
+		t.Errorf("Expected no branch named other, got %+v", found)
+	}
+
+	draft, merged, err := store.MergeBranch(branch.Id, 2, nil, 2, alice.Id)
+	if err != nil || draft != nil || merged.MergedHeadVersion != 2 || merged.MergedVersion != 2 || merged.MergedBy != alice.Id || merged.MergedAt == nil {
+		t.Fatalf("Expected the merge to be recorded, got %+v %+v (%v)", draft, merged, err)
+	}
+	if _, stale, _ := store.MergeBranch(branch.Id, 1, nil, 3, alice.Id); stale.MergedHeadVersion != 2 || stale.MergedVersion != 2 {
+		t.Errorf("Expected an older merge not to replace a newer one, got %+v", stale)
+	}
+
+	// A merged draft is written with the merge, or neither is.
+	mergedDraft := func(baseVersion int) *common.Draft {
+		return &common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "guide", Content: "tres", BaseVersion: &baseVersion}
+	}
+	var conflict *VersionConflictError
+	if _, _, err := store.MergeBranch(branch.Id, 3, mergedDraft(0), 0, alice.Id); !errors.As(err, &conflict) {
+		t.Errorf("Expected a stale merge to conflict, got %v", err)
+	}
+	if _, _, err := store.MergeBranch(branch.Id+100, 3, mergedDraft(1), 0, alice.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing branch to be refused, got %v", err)
+	}
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, documentId); document.LatestVersion != 1 {
+		t.Errorf("Expected refused merges not to add a version, got %d", document.LatestVersion)
+	}
+	if found, _ := store.GetBranch(documentId, "rewrite"); found.MergedHeadVersion != 2 {
+		t.Errorf("Expected refused merges not to be recorded, got %+v", found)
+	}
+	draft, merged, err = store.MergeBranch(branch.Id, 3, mergedDraft(1), 0, alice.Id)
+	if err != nil || draft == nil || draft.VersionNumber != 2 || draft.Content != "tres" || merged.MergedVersion != 2 || merged.MergedHeadVersion != 3 {
+		t.Errorf("Expected the merge to add version 2, got %+v %+v (%v)", draft, merged, err)
+	}
+	mustCreateDraft(t, store, "other", "x")
+	if branches, _ := store.GetBranches(documentId); len(branches) != 1 || branches[0].Name != "rewrite" {
+		t.Errorf("Expected the document's one branch, got %+v", branches)
+	}
+}
+
+func testDraftAuthorship(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	write := func(content, summary string, author int, metadata map[string]string) *Draft {
+		t.Helper()
+		draft, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "guide", Content: content,
+			UserId: author, Summary: summary, Metadata: metadata})
+		if err != nil {
+			t.Fatalf("Failed to create draft: %v", err)
+		}
+		return draft
+	}
+
+	first := write("Draft one", "First cut", alice.Id, map[string]string{"ticket": "DOC-1"})
+	if first.AuthorId != alice.Id || first.Summary != "First cut" || first.Metadata["ticket"] != "DOC-1" {
+		t.Fatalf("Expected alice's first cut, got %+v", first)
+	}
+	store.SetDocumentPermission(DocumentPermission{DocumentId: first.DocumentId, PrincipalType: PrincipalUser, PrincipalId: bob.Id, Role: RoleEditor})
+	write("Draft two", "Typos", bob.Id, nil)
+	write("Draft three", "", alice.Id, nil)
+
+	found, err := store.GetDraftById(DefaultWorkspaceId, first.Id)
+	if err != nil || found.AuthorId != alice.Id || found.Summary != "First cut" || len(found.Metadata) != 1 || found.Metadata["ticket"] != "DOC-1" {
+		t.Errorf("Expected the stored authorship of the first draft, got %+v (%v)", found, err)
+	}
+	if found, _ := store.GetDraftByVersion(DefaultWorkspaceId, first.DocumentId, 2); found.AuthorId != bob.Id || found.Metadata != nil {
+		t.Errorf("Expected bob's version 2 without metadata, got %+v", found)
+	}
+
+	if drafts, _ := store.GetLatestDrafts(everything, 1, alice.Id); !equalStrings(draftContents(drafts), []string{"Draft three"}) {
+		t.Errorf("Expected alice's latest draft, got %v", draftContents(drafts))
+	}
+	if drafts, _ := store.GetLatestDrafts(everything, 0, bob.Id); !equalStrings(draftContents(drafts), []string{"Draft two"}) {
+		t.Errorf("Expected bob's only draft, got %v", draftContents(drafts))
+	}
+	if results, err := store.SearchDrafts("draft", SearchOptions{Scope: everything, AuthorId: bob.Id}); err != nil || len(results) != 1 || results[0].AuthorId != bob.Id {
+		t.Errorf("Expected bob's draft alone to match, got %+v (%v)", results, err)
+	}
+
+	versions := func(options HistoryOptions) []int {
+		t.Helper()
+		history, err := store.GetDraftHistory(DefaultWorkspaceId, first.DocumentId, options)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		numbers := []int{}
+		for _, draft := range history {
+			numbers = append(numbers, draft.VersionNumber)
+		}
+		return numbers
+	}
+	for _, tc := range []struct {
+		options HistoryOptions
+		want    string
+	}{
+		{HistoryOptions{}, "[3 2 1]"},
+		{HistoryOptions{AuthorId: alice.Id}, "[3 1]"},
+		{HistoryOptions{Limit: 2}, "[3 2]"},
+		{HistoryOptions{Before: 2}, "[1]"},
+		{HistoryOptions{AuthorId: bob.Id, Before: 2}, "[]"},
+	} {
+		if got := fmt.Sprint(versions(tc.options)); got != tc.want {
+			t.Errorf("%+v: expected versions %s, got %s", tc.options, tc.want, got)
+		}
+	}
+	if history, _ := store.GetDraftHistory(DefaultWorkspaceId+1, first.DocumentId, HistoryOptions{}); len(history) != 0 {
+		t.Errorf("Expected no history from another workspace, got %+v", history)
+	}
+}
+
+func testSuggestions(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	first, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "guide", Content: "The quick brown fox", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	suggest := func(quote, text string) (int64, error) {
+		return store.AddCommentToDraft(DefaultWorkspaceId, Comment{
+			DraftId: first.Id, UserId: alice.Id, Text: "How about " + text,
+			Anchor: &Anchor{Quote: quote}, Suggestion: &Suggestion{Text: text, Status: SuggestionAccepted},
+		})
+	}
+
+	if _, err := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: first.Id, Text: "x", Suggestion: &Suggestion{Text: "y"}}); !IsAnchorError(err) {
+		t.Errorf("Expected a suggestion without an anchor to be refused, got %v", err)
+	}
+	quick, err := suggest("quick", "slow")
+	if err != nil {
+		t.Fatalf("Failed to add suggestion: %v", err)
+	}
+	brown, _ := suggest("brown", "red")
+	plain, _ := store.AddCommentToDraft(DefaultWorkspaceId, Comment{DraftId: first.Id, Text: "Nice"})
+
+	comment, _ := store.GetCommentById(DefaultWorkspaceId, int(quick))
+	if comment.Suggestion == nil || comment.Suggestion.Text != "slow" || comment.Suggestion.Quote != "quick" || comment.Suggestion.Status != SuggestionPending {
+		t.Fatalf("Expected a pending suggestion replacing quick, got %+v", comment.Suggestion)
+	}
+	if comment, _ := store.GetCommentById(DefaultWorkspaceId, int(plain)); comment.Suggestion != nil {
+		t.Errorf("Expected an ordinary comment to have no suggestion, got %+v", comment.Suggestion)
+	}
+
+	rejected, err := store.RejectSuggestion(DefaultWorkspaceId, int(brown), alice.Id)
+	if err != nil || rejected.Suggestion.Status != SuggestionRejected || *rejected.Suggestion.DecidedBy != alice.Id || !rejected.Resolved {
+		t.Errorf("Expected the suggestion to be rejected and resolved, got %+v (%v)", rejected, err)
+	}
+	if _, err := store.RejectSuggestion(DefaultWorkspaceId, int(brown), alice.Id); !errors.Is(err, ErrSuggestionDecided) {
+		t.Errorf("Expected a decided suggestion to be refused, got %v", err)
+	}
+	if _, err := store.RejectSuggestion(DefaultWorkspaceId, int(plain), alice.Id); !errors.Is(err, ErrNotSuggestion) {
+		t.Errorf("Expected an ordinary comment to be refused, got %v", err)
+	}
+	if comment, err := store.RejectSuggestion(DefaultWorkspaceId, 99, alice.Id); comment != nil || err != nil {
+		t.Errorf("Expected nothing for a missing comment, got %+v (%v)", comment, err)
+	}
+
+	accepting := common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "guide", Content: "The slow brown fox", UserId: alice.Id, AcceptSuggestions: []int{int(quick)}}
+	if _, err := store.CreateDraft(common.Draft{WorkspaceId: DefaultWorkspaceId, Name: "guide", AcceptSuggestions: []int{int(brown)}}); !errors.Is(err, ErrSuggestionDecided) {
+		t.Errorf("Expected accepting a rejected suggestion to be refused, got %v", err)
+	}
+	second, err := store.CreateDraft(accepting)
+	if err != nil || second.VersionNumber != 2 {
+		t.Fatalf("Expected version 2, got %+v (%v)", second, err)
+	}
+	accepted, _ := store.GetCommentById(DefaultWorkspaceId, int(quick))
+	if accepted.Suggestion.Status != SuggestionAccepted || accepted.Suggestion.DraftId == nil || *accepted.Suggestion.DraftId != second.Id || !accepted.Resolved {
+		t.Errorf("Expected the suggestion accepted by version 2 and resolved, got %+v", accepted)
+	}
+	comments, _ := store.GetCommentsAndReactionsByDraftId(DefaultWorkspaceId, second.Id, 0)
+	if len(comments) != 1 || comments[0].Id != int(plain) {
+		t.Errorf("Expected only the open comment carried onto version 2, got %+v", comments)
+	}
+	if _, err := store.CreateDraft(accepting); !errors.Is(err, ErrSuggestionDecided) {
+		t.Errorf("Expected a suggestion to be accepted once, got %v", err)
+	}
+	if document, _ := store.GetDocumentById(DefaultWorkspaceId, first.DocumentId); document.LatestVersion != 2 {
+		t.Errorf("Expected refused acceptances to leave the document at version 2, got %d", document.LatestVersion)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	for text, want := range map[string]string{
+		"@alice, have a look":              "[alice]",
+		"cc @Bob and @alice. Also @bob!":   "[bob alice]",
+		"mail alice@example.com or @carol": "[carol]",
+		"(@dave.smith) and @@eve":          "[dave.smith]",
+		"no mentions here":                 "[]",
+	} {
+		if got := fmt.Sprint(ParseMentions(text)); got != want {
+			t.Errorf("%q: expected %s, got %s", text, want, got)
+		}
+	}
+}
+
+func testNotifications(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice"})
+	bob, _ := store.CreateUser(User{Username: "bob"})
+	carol, _ := store.CreateUser(User{Username: "carol"})
+	acme, err := store.CreateWorkspace(Workspace{Name: "acme"}, alice.Id)
+	if err != nil {
+		t.Fatalf("Failed to create workspace: %v", err)
+	}
+	store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: acme.Id, UserId: bob.Id, Role: WorkspaceRoleMember})
+
+	first, err := store.CreateDraft(common.Draft{WorkspaceId: acme.Id, Name: "plan", Content: "v1", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	store.SetDocumentPermission(DocumentPermission{DocumentId: first.DocumentId, PrincipalType: PrincipalUser, PrincipalId: bob.Id, Role: RoleCommenter})
+
+	// Carol is not in the workspace, so mentioning carol notifies nobody.
+	rootId, err := store.AddCommentToDraft(acme.Id, Comment{DraftId: first.Id, UserId: alice.Id, Text: "@bob and @carol, thoughts? @alice"})
+	if err != nil {
+		t.Fatalf("Failed to add comment: %v", err)
+	}
+	inbox := func(userId int, options NotificationOptions) []Notification {
+		t.Helper()
+		notifications, err := store.GetNotifications(acme.Id, userId, options)
+		if err != nil {
+			t.Fatalf("Failed to get notifications: %v", err)
+		}
+		return notifications
+	}
+	mentioned := inbox(bob.Id, NotificationOptions{})
+	if len(mentioned) != 1 || mentioned[0].Reason != NotifyMention || mentioned[0].CommentId != int(rootId) ||
+		mentioned[0].ActorId != alice.Id || mentioned[0].DocumentId != first.DocumentId || mentioned[0].ReadAt != nil {
+		t.Fatalf("Expected bob to be mentioned once, got %+v", mentioned)
+	}
+	if got := inbox(alice.Id, NotificationOptions{}); len(got) != 0 {
+		t.Errorf("Expected alice not to be notified of their own mention, got %+v", got)
+	}
+	if got, _ := store.GetNotifications(acme.Id, carol.Id, NotificationOptions{}); len(got) != 0 {
+		t.Errorf("Expected carol to hear nothing outside their workspaces, got %+v", got)
+	}
+
+	// A reply that also mentions its parent's author notifies them once, for the mention.
+	parent := int(rootId)
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: first.Id, UserId: bob.Id, Text: "Agreed", ParentCommentId: &parent})
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: first.Id, UserId: bob.Id, Text: "@alice see above", ParentCommentId: &parent})
+	reasons := func(notifications []Notification) string {
+		got := []string{}
+		for _, notification := range notifications {
+			got = append(got, string(notification.Reason))
+		}
+		return fmt.Sprint(got)
+	}
+	if got := reasons(inbox(alice.Id, NotificationOptions{})); got != "[mention reply]" {
+		t.Errorf("Expected a mention then a reply for alice, newest first, got %s", got)
+	}
+
+	// Watchers hear of new drafts, but not of their own.
+	if err := store.WatchDocument(first.DocumentId, bob.Id); err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	store.WatchDocument(first.DocumentId, bob.Id)
+	store.WatchDocument(first.DocumentId, alice.Id)
+	if watchers, err := store.GetWatchers(first.DocumentId); err != nil || len(watchers) != 2 || watchers[0].Username != "bob" {
+		t.Errorf("Expected bob then alice watching, got %+v (%v)", watchers, err)
+	}
+	second, _ := store.CreateDraft(common.Draft{WorkspaceId: acme.Id, Name: "plan", Content: "v2", UserId: alice.Id, Summary: "Second pass"})
+	drafts := inbox(bob.Id, NotificationOptions{Limit: 1})
+	if len(drafts) != 1 || drafts[0].Reason != NotifyDraft || drafts[0].DraftId != second.Id || drafts[0].CommentId != 0 || drafts[0].Excerpt != "Second pass" {
+		t.Errorf("Expected bob to hear of the new draft, got %+v", drafts)
+	}
+	if got := reasons(inbox(alice.Id, NotificationOptions{})); got != "[mention reply]" {
+		t.Errorf("Expected alice not to hear of their own draft, got %s", got)
+	}
+	if removed, err := store.UnwatchDocument(first.DocumentId, bob.Id); !removed || err != nil {
+		t.Errorf("Expected bob to stop watching, got %v (%v)", removed, err)
+	}
+	if removed, _ := store.UnwatchDocument(first.DocumentId, bob.Id); removed {
+		t.Errorf("Expected unwatching twice to report false")
+	}
+	store.CreateDraft(common.Draft{WorkspaceId: acme.Id, Name: "plan", Content: "v3", UserId: alice.Id})
+	if got := inbox(bob.Id, NotificationOptions{}); len(got) != 2 {
+		t.Errorf("Expected no notification after unwatching, got %+v", got)
+	}
+
+	// Reading, counting and paging.
+	if count, err := store.CountUnreadNotifications(acme.Id, alice.Id); count != 2 || err != nil {
+		t.Errorf("Expected 2 unread for alice, got %d (%v)", count, err)
+	}
+	newest := inbox(alice.Id, NotificationOptions{})[0]
+	if older := inbox(alice.Id, NotificationOptions{Before: newest.Id}); reasons(older) != "[reply]" {
+		t.Errorf("Expected the reply before the newest, got %s", reasons(older))
+	}
+	if marked, err := store.MarkNotificationsRead(acme.Id, alice.Id, []int64{newest.Id}); marked != 1 || err != nil {
+		t.Errorf("Expected one notification marked, got %d (%v)", marked, err)
+	}
+	if marked, _ := store.MarkNotificationsRead(acme.Id, bob.Id, []int64{newest.Id}); marked != 0 {
+		t.Errorf("Expected bob not to mark alice's notification, got %d", marked)
+	}
+	if unread := inbox(alice.Id, NotificationOptions{UnreadOnly: true}); reasons(unread) != "[reply]" {
+		t.Errorf("Expected only the reply unread, got %s", reasons(unread))
+	}
+	if marked, _ := store.MarkNotificationsRead(acme.Id, alice.Id, nil); marked != 1 {
+		t.Errorf("Expected the rest marked, got %d", marked)
+	}
+	if count, _ := store.CountUnreadNotifications(acme.Id, alice.Id); count != 0 {
+		t.Errorf("Expected nothing unread, got %d", count)
+	}
+
+	// Losing access to the document hides its notifications.
+	store.RemoveDocumentPermission(first.DocumentId, PrincipalUser, bob.Id)
+	if count, _ := store.CountUnreadNotifications(acme.Id, bob.Id); count != 0 {
+		t.Errorf("Expected bob's notifications hidden once bob lost access, got %d", count)
+	}
+}
+
+func testDigests(t *testing.T, store Store) {
+	alice, _ := store.CreateUser(User{Username: "alice", Email: "alice@example.com"})
+	bob, _ := store.CreateUser(User{Username: "bob", Email: "bob@example.com"})
+	carol, _ := store.CreateUser(User{Username: "carol"})
+
+	if settings, err := store.GetNotificationSettings(bob.Id); err != nil || settings.DigestFrequency != DefaultDigestFrequency || settings.LastDigestAt != nil {
+		t.Errorf("Expected the default settings, got %+v (%v)", settings, err)
+	}
+	if settings, err := store.SetNotificationSettings(NotificationSettings{UserId: bob.Id, DigestFrequency: DigestHourly}); err != nil || settings.DigestFrequency != DigestHourly {
+		t.Errorf("Expected hourly digests, got %+v (%v)", settings, err)
+	}
+
+	acme, err := store.CreateWorkspace(Workspace{Name: "acme"}, alice.Id)
+	if err != nil {
+		t.Fatalf("Failed to create workspace: %v", err)
+	}
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: acme.Id, Name: "plan", Content: "v1", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	for _, user := range []*User{bob, carol} {
+		store.SetWorkspaceMember(WorkspaceMember{WorkspaceId: acme.Id, UserId: user.Id, Role: WorkspaceRoleMember})
+		store.SetDocumentPermission(DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: PrincipalUser, PrincipalId: user.Id, Role: RoleCommenter})
+	}
+	// Carol has no email address, so only bob gets a digest.
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob @carol first"})
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob second"})
+
+	now := time.Now()
+	digests, err := store.ClaimDigests(now, 10)
+	if err != nil {
+		t.Fatalf("Failed to claim digests: %v", err)
+	}
+	if len(digests) != 1 || digests[0].User.Id != bob.Id || digests[0].Frequency != DigestHourly || len(digests[0].Items) != 2 {
+		t.Fatalf("Expected bob's hourly digest of two notifications, got %+v", digests)
+	}
+	first := digests[0].Items[0]
+	if first.Excerpt != "@bob @carol first" || first.DocumentName != "plan" || first.ActorName != "alice" || first.EmailedAt == nil {
+		t.Errorf("Expected the oldest notification first with its names, got %+v", first)
+	}
+	if again, _ := store.ClaimDigests(now, 10); len(again) != 0 {
+		t.Errorf("Expected the notifications to be claimed once, got %+v", again)
+	}
+	if settings, _ := store.GetNotificationSettings(bob.Id); settings.LastDigestAt == nil || settings.DigestFrequency != DigestHourly {
+		t.Errorf("Expected the digest to be recorded, got %+v", settings)
+	}
+
+	// A new notification waits for the hour, and one that failed to send goes with it.
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob third"})
+	if early, _ := store.ClaimDigests(now.Add(30*time.Minute), 10); len(early) != 0 {
+		t.Errorf("Expected nothing before the hour is up, got %+v", early)
+	}
+	if err := store.ReleaseDigest(digests[0]); err != nil {
+		t.Fatalf("Failed to release digest: %v", err)
+	}
+	if next, _ := store.ClaimDigests(now.Add(time.Hour), 10); len(next) != 1 || len(next[0].Items) != 3 {
+		t.Errorf("Expected all three notifications in the next digest, got %+v", next)
+	}
+
+	// Read notifications and those of users who turned digests off are not sent.
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob read"})
+	store.MarkNotificationsRead(acme.Id, bob.Id, nil)
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: bob.Id, Text: "@alice hello"})
+	store.SetNotificationSettings(NotificationSettings{UserId: alice.Id, DigestFrequency: DigestOff})
+	if later, _ := store.ClaimDigests(now.Add(48*time.Hour), 10); len(later) != 0 {
+		t.Errorf("Expected nothing to send, got %+v", later)
+	}
+	store.SetNotificationSettings(NotificationSettings{UserId: alice.Id, DigestFrequency: DigestImmediate})
+	if immediate, _ := store.ClaimDigests(now.Add(48*time.Hour), 10); len(immediate) != 1 || immediate[0].User.Id != alice.Id {
+		t.Errorf("Expected alice's digest once turned back on, got %+v", immediate)
+	}
+
+	// Leaving the workspace stops digests of its documents, even with a role on them left behind.
+	store.AddCommentToDraft(acme.Id, Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob gone"})
+	store.RemoveWorkspaceMember(acme.Id, bob.Id)
+	if removed, _ := store.ClaimDigests(now.Add(72*time.Hour), 10); len(removed) != 0 {
+		t.Errorf("Expected nothing for a removed member, got %+v", removed)
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// acceptSuggestions - Marks suggestions accepted by a new draft and resolves their threads.
+// Returns ErrSuggestionDecided unless each is still pending on the draft's document.
+func (s *sqlStore) acceptSuggestions(tx *sql.Tx, draft Draft, commentIds []int, userId int) error {
+	for _, commentId := range commentIds {
+		var threadId int
+		query := `
+            UPDATE comments
+            SET SuggestionStatus = ?, SuggestionDecidedBy = ?, SuggestionDecidedAt = ?, SuggestionDraftId = ?
+            WHERE Id = ? AND SuggestionStatus = ? AND DraftId IN (SELECT Id FROM drafts WHERE DocumentId = ?)
+            RETURNING ThreadId`
+		if err := tx.QueryRow(s.rebind(query), SuggestionAccepted, userId, draft.CreatedAt, draft.Id, commentId,
+			SuggestionPending, draft.DocumentId).Scan(&threadId); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrSuggestionDecided
+			}
+			return err
+		}
+		if err := s.setThreadResolved(tx, threadId, true, &userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectSuggestion - Marks a suggestion rejected by a user and resolves its thread. Returns nil if
+// the comment is not in the workspace, ErrNotSuggestion if it is not a suggestion and
+// ErrSuggestionDecided if it was already accepted or rejected.
+func (s *sqlStore) RejectSuggestion(workspaceId, commentId, userId int) (*Comment, error) {
+	comment, err := s.GetCommentById(workspaceId, commentId)
+	if err != nil || comment == nil {
+		return nil, err
+	}
+	if comment.Suggestion == nil {
+		return nil, ErrNotSuggestion
+	}
+
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        UPDATE comments SET SuggestionStatus = ?, SuggestionDecidedBy = ?, SuggestionDecidedAt = ?
+        WHERE Id = ? AND SuggestionStatus = ?`
+	result, err := tx.Exec(s.rebind(query), SuggestionRejected, userId, time.Now(), commentId, SuggestionPending)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if rejected, err := result.RowsAffected(); err != nil || rejected == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = ErrSuggestionDecided
+		}
+		return nil, err
+	}
+	if err := s.setThreadResolved(tx, comment.ThreadId, true, &userId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetCommentById(workspaceId, commentId)
+}
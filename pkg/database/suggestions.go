@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SuggestionStatus - Whether a suggestion has been decided on.
+type SuggestionStatus string
+
+const (
+	SuggestionPending  SuggestionStatus = "pending"
+	SuggestionAccepted SuggestionStatus = "accepted" // Applied by the draft DraftId
+	SuggestionRejected SuggestionStatus = "rejected"
+)
+
+// Suggestion - A comment's proposal to replace the text it is anchored to, like a tracked
+// change. Quote is the text it replaces as it was when suggested.
+type Suggestion struct {
+	Text      string           `json:"text"`
+	Quote     string           `json:"quote"`
+	Status    SuggestionStatus `json:"status"`
+	DecidedBy *int             `json:"decidedBy,omitempty"`
+	DecidedAt *time.Time       `json:"decidedAt,omitempty"`
+	DraftId   *int             `json:"draftId,omitempty"`
+}
+
+var (
+	// ErrNotSuggestion - A comment that is not a suggestion was accepted or rejected.
+	ErrNotSuggestion = errors.New("the comment is not a suggestion")
+	// ErrSuggestionDecided - A suggestion was already accepted or rejected.
+	ErrSuggestionDecided = errors.New("the suggestion was already accepted or rejected")
+)
+
+// suggestionColumns - The suggestion columns of a comment c, in the order suggestionRow scans them.
+const suggestionColumns = `c.SuggestionText, c.SuggestionQuote, c.SuggestionStatus, c.SuggestionDecidedBy, c.SuggestionDecidedAt, c.SuggestionDraftId`
+
+// suggestionRow - Scans suggestionColumns; a NULL text means the comment is not a suggestion.
+type suggestionRow struct {
+	text       sql.NullString
+	suggestion Suggestion
+}
+
+func (row *suggestionRow) fields() []interface{} {
+	return []interface{}{&row.text, &row.suggestion.Quote, &row.suggestion.Status, &row.suggestion.DecidedBy,
+		&row.suggestion.DecidedAt, &row.suggestion.DraftId}
+}
+
+func (row *suggestionRow) value() *Suggestion {
+	if !row.text.Valid {
+		return nil
+	}
+	suggestion := row.suggestion
+	suggestion.Text = row.text.String
+	return &suggestion
+}
+
+// newSuggestion - The suggestion a new comment makes on its resolved anchor, nil for an ordinary
+// comment. Suggestions must be anchored and start a thread of their own.
+func newSuggestion(comment Comment, anchor Anchor) (*Suggestion, error) {
+	if comment.Suggestion == nil {
+		return nil, nil
+	}
+	if comment.Anchor == nil {
+		return nil, &AnchorError{Message: "a suggestion needs an anchor"}
+	}
+	if comment.ParentCommentId != nil {
+		return nil, &AnchorError{Message: "a reply cannot be a suggestion"}
+	}
+	return &Suggestion{Text: comment.Suggestion.Text, Quote: anchor.Quote, Status: SuggestionPending}, nil
+}
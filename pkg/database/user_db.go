@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreateUser - Creates a user, returning ErrUsernameTaken if the username exists.
+func (s *sqlStore) CreateUser(user User) (*User, error) {
+	user.CreatedAt = time.Now()
+	query := `
+        INSERT INTO users (Username, DisplayName, Email, CreatedAt) VALUES (?, ?, ?, ?)
+        ON CONFLICT (Username) DO NOTHING
+        RETURNING Id`
+	if err := s.QueryRow(s.rebind(query), user.Username, user.DisplayName, user.Email, user.CreatedAt).Scan(&user.Id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserById - Retrieves a user by their ID.
+func (s *sqlStore) GetUserById(id int) (*User, error) {
+	return s.queryUser(`SELECT Id, Username, DisplayName, Email, CreatedAt FROM users WHERE Id = ?`, id)
+}
+
+// GetUserByUsername - Retrieves a user by their username.
+func (s *sqlStore) GetUserByUsername(username string) (*User, error) {
+	return s.queryUser(`SELECT Id, Username, DisplayName, Email, CreatedAt FROM users WHERE Username = ?`, username)
+}
+
+// GetUserByAPIKey - Retrieves the owner of an API key that has not been revoked.
+func (s *sqlStore) GetUserByAPIKey(keyHash string) (*User, error) {
+	query := `
+        SELECT u.Id, u.Username, u.DisplayName, u.Email, u.CreatedAt
+        FROM api_keys k
+        JOIN users u ON u.Id = k.UserId
+        WHERE k.KeyHash = ? AND k.RevokedAt IS NULL`
+	return s.queryUser(query, keyHash)
+}
+
+func (s *sqlStore) queryUser(query string, args ...interface{}) (*User, error) {
+	var user User
+	if err := s.QueryRow(s.rebind(query), args...).Scan(&user.Id, &user.Username, &user.DisplayName, &user.Email, &user.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateAPIKey - Stores a new API key for a user under the hash of the key.
+func (s *sqlStore) CreateAPIKey(key APIKey, keyHash string) (*APIKey, error) {
+	key.CreatedAt = time.Now()
+	key.RevokedAt = nil
+	query := `INSERT INTO api_keys (UserId, Name, Prefix, KeyHash, CreatedAt) VALUES (?, ?, ?, ?, ?) RETURNING Id`
+	if err := s.QueryRow(s.rebind(query), key.UserId, key.Name, key.Prefix, keyHash, key.CreatedAt).Scan(&key.Id); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeysByUserId - Lists a user's API keys, revoked ones included, oldest first.
+func (s *sqlStore) GetAPIKeysByUserId(userId int) ([]APIKey, error) {
+	query := `SELECT Id, UserId, Name, Prefix, CreatedAt, RevokedAt FROM api_keys WHERE UserId = ? ORDER BY Id`
+	rows, err := s.Query(s.rebind(query), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey - Revokes one of a user's keys. Reports false if the user has no such active key.
+func (s *sqlStore) RevokeAPIKey(userId, keyId int) (bool, error) {
+	query := `UPDATE api_keys SET RevokedAt = ? WHERE Id = ? AND UserId = ? AND RevokedAt IS NULL`
+	result, err := s.Exec(s.rebind(query), time.Now(), keyId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreateWebhook - Adds a webhook to webhook.WorkspaceId.
+func (s *sqlStore) CreateWebhook(webhook Webhook) (*Webhook, error) {
+	webhook.CreatedAt = time.Now()
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	query := `
+        INSERT INTO webhooks (WorkspaceId, DocumentId, Url, Secret, EventTypes, CreatedBy, CreatedAt)
+        VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING Id`
+	err := s.QueryRow(s.rebind(query), webhook.WorkspaceId, webhook.DocumentId, webhook.URL, webhook.Secret,
+		joinEventTypes(webhook.EventTypes), webhook.CreatedBy, webhook.CreatedAt).Scan(&webhook.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+const webhookColumns = `Id, WorkspaceId, DocumentId, Url, Secret, EventTypes, CreatedBy, CreatedAt`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var webhook Webhook
+	var eventTypes string
+	if err := row.Scan(&webhook.Id, &webhook.WorkspaceId, &webhook.DocumentId, &webhook.URL, &webhook.Secret,
+		&eventTypes, &webhook.CreatedBy, &webhook.CreatedAt); err != nil {
+		return nil, err
+	}
+	webhook.EventTypes = splitEventTypes(eventTypes)
+	return &webhook, nil
+}
+
+// GetWebhookById - Retrieves a webhook in a workspace by its ID.
+func (s *sqlStore) GetWebhookById(workspaceId, id int) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE Id = ? AND WorkspaceId = ?`
+	webhook, err := scanWebhook(s.QueryRow(s.rebind(query), id, workspaceId))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	return webhook, err
+}
+
+// GetWebhooks - Lists the webhooks of a workspace, oldest first.
+func (s *sqlStore) GetWebhooks(workspaceId int) ([]Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE WorkspaceId = ? ORDER BY Id`
+	rows, err := s.Query(s.rebind(query), workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook - Removes a webhook and its deliveries. Reports false if the workspace has no
+// such webhook.
+func (s *sqlStore) DeleteWebhook(workspaceId, id int) (bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	query := `DELETE FROM webhook_deliveries WHERE WebhookId IN (SELECT Id FROM webhooks WHERE Id = ? AND WorkspaceId = ?)`
+	if _, err := tx.Exec(s.rebind(query), id, workspaceId); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	result, err := tx.Exec(s.rebind(`DELETE FROM webhooks WHERE Id = ? AND WorkspaceId = ?`), id, workspaceId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// queueDeliveries - Adds a delivery of the event to the outbox for every webhook that wants it,
+// in the transaction recording the event. Webhooks whose creator can no longer see the event's
+// document are skipped, as event streams skip them for their subscriber.
+func (s *sqlStore) queueDeliveries(tx *sql.Tx, event Event) error {
+	query := `
+        INSERT INTO webhook_deliveries (WebhookId, EventId, Status, NextAttemptAt, CreatedAt)
+        SELECT w.Id, ?, ?, ?, ? FROM webhooks w
+        WHERE w.WorkspaceId = ? AND (w.DocumentId = 0 OR w.DocumentId = ?) AND (w.EventTypes = '' OR w.EventTypes LIKE ?)
+          AND w.CreatedBy IN (SELECT UserId FROM workspace_members WHERE WorkspaceId = w.WorkspaceId)
+          AND EXISTS (SELECT 1 FROM document_permissions p WHERE p.DocumentId = ? AND ` + principalsOf("w.CreatedBy") + `)`
+	_, err := tx.Exec(s.rebind(query), event.Id, DeliveryPending, event.CreatedAt.UTC(), event.CreatedAt,
+		event.WorkspaceId, event.DocumentId, "%,"+event.Type+",%", event.DocumentId)
+	return err
+}
+
+const deliveryColumns = `
+        d.Id, d.WebhookId, d.EventId, e.Type, d.Status, d.Attempts, d.ResponseStatus, d.LastError,
+        d.NextAttemptAt, d.LastAttemptAt, d.DeliveredAt, d.CreatedAt`
+
+func deliveryFields(delivery *WebhookDelivery) []interface{} {
+	return []interface{}{
+		&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.EventType, &delivery.Status, &delivery.Attempts,
+		&delivery.ResponseStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.LastAttemptAt,
+		&delivery.DeliveredAt, &delivery.CreatedAt,
+	}
+}
+
+// GetWebhookDeliveries - The most recent deliveries to a webhook in a workspace, newest first.
+// At most limit deliveries are returned, capped at MaxDeliveryPage.
+func (s *sqlStore) GetWebhookDeliveries(workspaceId, webhookId, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > MaxDeliveryPage {
+		limit = MaxDeliveryPage
+	}
+	query := `
+        SELECT ` + deliveryColumns + `
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.Id = d.WebhookId
+        JOIN events e ON e.Id = d.EventId
+        WHERE d.WebhookId = ? AND w.WorkspaceId = ?
+        ORDER BY d.Id DESC LIMIT ?`
+	rows, err := s.Query(s.rebind(query), webhookId, workspaceId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := rows.Scan(deliveryFields(&delivery)...); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RedeliverWebhookDelivery - Queues the event of an earlier delivery to a webhook in the
+// workspace to be sent again now, as a new delivery. Nil if there is no such delivery.
+func (s *sqlStore) RedeliverWebhookDelivery(workspaceId, webhookId int, deliveryId int64) (*WebhookDelivery, error) {
+	now := time.Now()
+	query := `
+        INSERT INTO webhook_deliveries (WebhookId, EventId, Status, NextAttemptAt, CreatedAt)
+        SELECT d.WebhookId, d.EventId, ?, ?, ?
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.Id = d.WebhookId
+        WHERE d.Id = ? AND d.WebhookId = ? AND w.WorkspaceId = ?
+        RETURNING Id`
+	var id int64
+	if err := s.QueryRow(s.rebind(query), DeliveryPending, now.UTC(), now, deliveryId, webhookId, workspaceId).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+
+	query = `SELECT ` + deliveryColumns + ` FROM webhook_deliveries d JOIN events e ON e.Id = d.EventId WHERE d.Id = ?`
+	var delivery WebhookDelivery
+	if err := s.QueryRow(s.rebind(query), id).Scan(deliveryFields(&delivery)...); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimWebhookDeliveries - Takes up to limit pending deliveries that are due at now, counting
+// an attempt on each and holding them for lease so no other worker picks them up. A claim that
+// is not completed within the lease is retried.
+func (s *sqlStore) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]PendingDelivery, error) {
+	query := `
+        SELECT ` + deliveryColumns + `,
+               w.Url, w.Secret, e.Id, e.Type, e.WorkspaceId, e.DocumentId, e.DraftId, e.Data, e.CreatedAt
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.Id = d.WebhookId
+        JOIN events e ON e.Id = d.EventId
+        WHERE d.Status = ? AND d.NextAttemptAt <= ?
+        ORDER BY d.NextAttemptAt, d.Id LIMIT ?`
+	rows, err := s.Query(s.rebind(query), DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	due := []PendingDelivery{}
+	for rows.Next() {
+		var pending PendingDelivery
+		var data string
+		fields := append(deliveryFields(&pending.WebhookDelivery), &pending.URL, &pending.Secret, &pending.Event.Id,
+			&pending.Event.Type, &pending.Event.WorkspaceId, &pending.Event.DocumentId, &pending.Event.DraftId, &data,
+			&pending.Event.CreatedAt)
+		if err := rows.Scan(fields...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending.Event.Data = []byte(data)
+		due = append(due, pending)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Attempts doubles as a version, so only one of several workers racing for a delivery wins it.
+	claimed := []PendingDelivery{}
+	query = `
+        UPDATE webhook_deliveries SET Attempts = Attempts + 1, NextAttemptAt = ?
+        WHERE Id = ? AND Status = ? AND Attempts = ?`
+	for _, pending := range due {
+		result, err := s.Exec(s.rebind(query), now.Add(lease).UTC(), pending.Id, DeliveryPending, pending.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		if won, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if won == 0 {
+			continue
+		}
+		pending.Attempts++
+		pending.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, pending)
+	}
+	return claimed, nil
+}
+
+// CompleteWebhookDelivery - Records the outcome of an attempt on a claimed delivery.
+func (s *sqlStore) CompleteWebhookDelivery(id int64, result DeliveryResult) error {
+	next := result.AttemptedAt
+	var deliveredAt *time.Time
+	switch result.Status {
+	case DeliveryPending:
+		next = result.NextAttemptAt
+	case DeliveryDelivered:
+		deliveredAt = &result.AttemptedAt
+	}
+	query := `
+        UPDATE webhook_deliveries
+        SET Status = ?, ResponseStatus = ?, LastError = ?, LastAttemptAt = ?, NextAttemptAt = ?, DeliveredAt = ?
+        WHERE Id = ?`
+	_, err := s.Exec(s.rebind(query), result.Status, result.ResponseStatus, result.Error, result.AttemptedAt,
+		next.UTC(), deliveredAt, id)
+	return err
+}
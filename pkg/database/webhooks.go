@@ -0,0 +1,100 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// EventTypes - Every event type the store publishes, which webhooks can subscribe to.
+var EventTypes = []string{EventDraftCreated, EventCommentAdded, EventReactionAdded, EventReactionRemoved, EventDocumentPublished, EventDocumentUnpublished}
+
+// MaxDeliveryPage - The most deliveries GetWebhookDeliveries returns at once.
+const MaxDeliveryPage = 100
+
+// Webhook - An endpoint events in a workspace are posted to. The secret signs each delivery
+// and is only shown when the webhook is created.
+type Webhook struct {
+	Id          int       `json:"id"`
+	WorkspaceId int       `json:"workspaceId"`
+	DocumentId  int       `json:"documentId,omitempty"` // 0 for every document in the workspace
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	EventTypes  []string  `json:"eventTypes"` // Empty for every type
+	CreatedBy   int       `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Wants - Reports whether the webhook subscribes to an event.
+func (w Webhook) Wants(event Event) bool {
+	if w.WorkspaceId != event.WorkspaceId || (w.DocumentId != 0 && w.DocumentId != event.DocumentId) {
+		return false
+	}
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range w.EventTypes {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// joinEventTypes - The EventTypes column for a list of types: wrapped in commas so a single
+// type can be found with LIKE, or empty for every type.
+func joinEventTypes(eventTypes []string) string {
+	if len(eventTypes) == 0 {
+		return ""
+	}
+	return "," + strings.Join(eventTypes, ",") + ","
+}
+
+func splitEventTypes(column string) []string {
+	column = strings.Trim(column, ",")
+	if column == "" {
+		return []string{}
+	}
+	return strings.Split(column, ",")
+}
+
+// DeliveryStatus - Where a webhook delivery is in its lifecycle.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   // Waiting for its next attempt
+	DeliveryDelivered DeliveryStatus = "delivered" // The receiver answered with a 2xx status
+	DeliveryFailed    DeliveryStatus = "failed"    // Every attempt failed; it can be redelivered
+)
+
+// WebhookDelivery - One event sent, or to be sent, to one webhook.
+type WebhookDelivery struct {
+	Id             int64          `json:"id"`
+	WebhookId      int            `json:"webhookId"`
+	EventId        int64          `json:"eventId"`
+	EventType      string         `json:"eventType"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	ResponseStatus int            `json:"responseStatus,omitempty"` // From the last attempt
+	LastError      string         `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time      `json:"nextAttemptAt"`
+	LastAttemptAt  *time.Time     `json:"lastAttemptAt,omitempty"`
+	DeliveredAt    *time.Time     `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+}
+
+// PendingDelivery - A delivery claimed by a worker, with what it needs to send it.
+type PendingDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+	Event  Event
+}
+
+// DeliveryResult - The outcome of an attempt to deliver.
+type DeliveryResult struct {
+	Status         DeliveryStatus
+	ResponseStatus int
+	Error          string
+	AttemptedAt    time.Time
+	NextAttemptAt  time.Time // When to try again, if Status is DeliveryPending
+}
@@ -0,0 +1,439 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// loadWorkflow - A document's workflow and state, and whether it has a row yet.
+func (s *sqlStore) loadWorkflow(q queryer, documentId int) (*DocumentWorkflow, bool, error) {
+	rows, err := q.Query(s.rebind(`SELECT Config, State, UpdatedAt FROM document_workflows WHERE DocumentId = ?`), documentId)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	current := &DocumentWorkflow{DocumentId: documentId, Workflow: DefaultWorkflow()}
+	if !rows.Next() {
+		current.State = current.Workflow.Initial
+		return current, false, rows.Err()
+	}
+	var config string
+	if err := rows.Scan(&config, &current.State, &current.UpdatedAt); err != nil {
+		return nil, false, err
+	}
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &current.Workflow); err != nil {
+			return nil, false, err
+		}
+	}
+	return current, true, rows.Err()
+}
+
+// lockDocument - Takes the write lock on a document's row, so workflow changes are serialized
+// with the drafts that bump its version. Reports false if the document is not at version, or
+// does not exist if version is 0.
+func (s *sqlStore) lockDocument(tx *sql.Tx, documentId, version int) (bool, error) {
+	query := `UPDATE documents SET LatestVersion = LatestVersion WHERE Id = ? AND (? = 0 OR LatestVersion = ?)`
+	result, err := tx.Exec(s.rebind(query), documentId, version, version)
+	if err != nil {
+		return false, err
+	}
+	locked, err := result.RowsAffected()
+	return locked > 0, err
+}
+
+// moveState - Changes a document's state from one to another, creating its row if needed.
+// Returns ErrTransitionNotAllowed if the document is no longer in from.
+func (s *sqlStore) moveState(tx *sql.Tx, documentId int, exists bool, from, to string, now time.Time) error {
+	var result sql.Result
+	var err error
+	if exists {
+		query := `UPDATE document_workflows SET State = ?, UpdatedAt = ? WHERE DocumentId = ? AND State = ?`
+		result, err = tx.Exec(s.rebind(query), to, now, documentId, from)
+	} else {
+		query := `INSERT INTO document_workflows (DocumentId, Config, State, UpdatedAt) VALUES (?, '', ?, ?) ON CONFLICT (DocumentId) DO NOTHING`
+		result, err = tx.Exec(s.rebind(query), documentId, to, now)
+	}
+	if err != nil {
+		return err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return ErrTransitionNotAllowed
+	}
+	return nil
+}
+
+// recordTransition - Adds a state change to the audit trail, filling in its Id.
+func (s *sqlStore) recordTransition(tx *sql.Tx, transition *Transition) error {
+	query := `
+        INSERT INTO document_transitions (DocumentId, FromState, ToState, VersionNumber, UserId, Reason, CreatedAt)
+        VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING Id`
+	return tx.QueryRow(s.rebind(query), transition.DocumentId, transition.From, transition.To, transition.VersionNumber,
+		transition.UserId, transition.Reason, transition.CreatedAt).Scan(&transition.Id)
+}
+
+// latestVersion - The document's latest version number, 0 if it does not exist.
+func (s *sqlStore) latestVersion(q queryer, documentId int) (int, error) {
+	rows, err := q.Query(s.rebind(`SELECT LatestVersion FROM documents WHERE Id = ?`), documentId)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	version := 0
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+	}
+	return version, rows.Err()
+}
+
+// GetWorkflow - A document's workflow and the state it is in.
+func (s *sqlStore) GetWorkflow(documentId int) (*DocumentWorkflow, error) {
+	current, _, err := s.loadWorkflow(s.DB, documentId)
+	return current, err
+}
+
+// SetWorkflow - Replaces a document's workflow after validating it. A document in a state the
+// new workflow does not have moves to its initial state, recorded as made by userId.
+func (s *sqlStore) SetWorkflow(documentId int, workflow Workflow, userId int) (*DocumentWorkflow, error) {
+	if err := workflow.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := json.Marshal(workflow)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.lockDocument(tx, documentId, 0); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	current, _, err := s.loadWorkflow(tx, documentId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	updated := &DocumentWorkflow{DocumentId: documentId, Workflow: workflow, State: current.State, UpdatedAt: time.Now()}
+	if !workflow.HasState(current.State) {
+		updated.State = workflow.Initial
+		version, err := s.latestVersion(tx, documentId)
+		if err == nil {
+			err = s.recordTransition(tx, &Transition{
+				DocumentId: documentId, From: current.State, To: updated.State, VersionNumber: version,
+				UserId: userId, Reason: ReasonWorkflowChanged, CreatedAt: updated.UpdatedAt,
+			})
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	query := `
+        INSERT INTO document_workflows (DocumentId, Config, State, UpdatedAt) VALUES (?, ?, ?, ?)
+        ON CONFLICT (DocumentId) DO UPDATE SET Config = excluded.Config, State = excluded.State, UpdatedAt = excluded.UpdatedAt`
+	if _, err := tx.Exec(s.rebind(query), documentId, string(config), updated.State, updated.UpdatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+const approvalColumns = `Id, DocumentId, DraftId, VersionNumber, UserId, CreatedAt, InvalidatedAt`
+
+func (s *sqlStore) queryApprovals(q queryer, query string, args ...interface{}) ([]Approval, error) {
+	rows, err := q.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []Approval{}
+	for rows.Next() {
+		var approval Approval
+		if err := rows.Scan(&approval.Id, &approval.DocumentId, &approval.DraftId, &approval.VersionNumber,
+			&approval.UserId, &approval.CreatedAt, &approval.InvalidatedAt); err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, rows.Err()
+}
+
+// ApproveVersion - Records userId's approval of a document version, which must be its latest or
+// ErrNotLatestVersion is returned. The version's author cannot approve it and gets
+// ErrSelfApproval. Approving twice returns the first approval; the first is added to the audit trail.
+func (s *sqlStore) ApproveVersion(documentId, version, userId int) (*Approval, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if latest, err := s.lockDocument(tx, documentId, version); err != nil || !latest || version < 1 {
+		tx.Rollback()
+		if err == nil {
+			err = ErrNotLatestVersion
+		}
+		return nil, err
+	}
+	var authorId int
+	if err := tx.QueryRow(s.rebind(`SELECT AuthorId FROM drafts WHERE DocumentId = ? AND VersionNumber = ?`), documentId, version).Scan(&authorId); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			err = ErrNotLatestVersion
+		}
+		return nil, err
+	}
+	if authorId != 0 && authorId == userId {
+		tx.Rollback()
+		return nil, ErrSelfApproval
+	}
+
+	now := time.Now()
+	query := `
+        INSERT INTO document_approvals (DocumentId, DraftId, VersionNumber, UserId, CreatedAt)
+        SELECT DocumentId, Id, VersionNumber, ?, ? FROM drafts WHERE DocumentId = ? AND VersionNumber = ?
+        ON CONFLICT (DocumentId, VersionNumber, UserId) DO NOTHING`
+	result, err := tx.Exec(s.rebind(query), userId, now, documentId, version)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	added, err := result.RowsAffected()
+	if err == nil && added > 0 {
+		var current *DocumentWorkflow
+		if current, _, err = s.loadWorkflow(tx, documentId); err == nil {
+			err = s.recordTransition(tx, &Transition{
+				DocumentId: documentId, From: current.State, To: current.State, VersionNumber: version,
+				UserId: userId, Reason: ReasonApproved, CreatedAt: now,
+			})
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	approvals, err := s.queryApprovals(tx, `SELECT `+approvalColumns+` FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ? AND UserId = ?`,
+		documentId, version, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if len(approvals) == 0 {
+		return nil, ErrNotLatestVersion
+	}
+	return &approvals[0], nil
+}
+
+// WithdrawApproval - Removes userId's approval of a version and adds the withdrawal to the audit
+// trail. A document in an approved state whose latest version no longer has the approvals it
+// needs returns to its workflow's ReturnState. Reports false if there was no approval.
+func (s *sqlStore) WithdrawApproval(documentId, version, userId int) (bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := s.lockDocument(tx, documentId, 0); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	query := `DELETE FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ? AND UserId = ?`
+	result, err := tx.Exec(s.rebind(query), documentId, version, userId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil || removed == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := s.approvalWithdrawn(tx, documentId, version, userId); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// approvalWithdrawn - Records a withdrawn approval of version in the audit trail, moving the
+// document back to ReturnState if it is approved and the latest version no longer qualifies.
+func (s *sqlStore) approvalWithdrawn(tx *sql.Tx, documentId, version, userId int) error {
+	current, exists, err := s.loadWorkflow(tx, documentId)
+	if err != nil {
+		return err
+	}
+	latest, err := s.latestVersion(tx, documentId)
+	if err != nil {
+		return err
+	}
+	transition := Transition{
+		DocumentId: documentId, From: current.State, To: current.State, VersionNumber: version,
+		UserId: userId, Reason: ReasonApprovalWithdrawn, CreatedAt: time.Now(),
+	}
+	if version == latest && current.Workflow.approved(current.State) && current.State != current.Workflow.ReturnState {
+		approvals, err := s.queryApprovals(tx, `SELECT `+approvalColumns+` FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ? AND InvalidatedAt IS NULL`,
+			documentId, version)
+		if err != nil {
+			return err
+		}
+		if _, ok := current.Workflow.missingApprovals(approvals); !ok {
+			transition.To = current.Workflow.ReturnState
+			if err := s.moveState(tx, documentId, exists, current.State, transition.To, transition.CreatedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return s.recordTransition(tx, &transition)
+}
+
+// GetApprovals - The approvals of a document version, oldest first, including invalidated ones.
+func (s *sqlStore) GetApprovals(documentId, version int) ([]Approval, error) {
+	return s.queryApprovals(s.DB, `SELECT `+approvalColumns+` FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ? ORDER BY Id`,
+		documentId, version)
+}
+
+// TransitionDocument - Moves a document to another state of its workflow as userId. Returns
+// ErrTransitionNotAllowed if the workflow has no such transition from the current state,
+// ErrPermissionDenied if the user's role is too weak and an *ApprovalRequiredError if the latest
+// version lacks the approvals the transition needs.
+func (s *sqlStore) TransitionDocument(documentId int, to string, userId int, reason string) (*Transition, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.lockDocument(tx, documentId, 0); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	current, exists, err := s.loadWorkflow(tx, documentId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	allowed := current.Workflow.Transition(current.State, to)
+	if allowed == nil {
+		tx.Rollback()
+		return nil, ErrTransitionNotAllowed
+	}
+	if role, err := s.documentRole(tx, documentId, userId); err != nil || !role.AtLeast(allowed.Role) {
+		tx.Rollback()
+		if err == nil {
+			err = ErrPermissionDenied
+		}
+		return nil, err
+	}
+
+	version, err := s.latestVersion(tx, documentId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if allowed.RequiresApproval {
+		approvals, err := s.queryApprovals(tx, `SELECT `+approvalColumns+` FROM document_approvals WHERE DocumentId = ? AND VersionNumber = ? AND InvalidatedAt IS NULL`,
+			documentId, version)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if missing, ok := current.Workflow.missingApprovals(approvals); !ok {
+			tx.Rollback()
+			return nil, &ApprovalRequiredError{VersionNumber: version, MinApprovals: current.Workflow.MinApprovals, Approvals: len(approvals), Missing: missing}
+		}
+	}
+
+	transition := Transition{
+		DocumentId: documentId, From: current.State, To: to, VersionNumber: version,
+		UserId: userId, Reason: reason, CreatedAt: time.Now(),
+	}
+	if err := s.moveState(tx, documentId, exists, current.State, to, transition.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := s.recordTransition(tx, &transition); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &transition, nil
+}
+
+// GetTransitions - A document's audit trail of state changes, oldest first.
+func (s *sqlStore) GetTransitions(documentId int) ([]Transition, error) {
+	query := `
+        SELECT Id, DocumentId, FromState, ToState, VersionNumber, UserId, Reason, CreatedAt
+        FROM document_transitions WHERE DocumentId = ? ORDER BY Id`
+	rows, err := s.Query(s.rebind(query), documentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []Transition{}
+	for rows.Next() {
+		var transition Transition
+		if err := rows.Scan(&transition.Id, &transition.DocumentId, &transition.From, &transition.To,
+			&transition.VersionNumber, &transition.UserId, &transition.Reason, &transition.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, rows.Err()
+}
+
+// invalidateApprovals - Called as a new draft is created: approvals of earlier versions stop
+// counting, and a document in an approved state returns to its workflow's ReturnState.
+func (s *sqlStore) invalidateApprovals(tx *sql.Tx, draft Draft, userId int) error {
+	if draft.VersionNumber < 2 {
+		return nil
+	}
+	query := `UPDATE document_approvals SET InvalidatedAt = ? WHERE DocumentId = ? AND VersionNumber < ? AND InvalidatedAt IS NULL`
+	if _, err := tx.Exec(s.rebind(query), draft.CreatedAt, draft.DocumentId, draft.VersionNumber); err != nil {
+		return err
+	}
+
+	current, exists, err := s.loadWorkflow(tx, draft.DocumentId)
+	if err != nil {
+		return err
+	}
+	if !current.Workflow.approved(current.State) || current.State == current.Workflow.ReturnState {
+		return nil
+	}
+	if err := s.moveState(tx, draft.DocumentId, exists, current.State, current.Workflow.ReturnState, draft.CreatedAt); err != nil {
+		return err
+	}
+	return s.recordTransition(tx, &Transition{
+		DocumentId: draft.DocumentId, From: current.State, To: current.Workflow.ReturnState,
+		VersionNumber: draft.VersionNumber, UserId: userId, Reason: ReasonNewDraft, CreatedAt: draft.CreatedAt,
+	})
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Workflow - The review states a document moves through and who may move it. Transitions that
+// require approval need the document's latest version approved by every RequiredApprovers user
+// and by at least MinApprovals users in all.
+type Workflow struct {
+	States            []string             `json:"states"`
+	Initial           string               `json:"initial"`
+	Transitions       []WorkflowTransition `json:"transitions"`
+	RequiredApprovers []int                `json:"requiredApprovers"`
+	MinApprovals      int                  `json:"minApprovals"`
+	ReturnState       string               `json:"returnState"` // Where a new draft sends an approved document, Initial if empty
+}
+
+// WorkflowTransition - A move between two states.
+type WorkflowTransition struct {
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Role             Role   `json:"role"` // The weakest role that may make it, editor if empty
+	RequiresApproval bool   `json:"requiresApproval"`
+}
+
+// Workflow states of DefaultWorkflow.
+const (
+	StateDraft    = "draft"
+	StateInReview = "in_review"
+	StateApproved = "approved"
+)
+
+// DefaultWorkflow - The workflow of documents that have not been given their own: editors send
+// drafts for review and one approval of the latest version, by someone other than its author,
+// approves it. A new draft sends an approved document back to review.
+func DefaultWorkflow() Workflow {
+	return Workflow{
+		States:  []string{StateDraft, StateInReview, StateApproved},
+		Initial: StateDraft,
+		Transitions: []WorkflowTransition{
+			{From: StateDraft, To: StateInReview, Role: RoleEditor},
+			{From: StateInReview, To: StateDraft, Role: RoleEditor},
+			{From: StateInReview, To: StateApproved, Role: RoleEditor, RequiresApproval: true},
+			{From: StateApproved, To: StateDraft, Role: RoleEditor},
+		},
+		RequiredApprovers: []int{},
+		MinApprovals:      1,
+		ReturnState:       StateInReview,
+	}
+}
+
+// ErrInvalidWorkflow - SetWorkflow was given a workflow that is not self-consistent.
+var ErrInvalidWorkflow = errors.New("invalid workflow")
+
+// Validate - Checks states are named once, transitions and the initial and return states use
+// them, and fills in defaults for empty roles, MinApprovals and ReturnState.
+func (w *Workflow) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidWorkflow, fmt.Sprintf(format, args...))
+	}
+	if len(w.States) == 0 {
+		return invalid("at least one state is required")
+	}
+	for i, state := range w.States {
+		if strings.TrimSpace(state) == "" || state != strings.TrimSpace(state) {
+			return invalid("state names must be non-empty without surrounding spaces")
+		}
+		for _, earlier := range w.States[:i] {
+			if earlier == state {
+				return invalid("state %q is listed twice", state)
+			}
+		}
+	}
+	if !w.HasState(w.Initial) {
+		return invalid("initial state %q is not one of the states", w.Initial)
+	}
+	if w.ReturnState == "" {
+		w.ReturnState = w.Initial
+	}
+	if !w.HasState(w.ReturnState) {
+		return invalid("return state %q is not one of the states", w.ReturnState)
+	}
+	for i := range w.Transitions {
+		transition := &w.Transitions[i]
+		if !w.HasState(transition.From) || !w.HasState(transition.To) || transition.From == transition.To {
+			return invalid("transition from %q to %q must join two different states", transition.From, transition.To)
+		}
+		if transition.Role == RoleNone {
+			transition.Role = RoleEditor
+		}
+		if !transition.Role.Valid() {
+			return invalid("transition from %q to %q has unknown role %q", transition.From, transition.To, transition.Role)
+		}
+		if w.Transition(transition.From, transition.To) != transition {
+			return invalid("transition from %q to %q is listed twice", transition.From, transition.To)
+		}
+	}
+	if w.RequiredApprovers == nil {
+		w.RequiredApprovers = []int{}
+	}
+	if w.MinApprovals < len(w.RequiredApprovers) {
+		w.MinApprovals = len(w.RequiredApprovers)
+	}
+	if w.MinApprovals < 1 {
+		w.MinApprovals = 1
+	}
+	return nil
+}
+
+// HasState - Reports whether state is one of the workflow's states.
+func (w Workflow) HasState(state string) bool {
+	for _, known := range w.States {
+		if known == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition - The transition from one state to another, or nil if the workflow has none.
+func (w *Workflow) Transition(from, to string) *WorkflowTransition {
+	for i := range w.Transitions {
+		if w.Transitions[i].From == from && w.Transitions[i].To == to {
+			return &w.Transitions[i]
+		}
+	}
+	return nil
+}
+
+// approved - Reports whether a state can only be entered with approval, so a new draft, which
+// invalidates approvals, sends the document back to ReturnState.
+func (w Workflow) approved(state string) bool {
+	for _, transition := range w.Transitions {
+		if transition.To == state && transition.RequiresApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// missingApprovals - The required approvers absent from approvals, and whether there are enough.
+func (w Workflow) missingApprovals(approvals []Approval) ([]int, bool) {
+	approved := map[int]bool{}
+	for _, approval := range approvals {
+		approved[approval.UserId] = true
+	}
+	missing := []int{}
+	for _, userId := range w.RequiredApprovers {
+		if !approved[userId] {
+			missing = append(missing, userId)
+		}
+	}
+	return missing, len(missing) == 0 && len(approved) >= w.MinApprovals
+}
+
+// approvesPublication - Reports whether a version may be published: the document is in an
+// approved state and the version is its latest, or the version's approvals meet the workflow.
+func (w Workflow) approvesPublication(state string, latest bool, approvals []Approval) bool {
+	if latest && w.approved(state) {
+		return true
+	}
+	_, ok := w.missingApprovals(approvals)
+	return ok
+}
+
+// DocumentWorkflow - A document's workflow and the state it is in.
+type DocumentWorkflow struct {
+	DocumentId int       `json:"documentId"`
+	Workflow   Workflow  `json:"workflow"`
+	State      string    `json:"state"`
+	UpdatedAt  time.Time `json:"updatedAt"` // Zero while the document has never left its initial state
+}
+
+// Approval - A user's sign-off on one version of a document. Approvals stop counting once a
+// newer draft is created.
+type Approval struct {
+	Id            int        `json:"id"`
+	DocumentId    int        `json:"documentId"`
+	DraftId       int        `json:"draftId"`
+	VersionNumber int        `json:"versionNumber"`
+	UserId        int        `json:"userId"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	InvalidatedAt *time.Time `json:"invalidatedAt,omitempty"`
+}
+
+// Transition - An entry in a document's audit trail of state changes. Approving a version and
+// withdrawing an approval are entries too, with From and To the same unless the withdrawal takes
+// the document out of an approved state. UserId 0 marks one the service made itself.
+type Transition struct {
+	Id            int       `json:"id"`
+	DocumentId    int       `json:"documentId"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	VersionNumber int       `json:"versionNumber"`
+	UserId        int       `json:"userId"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Reasons recorded for the transitions the store makes itself.
+const (
+	ReasonNewDraft          = "a new draft invalidated the approvals"
+	ReasonWorkflowChanged   = "the workflow no longer has the previous state"
+	ReasonApproved          = "approved the version"
+	ReasonApprovalWithdrawn = "withdrew their approval"
+)
+
+var (
+	// ErrTransitionNotAllowed - The workflow has no transition from the document's state to the one asked for.
+	ErrTransitionNotAllowed = errors.New("the workflow does not allow this transition")
+	// ErrNotLatestVersion - Only a document's latest version can be approved.
+	ErrNotLatestVersion = errors.New("only the latest version can be approved")
+	// ErrSelfApproval - The author of a version cannot approve it.
+	ErrSelfApproval = errors.New("the author of a version cannot approve it")
+	// ErrNotApproved - Only an approved version can be published or scheduled for publication.
+	ErrNotApproved = errors.New("the version has not been approved")
+)
+
+// ApprovalRequiredError - A transition needs more approvals of the latest version.
+type ApprovalRequiredError struct {
+	VersionNumber int
+	MinApprovals  int
+	Approvals     int
+	Missing       []int // Required approvers who have not approved
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	message := fmt.Sprintf("version %d needs %d approvals and has %d", e.VersionNumber, e.MinApprovals, e.Approvals)
+	if len(e.Missing) > 0 {
+		message += fmt.Sprintf(", still missing required approvers %v", e.Missing)
+	}
+	return message
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreateWorkspace - Creates a workspace with adminId as its first admin.
+func (s *sqlStore) CreateWorkspace(workspace Workspace, adminId int) (*Workspace, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	workspace.CreatedAt = time.Now()
+	query := `INSERT INTO workspaces (Name, CreatedAt) VALUES (?, ?) RETURNING Id`
+	if err := tx.QueryRow(s.rebind(query), workspace.Name, workspace.CreatedAt).Scan(&workspace.Id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	query = `INSERT INTO workspace_members (WorkspaceId, UserId, Role, CreatedAt) VALUES (?, ?, ?, ?)`
+	if _, err := tx.Exec(s.rebind(query), workspace.Id, adminId, WorkspaceRoleAdmin, workspace.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	workspace.Role = WorkspaceRoleAdmin
+	return &workspace, nil
+}
+
+// GetWorkspacesByUserId - The workspaces a user belongs to with their role in each, in the
+// order they joined them.
+func (s *sqlStore) GetWorkspacesByUserId(userId int) ([]Workspace, error) {
+	query := `
+        SELECT w.Id, w.Name, w.CreatedAt, m.Role
+        FROM workspace_members m
+        JOIN workspaces w ON w.Id = m.WorkspaceId
+        WHERE m.UserId = ?
+        ORDER BY m.CreatedAt, w.Id`
+	rows, err := s.Query(s.rebind(query), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.Id, &workspace.Name, &workspace.CreatedAt, &workspace.Role); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetWorkspaceRole - A user's role in a workspace. WorkspaceRoleNone means they are not a member.
+func (s *sqlStore) GetWorkspaceRole(workspaceId, userId int) (WorkspaceRole, error) {
+	return s.workspaceRole(s.DB, workspaceId, userId)
+}
+
+func (s *sqlStore) workspaceRole(q queryer, workspaceId, userId int) (WorkspaceRole, error) {
+	query := `SELECT Role FROM workspace_members WHERE WorkspaceId = ? AND UserId = ?`
+	rows, err := q.Query(s.rebind(query), workspaceId, userId)
+	if err != nil {
+		return WorkspaceRoleNone, err
+	}
+	defer rows.Close()
+
+	role := WorkspaceRoleNone
+	if rows.Next() {
+		if err := rows.Scan(&role); err != nil {
+			return WorkspaceRoleNone, err
+		}
+	}
+	return role, rows.Err()
+}
+
+// SetWorkspaceMember - Adds a user to a workspace or changes their role. Returns ErrLastAdmin if
+// this demotes the only admin.
+func (s *sqlStore) SetWorkspaceMember(member WorkspaceMember) (*WorkspaceMember, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        INSERT INTO workspace_members (WorkspaceId, UserId, Role, CreatedAt) VALUES (?, ?, ?, ?)
+        ON CONFLICT (WorkspaceId, UserId) DO UPDATE SET Role = excluded.Role
+        RETURNING CreatedAt`
+	if err := tx.QueryRow(s.rebind(query), member.WorkspaceId, member.UserId, member.Role, time.Now()).Scan(&member.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := s.checkAdminRemains(tx, member.WorkspaceId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.QueryRow(s.rebind(`SELECT Username FROM users WHERE Id = ?`), member.UserId).Scan(&member.Username); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveWorkspaceMember - Removes a user from a workspace. Reports false if they were not a
+// member and returns ErrLastAdmin if they are its only admin. Permissions they were granted on
+// documents stay, but cannot be used without membership.
+func (s *sqlStore) RemoveWorkspaceMember(workspaceId, userId int) (bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(s.rebind(`DELETE FROM workspace_members WHERE WorkspaceId = ? AND UserId = ?`), workspaceId, userId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := s.checkAdminRemains(tx, workspaceId); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+// checkAdminRemains - Returns ErrLastAdmin if the workspace has no admin left.
+func (s *sqlStore) checkAdminRemains(tx *sql.Tx, workspaceId int) error {
+	query := `SELECT COUNT(*) FROM workspace_members WHERE WorkspaceId = ? AND Role = ?`
+	var admins int
+	if err := tx.QueryRow(s.rebind(query), workspaceId, WorkspaceRoleAdmin).Scan(&admins); err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// GetWorkspaceMembers - Lists the members of a workspace in the order they joined.
+func (s *sqlStore) GetWorkspaceMembers(workspaceId int) ([]WorkspaceMember, error) {
+	query := `
+        SELECT m.WorkspaceId, m.UserId, u.Username, m.Role, m.CreatedAt
+        FROM workspace_members m
+        JOIN users u ON u.Id = m.UserId
+        WHERE m.WorkspaceId = ?
+        ORDER BY m.CreatedAt, m.UserId`
+	rows, err := s.Query(s.rebind(query), workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []WorkspaceMember{}
+	for rows.Next() {
+		var member WorkspaceMember
+		if err := rows.Scan(&member.WorkspaceId, &member.UserId, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultWorkspaceId - The workspace holding everything created before workspaces existed.
+const DefaultWorkspaceId = 1
+
+// WorkspaceRole - What a member may do in a workspace.
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleNone   WorkspaceRole = ""
+	WorkspaceRoleMember WorkspaceRole = "member" // Work with the documents shared with them
+	WorkspaceRoleAdmin  WorkspaceRole = "admin"  // Also manage who belongs to the workspace
+)
+
+// Valid - Reports whether r is a role a member can hold.
+func (r WorkspaceRole) Valid() bool {
+	return r == WorkspaceRoleMember || r == WorkspaceRoleAdmin
+}
+
+// Workspace - A tenant. Documents, groups and their comments never cross workspaces.
+type Workspace struct {
+	Id        int           `json:"id"`
+	Name      string        `json:"name"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Role      WorkspaceRole `json:"role,omitempty"` // The listing user's role, when listed for a user
+}
+
+// WorkspaceMember - A user's membership of a workspace.
+type WorkspaceMember struct {
+	WorkspaceId int           `json:"workspaceId"`
+	UserId      int           `json:"userId"`
+	Username    string        `json:"username"`
+	Role        WorkspaceRole `json:"role"`
+	CreatedAt   time.Time     `json:"createdAt"`
+}
+
+var (
+	// ErrNotFound - A write referred to a draft or comment that is not in the workspace.
+	ErrNotFound = errors.New("not found in this workspace")
+	// ErrLastAdmin - The change would leave a workspace without an admin.
+	ErrLastAdmin = errors.New("a workspace must keep at least one admin")
+)
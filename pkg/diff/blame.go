@@ -0,0 +1,17 @@
+package diff
+
+// Blame - Carries line origins from one version of a text to the next: each line of newText
+// kept from oldText keeps its entry in oldOrigins, and every other line gets origin.
+func Blame[T any](oldText, newText string, oldOrigins []T, origin T) []T {
+	oldLines, newLines := SplitLines(oldText), SplitLines(newText)
+	origins := make([]T, len(newLines))
+	for i := range origins {
+		origins[i] = origin
+	}
+	for _, edit := range Diff(oldLines, newLines) {
+		if edit.Op == Equal {
+			origins[edit.NewIndex] = oldOrigins[edit.OldIndex]
+		}
+	}
+	return origins
+}
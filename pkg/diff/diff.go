@@ -0,0 +1,206 @@
+package diff
+
+import "unicode"
+
+// Op - What happened to one element between the old and new sequence.
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Edit - One step of an edit script. OldIndex is -1 for inserts and NewIndex is -1 for deletes.
+type Edit struct {
+	Op       Op
+	OldIndex int
+	NewIndex int
+}
+
+// maxEditDistance - Beyond this many differences Diff stops searching for a minimal
+// script and reports the rest of the region as replaced, keeping time bounded.
+const maxEditDistance = 4096
+
+// Diff - Computes a shortest edit script turning a into b with the linear space variant of
+// Myers' algorithm, which only keeps two rows of furthest reaching paths at a time.
+func Diff[T comparable](a, b []T) []Edit {
+	limit := (len(a) + len(b) + 1) / 2
+	if limit > maxEditDistance/2 {
+		limit = maxEditDistance / 2
+	}
+	s := &search[T]{
+		a:        a,
+		b:        b,
+		offset:   limit + 1,
+		forward:  make([]int, 2*limit+3),
+		backward: make([]int, 2*limit+3),
+		limit:    limit,
+		edits:    make([]Edit, 0, len(a)+len(b)),
+	}
+	s.compare(0, len(a), 0, len(b))
+	return s.edits
+}
+
+// search - The state of one Diff. forward and backward hold the furthest x reached on each
+// diagonal, indexed from offset, and are reused by every middle snake search.
+type search[T comparable] struct {
+	a, b              []T
+	offset            int
+	forward, backward []int
+	limit             int // Half of the edit distance a middle snake search gives up after
+	edits             []Edit
+}
+
+// compare - Appends the edits turning a[aLo:aHi] into b[bLo:bHi], splitting the regions at
+// their middle snake until one side is empty.
+func (s *search[T]) compare(aLo, aHi, bLo, bHi int) {
+	// Common prefixes and suffixes never need to go through the search.
+	for aLo < aHi && bLo < bHi && s.a[aLo] == s.b[bLo] {
+		s.edits = append(s.edits, Edit{Op: Equal, OldIndex: aLo, NewIndex: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && s.a[aHi-1-suffix] == s.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi || bLo == bHi:
+		s.replace(aLo, aHi, bLo, bHi)
+	default:
+		x, y, u, v, ok := s.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok {
+			s.replace(aLo, aHi, bLo, bHi)
+			break
+		}
+		s.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			s.edits = append(s.edits, Edit{Op: Equal, OldIndex: x, NewIndex: y})
+		}
+		s.compare(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		s.edits = append(s.edits, Edit{Op: Equal, OldIndex: aHi + i, NewIndex: bHi + i})
+	}
+}
+
+// replace - Appends a[aLo:aHi] as deleted and b[bLo:bHi] as inserted.
+func (s *search[T]) replace(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		s.edits = append(s.edits, Edit{Op: Delete, OldIndex: i, NewIndex: -1})
+	}
+	for j := bLo; j < bHi; j++ {
+		s.edits = append(s.edits, Edit{Op: Insert, OldIndex: -1, NewIndex: j})
+	}
+}
+
+// middleSnake - Finds the snake from (x, y) to (u, v) in the middle of a shortest edit script
+// for two non-empty regions by searching from both ends until the paths overlap. Reports false
+// if the regions differ in more than twice limit places.
+func (s *search[T]) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	if limit > s.limit {
+		limit = s.limit
+	}
+	forward, backward, offset := s.forward, s.backward, s.offset
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1] // Step down: insert from b
+			} else {
+				x = forward[offset+k-1] + 1 // Step right: delete from a
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && s.a[aLo+x] == s.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// The backward search has taken d-1 steps; diagonal k is its delta-k.
+			if back := delta - k; odd && back >= -(d-1) && back <= d-1 && x+backward[offset+back] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y, true
+			}
+		}
+
+		// The same search from the ends of both regions, in reversed coordinates.
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && s.a[aHi-1-x] == s.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if ahead := delta - k; !odd && ahead >= -d && ahead <= d && x+forward[offset+ahead] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// SplitLines - Splits text into lines without their line endings. A trailing newline
+// does not produce an empty last line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var lines []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, text[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		lines = append(lines, text[start:])
+	}
+	return lines
+}
+
+// SplitWords - Splits text into runs of letters and digits, runs of whitespace and
+// single punctuation characters. Joining the result gives back the text.
+func SplitWords(text string) []string {
+	var words []string
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		words = append(words, string(runes[i:j]))
+		i = j
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
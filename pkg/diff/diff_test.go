@@ -0,0 +1,289 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiffIsMinimalAndReplays(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		changes int
+	}{
+		{"ABCABBA", "CBABAC", 5},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"same", "same", 0},
+		{"kitten", "sitting", 5},
+	}
+	for _, tc := range cases {
+		a, b := strings.Split(tc.a, ""), strings.Split(tc.b, "")
+		if tc.a == "" {
+			a = nil
+		}
+		if tc.b == "" {
+			b = nil
+		}
+		edits := Diff(a, b)
+
+		changes := 0
+		var replayed []string
+		for _, edit := range edits {
+			switch edit.Op {
+			case Equal:
+				if a[edit.OldIndex] != b[edit.NewIndex] {
+					t.Errorf("%q -> %q: equal edit pairs %q with %q", tc.a, tc.b, a[edit.OldIndex], b[edit.NewIndex])
+				}
+				replayed = append(replayed, a[edit.OldIndex])
+			case Insert:
+				changes++
+				replayed = append(replayed, b[edit.NewIndex])
+			case Delete:
+				changes++
+			}
+		}
+		if strings.Join(replayed, "") != tc.b {
+			t.Errorf("%q -> %q: replaying edits gave %q", tc.a, tc.b, strings.Join(replayed, ""))
+		}
+		if changes != tc.changes {
+			t.Errorf("%q -> %q: expected %d changes, got %d", tc.a, tc.b, tc.changes, changes)
+		}
+	}
+}
+
+// replay - The sequence an edit script produces, failing if it pairs unequal elements.
+func replay(t *testing.T, a, b []int, edits []Edit) (result []int, changes int) {
+	t.Helper()
+	old, new := 0, 0
+	for _, edit := range edits {
+		switch edit.Op {
+		case Equal:
+			if edit.OldIndex != old || edit.NewIndex != new || a[old] != b[new] {
+				t.Fatalf("Equal edit %+v out of order or pairing unequal elements", edit)
+			}
+			result = append(result, a[old])
+			old++
+			new++
+		case Delete:
+			if edit.OldIndex != old {
+				t.Fatalf("Delete %+v out of order", edit)
+			}
+			old++
+			changes++
+		case Insert:
+			if edit.NewIndex != new {
+				t.Fatalf("Insert %+v out of order", edit)
+			}
+			result = append(result, b[new])
+			new++
+			changes++
+		}
+	}
+	return result, changes
+}
+
+func TestDiffMatchesLCS(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := make([]int, random.Intn(40))
+		b := make([]int, random.Intn(40))
+		for j := range a {
+			a[j] = random.Intn(4)
+		}
+		for j := range b {
+			b[j] = random.Intn(4)
+		}
+
+		// The fewest inserts and deletes is everything outside a longest common subsequence.
+		lcs := make([][]int, len(a)+1)
+		for x := range lcs {
+			lcs[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else if lcs[x+1][y] > lcs[x][y+1] {
+					lcs[x][y] = lcs[x+1][y]
+				} else {
+					lcs[x][y] = lcs[x][y+1]
+				}
+			}
+		}
+
+		result, changes := replay(t, a, b, Diff(a, b))
+		if fmt.Sprint(result) != fmt.Sprint(b) {
+			t.Fatalf("%v -> %v: replaying gave %v", a, b, result)
+		}
+		if expected := len(a) + len(b) - 2*lcs[0][0]; changes != expected {
+			t.Fatalf("%v -> %v: expected %d changes, got %d", a, b, expected, changes)
+		}
+	}
+}
+
+func TestDiffGivesUpOnLargeDistances(t *testing.T) {
+	// Nothing in common: far more differences than the search looks for.
+	a := make([]int, 50000)
+	b := make([]int, 50000)
+	for i := range a {
+		a[i] = i
+		b[i] = -i - 1
+	}
+	a[25000], b[25000] = 7, 7
+	result, changes := replay(t, a, b, Diff(a, b))
+	if len(result) != len(b) || changes < 2*len(a)-2 {
+		t.Errorf("Expected a valid script replacing nearly everything, got %d changes", changes)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	oldText := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n"
+	newText := "one\ntwo\nTHREE\nfour\nfive\nsix\nseven\neight\nnine\n"
+
+	hunks, stats := Lines(oldText, newText, 1)
+	if stats.Insertions != 2 || stats.Deletions != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	want := `--- a
++++ b
+@@ -2,3 +2,3 @@
+ two
+-three
++THREE
+ four
+@@ -8 +8,2 @@
+ eight
++nine
+`
+	if got := Unified(hunks, "a", "b"); got != want {
+		t.Errorf("Unexpected unified diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedNewFile(t *testing.T) {
+	hunks, _ := Lines("", "first\nsecond", -1)
+	if got := Unified(hunks, "a", "b"); !strings.Contains(got, "@@ -0,0 +1,2 @@") {
+		t.Errorf("Expected an empty old range, got:\n%s", got)
+	}
+}
+
+func TestWordDiffs(t *testing.T) {
+	hunks, _ := Lines("The quick brown fox\n", "The slow brown fox!\n", -1)
+	if len(hunks) != 1 || len(hunks[0].Lines) != 2 {
+		t.Fatalf("Expected one hunk with a deleted and inserted line, got %+v", hunks)
+	}
+
+	deleted, inserted := hunks[0].Lines[0], hunks[0].Lines[1]
+	wantDeleted := []Word{{Equal, "The "}, {Delete, "quick"}, {Equal, " brown fox"}}
+	wantInserted := []Word{{Equal, "The "}, {Insert, "slow"}, {Equal, " brown fox"}, {Insert, "!"}}
+	if !equalWords(deleted.Words, wantDeleted) {
+		t.Errorf("Unexpected deleted words %+v", deleted.Words)
+	}
+	if !equalWords(inserted.Words, wantInserted) {
+		t.Errorf("Unexpected inserted words %+v", inserted.Words)
+	}
+}
+
+func TestSideBySideHTMLEscapes(t *testing.T) {
+	hunks, _ := Lines("<b>old</b>\n", "<b>new</b>\n", -1)
+	got := SideBySideHTML(hunks, "v1", "v2")
+	if strings.Contains(got, "<b>") {
+		t.Errorf("Expected content to be escaped:\n%s", got)
+	}
+	if !strings.Contains(got, "<del>old</del>") || !strings.Contains(got, "<ins>new</ins>") {
+		t.Errorf("Expected word highlights:\n%s", got)
+	}
+}
+
+func equalWords(a, b []Word) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOffsetMap(t *testing.T) {
+	oldText := "The quick brown fox jumps over the lazy dog."
+	newText := "Intro. The slow brown fox jumps over the dog."
+	m := NewOffsetMap(oldText, newText)
+
+	cases := []struct {
+		quote string
+		want  string
+		ok    bool
+	}{
+		{"brown fox", "brown fox", true},
+		{"quick brown", "brown", true},
+		{"quick", "", false},
+		{"lazy ", "", false},
+		{"the lazy dog", "the dog", true},
+	}
+	for _, tc := range cases {
+		start := len([]rune(oldText[:strings.Index(oldText, tc.quote)]))
+		end := start + len([]rune(tc.quote))
+		newStart, newEnd, ok := m.MapRange(start, end)
+		if ok != tc.ok {
+			t.Errorf("%q: expected ok=%v", tc.quote, tc.ok)
+			continue
+		}
+		if got := string([]rune(newText)[newStart:newEnd]); ok && got != tc.want {
+			t.Errorf("%q: mapped to %q, want %q", tc.quote, got, tc.want)
+		}
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\nfive\n"
+	cases := []struct {
+		name, ours, theirs, want string
+		conflicts                int
+	}{
+		{"separate changes", "ONE\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\nFIVE\n", "ONE\ntwo\nthree\nfour\nFIVE\n", 0},
+		{"only theirs", base, "one\nthree\nfour\nfive\nsix\n", "one\nthree\nfour\nfive\nsix\n", 0},
+		{"same change", "one\n2\nthree\nfour\nfive\n", "one\n2\nthree\nfour\nfive\n", "one\n2\nthree\nfour\nfive\n", 0},
+		{"no trailing newline", base, "one\ntwo\nthree\nfour\nfive", "one\ntwo\nthree\nfour\nfive", 0},
+		{"adjacent changes", "ONE\ntwo\nthree\nfour\nfive\n", "one\nTWO\nthree\nfour\nfive\n",
+			"<<<<<<< main\nONE\ntwo\n=======\none\nTWO\n>>>>>>> rewrite\nthree\nfour\nfive\n", 1},
+		{"conflict", "one\nTWO\nthree\nfour\nfive\n", "one\nDeux\nthree\nfour\nfive\n",
+			"one\n<<<<<<< main\nTWO\n=======\nDeux\n>>>>>>> rewrite\nthree\nfour\nfive\n", 1},
+	}
+	for _, tc := range cases {
+		result := Merge3(base, tc.ours, tc.theirs, "main", "rewrite")
+		if result.Content != tc.want || len(result.Conflicts) != tc.conflicts {
+			t.Errorf("%s: got %q with %d conflicts, want %q with %d", tc.name, result.Content, len(result.Conflicts), tc.want, tc.conflicts)
+		}
+	}
+
+	conflict := Merge3(base, "one\nTWO\nthree\nfour\nfive\n", "one\nDeux\nthree\nfour\nfive\n", "main", "rewrite").Conflicts[0]
+	if conflict.StartLine != 2 || conflict.EndLine != 6 || conflict.Base != "two\n" || conflict.Ours != "TWO\n" || conflict.Theirs != "Deux\n" {
+		t.Errorf("Unexpected conflict %+v", conflict)
+	}
+}
+
+func TestBlame(t *testing.T) {
+	v1 := "one\ntwo\nthree\n"
+	v2 := "one\nTWO\nthree\nfour\n"
+	v3 := "zero\none\nTWO\nfour"
+
+	origins := Blame("", v1, nil, 1)
+	origins = Blame(v1, v2, origins, 2)
+	origins = Blame(v2, v3, origins, 3)
+
+	want := []int{3, 1, 2, 2}
+	if len(origins) != len(want) {
+		t.Fatalf("Expected origins %v, got %v", want, origins)
+	}
+	for i := range want {
+		if origins[i] != want[i] {
+			t.Fatalf("Expected origins %v, got %v", want, origins)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package diff
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// DefaultContext - Unchanged lines shown around each change, as in diff -u.
+const DefaultContext = 3
+
+// Word - A piece of a changed line and whether it survived.
+type Word struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Line - One line of a hunk. Line numbers are 1-based and 0 where the line does not exist.
+// Changed lines that pair up with a line on the other side carry a word-level diff.
+type Line struct {
+	Op      Op     `json:"op"`
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+	Text    string `json:"text"`
+	Words   []Word `json:"words,omitempty"`
+}
+
+// Hunk - A run of changes with surrounding context.
+type Hunk struct {
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+	Lines    []Line `json:"lines"`
+}
+
+// Stats - Counts of changed lines.
+type Stats struct {
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+}
+
+// Lines - Diffs two texts line by line and groups the changes into hunks with
+// context unchanged lines around them. A negative context means DefaultContext.
+func Lines(oldText, newText string, context int) ([]Hunk, Stats) {
+	if context < 0 {
+		context = DefaultContext
+	}
+	oldLines := SplitLines(oldText)
+	newLines := SplitLines(newText)
+
+	var all []Line
+	var stats Stats
+	for _, edit := range Diff(oldLines, newLines) {
+		switch edit.Op {
+		case Equal:
+			all = append(all, Line{Op: Equal, OldLine: edit.OldIndex + 1, NewLine: edit.NewIndex + 1, Text: oldLines[edit.OldIndex]})
+		case Delete:
+			all = append(all, Line{Op: Delete, OldLine: edit.OldIndex + 1, Text: oldLines[edit.OldIndex]})
+			stats.Deletions++
+		case Insert:
+			all = append(all, Line{Op: Insert, NewLine: edit.NewIndex + 1, Text: newLines[edit.NewIndex]})
+			stats.Insertions++
+		}
+	}
+	addWordDiffs(all)
+
+	return groupHunks(all, context), stats
+}
+
+// addWordDiffs - Pairs each block of deleted lines with the inserted lines that follow it
+// and records which words changed within each pair.
+func addWordDiffs(lines []Line) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != Delete {
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Op == Delete {
+			i++
+		}
+		insStart := i
+		for i < len(lines) && lines[i].Op == Insert {
+			i++
+		}
+
+		for j := 0; delStart+j < insStart && insStart+j < i; j++ {
+			oldWords := SplitWords(lines[delStart+j].Text)
+			newWords := SplitWords(lines[insStart+j].Text)
+			var removed, added []Word
+			for _, edit := range Diff(oldWords, newWords) {
+				switch edit.Op {
+				case Equal:
+					removed = appendWord(removed, Equal, oldWords[edit.OldIndex])
+					added = appendWord(added, Equal, newWords[edit.NewIndex])
+				case Delete:
+					removed = appendWord(removed, Delete, oldWords[edit.OldIndex])
+				case Insert:
+					added = appendWord(added, Insert, newWords[edit.NewIndex])
+				}
+			}
+			lines[delStart+j].Words = removed
+			lines[insStart+j].Words = added
+		}
+	}
+}
+
+// appendWord - Adds a word, merging it into the previous one when the op is the same.
+func appendWord(words []Word, op Op, text string) []Word {
+	if n := len(words); n > 0 && words[n-1].Op == op {
+		words[n-1].Text += text
+		return words
+	}
+	return append(words, Word{Op: op, Text: text})
+}
+
+func groupHunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == Equal {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while the next change is within two contexts' reach.
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == Equal {
+				run++
+			}
+			if run < len(lines) && run-end <= 2*context {
+				end = run
+				continue
+			}
+			end += context
+			if end > len(lines) {
+				end = len(lines)
+			}
+			break
+		}
+
+		hunk := Hunk{Lines: lines[start:end]}
+		for _, line := range hunk.Lines {
+			if line.Op != Insert {
+				hunk.OldLines++
+				if hunk.OldStart == 0 {
+					hunk.OldStart = line.OldLine
+				}
+			}
+			if line.Op != Delete {
+				hunk.NewLines++
+				if hunk.NewStart == 0 {
+					hunk.NewStart = line.NewLine
+				}
+			}
+		}
+		// Empty sides start at the line the change follows, as in diff -u.
+		if hunk.OldLines == 0 {
+			hunk.OldStart = precedingLine(lines[:start], true)
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart = precedingLine(lines[:start], false)
+		}
+		hunks = append(hunks, hunk)
+		i = end
+	}
+	return hunks
+}
+
+func precedingLine(lines []Line, old bool) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if old && lines[i].OldLine > 0 {
+			return lines[i].OldLine
+		}
+		if !old && lines[i].NewLine > 0 {
+			return lines[i].NewLine
+		}
+	}
+	return 0
+}
+
+// Unified - Renders hunks in unified diff format.
+func Unified(hunks []Hunk, oldName, newName string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", unifiedRange(hunk.OldStart, hunk.OldLines), unifiedRange(hunk.NewStart, hunk.NewLines))
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case Equal:
+				b.WriteString(" ")
+			case Delete:
+				b.WriteString("-")
+			case Insert:
+				b.WriteString("+")
+			}
+			b.WriteString(line.Text)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func unifiedRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// SideBySideHTML - Renders hunks as a two column HTML table, old text on the left.
+// Changed words are wrapped in <del> and <ins>.
+func SideBySideHTML(hunks []Hunk, oldName, newName string) string {
+	var b strings.Builder
+	b.WriteString(`<table class="diff">` + "\n")
+	fmt.Fprintf(&b, "<thead><tr><th colspan=\"2\">%s</th><th colspan=\"2\">%s</th></tr></thead>\n",
+		html.EscapeString(oldName), html.EscapeString(newName))
+	for _, hunk := range hunks {
+		b.WriteString("<tbody>\n")
+		fmt.Fprintf(&b, "<tr class=\"hunk\"><td colspan=\"4\">@@ -%s +%s @@</td></tr>\n",
+			unifiedRange(hunk.OldStart, hunk.OldLines), unifiedRange(hunk.NewStart, hunk.NewLines))
+
+		for i := 0; i < len(hunk.Lines); {
+			if hunk.Lines[i].Op == Equal {
+				writeRow(&b, &hunk.Lines[i], &hunk.Lines[i])
+				i++
+				continue
+			}
+			var deleted, inserted []*Line
+			for i < len(hunk.Lines) && hunk.Lines[i].Op == Delete {
+				deleted = append(deleted, &hunk.Lines[i])
+				i++
+			}
+			for i < len(hunk.Lines) && hunk.Lines[i].Op == Insert {
+				inserted = append(inserted, &hunk.Lines[i])
+				i++
+			}
+			for j := 0; j < len(deleted) || j < len(inserted); j++ {
+				var left, right *Line
+				if j < len(deleted) {
+					left = deleted[j]
+				}
+				if j < len(inserted) {
+					right = inserted[j]
+				}
+				writeRow(&b, left, right)
+			}
+		}
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+func writeRow(b *strings.Builder, left, right *Line) {
+	class := "equal"
+	if left != right {
+		class = "change"
+	}
+	fmt.Fprintf(b, `<tr class="%s">`, class)
+	writeCell(b, left, true)
+	writeCell(b, right, false)
+	b.WriteString("</tr>\n")
+}
+
+func writeCell(b *strings.Builder, line *Line, old bool) {
+	if line == nil {
+		b.WriteString(`<td class="num"></td><td class="empty"></td>`)
+		return
+	}
+	number := line.NewLine
+	if old {
+		number = line.OldLine
+	}
+	fmt.Fprintf(b, `<td class="num">%d</td><td class="%s">`, number, line.Op)
+	if len(line.Words) == 0 {
+		b.WriteString(html.EscapeString(line.Text))
+	}
+	for _, word := range line.Words {
+		text := html.EscapeString(word.Text)
+		switch word.Op {
+		case Delete:
+			b.WriteString("<del>" + text + "</del>")
+		case Insert:
+			b.WriteString("<ins>" + text + "</ins>")
+		default:
+			b.WriteString(text)
+		}
+	}
+	b.WriteString("</td>")
+}
@@ -0,0 +1,138 @@
+package diff
+
+import "strings"
+
+// Conflict - A region both sides changed differently. Lines are 1-based positions in the merged
+// text, from the opening marker to the closing one.
+type Conflict struct {
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Base      string `json:"base"`
+	Ours      string `json:"ours"`
+	Theirs    string `json:"theirs"`
+}
+
+// MergeResult - The outcome of a three-way merge. Content holds conflict markers around each
+// conflict, so it is only usable as is when Conflicts is empty.
+type MergeResult struct {
+	Content   string     `json:"content"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Merge3 - Merges the changes ours and theirs each made to base, line by line as diff3 does. A
+// region changed on only one side takes that side, one changed identically on both takes
+// either, and one changed differently is a conflict, written between markers naming the sides.
+func Merge3(base, ours, theirs, oursName, theirsName string) MergeResult {
+	baseLines := splitLinesWithEndings(base)
+	ourLines := splitLinesWithEndings(ours)
+	theirLines := splitLinesWithEndings(theirs)
+	inOurs := matchedLines(baseLines, ourLines)
+	inTheirs := matchedLines(baseLines, theirLines)
+
+	var b strings.Builder
+	line := 1
+	write := func(lines []string) {
+		for _, text := range lines {
+			b.WriteString(text)
+			line++
+		}
+	}
+	result := MergeResult{Conflicts: []Conflict{}}
+
+	i, o, t := 0, 0, 0
+	for i < len(baseLines) || o < len(ourLines) || t < len(theirLines) {
+		// A line both sides kept in place carries over.
+		if i < len(baseLines) && inOurs[i] == o && inTheirs[i] == t {
+			write(baseLines[i : i+1])
+			i, o, t = i+1, o+1, t+1
+			continue
+		}
+
+		// Otherwise the changed region runs to the next base line both sides kept.
+		next := i
+		for next < len(baseLines) && (inOurs[next] < 0 || inTheirs[next] < 0) {
+			next++
+		}
+		oEnd, tEnd := len(ourLines), len(theirLines)
+		if next < len(baseLines) {
+			oEnd, tEnd = inOurs[next], inTheirs[next]
+		}
+		baseChunk, ourChunk, theirChunk := baseLines[i:next], ourLines[o:oEnd], theirLines[t:tEnd]
+
+		switch {
+		case equalLines(ourChunk, baseChunk):
+			write(theirChunk)
+		case equalLines(theirChunk, baseChunk), equalLines(ourChunk, theirChunk):
+			write(ourChunk)
+		default:
+			conflict := Conflict{
+				StartLine: line,
+				Base:      strings.Join(baseChunk, ""),
+				Ours:      strings.Join(ourChunk, ""),
+				Theirs:    strings.Join(theirChunk, ""),
+			}
+			write([]string{"<<<<<<< " + oursName + "\n"})
+			write(terminated(ourChunk))
+			write([]string{"=======\n"})
+			write(terminated(theirChunk))
+			conflict.EndLine = line
+			write([]string{">>>>>>> " + theirsName + "\n"})
+			result.Conflicts = append(result.Conflicts, conflict)
+		}
+		i, o, t = next, oEnd, tEnd
+	}
+
+	result.Content = b.String()
+	return result
+}
+
+// matchedLines - The index in b each line of a is kept at, or -1 where it was removed.
+func matchedLines(a, b []string) []int {
+	matched := make([]int, len(a))
+	for i := range matched {
+		matched[i] = -1
+	}
+	for _, edit := range Diff(a, b) {
+		if edit.Op == Equal {
+			matched[edit.OldIndex] = edit.NewIndex
+		}
+	}
+	return matched
+}
+
+// splitLinesWithEndings - Splits text into lines that keep their newline, so joining them gives
+// back the text exactly.
+func splitLinesWithEndings(text string) []string {
+	var lines []string
+	for text != "" {
+		end := strings.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
+		}
+		lines = append(lines, text[:end])
+		text = text[end:]
+	}
+	return lines
+}
+
+// terminated - Lines with a newline added to the last one if it had none, so a marker can follow.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	out := append([]string{}, lines...)
+	out[len(out)-1] += "\n"
+	return out
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package diff
+
+import "unicode"
+
+// OffsetMap - Maps character (rune) offsets in an old text onto the new text, following
+// the words that survived a word-level diff.
+type OffsetMap struct {
+	old    []rune
+	mapped []int // New offset of each old rune, -1 if it was deleted
+}
+
+// NewOffsetMap - Diffs two texts word by word and records where each old character went.
+func NewOffsetMap(oldText, newText string) *OffsetMap {
+	oldWords := SplitWords(oldText)
+	newWords := SplitWords(newText)
+
+	oldStarts := runeStarts(oldWords)
+	newStarts := runeStarts(newWords)
+
+	m := &OffsetMap{old: []rune(oldText)}
+	m.mapped = make([]int, len(m.old))
+	for i := range m.mapped {
+		m.mapped[i] = -1
+	}
+	for _, edit := range Diff(oldWords, newWords) {
+		if edit.Op != Equal {
+			continue
+		}
+		oldStart, newStart := oldStarts[edit.OldIndex], newStarts[edit.NewIndex]
+		for i := 0; i < oldStarts[edit.OldIndex+1]-oldStart; i++ {
+			m.mapped[oldStart+i] = newStart + i
+		}
+	}
+	return m
+}
+
+// runeStarts - The rune offset each word starts at, followed by the total length.
+func runeStarts(words []string) []int {
+	starts := make([]int, 0, len(words)+1)
+	offset := 0
+	for _, word := range words {
+		starts = append(starts, offset)
+		offset += len([]rune(word))
+	}
+	return append(starts, offset)
+}
+
+// MapRange - Maps the old range [start, end) onto the new text. The new range runs from the
+// first to the last surviving non-space character, so edits inside the range are absorbed.
+// ok is false when nothing but whitespace survived.
+func (m *OffsetMap) MapRange(start, end int) (newStart, newEnd int, ok bool) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(m.old) {
+		end = len(m.old)
+	}
+
+	first, last := -1, -1
+	for i := start; i < end; i++ {
+		if m.mapped[i] < 0 || unicode.IsSpace(m.old[i]) {
+			continue
+		}
+		if first < 0 {
+			first = m.mapped[i]
+		}
+		last = m.mapped[i]
+	}
+	if first < 0 {
+		return 0, 0, false
+	}
+	return first, last + 1, true
+}
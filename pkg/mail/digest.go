@@ -0,0 +1,199 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"documentapi/pkg/database"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	texttemplate "text/template"
+	"time"
+)
+
+// Defaults for the Digester settings left at zero.
+const (
+	DefaultDigestInterval = time.Minute
+	DefaultDigestBatch    = 50
+)
+
+//go:embed templates/*
+var templates embed.FS
+
+var (
+	textDigest = texttemplate.Must(texttemplate.ParseFS(templates, "templates/digest.txt"))
+	htmlDigest = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/digest.html"))
+)
+
+// Digester - Emails users the notifications they have not read, batched by their digest
+// frequency. A digest that fails to send goes out with the user's next one. Several digesters
+// may share a store.
+//
+// With Replies set, each notification says where to reply to it by email, and a digest of a
+// single notification sets its Reply-To.
+type Digester struct {
+	Store    database.Store
+	Mailer   Mailer
+	From     string
+	Replies  *Replies         // Gives each notification a reply address, nil to leave replies off
+	Interval time.Duration    // How often due digests are looked for
+	Batch    int              // Digests claimed at once
+	Now      func() time.Time // Defaults to time.Now
+}
+
+// Run - Sends due digests every Interval until the context is cancelled.
+func (d *Digester) Run(ctx context.Context) {
+	if d.Interval <= 0 {
+		d.Interval = DefaultDigestInterval
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		d.SendDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue - Sends every digest due now, returning how many were sent.
+func (d *Digester) SendDue() int {
+	now := time.Now
+	if d.Now != nil {
+		now = d.Now
+	}
+	batch := d.Batch
+	if batch <= 0 {
+		batch = DefaultDigestBatch
+	}
+
+	sent := 0
+	for {
+		digests, err := d.Store.ClaimDigests(now(), batch)
+		if err != nil {
+			log.Printf("Failed to claim notification digests: %v", err)
+			return sent
+		}
+		failed := false
+		for _, digest := range digests {
+			if err := d.send(digest); err != nil {
+				log.Printf("Failed to send digest to user %d: %v", digest.User.Id, err)
+				if err := d.Store.ReleaseDigest(digest); err != nil {
+					log.Printf("Failed to release digest of user %d: %v", digest.User.Id, err)
+				}
+				failed = true
+				continue
+			}
+			sent++
+		}
+		// Released digests of immediate users are due again at once, so wait for the next run.
+		if len(digests) < batch || failed {
+			return sent
+		}
+	}
+}
+
+func (d *Digester) send(digest database.Digest) error {
+	message, err := RenderDigest(digest, d.Replies)
+	if err != nil {
+		return err
+	}
+	message.From = d.From
+	return d.Mailer.Send(message)
+}
+
+// digestView - What the digest templates are rendered with.
+type digestView struct {
+	Name      string
+	Frequency string
+	Count     int
+	Documents []digestDocument
+}
+
+// digestDocument - The notifications about one document, in the order they happened.
+type digestDocument struct {
+	Name    string
+	Entries []digestEntry
+}
+
+type digestEntry struct {
+	Actor   string
+	Action  string
+	Excerpt string
+	At      time.Time
+	ReplyTo string // Empty without replies
+}
+
+// actions - How a digest describes each kind of notification.
+var actions = map[database.NotificationReason]string{
+	database.NotifyMention: "mentioned you in a comment",
+	database.NotifyReply:   "replied to your comment",
+	database.NotifyDraft:   "added a new draft",
+}
+
+// RenderDigest - The email for a digest, addressed to its user, with a plain text and an HTML
+// part. Notifications are grouped by document. replies may be nil.
+func RenderDigest(digest database.Digest, replies *Replies) (Message, error) {
+	view := digestView{Name: digest.User.DisplayName, Frequency: string(digest.Frequency), Count: len(digest.Items)}
+	if view.Name == "" {
+		view.Name = digest.User.Username
+	}
+	if digest.Frequency == database.DigestImmediate {
+		view.Frequency = "as notifications arrive"
+	}
+	documents := map[int]int{}
+	for _, item := range digest.Items {
+		i, found := documents[item.DocumentId]
+		if !found {
+			i = len(view.Documents)
+			documents[item.DocumentId] = i
+			view.Documents = append(view.Documents, digestDocument{Name: item.DocumentName})
+		}
+		actor := item.ActorName
+		if actor == "" {
+			actor = "Someone"
+		}
+		entry := digestEntry{
+			Actor:   actor,
+			Action:  actions[item.Reason],
+			Excerpt: item.Excerpt,
+			At:      item.CreatedAt,
+		}
+		if replies != nil {
+			entry.ReplyTo = replies.AddressFor(ReplyToken{
+				WorkspaceId: item.WorkspaceId,
+				UserId:      digest.User.Id,
+				DraftId:     item.DraftId,
+				CommentId:   item.CommentId,
+			})
+		}
+		view.Documents[i].Entries = append(view.Documents[i].Entries, entry)
+	}
+
+	var text, html bytes.Buffer
+	if err := textDigest.Execute(&text, view); err != nil {
+		return Message{}, err
+	}
+	if err := htmlDigest.Execute(&html, view); err != nil {
+		return Message{}, err
+	}
+
+	subject := fmt.Sprintf("%d new notifications", view.Count)
+	if view.Count == 1 {
+		subject = "1 new notification"
+	}
+	message := Message{
+		To:      []string{digest.User.Email},
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}
+	if view.Count == 1 && replies != nil {
+		message.Headers = map[string]string{"Reply-To": view.Documents[0].Entries[0].ReplyTo}
+	}
+	return message, nil
+}
@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer - Writes each message to a .eml file in Dir instead of sending it, for development
+// and for checking what would have been sent.
+type FileMailer struct {
+	Dir string
+
+	mu   sync.Mutex
+	sent int
+}
+
+// Send - Writes the message to a new file named after the time and a counter.
+func (f *FileMailer) Send(message Message) error {
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.sent++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405"), f.sent)
+	f.mu.Unlock()
+	return os.WriteFile(filepath.Join(f.Dir, name), body, 0o644)
+}
+
+// MemoryMailer - Keeps every message it is given, for tests. Err, if set, is returned instead.
+type MemoryMailer struct {
+	Err error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send - Records the message.
+func (m *MemoryMailer) Send(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages - Every message sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
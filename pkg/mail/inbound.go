@@ -0,0 +1,203 @@
+package mail
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// ErrNoText - An inbound email has no text part to take a reply from.
+var ErrNoText = errors.New("email has no text")
+
+// InboundEmail - An email received in reply to a notification, reduced to what a comment needs.
+type InboundEmail struct {
+	From       string   // The sender's address, without a display name
+	Recipients []string // The To, Cc, Delivered-To and X-Original-To addresses
+	Subject    string
+	Text       string // The reply itself, without quoted history or a signature
+}
+
+// NormalizeAddress - The lowercased address of an email address, without a display name, so two
+// spellings of one mailbox compare equal.
+func NormalizeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(parsed.Address), nil
+}
+
+// ParseInbound - Reads a raw MIME email, takes its plain text part (or its HTML part as text if it
+// has none) and strips the quoted history and signature from it.
+func ParseInbound(r io.Reader) (*InboundEmail, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	from, err := NormalizeAddress(message.Header.Get("From"))
+	if err != nil {
+		return nil, errors.New("email has no valid From address")
+	}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+
+	inbound := &InboundEmail{From: from, Subject: subject}
+	for _, name := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		for _, value := range message.Header[name] {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, address := range addresses {
+				inbound.Recipients = append(inbound.Recipients, address.Address)
+			}
+		}
+	}
+
+	text, err := bodyText(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return nil, err
+	}
+	inbound.Text = StripReply(text)
+	return inbound, nil
+}
+
+// bodyText - The plain text of a body, searching multipart bodies for a text/plain part and
+// falling back to the first text/html one.
+func bodyText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain" // RFC 2045's default
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		parts := multipart.NewReader(body, params["boundary"])
+		htmlText := ""
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := bodyText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if errors.Is(err, ErrNoText) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType != "text/html" {
+				return text, nil
+			}
+			if htmlText == "" {
+				htmlText = text
+			}
+		}
+		if htmlText == "" {
+			return "", ErrNoText
+		}
+		return htmlText, nil
+	case mediaType == "text/plain" || mediaType == "text/html":
+		decoded, err := io.ReadAll(decodeTransfer(encoding, body))
+		if err != nil {
+			return "", err
+		}
+		text := string(decoded)
+		if mediaType == "text/html" {
+			text = htmlToText(text)
+		}
+		return strings.ReplaceAll(text, "\r\n", "\n"), nil
+	}
+	return "", ErrNoText
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineSkipper{body})
+	}
+	return body
+}
+
+// newlineSkipper - Drops the line breaks base64 bodies are wrapped with.
+type newlineSkipper struct {
+	r io.Reader
+}
+
+func (s *newlineSkipper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+var (
+	htmlBreaks     = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>|</blockquote>`)
+	htmlQuotes     = regexp.MustCompile(`(?is)<blockquote.*?</blockquote>`)
+	htmlTags       = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlInvisibles = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+)
+
+// htmlToText - Roughly the text of an HTML body: quoted blocks dropped, breaks kept and tags removed.
+func htmlToText(body string) string {
+	body = htmlInvisibles.ReplaceAllString(body, "")
+	body = htmlQuotes.ReplaceAllString(body, "")
+	body = htmlBreaks.ReplaceAllString(body, "\n")
+	return html.UnescapeString(htmlTags.ReplaceAllString(body, ""))
+}
+
+var (
+	// quoteHeader - The line mail clients put above the history they quote, such as
+	// "On Mon, 2 Jan 2026 at 10:00, Alice <alice@example.com> wrote:". Some wrap it onto two lines.
+	quoteHeader = regexp.MustCompile(`(?is)^on\b.{0,200}\bwrote:\s*$`)
+	// quoteSeparators - Lines that start forwarded or quoted history in Outlook and others.
+	quoteSeparators = regexp.MustCompile(`^(-{2,}\s*(Original|Forwarded) Message\s*-{2,}|_{10,}|From:\s.+)$`)
+	// mobileSignatures - The signatures phones add, such as "Sent from my iPhone".
+	mobileSignatures = regexp.MustCompile(`(?i)^(sent from my \w+|get outlook for \w+)`)
+)
+
+// StripReply - The new text of a reply: everything above the quoted history or the signature,
+// with quoted lines dropped and surrounding blank lines trimmed.
+func StripReply(text string) string {
+	var kept []string
+	previous := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if line == "--" || quoteSeparators.MatchString(trimmed) || mobileSignatures.MatchString(trimmed) ||
+			quoteHeader.MatchString(trimmed) {
+			break
+		}
+		// A quote header wrapped onto two lines.
+		if strings.HasPrefix(strings.ToLower(previous), "on ") && quoteHeader.MatchString(previous+" "+trimmed) {
+			kept = kept[:len(kept)-1]
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+		previous = trimmed
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -0,0 +1,291 @@
+package mail
+
+import (
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	message := Message{
+		From:    "docs@example.com",
+		To:      []string{"bob@example.com"},
+		Subject: "Café notes",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+		Headers: map[string]string{"reply-to": "reply+abc@example.com"},
+	}
+	body, err := message.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to render message: %v", err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "Café notes" || parsed.Header.Get("Reply-To") != "reply+abc@example.com" || parsed.Header.Get("To") != "<bob@example.com>" {
+		t.Errorf("Expected the headers to survive, got %v", parsed.Header)
+	}
+	if !strings.HasPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("Expected a multipart message, got %q", parsed.Header.Get("Content-Type"))
+	}
+	rest, _ := io.ReadAll(parsed.Body)
+	if !strings.Contains(string(rest), "plain body") || !strings.Contains(string(rest), "<p>html body</p>") {
+		t.Errorf("Expected both parts, got %s", rest)
+	}
+
+	plain, _ := Message{From: "a@example.com", To: []string{"b@example.com"}, Text: "only text"}.Bytes()
+	if !strings.Contains(string(plain), "Content-Type: text/plain") {
+		t.Errorf("Expected a plain text message, got %s", plain)
+	}
+
+	named, err := Message{From: "Docs <docs@example.com>", To: []string{`"Bob, Jr." <bob@example.com>`}, Text: "x"}.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to render message: %v", err)
+	}
+	parsed, _ = mail.ReadMessage(strings.NewReader(string(named)))
+	if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Name != "Bob, Jr." || to[0].Address != "bob@example.com" {
+		t.Errorf("Expected the quoted recipient, got %v (%v)", parsed.Header.Get("To"), err)
+	}
+
+	for _, injected := range []Message{
+		{From: "docs@example.com\r\nBcc: eve@example.com", To: []string{"bob@example.com"}, Text: "x"},
+		{From: "docs@example.com", To: []string{"bob@example.com\nBcc: eve@example.com"}, Text: "x"},
+		{From: "docs@example.com", To: []string{"not an address"}, Text: "x"},
+		{From: "docs@example.com", To: []string{"bob@example.com"}, Text: "x", Headers: map[string]string{"Reply-To": "a@example.com\r\nBcc: eve@example.com"}},
+	} {
+		if body, err := injected.Bytes(); err == nil {
+			t.Errorf("Expected %+v to be refused, got %s", injected, body)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &FileMailer{Dir: dir}
+	for i := 0; i < 2; i++ {
+		if err := mailer.Send(Message{From: "a@example.com", To: []string{"b@example.com"}, Text: "hello"}); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 || !strings.HasSuffix(entries[0].Name(), ".eml") {
+		t.Errorf("Expected two .eml files, got %v (%v)", entries, err)
+	}
+}
+
+func TestDigesterSendsDueDigests(t *testing.T) {
+	store := database.NewMemory()
+	alice, _ := store.CreateUser(database.User{Username: "alice", Email: "alice@example.com"})
+	bob, _ := store.CreateUser(database.User{Username: "bob", DisplayName: "Bob", Email: "bob@example.com"})
+	workspace, _ := store.CreateWorkspace(database.Workspace{Name: "acme"}, alice.Id)
+	store.SetWorkspaceMember(database.WorkspaceMember{WorkspaceId: workspace.Id, UserId: bob.Id, Role: database.WorkspaceRoleMember})
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: workspace.Id, Name: "Plan", Content: "v1", UserId: alice.Id})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	store.SetDocumentPermission(database.DocumentPermission{DocumentId: draft.DocumentId, PrincipalType: database.PrincipalUser, PrincipalId: bob.Id, Role: database.RoleEditor})
+	store.WatchDocument(draft.DocumentId, alice.Id)
+	store.AddCommentToDraft(workspace.Id, database.Comment{DraftId: draft.Id, UserId: alice.Id, Text: "@bob <b>please</b> review"})
+	store.CreateDraft(common.Draft{WorkspaceId: workspace.Id, Name: "Plan", Content: "v2", UserId: bob.Id, Summary: "Reviewed"})
+	store.SetNotificationSettings(database.NotificationSettings{UserId: alice.Id, DigestFrequency: database.DigestHourly})
+
+	now := time.Now()
+	mailer := &MemoryMailer{Err: errors.New("connection refused")}
+	digester := &Digester{Store: store, Mailer: mailer, From: "docs@example.com", Now: func() time.Time { return now }}
+	if sent := digester.SendDue(); sent != 0 {
+		t.Errorf("Expected nothing sent while the mailer fails, got %d", sent)
+	}
+
+	// Alice's failed hourly digest waits for the hour; bob's daily one waits for the day.
+	mailer.Err = nil
+	now = now.Add(time.Hour)
+	if sent := digester.SendDue(); sent != 1 {
+		t.Fatalf("Expected alice's digest to be retried, got %d", sent)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "alice@example.com" || messages[0].From != "docs@example.com" || messages[0].Subject != "1 new notification" {
+		t.Fatalf("Expected one digest for alice, got %+v", messages)
+	}
+	if !strings.Contains(messages[0].Text, "bob added a new draft") || !strings.Contains(messages[0].Text, "Reviewed") || !strings.Contains(messages[0].Text, "hourly") {
+		t.Errorf("Expected the draft in alice's digest, got %s", messages[0].Text)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if sent := digester.SendDue(); sent != 1 {
+		t.Fatalf("Expected bob's daily digest, got %d", sent)
+	}
+	bobs := mailer.Messages()[1]
+	if !strings.HasPrefix(bobs.Text, "Hi Bob,") || !strings.Contains(bobs.Text, "alice mentioned you in a comment") {
+		t.Errorf("Expected bob's digest to name the mention, got %s", bobs.Text)
+	}
+	if !strings.Contains(bobs.HTML, "&lt;b&gt;please&lt;/b&gt;") {
+		t.Errorf("Expected the HTML part to escape the comment, got %s", bobs.HTML)
+	}
+	if sent := digester.SendDue(); sent != 0 {
+		t.Errorf("Expected nothing left to send, got %d", sent)
+	}
+}
+
+func TestReplyTokens(t *testing.T) {
+	secret := []byte("s3cret")
+	token := ReplyToken{WorkspaceId: 2, UserId: 3, DraftId: 5, CommentId: 8}
+	replies := &Replies{Address: "reply@docs.example.com", Secret: secret}
+	address := replies.AddressFor(token)
+	if !strings.HasPrefix(address, "reply+2.3.5.8.") || !strings.HasSuffix(address, "@docs.example.com") {
+		t.Fatalf("Expected a plus address, got %s", address)
+	}
+
+	parsed, err := TokenFromAddresses(secret, []string{"team@example.com", "Docs <" + strings.ToUpper(address) + ">"})
+	if err != nil || parsed != token {
+		t.Errorf("Expected %+v back, got %+v (%v)", token, parsed, err)
+	}
+	tampered := strings.Replace(address, "reply+2.3.5.8.", "reply+2.4.5.8.", 1)
+	for _, addresses := range [][]string{{tampered}, {"reply@docs.example.com"}, {"not an address"}, nil} {
+		if _, err := TokenFromAddresses(secret, addresses); err != ErrInvalidReplyToken {
+			t.Errorf("Expected %v to be rejected, got %v", addresses, err)
+		}
+	}
+	if _, err := TokenFromAddresses([]byte("other"), []string{address}); err != ErrInvalidReplyToken {
+		t.Errorf("Expected a token signed with another secret to be rejected, got %v", err)
+	}
+}
+
+func TestStripReply(t *testing.T) {
+	cases := []struct{ name, text, expected string }{
+		{"plain", "Looks good.\n", "Looks good."},
+		{"quoted", "Looks good.\n\n> What do you think?\n> -- alice", "Looks good."},
+		{"attribution", "Yes.\r\n\r\nOn Mon, 5 Oct 2026 at 09:00, Docs <docs@example.com> wrote:\r\n> Earlier", "Yes."},
+		{"wrapped attribution", "Yes.\n\nOn Mon, 5 Oct 2026 at 09:00, Docs\n<docs@example.com> wrote:\n> Earlier", "Yes."},
+		{"signature", "Yes.\n\n-- \nBob\nSent from my desk", "Yes."},
+		{"mobile", "Yes.\n\nSent from my phone", "Yes."},
+		{"outlook", "Yes.\n\n________________________________\nFrom: Docs\nSent: Monday", "Yes."},
+		{"original message", "Yes.\n\n-----Original Message-----\nHi", "Yes."},
+		{"multiple lines", "First line.\n\nSecond paragraph.\n", "First line.\n\nSecond paragraph."},
+		{"only quoted", "> Earlier\n", ""},
+	}
+	for _, c := range cases {
+		if got := StripReply(c.text); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestParseInbound(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: Bob <Bob@Example.com>",
+		"To: Docs <reply+abc@docs.example.com>",
+		"Cc: team@example.com",
+		"Subject: =?utf-8?q?Re:_Caf=C3=A9?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Caf=C3=A9 it is, with a long line that wraps=",
+		" here.",
+		"",
+		"> Where shall we meet?",
+		"--b1",
+		"Content-Type: text/html; charset=utf-8",
+		"",
+		"<p>ignored</p>",
+		"--b1--",
+		"",
+	}, "\r\n")
+	email, err := ParseInbound(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+	if email.From != "bob@example.com" || email.Subject != "Re: Café" {
+		t.Errorf("Expected the sender and subject, got %+v", email)
+	}
+	if len(email.Recipients) != 2 || email.Recipients[0] != "reply+abc@docs.example.com" || email.Recipients[1] != "team@example.com" {
+		t.Errorf("Expected both recipients, got %v", email.Recipients)
+	}
+	if email.Text != "Café it is, with a long line that wraps here." {
+		t.Errorf("Expected the decoded reply, got %q", email.Text)
+	}
+
+	htmlOnly := "From: bob@example.com\r\nTo: reply+abc@docs.example.com\r\nContent-Type: text/html\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		"PHA+U291bmRzIGdvb2QgJmFtcDsgZG9uZTwvcD48YmxvY2txdW90ZT4mZ3Q7IG9sZDwvYmxvY2txdW90ZT4=\r\n"
+	email, err = ParseInbound(strings.NewReader(htmlOnly))
+	if err != nil {
+		t.Fatalf("Failed to parse HTML email: %v", err)
+	}
+	if email.Text != "Sounds good & done" {
+		t.Errorf("Expected the HTML part as text, got %q", email.Text)
+	}
+
+	if _, err := ParseInbound(strings.NewReader("To: reply@example.com\r\n\r\nHi")); err == nil {
+		t.Errorf("Expected an email without a sender to be rejected")
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	for _, address := range []string{"bob@example.com", "Bob@Example.COM", "Bob <bob@example.com>", `"bob"@example.com`, " bob@example.com "} {
+		if normalized, err := NormalizeAddress(address); err != nil || normalized != "bob@example.com" {
+			t.Errorf("Expected %q to be bob@example.com, got %q (%v)", address, normalized, err)
+		}
+	}
+	for _, address := range []string{"", "bob", "bob@example.com, eve@example.com"} {
+		if normalized, err := NormalizeAddress(address); err == nil {
+			t.Errorf("Expected %q to be refused, got %q", address, normalized)
+		}
+	}
+}
+
+func TestEnvelopeAddresses(t *testing.T) {
+	addresses, err := envelopeAddresses([]string{`"Document API" <docs@example.com>`, "bob@example.com"})
+	if err != nil || len(addresses) != 2 || addresses[0] != "docs@example.com" || addresses[1] != "bob@example.com" {
+		t.Errorf("Expected the bare addresses, got %v (%v)", addresses, err)
+	}
+	if _, err := envelopeAddresses([]string{"not an address"}); err == nil {
+		t.Errorf("Expected an invalid address to be refused")
+	}
+}
+
+func TestRenderDigestReplyTo(t *testing.T) {
+	replies := &Replies{Address: "reply@docs.example.com", Secret: []byte("s3cret")}
+	digest := database.Digest{
+		User:      database.User{Id: 3, Username: "bob", Email: "bob@example.com"},
+		Frequency: database.DigestImmediate,
+		Items: []database.DigestItem{{
+			Notification: database.Notification{WorkspaceId: 2, DocumentId: 1, DraftId: 5, CommentId: 8, Reason: database.NotifyReply, Excerpt: "Done"},
+			DocumentName: "Plan",
+			ActorName:    "alice",
+		}},
+	}
+	message, err := RenderDigest(digest, replies)
+	if err != nil {
+		t.Fatalf("Failed to render digest: %v", err)
+	}
+	address := replies.AddressFor(ReplyToken{WorkspaceId: 2, UserId: 3, DraftId: 5, CommentId: 8})
+	if message.Headers["Reply-To"] != address || !strings.Contains(message.Text, "Reply by email: "+address) ||
+		!strings.Contains(html.UnescapeString(message.HTML), "mailto:"+address) {
+		t.Errorf("Expected the reply address in the digest, got %+v", message)
+	}
+
+	// A digest of several notifications has one reply address per entry, none for the whole email.
+	digest.Items = append(digest.Items, digest.Items[0])
+	digest.Items[1].CommentId = 9
+	message, _ = RenderDigest(digest, replies)
+	if _, found := message.Headers["Reply-To"]; found {
+		t.Errorf("Expected no Reply-To for several notifications, got %v", message.Headers)
+	}
+	if message, _ = RenderDigest(digest, nil); strings.Contains(message.Text, "Reply by email") {
+		t.Errorf("Expected no reply addresses without Replies, got %s", message.Text)
+	}
+}
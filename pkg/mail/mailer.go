@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message - An email to send. Text is required; with HTML as well the message is sent as
+// multipart/alternative so clients pick the richest part they can show.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // Extra headers, such as Reply-To
+}
+
+// Mailer - Sends email.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Bytes - The message as an RFC 5322 email, ready to hand to an SMTP server or write to a file.
+// Returns an error if From or To is not a valid address, or a header would break onto a new line.
+func (m Message) Bytes() ([]byte, error) {
+	from, err := formatAddresses([]string{m.From})
+	if err != nil {
+		return nil, err
+	}
+	to, err := formatAddresses(m.To)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name, value := range m.Headers {
+		if strings.ContainsAny(name+value, "\r\n") {
+			return nil, fmt.Errorf("header %q contains a line break", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", to)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	for _, name := range names {
+		header(textproto.CanonicalMIMEHeaderKey(name), m.Headers[name])
+	}
+
+	if m.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		return b.Bytes(), writeQuotedPrintable(&b, m.Text)
+	}
+
+	parts := multipart.NewWriter(&b)
+	header("Content-Type", `multipart/alternative; boundary="`+parts.Boundary()+`"`)
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{`text/plain; charset="utf-8"`, m.Text},
+		{`text/html; charset="utf-8"`, m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// formatAddresses - The addresses as a header value, each parsed and quoted as needed so a
+// display name cannot add headers of its own.
+func formatAddresses(addresses []string) (string, error) {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		formatted[i] = parsed.String()
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,99 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidReplyToken - A reply address carries no token, or one that was not signed with the secret.
+var ErrInvalidReplyToken = errors.New("invalid reply token")
+
+// replyMACLength - Bytes of the HMAC kept in a token, enough that guessing one is hopeless while
+// the address stays short.
+const replyMACLength = 10
+
+// ReplyToken - What a reply to a notification email comments on and who may send it. CommentId
+// is the comment replied to, 0 to start a new thread on the draft.
+type ReplyToken struct {
+	WorkspaceId int
+	UserId      int
+	DraftId     int
+	CommentId   int
+}
+
+func (t ReplyToken) payload() string {
+	return fmt.Sprintf("%d.%d.%d.%d", t.WorkspaceId, t.UserId, t.DraftId, t.CommentId)
+}
+
+func replyMAC(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("reply:" + payload))
+	return hex.EncodeToString(mac.Sum(nil)[:replyMACLength])
+}
+
+// Sign - The token as it appears in a reply address: its Ids and their HMAC, using only
+// characters mail servers leave alone.
+func (t ReplyToken) Sign(secret []byte) string {
+	payload := t.payload()
+	return payload + "." + replyMAC(secret, payload)
+}
+
+// ParseReplyToken - Checks a signed token and returns what it identifies.
+func ParseReplyToken(secret []byte, token string) (ReplyToken, error) {
+	fields := strings.Split(strings.ToLower(token), ".")
+	if len(fields) != 5 {
+		return ReplyToken{}, ErrInvalidReplyToken
+	}
+	ids := make([]int, 4)
+	for i, field := range fields[:4] {
+		id, err := strconv.Atoi(field)
+		if err != nil || id < 0 {
+			return ReplyToken{}, ErrInvalidReplyToken
+		}
+		ids[i] = id
+	}
+	parsed := ReplyToken{WorkspaceId: ids[0], UserId: ids[1], DraftId: ids[2], CommentId: ids[3]}
+	if !hmac.Equal([]byte(replyMAC(secret, parsed.payload())), []byte(fields[4])) {
+		return ReplyToken{}, ErrInvalidReplyToken
+	}
+	return parsed, nil
+}
+
+// Replies - Builds the addresses replies to notification emails are sent to, by adding a signed
+// token to Address with plus addressing: reply@example.com becomes reply+TOKEN@example.com.
+type Replies struct {
+	Address string
+	Secret  []byte
+}
+
+// AddressFor - The reply address for a token.
+func (r *Replies) AddressFor(token ReplyToken) string {
+	local, domain, _ := strings.Cut(r.Address, "@")
+	return local + "+" + token.Sign(r.Secret) + "@" + domain
+}
+
+// TokenFromAddresses - The first valid token in a list of addresses, such as the To, Cc and
+// Delivered-To of an inbound email.
+func TokenFromAddresses(secret []byte, addresses []string) (ReplyToken, error) {
+	for _, address := range addresses {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			continue
+		}
+		local, _, _ := strings.Cut(parsed.Address, "@")
+		_, tag, found := strings.Cut(local, "+")
+		if !found {
+			continue
+		}
+		if token, err := ParseReplyToken(secret, tag); err == nil {
+			return token, nil
+		}
+	}
+	return ReplyToken{}, ErrInvalidReplyToken
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTPMailer - Sends email through an SMTP server, with STARTTLS when the server offers it.
+// Username and Password are used for PLAIN authentication when a username is given.
+type SMTPMailer struct {
+	Addr     string // host:port
+	Username string
+	Password string
+}
+
+// Send - Delivers the message to the server for every recipient in To. Display names only go
+// in the headers; the envelope carries the bare addresses.
+func (s *SMTPMailer) Send(message Message) error {
+	if len(message.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := envelopeAddresses([]string{message.From})
+	if err != nil {
+		return err
+	}
+	to, err := envelopeAddresses(message.To)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from[0], to, body)
+}
+
+// envelopeAddresses - The bare addresses for MAIL FROM and RCPT TO, without display names.
+func envelopeAddresses(addresses []string) ([]string, error) {
+	bare := make([]string, len(addresses))
+	for i, address := range addresses {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		bare[i] = parsed.Address
+	}
+	return bare, nil
+}
@@ -0,0 +1,53 @@
+package publish
+
+import (
+	"context"
+	"documentapi/pkg/database"
+	"log"
+	"time"
+)
+
+// DefaultInterval - How often a Scheduler left at zero looks for due publications.
+const DefaultInterval = 15 * time.Second
+
+// Scheduler - Publishes documents whose scheduled publication time has come. A publication goes
+// out on the first check after it is due. Several schedulers may share a store.
+type Scheduler struct {
+	Store    database.Store
+	Interval time.Duration
+	Now      func() time.Time // Defaults to time.Now
+}
+
+// Run - Checks for due publications every Interval until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.Interval <= 0 {
+		s.Interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.PublishDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishDue - Publishes everything due now, returning how many documents were published.
+func (s *Scheduler) PublishDue() int {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	published, err := s.Store.PublishDueDocuments(now())
+	if err != nil {
+		log.Printf("Failed to publish scheduled documents: %v", err)
+	}
+	for _, document := range published {
+		log.Printf("Published version %d of document %d as scheduled", document.PublishedVersion, document.Id)
+	}
+	return len(published)
+}
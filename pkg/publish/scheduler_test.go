@@ -0,0 +1,63 @@
+package publish
+
+import (
+	"context"
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPublishesDueDocuments(t *testing.T) {
+	store := database.NewMemory()
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: database.DefaultWorkspaceId, Name: "notes", Content: "one"})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+	store.ApproveVersion(draft.DocumentId, 1, 1)
+	now := time.Now()
+	if _, err := store.SchedulePublication(draft.DocumentId, 1, now.Add(time.Hour), 0); err != nil {
+		t.Fatalf("Failed to schedule publication: %v", err)
+	}
+
+	scheduler := &Scheduler{Store: store, Now: func() time.Time { return now }}
+	if published := scheduler.PublishDue(); published != 0 {
+		t.Errorf("Expected nothing to be due, published %d", published)
+	}
+	now = now.Add(2 * time.Hour)
+	if published := scheduler.PublishDue(); published != 1 {
+		t.Errorf("Expected the document to be published, published %d", published)
+	}
+	document, _ := store.GetDocumentById(database.DefaultWorkspaceId, draft.DocumentId)
+	if document.PublishedVersion != 1 || document.ScheduledVersion != 0 {
+		t.Errorf("Expected version 1 published and nothing scheduled, got %+v", document)
+	}
+}
+
+func TestSchedulerRunStopsWithContext(t *testing.T) {
+	store := database.NewMemory()
+	draft, _ := store.CreateDraft(common.Draft{WorkspaceId: database.DefaultWorkspaceId, Name: "notes", Content: "one"})
+	store.ApproveVersion(draft.DocumentId, 1, 1)
+	store.SchedulePublication(draft.DocumentId, 1, time.Now(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		(&Scheduler{Store: store, Interval: 10 * time.Millisecond}).Run(ctx)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if document, _ := store.GetDocumentById(database.DefaultWorkspaceId, draft.DocumentId); document.PublishedVersion == 1 {
+			break
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Run to return once the context is cancelled")
+	}
+	if document, _ := store.GetDocumentById(database.DefaultWorkspaceId, draft.DocumentId); document.PublishedVersion != 1 {
+		t.Errorf("Expected the due document to be published, got %+v", document)
+	}
+}
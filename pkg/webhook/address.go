@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress - A webhook URL is, or resolves to, an address inside the network the
+// service runs in, which receivers must not be able to reach through it.
+var ErrPrivateAddress = errors.New("webhooks cannot be sent to loopback, private or link-local addresses")
+
+// PublicIP - Reports whether ip is not loopback, private, link-local, multicast or unspecified.
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// CheckURL - Returns ErrPrivateAddress if the URL's host is, or currently resolves to, an address
+// PublicIP refuses. Hosts that do not resolve are let through; the Dispatcher checks the address
+// it connects to whenever it sends.
+func CheckURL(ctx context.Context, target *url.URL) error {
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// NewClient - An HTTP client that refuses to connect to addresses PublicIP refuses, checked
+// after the host is resolved so DNS cannot point a delivery or a redirect inside the network.
+// It ignores proxy settings, as the check would only see the proxy's address.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the Dispatcher settings left at zero.
+const (
+	DefaultWorkers      = 4
+	DefaultPollInterval = time.Second
+	DefaultMaxAttempts  = 8
+	DefaultBackoff      = 10 * time.Second
+	DefaultMaxBackoff   = time.Hour
+	DefaultTimeout      = 10 * time.Second
+)
+
+// maxErrorLength - How much of a failure is kept on the delivery.
+const maxErrorLength = 500
+
+// Dispatcher - Works through the webhook outbox with a pool of workers. A delivery succeeds when
+// the receiver answers 2xx; anything else is retried after Backoff, doubling with every attempt up
+// to MaxBackoff, until MaxAttempts have failed. Several dispatchers may share a store.
+type Dispatcher struct {
+	Store        database.Store
+	Client       *http.Client  // Defaults to NewClient with a DefaultTimeout timeout
+	AllowPrivate bool          // Lets the default client reach loopback and private addresses
+	Workers      int           // Deliveries sent at once
+	PollInterval time.Duration // How often the outbox is checked when idle
+	MaxAttempts  int
+	Backoff      time.Duration // The wait before the second attempt
+	MaxBackoff   time.Duration
+}
+
+// Run - Delivers until the context is cancelled, then waits for the deliveries in flight.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.setDefaults()
+
+	jobs := make(chan database.PendingDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pending := range jobs {
+				d.deliver(ctx, pending)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	poll := time.NewTicker(d.PollInterval)
+	defer poll.Stop()
+	for ctx.Err() == nil {
+		claimed, err := d.Store.ClaimWebhookDeliveries(time.Now(), d.lease(), d.Workers)
+		if err != nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+		}
+		for _, pending := range claimed {
+			select {
+			case jobs <- pending:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(claimed) == d.Workers {
+			continue // There may be more waiting
+		}
+		select {
+		case <-ctx.Done():
+		case <-poll.C:
+		}
+	}
+}
+
+func (d *Dispatcher) setDefaults() {
+	if d.Client == nil && d.AllowPrivate {
+		d.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if d.Client == nil {
+		d.Client = NewClient(DefaultTimeout)
+	}
+	if d.Workers <= 0 {
+		d.Workers = DefaultWorkers
+	}
+	if d.PollInterval <= 0 {
+		d.PollInterval = DefaultPollInterval
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = DefaultMaxAttempts
+	}
+	if d.Backoff <= 0 {
+		d.Backoff = DefaultBackoff
+	}
+	if d.MaxBackoff <= 0 {
+		d.MaxBackoff = DefaultMaxBackoff
+	}
+}
+
+// lease - How long a claimed delivery is held before another worker may retry it: long enough
+// for the request to time out and the result to be recorded.
+func (d *Dispatcher) lease() time.Duration {
+	timeout := d.Client.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return 2*timeout + d.PollInterval
+}
+
+// backoff - The wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.Backoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
+
+// deliver - Sends a claimed delivery and records the outcome. Deliveries interrupted by shutdown
+// are left for their lease to run out.
+func (d *Dispatcher) deliver(ctx context.Context, pending database.PendingDelivery) {
+	result := d.send(ctx, pending)
+	if ctx.Err() != nil {
+		return
+	}
+	if err := d.Store.CompleteWebhookDelivery(pending.Id, result); err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", pending.Id, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, pending database.PendingDelivery) database.DeliveryResult {
+	result := database.DeliveryResult{Status: database.DeliveryDelivered, AttemptedAt: time.Now()}
+
+	err := func() error {
+		body, err := json.Marshal(pending.Event)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", pending.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "documentapi-webhooks")
+		req.Header.Set(WebhookHeader, strconv.Itoa(pending.WebhookId))
+		req.Header.Set(DeliveryHeader, strconv.FormatInt(pending.Id, 10))
+		req.Header.Set(EventHeader, pending.Event.Type)
+		req.Header.Set(SignatureHeader, Sign(pending.Secret, body))
+
+		resp, err := d.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+		result.ResponseStatus = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("receiver answered %s", resp.Status)
+		}
+		return nil
+	}()
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	if len(result.Error) > maxErrorLength {
+		result.Error = result.Error[:maxErrorLength]
+	}
+	result.Status = database.DeliveryFailed
+	if pending.Attempts < d.MaxAttempts {
+		result.Status = database.DeliveryPending
+		result.NextAttemptAt = result.AttemptedAt.Add(d.backoff(pending.Attempts))
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature" // sha256=<hex HMAC-SHA256 of the body keyed with the secret>
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	WebhookHeader   = "X-Webhook-Id"
+)
+
+// SecretPrefix - Starts every generated webhook secret.
+const SecretPrefix = "whsec_"
+
+const signaturePrefix = "sha256="
+
+// NewSecret - A random secret for a webhook created without one.
+func NewSecret() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return SecretPrefix + hex.EncodeToString(random), nil
+}
+
+// Sign - The SignatureHeader value for a body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify - Reports whether a SignatureHeader value is the body's signature, for receivers.
+func Verify(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"context"
+	"documentapi/pkg/common"
+	"documentapi/pkg/database"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"draft.created"}`)
+	signature := Sign("secret", body)
+	if !strings.HasPrefix(signature, "sha256=") || !Verify("secret", body, signature) {
+		t.Fatalf("Expected %q to verify", signature)
+	}
+	if Verify("other", body, signature) || Verify("secret", []byte(`{}`), signature) || Verify("secret", body, strings.TrimPrefix(signature, "sha256=")) {
+		t.Errorf("Expected a wrong secret, body or format not to verify")
+	}
+
+	secret, err := NewSecret()
+	if err != nil || !strings.HasPrefix(secret, SecretPrefix) {
+		t.Errorf("Expected a generated secret, got %q (%v)", secret, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, wait := range expected {
+		if got := d.backoff(i + 1); got != wait {
+			t.Errorf("Expected %v after %d attempts, got %v", wait, i+1, got)
+		}
+	}
+}
+
+// receiver - A webhook endpoint answering with the given statuses in turn, then 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// deliver - Runs a dispatcher until the webhook's only delivery is no longer pending.
+func deliver(t *testing.T, store database.Store, d *Dispatcher, webhookId int) database.WebhookDelivery {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := store.GetWebhookDeliveries(database.DefaultWorkspaceId, webhookId, 0)
+		if err != nil {
+			t.Fatalf("Failed to list deliveries: %v", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != database.DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the delivery")
+	return database.WebhookDelivery{}
+}
+
+// member - Creates a user in the default workspace, who can see the drafts they create.
+func member(t *testing.T, store database.Store) int {
+	t.Helper()
+	user, err := store.CreateUser(database.User{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	store.SetWorkspaceMember(database.WorkspaceMember{WorkspaceId: database.DefaultWorkspaceId, UserId: user.Id, Role: database.WorkspaceRoleAdmin})
+	return user.Id
+}
+
+func TestDispatcherRetriesUntilDelivered(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	store := database.NewMemory()
+	userId := member(t, store)
+	hook, _ := store.CreateWebhook(database.Webhook{WorkspaceId: database.DefaultWorkspaceId, URL: server.URL, Secret: "secret", CreatedBy: userId})
+	draft, err := store.CreateDraft(common.Draft{WorkspaceId: database.DefaultWorkspaceId, UserId: userId, Name: "plan", Content: "one"})
+	if err != nil {
+		t.Fatalf("Failed to create draft: %v", err)
+	}
+
+	d := &Dispatcher{Store: store, Workers: 2, PollInterval: 5 * time.Millisecond, Backoff: 10 * time.Millisecond, MaxAttempts: 5, AllowPrivate: true}
+	delivery := deliver(t, store, d, hook.Id)
+	if delivery.Status != database.DeliveryDelivered || delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusOK || delivery.DeliveredAt == nil {
+		t.Errorf("Expected delivery on the third attempt, got %+v", delivery)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(rc.requests))
+	}
+	request, body := rc.requests[2], rc.bodies[2]
+	if !Verify("secret", body, request.Header.Get(SignatureHeader)) {
+		t.Errorf("Expected a valid signature, got %q", request.Header.Get(SignatureHeader))
+	}
+	if request.Header.Get(EventHeader) != database.EventDraftCreated || request.Header.Get(DeliveryHeader) != "1" || request.Header.Get(WebhookHeader) != "1" {
+		t.Errorf("Expected the delivery headers, got %v", request.Header)
+	}
+	var event database.Event
+	if err := json.Unmarshal(body, &event); err != nil || event.Type != database.EventDraftCreated || event.DraftId != draft.Id {
+		t.Errorf("Expected the event as the body, got %s (%v)", body, err)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	store := database.NewMemory()
+	userId := member(t, store)
+	hook, _ := store.CreateWebhook(database.Webhook{WorkspaceId: database.DefaultWorkspaceId, URL: server.URL, Secret: "secret", CreatedBy: userId})
+	store.CreateDraft(common.Draft{WorkspaceId: database.DefaultWorkspaceId, UserId: userId, Name: "plan", Content: "one"})
+
+	d := &Dispatcher{Store: store, Workers: 1, PollInterval: 5 * time.Millisecond, Backoff: time.Millisecond, MaxAttempts: 2, AllowPrivate: true}
+	delivery := deliver(t, store, d, hook.Id)
+	if delivery.Status != database.DeliveryFailed || delivery.Attempts != 2 || delivery.ResponseStatus != http.StatusInternalServerError || !strings.Contains(delivery.LastError, "500") {
+		t.Errorf("Expected the delivery to fail after 2 attempts, got %+v", delivery)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, raw := range []string{"http://127.0.0.1/hook", "http://localhost:8080/hook", "http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hook", "http://192.168.1.1/hook", "http://[::1]/hook", "http://[fe80::1]/hook", "http://0.0.0.0/hook"} {
+		target, _ := url.Parse(raw)
+		if err := CheckURL(context.Background(), target); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("Expected %s to be refused, got %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"https://93.184.216.34/hook", "https://[2606:2800:220:1::]/hook"} {
+		target, _ := url.Parse(raw)
+		if err := CheckURL(context.Background(), target); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", raw, err)
+		}
+	}
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	store := database.NewMemory()
+	userId := member(t, store)
+	hook, _ := store.CreateWebhook(database.Webhook{WorkspaceId: database.DefaultWorkspaceId, URL: server.URL, Secret: "secret", CreatedBy: userId})
+	store.CreateDraft(common.Draft{WorkspaceId: database.DefaultWorkspaceId, UserId: userId, Name: "plan", Content: "one"})
+
+	d := &Dispatcher{Store: store, Workers: 1, PollInterval: 5 * time.Millisecond, Backoff: time.Millisecond, MaxAttempts: 1}
+	delivery := deliver(t, store, d, hook.Id)
+	if delivery.Status != database.DeliveryFailed || !strings.Contains(delivery.LastError, ErrPrivateAddress.Error()) {
+		t.Errorf("Expected the delivery to be refused, got %+v", delivery)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.requests) != 0 {
+		t.Errorf("Expected no request to reach the receiver, got %d", len(rc.requests))
+	}
+}